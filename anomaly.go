@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+const (
+	defaultSizeAnomalyWindow = 7
+	sizeAnomalyMinRuns       = 3
+
+	eventSizeAnomaly = "backup_size_anomaly"
+)
+
+// checkSizeAnomaly compares a new backup's size with the rolling average of
+// the previous successful backups of the same database. A dump that shrank
+// is critical - it usually means something was silently excluded or cut
+// short - while unexpected growth is a warning.
+func (m *Monitor) checkSizeAnomaly(e CatalogEntry) {
+	if m.config.SizeAnomalyPercent <= 0 || e.Size <= 0 {
+		return
+	}
+	window := m.config.SizeAnomalyWindow
+	if window <= 0 {
+		window = defaultSizeAnomalyWindow
+	}
+
+	entries, err := loadCatalog()
+	if err != nil {
+		log.Printf("Size anomaly check skipped: %v", err)
+		return
+	}
+	var sizes []int64
+	for i := len(entries) - 1; i >= 0 && len(sizes) < window; i-- {
+		p := entries[i]
+		if p.Success && p.Database == e.Database && p.Kind == e.Kind && p.Scope == e.Scope && p.File != e.File && p.Size > 0 {
+			sizes = append(sizes, p.Size)
+		}
+	}
+	if len(sizes) < sizeAnomalyMinRuns {
+		return
+	}
+
+	var total int64
+	for _, s := range sizes {
+		total += s
+	}
+	avg := float64(total) / float64(len(sizes))
+	deviation := (float64(e.Size) - avg) * 100 / avg
+	if math.Abs(deviation) < float64(m.config.SizeAnomalyPercent) {
+		return
+	}
+
+	severity, change := severityWarning, "larger"
+	if deviation < 0 {
+		severity, change = severityCritical, "smaller"
+	}
+	msg := fmt.Sprintf("%s is %.0f%% %s than the average of the last %d backups (%s vs %s)",
+		e.File, math.Abs(deviation), change, len(sizes), formatBytes(e.Size), formatBytes(int64(avg)))
+	log.Printf("Backup size anomaly: %s", msg)
+	m.notify(Notification{
+		Event:    eventSizeAnomaly,
+		Severity: severity,
+		Title:    "Unusual backup size",
+		Message:  msg,
+		Database: e.Database,
+		Details: map[string]string{
+			"file":      e.File,
+			"size":      fmt.Sprintf("%d", e.Size),
+			"average":   fmt.Sprintf("%.0f", avg),
+			"deviation": fmt.Sprintf("%.1f", deviation),
+		},
+	})
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+const defaultAPIListen = "127.0.0.1:8765"
+
+// StatusResponse is returned by GET /api/status.
+type StatusResponse struct {
+	Connected         bool
+	LastBackupTime    time.Time
+	LastBackupStatus  string
+	NextScheduledTime time.Time
+	Restore           *RestoreJob `json:",omitempty"`
+}
+
+type RestoreRequest struct {
+	File        string // backup file name inside the backups directory
+	Destination string // stream File from this upload destination instead
+	Database    string // target database (ignored for pg_dumpall files)
+	Drop        bool   // drop and recreate the target database first
+	Confirm     string // must repeat Database when Drop is set
+}
+
+func (m *Monitor) startAPI() {
+	listen := m.config.APIListen
+	if listen == "" {
+		listen = defaultAPIListen
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/status", m.handleStatus)
+	mux.HandleFunc("/api/backup", m.handleBackup)
+	mux.HandleFunc("/api/keys", m.handleAPIKeys)
+	mux.HandleFunc("/api/restore", m.handleRestore)
+	mux.HandleFunc("/api/restore/cancel", m.handleRestoreCancel)
+	mux.HandleFunc("/api/backups", m.handleBackups)
+	mux.HandleFunc("/api/backups/hold", m.handleHold)
+	mux.HandleFunc("/api/chains", m.handleChains)
+	mux.HandleFunc("/api/retention/simulate", m.handleRetentionSimulation)
+	mux.HandleFunc("/api/destinations/backfill", m.handleBackfill)
+	mux.HandleFunc("/api/schedule.ics", m.handleScheduleICS)
+	mux.HandleFunc("/api/webhook/backup", m.handleWebhookBackup)
+	mux.HandleFunc("/api/jobs", m.handleJobs)
+	mux.HandleFunc("/api/jobs/", m.handleJob)
+
+	server := &http.Server{Addr: listen, Handler: m.requireAuth(mux)}
+
+	var err error
+	if m.config.APITLSCert != "" {
+		server.TLSConfig, err = m.apiTLSConfig()
+		if err != nil {
+			log.Printf("API not started: %v", err)
+			return
+		}
+		log.Printf("API listening on https://%s (client certificates required: %t)", listen, m.config.APIClientCA != "")
+		err = server.ListenAndServeTLS(m.config.APITLSCert, m.config.APITLSKey)
+	} else {
+		if !isLocalListen(listen) && len(m.config.APIUsers) == 0 && !activeAPIKeys() {
+			log.Printf("WARNING: API on %s without TLS or authentication", listen)
+		}
+		log.Printf("API listening on http://%s", listen)
+		err = server.ListenAndServe()
+	}
+	log.Printf("API server stopped: %v", err)
+}
+
+func isLocalListen(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	return err == nil && host != "" && isLocalHost(host)
+}
+
+func (m *Monitor) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := StatusResponse{
+		Connected:         m.isConnected,
+		LastBackupTime:    m.lastBackupTime,
+		LastBackupStatus:  m.lastBackupStatus,
+		NextScheduledTime: m.nextScheduledTime,
+	}
+	if job, ok := m.restoreSnapshot(); ok {
+		status.Restore = &job
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (m *Monitor) handleRestore(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		job, ok := m.restoreSnapshot()
+		if !ok {
+			writeError(w, http.StatusNotFound, "no restore has been started")
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+
+	case http.MethodPost:
+		var req RestoreRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+			return
+		}
+		// Only files from the backups directory can be restored
+		file := filepath.Join(".", "backups", filepath.Base(req.File))
+		if req.Drop {
+			if err := m.confirmDestructive(apiActor(r), "restore_drop", req.Database, req.Confirm); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		var job *RestoreJob
+		var err error
+		if req.Destination != "" {
+			job, err = m.startRemoteRestore(req.Destination, req.File, req.Database, req.Drop)
+		} else {
+			job, err = m.startRestore(file, req.Database, req.Drop)
+		}
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "use GET or POST")
+	}
+}
+
+func (m *Monitor) handleRestoreCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	if err := m.cancelRestore(); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleBackup starts a backup like the webhook, for callers the API itself
+// authenticates: an API key with the trigger-backup scope or an APIUsers
+// login. The job is polled at GET /api/jobs/<ID>.
+func (m *Monitor) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+	if err := m.checkBackupRequest(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	job := m.startBackupJob(req, triggerAPI)
+	m.audit(apiActor(r), "backup", job.Database, fmt.Sprintf("job %s label %q", job.ID, job.Label))
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+type HoldRequest struct {
+	File   string
+	Hold   bool // true places the hold, false lifts it
+	Reason string
+}
+
+// handleBackups lists the catalog (GET) or deletes a backup (DELETE ?file=).
+func (m *Monitor) handleBackups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		entries, err := loadCatalog()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+
+	case http.MethodDelete:
+		file := r.URL.Query().Get("file")
+		if file == "" {
+			writeError(w, http.StatusBadRequest, "file is required")
+			return
+		}
+		if err := m.confirmDestructive(apiActor(r), "delete_backup", filepath.Base(file), r.URL.Query().Get("confirm")); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := m.deleteBackup(file, apiActor(r)); err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "use GET or DELETE")
+	}
+}
+
+func (m *Monitor) handleHold(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	var req HoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+	if err := m.setLegalHold(req.File, req.Hold, req.Reason, apiActor(r)); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type BackfillRequest struct {
+	Destination string
+	Count       int // recent backups to upload (default BackfillCount)
+}
+
+// handleBackfill starts uploading recent backups to a destination in the
+// background; progress shows in the tray and the log.
+func (m *Monitor) handleBackfill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	var req BackfillRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+	if req.Count == 0 {
+		req.Count = m.config.BackfillCount
+	}
+	if err := m.backfill(req.Destination, req.Count, apiActor(r)); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func apiActor(r *http.Request) string {
+	if user := apiUser(r); user != "" {
+		return "api:" + user + "@" + r.RemoteAddr
+	}
+	return "api:" + r.RemoteAddr
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("API response failed: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"Error": msg})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// requireAuth wraps the API with HTTP basic auth when APIUsers is set or API
+// keys exist. A bearer API key is limited to the endpoints its scopes cover.
+// The webhook endpoint carries its own HMAC signature and is exempt.
+func (m *Monitor) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/webhook/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if token, ok := bearerToken(r); ok {
+			if code, msg := authorizeAPIKey(r, token); code != http.StatusOK {
+				writeError(w, code, msg)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(m.config.APIUsers) == 0 && !activeAPIKeys() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, token, ok := r.BasicAuth()
+		want, known := m.config.APIUsers[user]
+		if !ok || !known || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+			if ok {
+				log.Printf("API: rejected credentials for %q from %s", user, r.RemoteAddr)
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="pg-monitor"`)
+			writeError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiTLSConfig requires client certificates signed by APIClientCA (mutual
+// TLS) when it is set.
+func (m *Monitor) apiTLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if m.config.APIClientCA == "" {
+		return cfg, nil
+	}
+
+	pem, err := os.ReadFile(m.config.APIClientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", m.config.APIClientCA)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
+
+// apiUser names the authenticated caller: the API key, the basic auth user
+// or the client certificate's common name.
+func apiUser(r *http.Request) string {
+	if token, ok := bearerToken(r); ok {
+		if key, ok := lookupAPIKey(token); ok {
+			return "key:" + key.Name
+		}
+	}
+	if user, _, ok := r.BasicAuth(); ok {
+		return user
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return ""
+}
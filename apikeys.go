@@ -0,0 +1,346 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	apiKeysFile    = "api-keys.json"
+	apiKeyPrefix   = "pgm_"
+	apiKeyIDBytes  = 4
+	apiKeySecBytes = 24
+
+	scopeReadStatus    = "read-status"
+	scopeTriggerBackup = "trigger-backup"
+	scopeRestore       = "restore"
+
+	// apiKeyApprovalTimeout is how long the tray offers to approve a first
+	// key requested without credentials.
+	apiKeyApprovalTimeout = 2 * time.Minute
+)
+
+var apiScopes = []string{scopeReadStatus, scopeTriggerBackup, scopeRestore}
+
+// APIKey is a bearer token for the HTTP API limited to a set of scopes. Only
+// the token's SHA-256 is stored; the token itself is shown once at creation.
+type APIKey struct {
+	ID      string
+	Name    string
+	Scopes  []string
+	Hash    string
+	Created time.Time
+	Revoked time.Time `json:",omitempty"`
+}
+
+// apiKeysMu guards api-keys.json and its cached contents. The file is
+// checked on every authenticated request, so it is only read again when its
+// modification time or size changes.
+var (
+	apiKeysMu      sync.Mutex
+	apiKeysCache   []APIKey
+	apiKeysModTime time.Time
+	apiKeysSize    int64
+
+	// apiKeyApprovalMu lets one first-key approval wait in the tray at a time.
+	apiKeyApprovalMu sync.Mutex
+)
+
+// loadAPIKeys returns a copy of the keys; callers hold apiKeysMu.
+func loadAPIKeys() []APIKey {
+	info, err := os.Stat(apiKeysFile)
+	if err != nil {
+		apiKeysCache, apiKeysModTime, apiKeysSize = nil, time.Time{}, 0
+		return nil
+	}
+	if !info.ModTime().Equal(apiKeysModTime) || info.Size() != apiKeysSize {
+		var keys []APIKey
+		if data, err := os.ReadFile(apiKeysFile); err == nil {
+			if err := json.Unmarshal(data, &keys); err != nil {
+				log.Printf("Ignoring unreadable %s: %v", apiKeysFile, err)
+			}
+		}
+		apiKeysCache, apiKeysModTime, apiKeysSize = keys, info.ModTime(), info.Size()
+	}
+	return append([]APIKey(nil), apiKeysCache...)
+}
+
+// saveAPIKeys writes the keys; callers hold apiKeysMu.
+func saveAPIKeys(keys []APIKey) error {
+	data, _ := json.MarshalIndent(keys, "", "  ")
+	if err := os.WriteFile(apiKeysFile, data, 0600); err != nil {
+		return err
+	}
+	// A write within the file system's timestamp resolution keeps the old
+	// modification time, so forget it rather than trust the cache.
+	apiKeysModTime = time.Time{}
+	return nil
+}
+
+func hashAPIKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseScopes checks a comma-separated scope list.
+func parseScopes(list string) ([]string, error) {
+	var scopes []string
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !containsString(apiScopes, s) {
+			return nil, fmt.Errorf("unknown scope %q (%s)", s, strings.Join(apiScopes, ", "))
+		}
+		if !containsString(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required (%s)", strings.Join(apiScopes, ", "))
+	}
+	return scopes, nil
+}
+
+// createAPIKey adds a key and returns it with its token, "pgm_<id>_<secret>".
+func (m *Monitor) createAPIKey(name string, scopes []string, actor string) (APIKey, string, error) {
+	if name == "" {
+		return APIKey{}, "", fmt.Errorf("a key needs a name")
+	}
+	id := make([]byte, apiKeyIDBytes)
+	secret := make([]byte, apiKeySecBytes)
+	if _, err := rand.Read(id); err != nil {
+		return APIKey{}, "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return APIKey{}, "", err
+	}
+	key := APIKey{ID: hex.EncodeToString(id), Name: name, Scopes: scopes, Created: time.Now()}
+	token := apiKeyPrefix + key.ID + "_" + hex.EncodeToString(secret)
+	key.Hash = hashAPIKey(token)
+
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	keys := loadAPIKeys()
+	for _, k := range keys {
+		if k.Name == name && k.Revoked.IsZero() {
+			return APIKey{}, "", fmt.Errorf("an active key named %q exists; revoke it first", name)
+		}
+	}
+	if err := saveAPIKeys(append(keys, key)); err != nil {
+		return APIKey{}, "", err
+	}
+	m.audit(actor, "api_key_created", name, fmt.Sprintf("id %s scopes %s", key.ID, strings.Join(scopes, ",")))
+	return key, token, nil
+}
+
+// revokeAPIKey revokes the active key with the given ID or name. Revoked
+// keys stay in the file so the audit log's key IDs can still be resolved.
+func (m *Monitor) revokeAPIKey(idOrName, actor string) error {
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	keys := loadAPIKeys()
+	for i := range keys {
+		k := &keys[i]
+		if (k.ID == idOrName || k.Name == idOrName) && k.Revoked.IsZero() {
+			k.Revoked = time.Now()
+			if err := saveAPIKeys(keys); err != nil {
+				return err
+			}
+			m.audit(actor, "api_key_revoked", k.Name, "id "+k.ID)
+			return nil
+		}
+	}
+	return fmt.Errorf("no active API key %q", idOrName)
+}
+
+// activeAPIKeys reports whether any unrevoked key exists; keys make
+// authentication mandatory even without APIUsers.
+func activeAPIKeys() bool {
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	for _, k := range loadAPIKeys() {
+		if k.Revoked.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return strings.TrimSpace(token), ok
+}
+
+// lookupAPIKey finds the active key a token belongs to.
+func lookupAPIKey(token string) (APIKey, bool) {
+	rest, ok := strings.CutPrefix(token, apiKeyPrefix)
+	if !ok {
+		return APIKey{}, false
+	}
+	id, _, _ := strings.Cut(rest, "_")
+	hash := hashAPIKey(token)
+
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+	for _, k := range loadAPIKeys() {
+		if k.ID == id && k.Revoked.IsZero() && subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hash)) == 1 {
+			return k, true
+		}
+	}
+	return APIKey{}, false
+}
+
+// requiredScope maps a request to the scope an API key needs for it. ""
+// means API keys are never allowed: deleting backups, legal holds,
+// backfills and key management need an APIUsers login.
+func requiredScope(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case path == "/api/status", path == "/api/chains", path == "/api/retention/simulate", path == "/api/schedule.ics", path == "/api/jobs", strings.HasPrefix(path, "/api/jobs/"):
+		return scopeReadStatus
+	case path == "/api/backups" && r.Method == http.MethodGet:
+		return scopeReadStatus
+	case path == "/api/backup":
+		return scopeTriggerBackup
+	case path == "/api/restore" && r.Method == http.MethodGet:
+		return scopeReadStatus
+	case path == "/api/restore", path == "/api/restore/cancel":
+		return scopeRestore
+	}
+	return ""
+}
+
+// authorizeAPIKey checks a bearer token and its scopes for r.
+func authorizeAPIKey(r *http.Request, token string) (int, string) {
+	key, ok := lookupAPIKey(token)
+	if !ok {
+		log.Printf("API: rejected API key from %s", r.RemoteAddr)
+		return http.StatusUnauthorized, "invalid or revoked API key"
+	}
+	scope := requiredScope(r)
+	if scope == "" || !containsString(key.Scopes, scope) {
+		log.Printf("API: key %s (%s) denied %s %s", key.ID, key.Name, r.Method, r.URL.Path)
+		if scope == "" {
+			return http.StatusForbidden, "not available to API keys"
+		}
+		return http.StatusForbidden, fmt.Sprintf("API key lacks the %s scope", scope)
+	}
+	return http.StatusOK, ""
+}
+
+// approveFirstAPIKey asks in the tray whether a key requested without
+// credentials may be created: with no APIUsers and no keys the API is open,
+// and whoever asked first would otherwise get a key that locks everyone else
+// out. Headless builds have no tray and refuse; -create-api-key on the
+// machine itself is the way there.
+func (m *Monitor) approveFirstAPIKey(name string, scopes []string, actor string) bool {
+	if !trayAvailable || m.apiKeyItem == nil {
+		return false
+	}
+	apiKeyApprovalMu.Lock()
+	defer apiKeyApprovalMu.Unlock()
+
+	m.apiKeyItem.SetTitle(fmt.Sprintf("Approve API key %q (%s)", name, strings.Join(scopes, ",")))
+	m.apiKeyItem.SetTooltip("Requested over the HTTP API by " + actor)
+	m.apiKeyItem.Show()
+	defer m.apiKeyItem.Hide()
+	log.Printf("API: key %q requested by %s without credentials; waiting for approval in the tray", name, actor)
+
+	select {
+	case <-m.apiKeyItem.ClickedCh:
+		m.audit(localActor(), "api_key_approved", name, "requested by "+actor)
+		return true
+	case <-time.After(apiKeyApprovalTimeout):
+		m.audit(actor, "api_key_not_approved", name, "no approval in the tray")
+		return false
+	}
+}
+
+// handleAPIKeys lists (GET), creates (POST {"Name", "Scopes"}) and revokes
+// (DELETE ?key=<id or name>) API keys. Only APIUsers get here, or anyone
+// while the API is still open; creating the first key then needs approval
+// in the tray.
+func (m *Monitor) handleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		apiKeysMu.Lock()
+		keys := loadAPIKeys()
+		apiKeysMu.Unlock()
+		for i := range keys {
+			keys[i].Hash = ""
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+		writeJSON(w, http.StatusOK, keys)
+
+	case http.MethodPost:
+		var req struct {
+			Name   string
+			Scopes []string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+			return
+		}
+		scopes, err := parseScopes(strings.Join(req.Scopes, ","))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if apiUser(r) == "" && !m.approveFirstAPIKey(req.Name, scopes, apiActor(r)) {
+			writeError(w, http.StatusForbidden, "the first API key must be approved in the tray menu or created with -create-api-key on this machine")
+			return
+		}
+		key, token, err := m.createAPIKey(req.Name, scopes, apiActor(r))
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		key.Hash = ""
+		writeJSON(w, http.StatusCreated, struct {
+			APIKey
+			Token string
+		}{key, token})
+
+	case http.MethodDelete:
+		if err := m.revokeAPIKey(r.URL.Query().Get("key"), apiActor(r)); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "use GET, POST or DELETE")
+	}
+}
+
+// printAPIKeys lists the keys for -list-api-keys.
+func printAPIKeys() {
+	apiKeysMu.Lock()
+	keys := loadAPIKeys()
+	apiKeysMu.Unlock()
+	if len(keys) == 0 {
+		fmt.Println("No API keys")
+		return
+	}
+	for _, k := range keys {
+		state := "active"
+		if !k.Revoked.IsZero() {
+			state = "revoked " + k.Revoked.Format("2006-01-02")
+		}
+		fmt.Printf("%s  %-20s %-40s created %s, %s\n", k.ID, k.Name, strings.Join(k.Scopes, ","), k.Created.Format("2006-01-02"), state)
+	}
+}
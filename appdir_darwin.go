@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// appDataDir is where state lives when running from a macOS app bundle:
+// Finder starts bundles in "/", and the bundle itself must stay unmodified
+// to keep its signature valid.
+func appDataDir() string {
+	exe, err := os.Executable()
+	if err != nil || !strings.Contains(exe, ".app/Contents/MacOS/") {
+		return ""
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, "Library", "Application Support", "PG Monitor")
+}
@@ -0,0 +1,9 @@
+//go:build !darwin
+
+package main
+
+// appDataDir returns "": config, logs and backups live in the working
+// directory.
+func appDataDir() string {
+	return ""
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+const auditLogFile = "audit-log.jsonl"
+
+// AuditRecord is one line of the append-only audit log.
+type AuditRecord struct {
+	Time   time.Time
+	Actor  string
+	Action string
+	Target string
+	Detail string `json:",omitempty"`
+}
+
+var auditMu sync.Mutex
+
+func (m *Monitor) audit(actor, action, target, detail string) {
+	rec := AuditRecord{Time: time.Now(), Actor: actor, Action: action, Target: target, Detail: detail}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("Audit record failed: %v", err)
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	f, err := os.OpenFile(auditLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Printf("Audit log unavailable: %v", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Printf("Audit record failed: %v", err)
+	}
+	log.Printf("AUDIT %s %s %s %s", actor, action, target, detail)
+}
+
+// localActor names the logged-in OS user for actions taken in the tray or CLI.
+func localActor() string {
+	if u, err := user.Current(); err == nil {
+		return "local:" + u.Username
+	}
+	return "local"
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	freqHourly = "hourly"
+	freqDaily  = "daily"
+	freqWeekly = "weekly"
+	freqOff    = "off"
+
+	schedulePlanFile   = "schedule-plan.json"
+	replanInterval     = 24 * time.Hour
+	autoScheduleTick   = time.Minute
+	maxPlanMenuItems   = 40
+	weeklyBackupDay    = time.Sunday
+	smallDatabaseBytes = 1 * gb
+	largeDatabaseBytes = 50 * gb
+	busyChangesPerHour = 10000
+	quietChangesPerHr  = 1000
+)
+
+// ScheduledDatabase is one line of the derived backup plan.
+type ScheduledDatabase struct {
+	Database       string
+	SizeBytes      int64
+	ChangesPerHour float64
+	Derived        string // frequency chosen from size and change rate
+	Override       string `json:",omitempty"` // from ScheduleOverrides
+	Frequency      string // effective frequency
+
+	// Row change counter from pg_stat_database, kept to measure the change
+	// rate between two planning runs.
+	ChangeCounter int64
+	SampledAt     time.Time
+}
+
+type SchedulePlan struct {
+	Generated time.Time
+	Databases []ScheduledDatabase
+}
+
+// deriveFrequency: small busy databases hourly, huge static ones weekly,
+// everything else daily.
+func deriveFrequency(size int64, changesPerHour float64) string {
+	switch {
+	case size < smallDatabaseBytes && changesPerHour >= busyChangesPerHour:
+		return freqHourly
+	case size > largeDatabaseBytes && changesPerHour < quietChangesPerHr:
+		return freqWeekly
+	default:
+		return freqDaily
+	}
+}
+
+func loadSchedulePlan() SchedulePlan {
+	var plan SchedulePlan
+	if data, err := os.ReadFile(schedulePlanFile); err == nil {
+		if err := json.Unmarshal(data, &plan); err != nil {
+			log.Printf("Ignoring unreadable %s: %v", schedulePlanFile, err)
+		}
+	}
+	return plan
+}
+
+func (m *Monitor) buildSchedulePlan(previous SchedulePlan) (SchedulePlan, error) {
+	db, err := m.openDB(m.config.DBName)
+	if err != nil {
+		return previous, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), tuningQueryTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT d.datname, pg_database_size(d.oid),
+		       COALESCE(s.tup_inserted + s.tup_updated + s.tup_deleted, 0),
+		       COALESCE(s.stats_reset, pg_postmaster_start_time())
+		FROM pg_database d JOIN pg_stat_database s ON s.datid = d.oid
+		WHERE NOT d.datistemplate AND d.datallowconn
+		ORDER BY d.datname`)
+	if err != nil {
+		return previous, err
+	}
+	defer rows.Close()
+
+	prev := make(map[string]ScheduledDatabase)
+	for _, d := range previous.Databases {
+		prev[d.Database] = d
+	}
+
+	now := time.Now()
+	plan := SchedulePlan{Generated: now}
+	for rows.Next() {
+		var d ScheduledDatabase
+		var statsReset time.Time
+		if err := rows.Scan(&d.Database, &d.SizeBytes, &d.ChangeCounter, &statsReset); err != nil {
+			return previous, err
+		}
+		if !m.inDatabaseList(d.Database) {
+			continue
+		}
+		d.SampledAt = now
+
+		// Prefer the delta since the last plan; fall back to the average since
+		// the statistics were reset
+		if p, ok := prev[d.Database]; ok && d.ChangeCounter >= p.ChangeCounter && now.Sub(p.SampledAt) > time.Hour {
+			d.ChangesPerHour = float64(d.ChangeCounter-p.ChangeCounter) / now.Sub(p.SampledAt).Hours()
+		} else if hours := now.Sub(statsReset).Hours(); hours > 0 {
+			d.ChangesPerHour = float64(d.ChangeCounter) / hours
+		}
+
+		d.Derived = deriveFrequency(d.SizeBytes, d.ChangesPerHour)
+		d.Frequency = d.Derived
+		if o, ok := m.scheduleOverride(d.Database); ok {
+			d.Override = o
+			d.Frequency = o
+		}
+		plan.Databases = append(plan.Databases, d)
+	}
+	if err := rows.Err(); err != nil {
+		return previous, err
+	}
+
+	data, _ := json.MarshalIndent(plan, "", "  ")
+	if err := os.WriteFile(schedulePlanFile, data, 0644); err != nil {
+		log.Printf("Failed to write %s: %v", schedulePlanFile, err)
+	}
+	return plan, nil
+}
+
+// nextAutoRun returns when a database with the given frequency is next due,
+// counting from its last backup.
+func (m *Monitor) nextAutoRun(frequency string, last time.Time) time.Time {
+	switch frequency {
+	case freqHourly:
+		return last.Add(time.Hour)
+	case freqWeekly:
+		next := m.calculateNextBackupTime(last)
+		for next.Weekday() != weeklyBackupDay {
+			next = next.Add(24 * time.Hour)
+		}
+		return next
+	case freqOff:
+		return time.Time{}
+	default:
+		return m.calculateNextBackupTime(last)
+	}
+}
+
+// lastSuccessfulBackups reads the catalog so the plan survives restarts.
+func lastSuccessfulBackups() map[string]time.Time {
+	last := make(map[string]time.Time)
+	entries, err := loadCatalog()
+	if err != nil {
+		log.Printf("Catalog unreadable, auto schedule starts fresh: %v", err)
+		return last
+	}
+	for _, e := range entries {
+		if e.Success && e.Kind == "database" && e.Finished.After(last[e.Database]) {
+			last[e.Database] = e.Finished
+		}
+	}
+	return last
+}
+
+// autoScheduleLoop replaces the single daily schedule when AutoSchedule is
+// enabled: each database is backed up at the frequency of the derived plan.
+func (m *Monitor) autoScheduleLoop() {
+	log.Printf("Auto schedule enabled, deriving per-database backup plan")
+
+	started := time.Now()
+	lastRuns := lastSuccessfulBackups()
+	var nextGlobals time.Time
+	plan := loadSchedulePlan()
+	hb := newClockHeartbeat()
+
+	for {
+		if time.Since(plan.Generated) > replanInterval || len(plan.Databases) == 0 {
+			newPlan, err := m.buildSchedulePlan(plan)
+			if err != nil {
+				log.Printf("Failed to derive schedule plan: %v", err)
+			} else {
+				plan = newPlan
+				m.logSchedulePlan(plan)
+			}
+			m.updatePlanMenu(plan)
+		}
+
+		var earliest time.Time
+		for _, d := range plan.Databases {
+			last := lastRuns[d.Database]
+			if last.IsZero() {
+				last = started
+			}
+			due := m.nextAutoRun(d.Frequency, last)
+			if due.IsZero() {
+				continue
+			}
+			if !due.After(time.Now()) && m.autoBackupPaused() {
+				// Stays due and runs as soon as auto backups are resumed
+				due = time.Now()
+			} else if !due.After(time.Now()) {
+				if m.runMissed(d.Frequency+" backup of "+d.Database, time.Since(due)) && m.waitForPreconditions(scheduleLogical) {
+					m.backupJob(BackupJob{Database: d.Database, Trigger: triggerScheduled}, func() CatalogEntry {
+						log.Printf("Running %s auto-scheduled backup of %s", d.Frequency, d.Database)
+						return m.backupOne(d.Database, false, backupOptions{Scheduled: true})
+					})
+				}
+				lastRuns[d.Database] = time.Now()
+				due = m.nextAutoRun(d.Frequency, lastRuns[d.Database])
+			}
+			if earliest.IsZero() || due.Before(earliest) {
+				earliest = due
+			}
+		}
+		if due, ok := m.autoGlobals(&nextGlobals); ok && (earliest.IsZero() || due.Before(earliest)) {
+			earliest = due
+		}
+
+		m.nextScheduledTime = earliest
+		m.updateNextBackupStatus()
+		time.Sleep(autoScheduleTick)
+		hb.beat()
+	}
+}
+
+// autoGlobals backs up the globals daily at AutoBackupTime when
+// BackupGlobals is set, next to the per-database plan, and returns when
+// they are due next. Without an earlier globals backup in the catalog the
+// first one is due at the next AutoBackupTime.
+func (m *Monitor) autoGlobals(next *time.Time) (time.Time, bool) {
+	if !m.config.BackupGlobals {
+		return time.Time{}, false
+	}
+	if next.IsZero() {
+		*next = m.calculateNextBackupTime(time.Now())
+		if last := lastGlobalsBackup(); !last.IsZero() {
+			*next = m.nextAutoRun(freqDaily, last)
+		}
+	}
+	if next.After(time.Now()) {
+		return *next, true
+	}
+	if m.autoBackupPaused() {
+		return time.Now(), true
+	}
+	if m.runMissed("daily backup of roles and tablespaces", time.Since(*next)) && m.waitForPreconditions(scheduleLogical) {
+		m.backupJob(BackupJob{Database: globalsKind, Trigger: triggerScheduled}, func() CatalogEntry {
+			return m.backupGlobals(backupOptions{Scheduled: true})
+		})
+	}
+	*next = m.nextAutoRun(freqDaily, time.Now())
+	return *next, true
+}
+
+func (m *Monitor) logSchedulePlan(plan SchedulePlan) {
+	sorted := append([]ScheduledDatabase(nil), plan.Databases...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Database < sorted[j].Database })
+	for _, d := range sorted {
+		log.Printf("Schedule plan: %s -> %s (derived %s, %s, %.0f changes/h)",
+			d.Database, d.Frequency, d.Derived, formatBytes(d.SizeBytes), d.ChangesPerHour)
+	}
+}
+
+func (m *Monitor) addPlanMenu() {
+	m.planItem = tray.AddMenuItem("Schedule Plan", "Per-database backup frequency (override with ScheduleOverrides)")
+	for i := 0; i < maxPlanMenuItems; i++ {
+		item := m.planItem.AddSubMenuItem("", "")
+		item.Disable()
+		item.Hide()
+		m.planItems = append(m.planItems, item)
+	}
+}
+
+func (m *Monitor) updatePlanMenu(plan SchedulePlan) {
+	if m.planItem == nil {
+		return
+	}
+	for i, item := range m.planItems {
+		if i >= len(plan.Databases) {
+			item.Hide()
+			continue
+		}
+		d := plan.Databases[i]
+		title := fmt.Sprintf("%s: %s (%s, %.0f chg/h)", d.Database, d.Frequency, formatBytes(d.SizeBytes), d.ChangesPerHour)
+		if d.Override != "" {
+			title += " *override"
+		}
+		item.SetTitle(title)
+		item.Show()
+	}
+}
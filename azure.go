@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	azureAPIVersion      = "2021-08-06"
+	azureDefaultBlockMB  = 16
+	azureRequestTimeout  = 30 * time.Minute
+	azureDefaultEndpoint = "core.windows.net"
+)
+
+// azureAccount is where and how a destination reaches its container: with
+// the account key (Shared Key signing) or a SAS token appended to every URL.
+type azureAccount struct {
+	name     string
+	key      []byte
+	sas      string
+	endpoint string // https://<account>.blob.core.windows.net
+}
+
+// azureAccountOf reads ConnectionString (AccountName/AccountKey,
+// SharedAccessSignature, BlobEndpoint, EndpointSuffix, DefaultEndpointsProtocol)
+// or, without one, Endpoint and SASToken.
+func azureAccountOf(d Destination) (azureAccount, error) {
+	if d.Container == "" {
+		return azureAccount{}, fmt.Errorf("destination %s: Azure needs a Container", d.Name)
+	}
+	a := azureAccount{endpoint: strings.TrimSuffix(d.Endpoint, "/"), sas: strings.TrimPrefix(d.SASToken, "?")}
+	if d.ConnectionString != "" {
+		settings := make(map[string]string)
+		for _, part := range strings.Split(d.ConnectionString, ";") {
+			if k, v, ok := strings.Cut(part, "="); ok {
+				settings[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+			}
+		}
+		a.name = settings["accountname"]
+		if key := settings["accountkey"]; key != "" {
+			decoded, err := base64.StdEncoding.DecodeString(key)
+			if err != nil {
+				return azureAccount{}, fmt.Errorf("destination %s: invalid AccountKey: %v", d.Name, err)
+			}
+			a.key = decoded
+		}
+		if sas := settings["sharedaccesssignature"]; sas != "" {
+			a.sas = strings.TrimPrefix(sas, "?")
+		}
+		a.endpoint = strings.TrimSuffix(settings["blobendpoint"], "/")
+		if a.endpoint == "" && a.name != "" {
+			protocol, suffix := settings["defaultendpointsprotocol"], settings["endpointsuffix"]
+			if protocol == "" {
+				protocol = "https"
+			}
+			if suffix == "" {
+				suffix = azureDefaultEndpoint
+			}
+			a.endpoint = fmt.Sprintf("%s://%s.blob.%s", protocol, a.name, suffix)
+		}
+	}
+	if a.endpoint == "" {
+		return azureAccount{}, fmt.Errorf("destination %s: Azure needs a ConnectionString or Endpoint", d.Name)
+	}
+	if a.key == nil && a.sas == "" {
+		return azureAccount{}, fmt.Errorf("destination %s: Azure needs an AccountKey or SAS token", d.Name)
+	}
+	if a.name == "" {
+		if u, err := url.Parse(a.endpoint); err == nil {
+			a.name, _, _ = strings.Cut(u.Host, ".")
+		}
+	}
+	return a, nil
+}
+
+// azureCall sends a request for blob name in the container ("" addresses
+// the container itself) and turns a non-2xx answer into an error; a missing
+// blob is reported as os.ErrNotExist.
+func azureCall(ctx context.Context, d Destination, method, name string, query url.Values, header http.Header, body io.Reader, length int64) (*http.Response, error) {
+	a, err := azureAccountOf(d)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(a.endpoint + "/" + d.Container)
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		u.Path += "/" + keyPrefix(d) + name
+	}
+	raw := query.Encode()
+	if a.key == nil && a.sas != "" {
+		raw = strings.TrimPrefix(raw+"&"+a.sas, "&")
+	}
+	u.RawQuery = raw
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = length
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	if a.key != nil {
+		signAzure(req, a, query, length)
+	}
+
+	client := &http.Client{Timeout: azureRequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &os.PathError{Op: method, Path: u.Path, Err: os.ErrNotExist}
+	}
+	var azErr struct {
+		Code    string
+		Message string
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&azErr)
+	return nil, fmt.Errorf("Azure %s %s: %s %s %s", method, name, resp.Status, azErr.Code, strings.SplitN(azErr.Message, "\n", 2)[0])
+}
+
+// signAzure adds a Shared Key Authorization header.
+func signAzure(req *http.Request, a azureAccount, query url.Values, length int64) {
+	contentLength := ""
+	if length > 0 {
+		contentLength = strconv.FormatInt(length, 10)
+	}
+
+	var msHeaders []string
+	for k := range req.Header {
+		if lower := strings.ToLower(k); strings.HasPrefix(lower, "x-ms-") {
+			msHeaders = append(msHeaders, lower+":"+strings.TrimSpace(req.Header.Get(k)))
+		}
+	}
+	sort.Strings(msHeaders)
+
+	resource := "/" + a.name + req.URL.EscapedPath()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(k) + ":" + strings.Join(values, ",")
+	}
+
+	toSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date: x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		strings.Join(msHeaders, "\n"),
+		resource,
+	}, "\n")
+
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", a.name, base64.StdEncoding.EncodeToString(mac.Sum(nil))))
+}
+
+func (m *Monitor) azureBlockSize() int64 {
+	if size := m.uploadChunkSize(); size > 0 {
+		return size
+	}
+	return azureDefaultBlockMB * mb
+}
+
+// azureBlockID names block n; all IDs of a blob must have the same length.
+func azureBlockID(n int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%06d", n)))
+}
+
+func azurePutBlob(ctx context.Context, d Destination, name string, body io.Reader, length int64) error {
+	header := http.Header{"X-Ms-Blob-Type": {"BlockBlob"}}
+	resp, err := azureCall(ctx, d, http.MethodPut, name, nil, header, body, length)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func azurePutBlock(ctx context.Context, d Destination, name string, n int, body io.Reader, length int64) error {
+	query := url.Values{"comp": {"block"}, "blockid": {azureBlockID(n)}}
+	resp, err := azureCall(ctx, d, http.MethodPut, name, query, nil, body, length)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// azureCommitBlocks turns blocks 0..count-1 into the blob.
+func azureCommitBlocks(ctx context.Context, d Destination, name string, count int) error {
+	var list bytes.Buffer
+	list.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList>`)
+	for n := 0; n < count; n++ {
+		fmt.Fprintf(&list, "<Latest>%s</Latest>", azureBlockID(n))
+	}
+	list.WriteString("</BlockList>")
+
+	resp, err := azureCall(ctx, d, http.MethodPut, name, url.Values{"comp": {"blocklist"}}, nil, bytes.NewReader(list.Bytes()), int64(list.Len()))
+	if err != nil {
+		return fmt.Errorf("committing blocks: %v", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// uploadAzure uploads a file as a block blob: in one request up to the
+// block size, above it block by block with a final commit. Uploaded blocks
+// are counted in upload-chunks.json; Azure keeps uncommitted blocks for a
+// week, so a retried upload continues with the next block.
+func (m *Monitor) uploadAzure(d Destination, filePath, rate string) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx := context.Background()
+	block := m.azureBlockSize()
+	if info.Size() <= block {
+		body := m.chunkBody(f, rate)
+		defer body.Close()
+		return azurePutBlob(ctx, d, info.Name(), body, info.Size())
+	}
+
+	key := d.Name + " " + remoteLocation(d, info.Name())
+	var t ChunkedTransfer
+	updateChunkState(key, func(s *ChunkedTransfer) bool {
+		if s.Size != info.Size() || !s.ModTime.Equal(info.ModTime()) || s.ChunkSize != block || time.Since(s.Started) > 6*24*time.Hour {
+			*s = ChunkedTransfer{Size: info.Size(), ModTime: info.ModTime(), ChunkSize: block, Started: time.Now()}
+		}
+		t = *s
+		return true
+	})
+	if t.Done > 0 {
+		log.Printf("Resuming upload of %s to %s at block %d", info.Name(), d.Name, t.Done+1)
+	}
+
+	blocks := int((t.Size + block - 1) / block)
+	for n := t.Done; n < blocks; n++ {
+		offset := int64(n) * block
+		length := min64(block, t.Size-offset)
+		body := m.chunkBody(io.NewSectionReader(f, offset, length), rate)
+		err := azurePutBlock(ctx, d, info.Name(), n, body, length)
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("block %d of %d: %v", n+1, blocks, err)
+		}
+		updateChunkState(key, func(s *ChunkedTransfer) bool {
+			s.Done = n + 1
+			return true
+		})
+	}
+
+	if err := azureCommitBlocks(ctx, d, info.Name(), blocks); err != nil {
+		// Blocks may have been garbage collected; start over next time
+		updateChunkState(key, func(*ChunkedTransfer) bool { return false })
+		return err
+	}
+	updateChunkState(key, func(*ChunkedTransfer) bool { return false })
+	log.Printf("Uploaded %s to %s in %d blocks", info.Name(), d.Name, blocks)
+	return nil
+}
+
+func openAzure(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	resp, err := azureCall(ctx, d, http.MethodGet, name, nil, nil, nil, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+func deleteAzure(d Destination, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteDeleteTimeout)
+	defer cancel()
+	resp, err := azureCall(ctx, d, http.MethodDelete, name, nil, nil, nil, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listAzure lists the blobs directly below the prefix.
+func listAzure(d Destination) ([]remoteObject, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteListTimeout)
+	defer cancel()
+
+	prefix := keyPrefix(d)
+	var objects []remoteObject
+	marker := ""
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}, "delimiter": {"/"}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		resp, err := azureCall(ctx, d, http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Blobs struct {
+				Blob []struct {
+					Name       string
+					Properties struct {
+						Size     int64  `xml:"Content-Length"`
+						Modified string `xml:"Last-Modified"`
+					}
+				}
+			}
+			NextMarker string
+		}
+		err = xml.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Azure list %s: %v", d.Container, err)
+		}
+		for _, b := range result.Blobs.Blob {
+			name := strings.TrimPrefix(b.Name, prefix)
+			if name == "" {
+				continue
+			}
+			o := remoteObject{Name: name, Size: b.Properties.Size}
+			o.Modified, _ = http.ParseTime(b.Properties.Modified)
+			objects = append(objects, o)
+		}
+		if result.NextMarker == "" {
+			return objects, nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+// azureWriter streams into a block blob, committed on Finish. Blocks of an
+// aborted stream are never committed and Azure discards them after a week.
+type azureWriter struct {
+	d      Destination
+	name   string
+	block  int64
+	buf    []byte
+	blocks int
+}
+
+func (m *Monitor) newAzureWriter(d Destination, name string) *azureWriter {
+	return &azureWriter{d: d, name: name, block: m.azureBlockSize()}
+}
+
+func (w *azureWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for int64(len(w.buf)) >= w.block {
+		if err := azurePutBlock(context.Background(), w.d, w.name, w.blocks, bytes.NewReader(w.buf[:w.block]), w.block); err != nil {
+			return 0, err
+		}
+		w.blocks++
+		w.buf = append(w.buf[:0], w.buf[w.block:]...)
+	}
+	return len(p), nil
+}
+
+func (w *azureWriter) Finish() error {
+	ctx := context.Background()
+	if w.blocks == 0 {
+		return azurePutBlob(ctx, w.d, w.name, bytes.NewReader(w.buf), int64(len(w.buf)))
+	}
+	if len(w.buf) > 0 {
+		if err := azurePutBlock(ctx, w.d, w.name, w.blocks, bytes.NewReader(w.buf), int64(len(w.buf))); err != nil {
+			return err
+		}
+		w.blocks++
+	}
+	return azureCommitBlocks(ctx, w.d, w.name, w.blocks)
+}
+
+func (w *azureWriter) Abort() {}
+
+// testAzureUpload is the Azure counterpart of testUploadTo.
+func testAzureUpload(d Destination) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+	defer cancel()
+
+	name := fmt.Sprintf("pg-monitor-test-%s.txt", time.Now().Format("20060102_150405"))
+	body := []byte("pg-monitor upload test\n")
+	start := time.Now()
+	if err := azurePutBlob(ctx, d, name, bytes.NewReader(body), int64(len(body))); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("container %s does not exist", d.Container)
+		}
+		return "", fmt.Errorf("upload rejected: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	if err := deleteAzure(d, name); err != nil {
+		log.Printf("Test upload: could not delete %s from %s: %v", name, d.Container, err)
+	}
+	return fmt.Sprintf("%s: uploaded and deleted %s in %v", d.Name, name, elapsed.Round(time.Millisecond)), nil
+}
+
+// azureBackend stores "azure" destinations.
+type azureBackend struct{}
+
+func init() {
+	registerStorageBackend(destinationAzure, azureBackend{})
+}
+
+func (azureBackend) Put(m *Monitor, d Destination, filePath, rate string) error {
+	return m.uploadAzure(d, filePath, rate)
+}
+
+func (azureBackend) Stream(m *Monitor, d Destination, name string) (streamSink, error) {
+	return m.newAzureWriter(d, name), nil
+}
+
+func (azureBackend) Open(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	return openAzure(ctx, d, name)
+}
+
+func (azureBackend) Stat(d Destination, name string) (remoteObject, error) {
+	return statListed(listAzure, d, name)
+}
+
+func (azureBackend) List(d Destination) ([]remoteObject, error) {
+	return listAzure(d)
+}
+
+func (azureBackend) Delete(d Destination, name string) error {
+	return deleteAzure(d, name)
+}
+
+func (azureBackend) Test(d Destination) (string, error) {
+	return testAzureUpload(d)
+}
+
+func (azureBackend) Location(d Destination, name string) string {
+	return fmt.Sprintf("azure://%s/%s%s", d.Container, keyPrefix(d), name)
+}
@@ -0,0 +1,579 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	b2AuthorizeURL   = "https://api.backblazeb2.com/b2api/v2/b2_authorize_account"
+	b2DefaultPartMB  = 16
+	b2RequestTimeout = 30 * time.Minute
+)
+
+// b2Session is an authorized B2 account: the API and download hosts to use
+// and the token for both, valid for a day.
+type b2Session struct {
+	token       string
+	apiURL      string
+	downloadURL string
+	bucketID    string
+	expires     time.Time
+}
+
+var (
+	b2SessionMu sync.Mutex
+	b2Sessions  = make(map[string]b2Session) // by application key ID and bucket
+)
+
+// b2Authorize returns a session for the destination's application key
+// (AccessKey is the key ID, SecretKey the key), cached until shortly before
+// the token expires. A key restricted to one bucket names its bucket ID;
+// otherwise the bucket is looked up by name.
+func b2Authorize(d Destination) (b2Session, error) {
+	if d.AccessKey == "" || d.SecretKey == "" || d.Bucket == "" {
+		return b2Session{}, fmt.Errorf("destination %s: B2 needs AccessKey (key ID), SecretKey (application key) and Bucket", d.Name)
+	}
+	cacheKey := d.AccessKey + " " + d.Bucket
+	b2SessionMu.Lock()
+	defer b2SessionMu.Unlock()
+	if s, ok := b2Sessions[cacheKey]; ok && time.Until(s.expires) > time.Hour {
+		return s, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, b2AuthorizeURL, nil)
+	if err != nil {
+		return b2Session{}, err
+	}
+	req.SetBasicAuth(d.AccessKey, d.SecretKey)
+	client := &http.Client{Timeout: diagnosticTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return b2Session{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return b2Session{}, b2Error(resp, "b2_authorize_account")
+	}
+	var auth struct {
+		AccountID          string `json:"accountId"`
+		AuthorizationToken string `json:"authorizationToken"`
+		APIURL             string `json:"apiUrl"`
+		DownloadURL        string `json:"downloadUrl"`
+		Allowed            struct {
+			BucketID   string `json:"bucketId"`
+			BucketName string `json:"bucketName"`
+		} `json:"allowed"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&auth); err != nil {
+		return b2Session{}, fmt.Errorf("b2_authorize_account: %v", err)
+	}
+	s := b2Session{
+		token:       auth.AuthorizationToken,
+		apiURL:      auth.APIURL,
+		downloadURL: auth.DownloadURL,
+		expires:     time.Now().Add(24 * time.Hour),
+	}
+
+	if auth.Allowed.BucketID != "" {
+		if auth.Allowed.BucketName != d.Bucket {
+			return b2Session{}, fmt.Errorf("application key is restricted to bucket %s, not %s", auth.Allowed.BucketName, d.Bucket)
+		}
+		s.bucketID = auth.Allowed.BucketID
+	} else {
+		var buckets struct {
+			Buckets []struct {
+				BucketID string `json:"bucketId"`
+			} `json:"buckets"`
+		}
+		err := b2Post(s, "b2_list_buckets", map[string]string{"accountId": auth.AccountID, "bucketName": d.Bucket}, &buckets)
+		if err != nil {
+			return b2Session{}, err
+		}
+		if len(buckets.Buckets) == 0 {
+			return b2Session{}, &os.PathError{Op: "b2_list_buckets", Path: d.Bucket, Err: os.ErrNotExist}
+		}
+		s.bucketID = buckets.Buckets[0].BucketID
+	}
+	b2Sessions[cacheKey] = s
+	return s, nil
+}
+
+// b2Forget drops a cached session the server no longer accepts.
+func b2Forget(d Destination) {
+	b2SessionMu.Lock()
+	delete(b2Sessions, d.AccessKey+" "+d.Bucket)
+	b2SessionMu.Unlock()
+}
+
+// b2APIError is an error answer of the B2 API.
+type b2APIError struct {
+	Call    string
+	Status  int
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *b2APIError) Error() string {
+	return fmt.Sprintf("B2 %s: %d %s %s", e.Call, e.Status, e.Code, e.Message)
+}
+
+// b2Error turns a B2 error response into an error; a missing file is
+// reported as os.ErrNotExist.
+func b2Error(resp *http.Response, call string) error {
+	e := &b2APIError{Call: call, Status: resp.StatusCode}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(e)
+	if resp.StatusCode == http.StatusNotFound || e.Code == "file_not_present" || e.Code == "no_such_file" {
+		return &os.PathError{Op: call, Path: e.Message, Err: os.ErrNotExist}
+	}
+	return e
+}
+
+// b2Post calls an API operation with a JSON request and decodes the JSON
+// answer into result.
+func b2Post(s b2Session, call string, request, result interface{}) error {
+	body, _ := json.Marshal(request)
+	req, err := http.NewRequest(http.MethodPost, s.apiURL+"/b2api/v2/"+call, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", s.token)
+	client := &http.Client{Timeout: remoteListTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return b2Error(resp, call)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(result); err != nil {
+		return fmt.Errorf("B2 %s: %v", call, err)
+	}
+	return nil
+}
+
+// b2Call authorizes and calls an operation, once more with a fresh session
+// when the token has expired.
+func b2Call(d Destination, call string, request func(s b2Session) interface{}, result interface{}) error {
+	s, err := b2Authorize(d)
+	if err != nil {
+		return err
+	}
+	err = b2Post(s, call, request(s), result)
+	if e, ok := err.(*b2APIError); ok && e.Status == http.StatusUnauthorized {
+		b2Forget(d)
+		if s, err = b2Authorize(d); err != nil {
+			return err
+		}
+		err = b2Post(s, call, request(s), result)
+	}
+	return err
+}
+
+func (m *Monitor) b2PartSize() int64 {
+	if size := m.uploadChunkSize(); size > 0 {
+		return size
+	}
+	return b2DefaultPartMB * mb
+}
+
+// b2Target is an upload URL with its own token, as handed out by
+// b2_get_upload_url and b2_get_upload_part_url.
+type b2Target struct {
+	UploadURL          string `json:"uploadUrl"`
+	AuthorizationToken string `json:"authorizationToken"`
+}
+
+// b2Send POSTs one file or part to an upload URL. B2 checks the body against
+// sha1.
+func b2Send(t b2Target, header http.Header, body io.Reader, length int64, sha1Hex string) error {
+	req, err := http.NewRequest(http.MethodPost, t.UploadURL, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = length
+	req.Header = header
+	req.Header.Set("Authorization", t.AuthorizationToken)
+	req.Header.Set("X-Bz-Content-Sha1", sha1Hex)
+
+	client := &http.Client{Timeout: b2RequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return b2Error(resp, "upload")
+	}
+	return nil
+}
+
+// b2FileName is the B2 file name of name below the prefix, percent-encoded
+// for the X-Bz-File-Name header.
+func b2FileName(d Destination, name string) string {
+	return strings.ReplaceAll(url.PathEscape(keyPrefix(d)+name), "%2F", "/")
+}
+
+// b2PutFile uploads body as a whole file.
+func b2PutFile(d Destination, name string, body io.Reader, length int64, sha1Hex string) error {
+	var t b2Target
+	if err := b2Call(d, "b2_get_upload_url", func(s b2Session) interface{} {
+		return map[string]string{"bucketId": s.bucketID}
+	}, &t); err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("X-Bz-File-Name", b2FileName(d, name))
+	header.Set("Content-Type", "b2/x-auto")
+	return b2Send(t, header, body, length, sha1Hex)
+}
+
+// b2PutPart uploads part n (1-based) of a large file.
+func b2PutPart(d Destination, fileID string, n int, body io.Reader, length int64, sha1Hex string) error {
+	var t b2Target
+	if err := b2Call(d, "b2_get_upload_part_url", func(b2Session) interface{} {
+		return map[string]string{"fileId": fileID}
+	}, &t); err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("X-Bz-Part-Number", strconv.Itoa(n))
+	return b2Send(t, header, body, length, sha1Hex)
+}
+
+func b2StartLargeFile(d Destination, name string) (string, error) {
+	var result struct {
+		FileID string `json:"fileId"`
+	}
+	err := b2Call(d, "b2_start_large_file", func(s b2Session) interface{} {
+		return map[string]string{"bucketId": s.bucketID, "fileName": keyPrefix(d) + name, "contentType": "b2/x-auto"}
+	}, &result)
+	return result.FileID, err
+}
+
+func b2FinishLargeFile(d Destination, fileID string, sha1s []string) error {
+	return b2Call(d, "b2_finish_large_file", func(b2Session) interface{} {
+		return map[string]interface{}{"fileId": fileID, "partSha1Array": sha1s}
+	}, nil)
+}
+
+func sha1Hex(r io.Reader) (string, error) {
+	h := sha1.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// uploadB2 uploads a file in one request up to the part size (UploadChunkMB,
+// default 16 MB) and as a large file above it. The large file ID and the
+// SHA-1 of every finished part are kept in upload-chunks.json, so a retried
+// upload continues with the next part.
+func (m *Monitor) uploadB2(d Destination, filePath, rate string) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	partSize := m.b2PartSize()
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if info.Size() <= partSize {
+		sum, err := sha1Hex(f)
+		if err != nil {
+			return err
+		}
+		body := m.chunkBody(io.NewSectionReader(f, 0, info.Size()), rate)
+		defer body.Close()
+		return b2PutFile(d, info.Name(), body, info.Size(), sum)
+	}
+
+	key := d.Name + " " + remoteLocation(d, info.Name())
+	var t ChunkedTransfer
+	updateChunkState(key, func(s *ChunkedTransfer) bool {
+		if s.UploadID == "" || s.Size != info.Size() || !s.ModTime.Equal(info.ModTime()) || s.ChunkSize != partSize {
+			*s = ChunkedTransfer{Size: info.Size(), ModTime: info.ModTime(), ChunkSize: partSize, Started: time.Now()}
+		}
+		t = *s
+		return true
+	})
+	if t.UploadID == "" {
+		if t.UploadID, err = b2StartLargeFile(d, info.Name()); err != nil {
+			return err
+		}
+		updateChunkState(key, func(s *ChunkedTransfer) bool {
+			s.UploadID = t.UploadID
+			return true
+		})
+	} else {
+		log.Printf("Resuming large file upload of %s to %s at part %d", info.Name(), d.Name, len(t.ETags)+1)
+	}
+
+	parts := int((t.Size + partSize - 1) / partSize)
+	for n := len(t.ETags); n < parts; n++ {
+		offset := int64(n) * partSize
+		length := min64(partSize, t.Size-offset)
+		sum, err := sha1Hex(io.NewSectionReader(f, offset, length))
+		if err != nil {
+			return err
+		}
+		body := m.chunkBody(io.NewSectionReader(f, offset, length), rate)
+		err = b2PutPart(d, t.UploadID, n+1, body, length, sum)
+		body.Close()
+		if err != nil {
+			if os.IsNotExist(err) {
+				// The large file was canceled on the server; start over next time
+				updateChunkState(key, func(*ChunkedTransfer) bool { return false })
+			}
+			return fmt.Errorf("part %d of %d: %v", n+1, parts, err)
+		}
+		updateChunkState(key, func(s *ChunkedTransfer) bool {
+			s.ETags = append(s.ETags, sum)
+			return true
+		})
+		t.ETags = append(t.ETags, sum)
+	}
+
+	if err := b2FinishLargeFile(d, t.UploadID, t.ETags); err != nil {
+		return err
+	}
+	updateChunkState(key, func(*ChunkedTransfer) bool { return false })
+	log.Printf("Uploaded %s to %s in %d parts", info.Name(), d.Name, parts)
+	return nil
+}
+
+func openB2(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	s, err := b2Authorize(d)
+	if err != nil {
+		return nil, 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.downloadURL+"/file/"+url.PathEscape(d.Bucket)+"/"+b2FileName(d, name), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Authorization", s.token)
+	client := &http.Client{Timeout: b2RequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, 0, b2Error(resp, "download")
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// deleteB2 deletes every version of a file, so the space is freed rather
+// than the file only being hidden.
+func deleteB2(d Destination, name string) error {
+	fileName := keyPrefix(d) + name
+	var versions struct {
+		Files []struct {
+			FileID   string `json:"fileId"`
+			FileName string `json:"fileName"`
+		} `json:"files"`
+	}
+	err := b2Call(d, "b2_list_file_versions", func(s b2Session) interface{} {
+		return map[string]interface{}{"bucketId": s.bucketID, "startFileName": fileName, "prefix": fileName, "maxFileCount": 100}
+	}, &versions)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions.Files {
+		if v.FileName != fileName {
+			continue
+		}
+		err := b2Call(d, "b2_delete_file_version", func(b2Session) interface{} {
+			return map[string]string{"fileName": v.FileName, "fileId": v.FileID}
+		}, nil)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// listB2 lists the files directly below the prefix.
+func listB2(d Destination) ([]remoteObject, error) {
+	prefix := keyPrefix(d)
+	var objects []remoteObject
+	start := ""
+	for {
+		var result struct {
+			Files []struct {
+				FileName        string `json:"fileName"`
+				ContentLength   int64  `json:"contentLength"`
+				UploadTimestamp int64  `json:"uploadTimestamp"` // milliseconds
+				Action          string `json:"action"`
+			} `json:"files"`
+			NextFileName string `json:"nextFileName"`
+		}
+		err := b2Call(d, "b2_list_file_names", func(s b2Session) interface{} {
+			request := map[string]interface{}{"bucketId": s.bucketID, "prefix": prefix, "delimiter": "/", "maxFileCount": 1000}
+			if start != "" {
+				request["startFileName"] = start
+			}
+			return request
+		}, &result)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range result.Files {
+			if name := strings.TrimPrefix(f.FileName, prefix); name != "" && f.Action == "upload" {
+				objects = append(objects, remoteObject{Name: name, Size: f.ContentLength, Modified: time.UnixMilli(f.UploadTimestamp)})
+			}
+		}
+		if result.NextFileName == "" {
+			return objects, nil
+		}
+		start = result.NextFileName
+	}
+}
+
+// b2Writer streams into a large file, started with the first full part; a
+// stream shorter than one part is uploaded as a whole file on Finish.
+type b2Writer struct {
+	d      Destination
+	name   string
+	part   int64
+	buf    []byte
+	fileID string
+	sha1s  []string
+}
+
+func (m *Monitor) newB2Writer(d Destination, name string) *b2Writer {
+	return &b2Writer{d: d, name: name, part: m.b2PartSize()}
+}
+
+func (w *b2Writer) putPart(p []byte) error {
+	if w.fileID == "" {
+		var err error
+		if w.fileID, err = b2StartLargeFile(w.d, w.name); err != nil {
+			return err
+		}
+	}
+	sum := sha1.Sum(p)
+	s := hex.EncodeToString(sum[:])
+	if err := b2PutPart(w.d, w.fileID, len(w.sha1s)+1, bytes.NewReader(p), int64(len(p)), s); err != nil {
+		return err
+	}
+	w.sha1s = append(w.sha1s, s)
+	return nil
+}
+
+func (w *b2Writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for int64(len(w.buf)) >= w.part {
+		if err := w.putPart(w.buf[:w.part]); err != nil {
+			return 0, err
+		}
+		w.buf = append(w.buf[:0], w.buf[w.part:]...)
+	}
+	return len(p), nil
+}
+
+func (w *b2Writer) Finish() error {
+	if w.fileID == "" {
+		sum := sha1.Sum(w.buf)
+		return b2PutFile(w.d, w.name, bytes.NewReader(w.buf), int64(len(w.buf)), hex.EncodeToString(sum[:]))
+	}
+	if len(w.buf) > 0 {
+		if err := w.putPart(w.buf); err != nil {
+			return err
+		}
+	}
+	return b2FinishLargeFile(w.d, w.fileID, w.sha1s)
+}
+
+// Abort cancels the large file, so its parts are discarded.
+func (w *b2Writer) Abort() {
+	if w.fileID == "" {
+		return
+	}
+	if err := b2Call(w.d, "b2_cancel_large_file", func(b2Session) interface{} {
+		return map[string]string{"fileId": w.fileID}
+	}, nil); err != nil {
+		log.Printf("Failed to cancel B2 upload of %s: %v", w.name, err)
+	}
+}
+
+// testB2Upload is the B2 counterpart of testUploadTo.
+func testB2Upload(d Destination) (string, error) {
+	name := fmt.Sprintf("pg-monitor-test-%s.txt", time.Now().Format("20060102_150405"))
+	body := []byte("pg-monitor upload test\n")
+	sum := sha1.Sum(body)
+	start := time.Now()
+	if err := b2PutFile(d, name, bytes.NewReader(body), int64(len(body)), hex.EncodeToString(sum[:])); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("bucket %s does not exist", d.Bucket)
+		}
+		return "", fmt.Errorf("upload rejected: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	if err := deleteB2(d, name); err != nil {
+		log.Printf("Test upload: could not delete %s from %s: %v", name, d.Bucket, err)
+	}
+	return fmt.Sprintf("%s: uploaded and deleted %s in %v", d.Name, name, elapsed.Round(time.Millisecond)), nil
+}
+
+// b2Backend stores "b2" destinations.
+type b2Backend struct{}
+
+func init() {
+	registerStorageBackend(destinationB2, b2Backend{})
+}
+
+func (b2Backend) Put(m *Monitor, d Destination, filePath, rate string) error {
+	return m.uploadB2(d, filePath, rate)
+}
+
+func (b2Backend) Stream(m *Monitor, d Destination, name string) (streamSink, error) {
+	return m.newB2Writer(d, name), nil
+}
+
+func (b2Backend) Open(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	return openB2(ctx, d, name)
+}
+
+func (b2Backend) Stat(d Destination, name string) (remoteObject, error) {
+	return statListed(listB2, d, name)
+}
+
+func (b2Backend) List(d Destination) ([]remoteObject, error) {
+	return listB2(d)
+}
+
+func (b2Backend) Delete(d Destination, name string) error {
+	return deleteB2(d, name)
+}
+
+func (b2Backend) Test(d Destination) (string, error) {
+	return testB2Upload(d)
+}
+
+func (b2Backend) Location(d Destination, name string) string {
+	return fmt.Sprintf("b2://%s/%s%s", d.Bucket, keyPrefix(d), name)
+}
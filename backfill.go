@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	knownDestinationsFile = "known-destinations.json"
+	configWatchInterval   = time.Minute
+
+	eventDestinationAdded = "destination_added"
+	eventBackfillFinished = "backfill_finished"
+)
+
+// destMu guards m.config.Destinations, which is replaced when config.json
+// changes while running.
+var destMu sync.RWMutex
+
+// destinationWatchLoop picks up destinations added to config.json without a
+// restart and offers a backfill for each new one.
+func (m *Monitor) destinationWatchLoop() {
+	m.checkNewDestinations()
+
+	var modified time.Time
+	if info, err := os.Stat("config.json"); err == nil {
+		modified = info.ModTime()
+	}
+	for {
+		time.Sleep(configWatchInterval)
+		info, err := os.Stat("config.json")
+		if err != nil || !info.ModTime().After(modified) {
+			continue
+		}
+		modified = info.ModTime()
+		m.reloadDestinations()
+	}
+}
+
+// reloadDestinations applies the Destinations of a changed config.json; all
+// other settings still need a restart.
+func (m *Monitor) reloadDestinations() {
+	config, err := loadConfig("config.json")
+	if err != nil {
+		m.configError("ignoring changed config.json: %v", err)
+		return
+	}
+
+	destMu.Lock()
+	m.config.Destinations = config.Destinations
+	destMu.Unlock()
+	log.Printf("Reloaded %d upload destination(s) from config.json", len(config.Destinations))
+	m.checkNewDestinations()
+}
+
+// checkNewDestinations compares the configured destinations with those seen
+// before. On the first run every destination counts as known, since it has
+// been receiving backups all along.
+func (m *Monitor) checkNewDestinations() {
+	known, seeded := loadKnownDestinations()
+
+	var names []string
+	for _, d := range m.destinations() {
+		names = append(names, d.Name)
+		if seeded && !known[d.Name] {
+			m.offerBackfill(d.Name)
+		}
+	}
+	saveKnownDestinations(names)
+}
+
+func loadKnownDestinations() (map[string]bool, bool) {
+	data, err := os.ReadFile(knownDestinationsFile)
+	if err != nil {
+		return nil, false
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		log.Printf("Ignoring unreadable %s: %v", knownDestinationsFile, err)
+		return nil, false
+	}
+	known := make(map[string]bool)
+	for _, name := range names {
+		known[name] = true
+	}
+	return known, true
+}
+
+func saveKnownDestinations(names []string) {
+	data, _ := json.MarshalIndent(names, "", "  ")
+	if err := os.WriteFile(knownDestinationsFile, data, 0644); err != nil {
+		log.Printf("Failed to write %s: %v", knownDestinationsFile, err)
+	}
+}
+
+func (m *Monitor) offerBackfill(name string) {
+	log.Printf("New upload destination %s", name)
+	if m.config.BackfillCount <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	m.backfillOffers = append(m.backfillOffers, name)
+	m.mu.Unlock()
+	m.updateBackfillItem()
+
+	m.notify(Notification{
+		Event:    eventDestinationAdded,
+		Severity: severityInfo,
+		Title:    "Upload destination added",
+		Message:  fmt.Sprintf("%s only holds backups from now on; use \"Backfill %s\" in the tray menu to upload the last %d backups to it", name, name, m.config.BackfillCount),
+		Details:  map[string]string{"destination": name},
+	})
+}
+
+func (m *Monitor) addBackfillMenu() {
+	m.backfillItem = tray.AddMenuItem("Backfill", "Upload recent backups to a newly added destination")
+	m.backfillItem.Hide()
+
+	go func() {
+		for range m.backfillItem.ClickedCh {
+			m.mu.Lock()
+			name := ""
+			if len(m.backfillOffers) > 0 {
+				name = m.backfillOffers[0]
+			}
+			m.mu.Unlock()
+			if name == "" {
+				continue
+			}
+			if err := m.backfill(name, m.config.BackfillCount, localActor()); err != nil {
+				log.Printf("Backfill of %s not started: %v", name, err)
+			}
+		}
+	}()
+}
+
+// updateBackfillItem shows the oldest pending offer; the item is left alone
+// while a backfill runs and reports its progress.
+func (m *Monitor) updateBackfillItem() {
+	if m.backfillItem == nil {
+		return
+	}
+	m.mu.Lock()
+	running := m.backfilling
+	name := ""
+	if len(m.backfillOffers) > 0 {
+		name = m.backfillOffers[0]
+	}
+	m.mu.Unlock()
+
+	switch {
+	case running:
+	case name == "":
+		m.backfillItem.Hide()
+	default:
+		m.backfillItem.SetTitle(fmt.Sprintf("Backfill %s (last %d backups)", name, m.config.BackfillCount))
+		m.backfillItem.Enable()
+		m.backfillItem.Show()
+	}
+}
+
+// backfill uploads the last count backups to the named destination in the
+// background. Only one backfill runs at a time.
+func (m *Monitor) backfill(name string, count int, actor string) error {
+	d, ok := m.destination(name)
+	if !ok {
+		return fmt.Errorf("unknown destination %q", name)
+	}
+	if count <= 0 {
+		return fmt.Errorf("nothing to backfill: count must be positive")
+	}
+
+	m.mu.Lock()
+	if m.backfilling {
+		m.mu.Unlock()
+		return fmt.Errorf("a backfill is already running")
+	}
+	m.backfilling = true
+	var offers []string
+	for _, o := range m.backfillOffers {
+		if o != name {
+			offers = append(offers, o)
+		}
+	}
+	m.backfillOffers = offers
+	m.mu.Unlock()
+
+	m.audit(actor, "backfill_started", name, fmt.Sprintf("last %d backups", count))
+	go func() {
+		m.runBackfill(d, count)
+		m.mu.Lock()
+		m.backfilling = false
+		m.mu.Unlock()
+		m.updateBackfillItem()
+	}()
+	return nil
+}
+
+func (m *Monitor) runBackfill(d Destination, count int) {
+	backups, err := backfillCandidates(d.Name, count)
+	if err != nil {
+		log.Printf("Backfill of %s failed: %v", d.Name, err)
+		return
+	}
+	log.Printf("Backfilling %d backup(s) to %s", len(backups), d.Name)
+
+	done := 0
+	for i, e := range backups {
+		if m.backfillItem != nil {
+			m.backfillItem.SetTitle(fmt.Sprintf("Backfill %s: %d/%d", d.Name, i+1, len(backups)))
+			m.backfillItem.Disable()
+			m.backfillItem.Show()
+		}
+		if err := m.backfillOne(d, e); err != nil {
+			log.Printf("Backfill of %s to %s failed: %v", e.File, d.Name, err)
+			continue
+		}
+		m.markUploaded(e.File, d.Name, 0)
+		done++
+	}
+
+	log.Printf("Backfill to %s finished: %d of %d backup(s) uploaded", d.Name, done, len(backups))
+	severity := severityInfo
+	if done < len(backups) {
+		severity = severityWarning
+	}
+	m.notify(Notification{
+		Event:    eventBackfillFinished,
+		Severity: severity,
+		Title:    "Backfill finished",
+		Message:  fmt.Sprintf("%d of %d backup(s) uploaded to %s", done, len(backups), d.Name),
+		Details:  map[string]string{"destination": d.Name},
+	})
+}
+
+// backfillCandidates returns the last count successful backups still on disk
+// that the destination doesn't hold yet, newest first.
+func backfillCandidates(dest string, count int) ([]CatalogEntry, error) {
+	entries, err := loadCatalog()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Finished.After(entries[j].Finished) })
+
+	var backups []CatalogEntry
+	for _, e := range entries {
+		if count == 0 {
+			break
+		}
+		if !e.Success || e.Deleted {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(".", "backups", e.File)); err != nil {
+			continue
+		}
+		held := false
+		for _, u := range e.Uploaded {
+			held = held || u == dest
+		}
+		if !held {
+			backups = append(backups, e)
+		}
+		count--
+	}
+	return backups, nil
+}
+
+// backfillOne uploads one backup the way it was uploaded to the other
+// destinations, at BackfillLimitRate and within the destination's quota.
+// Files the destination already holds at the same size, e.g. from an
+// interrupted backfill, are not sent again.
+func (m *Monitor) backfillOne(d Destination, e CatalogEntry) error {
+	files, err := m.backfillFiles(filepath.Join(".", "backups", e.File), e)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		pending := spooledFiles()
+		for _, f := range files {
+			if !pending[f] && (isOpaqueBlob(f) || isDumpTarball(f) || isEncryptedUpload(f)) {
+				os.Remove(f)
+			}
+		}
+	}()
+
+	if err := m.enforceQuota(d, e.File, uploadSize(files)); err != nil {
+		return err
+	}
+	b, err := storageFor(d)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		if o, err := b.Stat(d, filepath.Base(f)); err == nil && o.Size == info.Size() {
+			continue
+		}
+		if err := m.uploadRateLimited(d, f, m.config.BackfillLimitRate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillFiles recreates the upload files of an earlier backup under the
+// remote names recorded in its catalog entry, so quota, retention and remote
+// restore find them on the new destination too. A plaintext directory dump
+// tarball is left for backfillOne to remove after the upload. Backups
+// uploaded before EncryptBackups or UntrustedRemote was turned on are not
+// copied in the clear.
+func (m *Monitor) backfillFiles(backupFile string, e CatalogEntry) ([]string, error) {
+	manifestFile := manifestPath(backupFile)
+	if _, err := os.Stat(manifestFile); err != nil {
+		manifestFile = ""
+	}
+	switch {
+	case e.RemoteName == "" && m.config.UntrustedRemote:
+		return nil, fmt.Errorf("uploaded before UntrustedRemote was enabled")
+	case !e.Encrypted && e.RemoteName == "" && m.config.EncryptBackups:
+		return nil, fmt.Errorf("uploaded before EncryptBackups was enabled")
+	}
+
+	if isDirectoryDump(backupFile) {
+		tarball, err := tarDirectory(backupFile)
+		if err != nil {
+			return nil, err
+		}
+		backupFile = tarball
+	}
+
+	if e.RemoteName != "" {
+		blob := filepath.Join(filepath.Dir(backupFile), e.RemoteName)
+		err := m.encryptOpenPGP(backupFile, blob)
+		if isDumpTarball(backupFile) {
+			os.Remove(backupFile)
+		}
+		if err != nil {
+			return nil, err
+		}
+		files := []string{blob}
+		if e.RemoteManifest != "" && manifestFile != "" {
+			blob := filepath.Join(filepath.Dir(backupFile), e.RemoteManifest)
+			if err := m.encryptOpenPGP(manifestFile, blob); err != nil {
+				os.Remove(files[0])
+				return nil, err
+			}
+			files = append(files, blob)
+		}
+		return files, nil
+	}
+
+	signature := manifestFile + signatureSuffix
+	if e.Encrypted {
+		encrypted, err := m.encryptForUpload(backupFile)
+		if isDumpTarball(backupFile) {
+			os.Remove(backupFile)
+		}
+		if err != nil {
+			return nil, err
+		}
+		backupFile = encrypted
+		if manifestFile != "" {
+			if manifestFile, err = m.encryptForUpload(manifestFile); err != nil {
+				os.Remove(backupFile)
+				return nil, err
+			}
+		}
+	}
+
+	files := []string{backupFile}
+	if manifestFile != "" {
+		files = append(files, manifestFile)
+		if _, err := os.Stat(signature); err == nil {
+			files = append(files, signature)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	kindFull        = "full"
+	kindIncremental = "incremental"
+
+	defaultFullBackupEvery  = 6
+	defaultPhysicalChains   = 2
+	pgBackupManifestName    = "backup_manifest"
+	physicalBackupSubfolder = "physical"
+
+	physicalFormatPlain = "plain"
+	physicalFormatTar   = "tar"
+)
+
+func physicalBackupDir() string {
+	return filepath.Join(".", "backups", physicalBackupSubfolder)
+}
+
+// physicalBackupJob runs baseBackup through the backup queue, so it waits
+// for a running pg_dump (or, scheduled, is skipped per BackupOverlapPolicy)
+// and shows up in /api/jobs.
+func (m *Monitor) physicalBackupJob(trigger string) {
+	m.backupJob(BackupJob{Database: "physical", Trigger: trigger}, m.baseBackup)
+}
+
+// baseBackup takes a physical backup of the whole cluster with pg_basebackup.
+// With IncrementalBackups enabled (PostgreSQL 17+, summarize_wal = on) it
+// takes an incremental backup against the newest existing one until
+// FullBackupEvery increments have accumulated, then starts a new chain.
+func (m *Monitor) baseBackup() (entry CatalogEntry) {
+	m.baseBackupItem.SetTitle("Physical Backup (Running...)")
+	m.baseBackupItem.Disable()
+	defer func() {
+		m.baseBackupItem.SetTitle("Physical Backup")
+		m.baseBackupItem.Enable()
+	}()
+
+	backupDir := physicalBackupDir()
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		log.Printf("Failed to create backup directory: %v", err)
+		tray.SetTooltip(fmt.Sprintf("Failed to create backup directory: %v", err))
+		return
+	}
+
+	if err := m.checkBackupVolume(backupDir); err != nil {
+		log.Printf("Base backup failed: %v", err)
+		tray.SetTooltip("Physical backup refused: backup directory is on the data volume")
+		return
+	}
+
+	source, err := m.selectBackupSource()
+	if err != nil {
+		log.Printf("Base backup failed: %v", err)
+		tray.SetTooltip(fmt.Sprintf("Physical backup failed: %v", err))
+		return
+	}
+
+	backups, err := listBaseBackups()
+	if err != nil {
+		log.Printf("Failed to read existing physical backups: %v", err)
+	}
+
+	tarFormat := m.config.PhysicalBackupFormat == physicalFormatTar
+	kind := kindFull
+	var parent BackupManifest
+	if m.config.IncrementalBackups && tarFormat {
+		// pg_combinebackup only reads plain-format backups
+		log.Printf("IncrementalBackups ignored: tar-format physical backups are always full")
+	} else if m.config.IncrementalBackups {
+		// Only a plain-format backup of the same server can be a parent: a
+		// tar one (taken before PhysicalBackupFormat changed) is unreadable
+		// to pg_combinebackup, another host's summaries don't match
+		var chain []BackupManifest
+		for _, b := range backups {
+			if b.Host == source.Host && !isTarBaseBackup(filepath.Join(backupDir, b.File)) {
+				chain = append(chain, b)
+			}
+		}
+		if len(chain) > 0 && incrementsSinceFull(chain) < m.fullBackupEvery() {
+			parent = chain[len(chain)-1]
+			kind = kindIncremental
+		}
+	}
+
+	timestamp := time.Now().Format("20060102_150405")
+	name := fmt.Sprintf("vindija-bl_base_%s_%s", kind, timestamp)
+	target := filepath.Join(backupDir, name)
+
+	args := []string{
+		"-h", source.Host,
+		"-p", fmt.Sprintf("%d", source.Port),
+		"-U", m.config.User,
+		"-D", target,
+		"-X", "stream",
+	}
+	if tarFormat {
+		// base.tar.gz (plus one per tablespace) and pg_wal.tar.gz with the WAL
+		// needed to make the backup consistent
+		args = append(args, "-Ft", "-z")
+	} else {
+		args = append(args, "-Fp")
+	}
+	if kind == kindIncremental {
+		args = append(args, "--incremental="+filepath.Join(backupDir, parent.File, pgBackupManifestName))
+		log.Printf("Starting incremental base backup to: %s (parent %s)", target, parent.File)
+	} else {
+		log.Printf("Starting full base backup to: %s", target)
+	}
+	tray.SetTooltip("Creating physical backup...")
+
+	entry = CatalogEntry{File: name, Database: "physical " + kind, Kind: "physical", Host: source.Host, Started: time.Now()}
+	defer func() {
+		entry.Finished = time.Now()
+		entry.Status = m.lastBackupStatus
+		m.catalogAdd(entry)
+		m.runPostBackupCommand(entry)
+	}()
+
+	if err := m.runPreBackupCommand(entry); err != nil {
+		log.Printf("Base backup cancelled: %v", err)
+		tray.SetTooltip("Physical backup cancelled: pre-backup command failed")
+		m.lastBackupStatus = "Failed (pre-backup command)"
+		m.updateBackupStatus()
+		m.notifyBackup(false, "physical", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, pgTool("pg_basebackup"), args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", m.config.Password))
+
+	window := m.watchBackupWindow(cancel, cmd, "physical", m.config.BackupWindowMinutes)
+	output, err := cmd.CombinedOutput()
+	window.Stop()
+	entry.Overrun = window.Overrun()
+	if err != nil {
+		log.Printf("Base backup failed: %v\nOutput: %s", err, string(output))
+		tray.SetTooltip("Physical backup failed - check logs")
+		os.RemoveAll(target)
+		m.lastBackupStatus = "Failed"
+		m.updateBackupStatus()
+		m.notifyBackup(false, "physical", fmt.Sprintf("pg_basebackup: %v", err))
+		return
+	}
+
+	size, _ := dirSize(target)
+	entry.Size = size
+	entry.Success = true
+	manifest := BackupManifest{
+		Version:      manifestFormatVersion,
+		File:         name,
+		Size:         size,
+		AllDatabases: true,
+		Host:         source.Host,
+		Standby:      source.Standby,
+		CreatedAt:    time.Now(),
+		Kind:         kind,
+		Server:       m.serverSnapshot(source, m.config.DBName, true),
+	}
+	if kind == kindIncremental {
+		manifest.Parent = parent.File
+	}
+	if _, err := m.saveManifest(target, manifest); err != nil {
+		log.Printf("Failed to write manifest: %v", err)
+	}
+
+	sizeMB := float64(size) / 1024.0 / 1024.0
+	log.Printf("Base backup completed: %s (%.2f MB, %s)", target, sizeMB, kind)
+	tray.SetTooltip(fmt.Sprintf("Physical backup complete: %.2f MB (%s)", sizeMB, kind))
+	m.lastBackupTime = time.Now()
+	m.lastBackupStatus = fmt.Sprintf("%.2f MB %s", sizeMB, kind)
+	m.updateBackupStatus()
+	m.notifyBackup(true, "physical", fmt.Sprintf("%s: %s", name, m.lastBackupStatus))
+
+	m.prunePhysicalBackups()
+	return entry
+}
+
+// physicalScheduleLoop takes a physical backup at PhysicalBackupTime, daily
+// or on PhysicalBackupWeekday only, independently of the logical schedule.
+func (m *Monitor) physicalScheduleLoop() {
+	log.Printf("Scheduled physical backups enabled at %s", m.config.PhysicalBackupTime)
+	if day := m.config.PhysicalBackupWeekday; day != "" {
+		if _, ok := parseWeekday(day); !ok {
+			m.configError("invalid PhysicalBackupWeekday %q, backing up daily", day)
+		}
+	}
+	for {
+		next := m.nextPhysicalBackup(time.Now())
+		late, jumped := waitUntil(next)
+		if jumped {
+			continue
+		}
+
+		switch {
+		case !m.runMissed("scheduled physical backup", late):
+		case m.autoBackupPaused():
+			log.Printf("Scheduled physical backup skipped: auto backups paused")
+		case !m.waitForPreconditions(schedulePhysical):
+		default:
+			log.Printf("Running scheduled physical backup...")
+			m.physicalBackupJob(triggerPhysicalScheduled)
+		}
+	}
+}
+
+func (m *Monitor) nextPhysicalBackup(from time.Time) time.Time {
+	at, err := time.Parse("15:04", m.config.PhysicalBackupTime)
+	if err != nil {
+		log.Printf("Invalid PhysicalBackupTime: %v, using 01:00", err)
+		at, _ = time.Parse("15:04", "01:00")
+	}
+
+	weekday, weekly := parseWeekday(m.config.PhysicalBackupWeekday)
+	next := time.Date(from.Year(), from.Month(), from.Day(), at.Hour(), at.Minute(), 0, 0, from.Location())
+	for !next.After(from) || (weekly && next.Weekday() != weekday) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// parseWeekday reads a weekday setting such as "Sunday", in any case.
+func parseWeekday(s string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), s) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+func (m *Monitor) fullBackupEvery() int {
+	if m.config.FullBackupEvery > 0 {
+		return m.config.FullBackupEvery
+	}
+	return defaultFullBackupEvery
+}
+
+// listBaseBackups returns the manifests of all physical backups, oldest first.
+func listBaseBackups() ([]BackupManifest, error) {
+	entries, err := os.ReadDir(physicalBackupDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var backups []BackupManifest
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		manifest, err := readManifest(filepath.Join(physicalBackupDir(), entry.Name()))
+		if err != nil {
+			log.Printf("Skipping %s: no readable manifest (%v)", entry.Name(), err)
+			continue
+		}
+		backups = append(backups, manifest)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.Before(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+func isTarBaseBackup(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "base.tar.gz"))
+	return err == nil
+}
+
+func incrementsSinceFull(backups []BackupManifest) int {
+	count := 0
+	for i := len(backups) - 1; i >= 0; i-- {
+		if backups[i].Kind != kindIncremental {
+			break
+		}
+		count++
+	}
+	return count
+}
+
+// backupChain resolves target back to its full backup and returns the chain
+// in restore order (full first).
+func backupChain(backups []BackupManifest, target string) ([]BackupManifest, error) {
+	byName := make(map[string]BackupManifest)
+	for _, b := range backups {
+		byName[b.File] = b
+	}
+
+	var chain []BackupManifest
+	for name := target; name != ""; {
+		b, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("backup %s is missing from the chain", name)
+		}
+		chain = append([]BackupManifest{b}, chain...)
+		if len(chain) > len(backups) {
+			return nil, fmt.Errorf("backup chain for %s is cyclic", target)
+		}
+		name = b.Parent
+	}
+
+	if chain[0].Kind != kindFull {
+		return nil, fmt.Errorf("backup chain for %s does not start with a full backup", target)
+	}
+	return chain, nil
+}
+
+// physicalPruneCandidates keeps the newest keepChains full backups and every
+// increment built on them. A backup referenced (directly or transitively) by
+// a kept or held backup is never returned, even if it belongs to an older
+// chain.
+func physicalPruneCandidates(backups []BackupManifest, keepChains int, held map[string]bool) []BackupManifest {
+	var fulls []string
+	for _, b := range backups {
+		if b.Kind == kindFull {
+			fulls = append(fulls, b.File)
+		}
+	}
+	if len(fulls) <= keepChains {
+		return nil
+	}
+
+	keptFulls := make(map[string]bool)
+	for _, name := range fulls[len(fulls)-keepChains:] {
+		keptFulls[name] = true
+	}
+
+	keep := make(map[string]bool)
+	for _, b := range backups {
+		chain, err := backupChain(backups, b.File)
+		if err != nil {
+			// Broken chains are left alone for manual inspection
+			keep[b.File] = true
+			continue
+		}
+		if keptFulls[chain[0].File] || held[b.File] {
+			for _, link := range chain {
+				keep[link.File] = true
+			}
+		}
+	}
+
+	var prune []BackupManifest
+	for _, b := range backups {
+		if !keep[b.File] {
+			prune = append(prune, b)
+		}
+	}
+	return prune
+}
+
+func (m *Monitor) prunePhysicalBackups() {
+	keepChains := m.config.PhysicalRetentionChains
+	if keepChains <= 0 {
+		keepChains = defaultPhysicalChains
+	}
+
+	backups, err := listBaseBackups()
+	if err != nil {
+		log.Printf("Physical retention skipped: %v", err)
+		return
+	}
+
+	held, err := heldBackups()
+	if err != nil {
+		log.Printf("Physical retention skipped: catalog unreadable, holds unknown: %v", err)
+		return
+	}
+
+	for _, b := range physicalPruneCandidates(backups, keepChains, held) {
+		path := filepath.Join(physicalBackupDir(), b.File)
+		log.Printf("Pruning physical backup %s (%s)", b.File, b.Kind)
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("Failed to prune %s: %v", path, err)
+			continue
+		}
+		os.Remove(manifestPath(path))
+		os.Remove(manifestPath(path) + signatureSuffix)
+		m.markDeleted(b.File)
+	}
+}
+
+// combineBaseBackup reconstructs a restorable data directory from target and
+// its ancestors using pg_combinebackup. Full backups are simply copied;
+// tar-format backups are restored by extracting their tarballs instead.
+func (m *Monitor) combineBaseBackup(target, outputDir string) error {
+	// Tar-format backups are always full, so there is nothing to combine
+	dir := filepath.Join(physicalBackupDir(), filepath.Base(target))
+	if isTarBaseBackup(dir) {
+		log.Printf("Extracting %s into %s", filepath.Base(target), outputDir)
+		return extractBaseTar(dir, outputDir)
+	}
+
+	backups, err := listBaseBackups()
+	if err != nil {
+		return err
+	}
+
+	chain, err := backupChain(backups, filepath.Base(target))
+	if err != nil {
+		return err
+	}
+
+	var args []string
+	for _, b := range chain {
+		args = append(args, filepath.Join(physicalBackupDir(), b.File))
+	}
+	args = append(args, "-o", outputDir)
+
+	log.Printf("Combining %d backups into %s", len(chain), outputDir)
+	output, err := exec.Command(pgTool("pg_combinebackup"), args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("pg_combinebackup failed: %v, output: %s", err, string(output))
+	}
+	return nil
+}
+
+func (m *Monitor) verifyBaseBackup(dir string) error {
+	output, err := exec.Command(pgTool("pg_verifybackup"), dir).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("pg_verifybackup failed: %v, output: %s", err, string(output))
+	}
+	return nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	treemapWidth  = 900
+	treemapHeight = 320
+)
+
+var treemapColors = []string{"#4e79a7", "#f28e2b", "#59a14f", "#e15759", "#76b7b2", "#edc948", "#b07aa1", "#9c755f"}
+
+type browserBackup struct {
+	File       string
+	Kind       string
+	Size       int64
+	Finished   time.Time
+	Hold       bool
+	HoldReason string
+}
+
+type browserMonth struct {
+	Month   string
+	Size    int64
+	Backups []browserBackup
+}
+
+type browserGroup struct {
+	Database string
+	Size     int64
+	Months   []*browserMonth
+}
+
+type treemapRect struct {
+	X, Y, W, H float64
+	Color      string
+	Label      string
+	Title      string
+}
+
+type browserView struct {
+	Groups  []*browserGroup
+	Total   int64
+	Treemap []treemapRect
+	Width   int
+	Height  int
+	Results []string
+	Chains  []BackupChain
+}
+
+var browserPage = template.Must(template.New("browser").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+	"time":  func(t time.Time) string { return t.Format("2006-01-02 15:04") },
+	"indent": func(depth int) string {
+		if depth == 0 {
+			return ""
+		}
+		return strings.Repeat("\u00a0\u00a0", depth-1) + "\u2514 "
+	},
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Backups</title>
+<style>body{font-family:sans-serif;margin:1em}table{border-collapse:collapse}td,th{padding:2px 8px;text-align:left}
+td.n{text-align:right}tr.m td{background:#eee;font-weight:bold}.hold{color:#b00}</style></head>
+<body><h3>Retained backups: {{bytes .Total}}</h3>
+{{range .Results}}<p>{{.}}</p>{{end}}
+<svg width="{{.Width}}" height="{{.Height}}" style="font-size:11px">
+{{range .Treemap}}<g><title>{{.Title}}</title><rect x="{{.X}}" y="{{.Y}}" width="{{.W}}" height="{{.H}}" fill="{{.Color}}" stroke="#fff"/>
+{{if .Label}}<text x="{{.X}}" y="{{.Y}}" dx="4" dy="14" fill="#fff">{{.Label}}</text>{{end}}</g>
+{{end}}</svg>
+<form method="post">
+{{range .Groups}}<h4>{{.Database}} - {{bytes .Size}}</h4>
+<table>{{range .Months}}<tr class="m"><td></td><td>{{.Month}}</td><td class="n">{{bytes .Size}}</td><td></td></tr>
+{{range .Backups}}<tr><td>{{if .Hold}}<span class="hold" title="{{.HoldReason}}">hold</span>{{else}}<input type="checkbox" name="file" value="{{.File}}">{{end}}</td>
+<td>{{.File}}</td><td class="n">{{bytes .Size}}</td><td>{{time .Finished}}</td></tr>
+{{end}}{{end}}</table>
+{{end}}
+<p>Type the file name (or, for several backups, their number) to confirm:
+<input name="confirm" autocomplete="off"> <button type="submit">Delete selected</button></p>
+</form>
+{{if .Chains}}<h3>Physical backup chains</h3>
+<p>Each backup is a restore point; <code>-combine</code> rebuilds it from the full backup and the increments above it.
+Retention never removes a backup that a kept or held backup depends on.</p>
+{{range .Chains}}<h4>{{.Full}}{{if .Broken}} <span class="hold">broken: {{.Broken}}</span>{{end}}</h4>
+<table><tr><th>Backup</th><th>Kind</th><th class="n">Size</th><th>Restore point</th><th></th></tr>
+{{range .Links}}<tr><td>{{indent .Depth}}{{.File}}</td><td>{{.Kind}}</td><td class="n">{{bytes .Size}}</td>
+<td>{{time .CreatedAt}}</td><td>{{if .Restorable}}restorable{{else}}<span class="hold">missing parent {{.Parent}}</span>{{end}}
+{{if .Hold}} <span class="hold">hold</span>{{end}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}</body></html>`))
+
+// handleBackupBrowser lists retained backups by database and month with a
+// treemap of their sizes; selected backups are deleted through deleteBackup,
+// so legal holds are respected and every delete is audited.
+func (m *Monitor) handleBackupBrowser(w http.ResponseWriter, r *http.Request) {
+	var view browserView
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		files := r.Form["file"]
+		target := strconv.Itoa(len(files))
+		if len(files) == 1 {
+			target = files[0]
+		}
+		if len(files) > 0 {
+			if err := m.confirmDestructive(localActor(), "delete_backup", target, r.FormValue("confirm")); err != nil {
+				view.Results = append(view.Results, err.Error())
+				files = nil
+			}
+		}
+		for _, file := range files {
+			if err := m.deleteBackup(file, localActor()); err != nil {
+				view.Results = append(view.Results, fmt.Sprintf("%s: %v", file, err))
+			} else {
+				view.Results = append(view.Results, fmt.Sprintf("%s deleted", file))
+			}
+		}
+	}
+
+	groups, err := retainedBackups()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	view.Groups = groups
+	for _, g := range groups {
+		view.Total += g.Size
+	}
+	view.Width, view.Height = treemapWidth, treemapHeight
+	view.Treemap = treemap(groups, view.Total)
+	if chains, err := physicalChains(); err == nil {
+		view.Chains = chains
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	browserPage.Execute(w, view)
+}
+
+// retainedBackups groups the catalog's existing backup files by database and
+// month, largest database first.
+func retainedBackups() ([]*browserGroup, error) {
+	entries, err := loadCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	byDB := make(map[string]*browserGroup)
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if !e.Success || e.Deleted || e.File == "" || seen[e.File] {
+			continue
+		}
+		path := filepath.Join(".", "backups", e.File)
+		info, err := os.Stat(path)
+		if err != nil || (info.IsDir() && !isDirectoryDump(e.File)) {
+			continue
+		}
+		size, err := backupSize(path)
+		if err != nil {
+			continue
+		}
+		seen[e.File] = true
+
+		g := byDB[e.Database]
+		if g == nil {
+			g = &browserGroup{Database: e.Database}
+			byDB[e.Database] = g
+		}
+		month := e.Finished.Format("2006-01")
+		var mo *browserMonth
+		for _, x := range g.Months {
+			if x.Month == month {
+				mo = x
+			}
+		}
+		if mo == nil {
+			mo = &browserMonth{Month: month}
+			g.Months = append(g.Months, mo)
+		}
+		mo.Backups = append(mo.Backups, browserBackup{
+			File: e.File, Kind: e.Kind, Size: size, Finished: e.Finished,
+			Hold: e.LegalHold, HoldReason: e.HoldReason,
+		})
+		mo.Size += size
+		g.Size += size
+	}
+
+	var groups []*browserGroup
+	for _, g := range byDB {
+		sort.Slice(g.Months, func(i, j int) bool { return g.Months[i].Month > g.Months[j].Month })
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Size > groups[j].Size })
+	return groups, nil
+}
+
+// treemap lays out databases as columns proportional to their size, each
+// split into rows per month (slice-and-dice).
+func treemap(groups []*browserGroup, total int64) []treemapRect {
+	if total == 0 {
+		return nil
+	}
+	var rects []treemapRect
+	x := 0.0
+	for i, g := range groups {
+		w := float64(g.Size) / float64(total) * treemapWidth
+		y := 0.0
+		for _, mo := range g.Months {
+			h := float64(mo.Size) / float64(g.Size) * treemapHeight
+			rect := treemapRect{
+				X: x, Y: y, W: w, H: h,
+				Color: treemapColors[i%len(treemapColors)],
+				Title: fmt.Sprintf("%s %s: %s in %d backup(s)", g.Database, mo.Month, formatBytes(mo.Size), len(mo.Backups)),
+			}
+			if w > 60 && h > 18 {
+				rect.Label = fmt.Sprintf("%s %s", g.Database, mo.Month)
+			}
+			rects = append(rects, rect)
+			y += h
+		}
+		x += w
+	}
+	return rects
+}
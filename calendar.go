@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	calendarHorizon        = 14 * 24 * time.Hour
+	defaultBackupEventTime = 30 * time.Minute
+	icsTimeFormat          = "20060102T150405Z"
+)
+
+// BackupOccurrence is one upcoming scheduled backup.
+type BackupOccurrence struct {
+	Database  string
+	Frequency string
+	Start     time.Time
+	End       time.Time // start plus the backup window, or the last run's duration
+}
+
+// upcomingBackups computes the scheduled backups between now and until, the
+// same way scheduleBackups and autoScheduleLoop pick their next run.
+func (m *Monitor) upcomingBackups(until time.Time) []BackupOccurrence {
+	if !m.config.AutoBackupEnabled {
+		return nil
+	}
+
+	now := time.Now()
+	durations := lastBackupDurations()
+	var occurrences []BackupOccurrence
+	add := func(database, frequency string, start time.Time) {
+		d := time.Duration(m.backupWindowMinutes(database)) * time.Minute
+		if d <= 0 {
+			d = durations[database]
+		}
+		if d < defaultBackupEventTime {
+			d = defaultBackupEventTime
+		}
+		occurrences = append(occurrences, BackupOccurrence{Database: database, Frequency: frequency, Start: start, End: start.Add(d)})
+	}
+
+	if !m.config.AutoSchedule {
+		databases := []string{m.config.DBName}
+		if len(m.config.Databases) > 0 {
+			databases = m.config.Databases
+		} else if m.config.AutoBackupAll {
+			databases = []string{"all databases"}
+		}
+		for next := m.calculateNextBackupTime(now); next.Before(until); next = m.calculateNextBackupTime(next) {
+			for _, database := range databases {
+				add(database, freqDaily, next)
+			}
+		}
+		return occurrences
+	}
+
+	lastRuns := lastSuccessfulBackups()
+	for _, d := range loadSchedulePlan().Databases {
+		last := lastRuns[d.Database]
+		if last.IsZero() {
+			last = now
+		}
+		for next := m.nextAutoRun(d.Frequency, last); !next.IsZero() && next.Before(until); next = m.nextAutoRun(d.Frequency, next) {
+			if next.Before(now) {
+				next = now
+			}
+			add(d.Database, d.Frequency, next)
+		}
+	}
+	sort.Slice(occurrences, func(i, j int) bool { return occurrences[i].Start.Before(occurrences[j].Start) })
+	return occurrences
+}
+
+// lastBackupDurations returns how long the latest successful backup of each
+// database took, as an estimate for the calendar.
+func lastBackupDurations() map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	entries, err := loadCatalog()
+	if err != nil {
+		return durations
+	}
+	for _, e := range entries {
+		if e.Success {
+			durations[e.Database] = e.Duration()
+		}
+	}
+	return durations
+}
+
+// scheduleICS renders the upcoming backups as an iCalendar file.
+func (m *Monitor) scheduleICS() string {
+	var b strings.Builder
+	line := func(s string) {
+		// Fold lines longer than 75 octets (RFC 5545 3.1)
+		for len(s) > 75 {
+			b.WriteString(s[:75] + "\r\n")
+			s = " " + s[75:]
+		}
+		b.WriteString(s + "\r\n")
+	}
+
+	stamp := time.Now().UTC().Format(icsTimeFormat)
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//pg-monitor//backup schedule//EN")
+	line("X-WR-CALNAME:" + icsEscape("PostgreSQL backups "+m.config.Host))
+	for _, o := range m.upcomingBackups(time.Now().Add(calendarHorizon)) {
+		line("BEGIN:VEVENT")
+		line(fmt.Sprintf("UID:%d-%s@%s.pg-monitor", o.Start.Unix(), strings.ReplaceAll(o.Database, " ", "_"), m.config.Host))
+		line("DTSTAMP:" + stamp)
+		line("DTSTART:" + o.Start.UTC().Format(icsTimeFormat))
+		line("DTEND:" + o.End.UTC().Format(icsTimeFormat))
+		line("SUMMARY:" + icsEscape(fmt.Sprintf("Backup %s on %s", o.Database, m.config.Host)))
+		line("DESCRIPTION:" + icsEscape(fmt.Sprintf("%s backup of %s on %s:%d", o.Frequency, o.Database, m.config.Host, m.config.Port)))
+		line("CATEGORIES:Backup")
+		line("TRANSP:TRANSPARENT")
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return b.String()
+}
+
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+func (m *Monitor) writeScheduleICS(file string) error {
+	return os.WriteFile(file, []byte(m.scheduleICS()), 0644)
+}
+
+func (m *Monitor) handleScheduleICS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write([]byte(m.scheduleICS()))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const catalogFile = "backup-catalog.json"
+
+// CatalogEntry records one backup run, successful or not.
+type CatalogEntry struct {
+	File     string
+	Database string
+	Kind     string // "database", "cluster" or "physical"
+	Label    string `json:",omitempty"` // set by the caller of a triggered backup
+	Scope    string `json:",omitempty"` // schemas/tables of a partial dump
+	Host     string
+	Started  time.Time
+	Finished time.Time
+	Size     int64
+	SHA256   string `json:",omitempty"` // checksum from the manifest
+	Success  bool
+	Status   string
+	Overrun  bool `json:",omitempty"` // ran past its backup window
+
+	ConsistencyGroup string `json:",omitempty"` // consistency group run this dump belongs to
+
+	// Opaque object names on an untrusted destination; the catalog is the
+	// only place mapping them back to this backup.
+	RemoteName     string `json:",omitempty"`
+	RemoteManifest string `json:",omitempty"`
+
+	Uploaded     []string          `json:",omitempty"` // destinations holding a copy
+	UploadErrors map[string]string `json:",omitempty"` // destination -> why its upload failed, until a retry succeeds
+	Encrypted    bool              `json:",omitempty"` // uploaded as AES-256-GCM .enc files
+
+	LegalHold  bool      `json:",omitempty"` // retention and delete refuse to touch this backup
+	HoldReason string    `json:",omitempty"`
+	HoldSince  time.Time `json:",omitempty"`
+	Deleted    bool      `json:",omitempty"` // file removed by retention or manual delete
+
+	Verified    bool      `json:",omitempty"` // restored into a scratch database and sanity-checked
+	VerifiedAt  time.Time `json:",omitempty"`
+	VerifyError string    `json:",omitempty"`
+}
+
+func (e CatalogEntry) Duration() time.Duration {
+	return e.Finished.Sub(e.Started)
+}
+
+var catalogMu sync.Mutex
+
+func loadCatalog() ([]CatalogEntry, error) {
+	var entries []CatalogEntry
+
+	data, err := os.ReadFile(catalogFile)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &entries)
+	return entries, err
+}
+
+func saveCatalog(entries []CatalogEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated catalog
+	tmp := catalogFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, catalogFile)
+}
+
+// updateCatalog applies fn to the catalog under the catalog lock and saves
+// the result.
+func updateCatalog(fn func(entries []CatalogEntry) ([]CatalogEntry, error)) error {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+
+	entries, err := loadCatalog()
+	if err != nil {
+		return err
+	}
+
+	entries, err = fn(entries)
+	if err != nil {
+		return err
+	}
+	return saveCatalog(entries)
+}
+
+func (m *Monitor) catalogAdd(entry CatalogEntry) {
+	err := updateCatalog(func(entries []CatalogEntry) ([]CatalogEntry, error) {
+		return append(entries, entry), nil
+	})
+	if err != nil {
+		log.Printf("Failed to record %s in catalog: %v", entry.File, err)
+		return
+	}
+	m.updateHistoryMenu()
+	m.updateDestinationsMenu()
+
+	m.exportPoints(backupPoint(entry))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	catalogSyncObject          = "pg-monitor-catalog.json"
+	defaultCatalogSyncInterval = 60 * time.Minute
+	catalogRecoverTimeout      = 5 * time.Minute
+)
+
+// catalogSyncLoop uploads the catalog to CatalogSyncDestination at most every
+// CatalogSyncMinutes, and only when it changed, so a rebuilt backup host can
+// learn what exists remotely - including the opaque object names that are
+// recorded nowhere else.
+func (m *Monitor) catalogSyncLoop() {
+	interval := time.Duration(m.config.CatalogSyncMinutes) * time.Minute
+	if interval <= 0 {
+		interval = defaultCatalogSyncInterval
+	}
+
+	var synced time.Time
+	for {
+		info, err := os.Stat(catalogFile)
+		if err == nil && info.ModTime().After(synced) {
+			if err := m.syncCatalog(); err != nil {
+				log.Printf("Catalog sync to %s failed: %v", m.config.CatalogSyncDestination, err)
+			} else {
+				synced = info.ModTime()
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// syncCatalog encrypts the catalog with the upload key (EncryptBackups) or
+// OpenPGP and uploads it; it is never uploaded in the clear.
+func (m *Monitor) syncCatalog() error {
+	d, ok := m.destination(m.config.CatalogSyncDestination)
+	if !ok {
+		return fmt.Errorf("unknown destination %q", m.config.CatalogSyncDestination)
+	}
+
+	// The object keeps its name on the remote, so it is written under that
+	// name locally and removed after the upload
+	var encrypted string
+	switch {
+	case m.config.EncryptionKey != "" || m.config.EncryptionKeyring != "":
+		key, err := m.encryptionKey()
+		if err != nil {
+			return err
+		}
+		encrypted = catalogSyncObject + encryptedExt
+		if err := encryptFile(catalogFile, encrypted, key); err != nil {
+			os.Remove(encrypted)
+			return err
+		}
+	case m.config.PGPRecipient != "" || m.config.PGPPassphraseFile != "":
+		encrypted = catalogSyncObject + pgpExt
+		if err := m.encryptOpenPGP(catalogFile, encrypted); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("catalog sync requires EncryptionKey/EncryptionKeyring or PGPRecipient/PGPPassphraseFile")
+	}
+	defer os.Remove(encrypted)
+
+	if err := m.uploadRateLimited(d, encrypted, m.config.BackfillLimitRate); err != nil {
+		return err
+	}
+	log.Printf("Catalog synced to %s", d.Name)
+	return nil
+}
+
+// recoverCatalog downloads the synced catalog from a destination for
+// -recover-catalog and merges it into the local one; entries already present
+// locally are kept as they are.
+func (m *Monitor) recoverCatalog(dest string) (int, error) {
+	d, ok := m.destination(dest)
+	if !ok {
+		return 0, fmt.Errorf("unknown destination %q", dest)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), catalogRecoverTimeout)
+	defer cancel()
+
+	var data []byte
+	body, _, err := openRemote(ctx, d, catalogSyncObject+encryptedExt)
+	if err == nil {
+		key, keyErr := m.encryptionKey()
+		if keyErr != nil {
+			body.Close()
+			return 0, keyErr
+		}
+		plain := decryptingReader(body, key)
+		data, err = io.ReadAll(plain)
+		plain.Close()
+		body.Close()
+	} else if os.IsNotExist(err) {
+		body, _, err = openRemote(ctx, d, catalogSyncObject+pgpExt)
+		if err != nil {
+			return 0, err
+		}
+		var plain io.ReadCloser
+		if plain, err = m.openPGPReader(body); err == nil {
+			data, err = io.ReadAll(plain)
+			if closeErr := plain.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		body.Close()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var remote []CatalogEntry
+	if err := json.Unmarshal(data, &remote); err != nil {
+		return 0, fmt.Errorf("synced catalog unreadable: %v", err)
+	}
+
+	added := 0
+	err = updateCatalog(func(entries []CatalogEntry) ([]CatalogEntry, error) {
+		have := make(map[string]bool)
+		for _, e := range entries {
+			have[e.File+"\x00"+e.Started.String()] = true
+		}
+		for _, e := range remote {
+			if !have[e.File+"\x00"+e.Started.String()] {
+				entries = append(entries, e)
+				added++
+			}
+		}
+		return entries, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	m.audit(localActor(), "catalog_recovered", dest, fmt.Sprintf("%d entries added", added))
+	return added, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// BackupChain is a full physical backup and the increments built on it. Each
+// link is a restore point: pg_combinebackup can rebuild the cluster as of its
+// CreatedAt from the links up to and including it.
+type BackupChain struct {
+	Full   string
+	Links  []ChainLink
+	Broken string `json:",omitempty"` // why links of this chain cannot be restored
+}
+
+type ChainLink struct {
+	File       string
+	Kind       string
+	Parent     string `json:",omitempty"`
+	CreatedAt  time.Time
+	Size       int64
+	Depth      int  // 0 for the full backup, n for the n-th increment
+	Restorable bool // every ancestor is present
+	Hold       bool `json:",omitempty"`
+}
+
+// backupChains groups physical backups into chains, newest chain first.
+// Backups whose chain cannot be resolved are collected in chains marked
+// Broken rather than dropped, so a gap is visible.
+func backupChains(backups []BackupManifest, held map[string]bool) []BackupChain {
+	byFull := make(map[string]*BackupChain)
+	var order []string
+	for _, b := range backups {
+		link := ChainLink{
+			File: b.File, Kind: b.Kind, Parent: b.Parent, CreatedAt: b.CreatedAt,
+			Size: b.Size, Hold: held[b.File],
+		}
+
+		root := b.File
+		chain, err := backupChain(backups, b.File)
+		if err == nil {
+			root = chain[0].File
+			link.Depth = len(chain) - 1
+			link.Restorable = true
+		}
+
+		c := byFull[root]
+		if c == nil {
+			c = &BackupChain{Full: root}
+			byFull[root] = c
+			order = append(order, root)
+		}
+		if err != nil {
+			c.Broken = err.Error()
+		}
+		c.Links = append(c.Links, link)
+	}
+
+	var chains []BackupChain
+	for i := len(order) - 1; i >= 0; i-- {
+		chains = append(chains, *byFull[order[i]])
+	}
+	return chains
+}
+
+// heldBackups returns the catalog's files on legal hold.
+func heldBackups() (map[string]bool, error) {
+	entries, err := loadCatalog()
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[string]bool)
+	for _, e := range entries {
+		if e.LegalHold {
+			held[e.File] = true
+		}
+	}
+	return held, nil
+}
+
+func physicalChains() ([]BackupChain, error) {
+	backups, err := listBaseBackups()
+	if err != nil {
+		return nil, err
+	}
+	held, err := heldBackups()
+	if err != nil {
+		return nil, err
+	}
+	return backupChains(backups, held), nil
+}
+
+func (m *Monitor) handleChains(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	chains, err := physicalChains()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, chains)
+}
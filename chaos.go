@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Failure injection for testing alerting, retries and the catalog without
+// breaking anything real. Enabled only through the environment, e.g.
+//
+//	PG_MONITOR_CHAOS=dump,upload:0.5
+//
+// where each stage may carry a probability (default 1).
+const (
+	chaosEnv = "PG_MONITOR_CHAOS"
+
+	chaosDump     = "dump"     // pg_dump/pg_dumpall fails
+	chaosDiskFull = "diskfull" // writing the dump runs out of space
+	chaosUpload   = "upload"   // upload times out
+	chaosChecksum = "checksum" // manifest records a checksum that doesn't match
+)
+
+var chaosStages = parseChaos(os.Getenv(chaosEnv))
+
+func parseChaos(spec string) map[string]float64 {
+	stages := make(map[string]float64)
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(strings.ToLower(s))
+		if s == "" {
+			continue
+		}
+		p := 1.0
+		if i := strings.Index(s, ":"); i >= 0 {
+			if v, err := strconv.ParseFloat(s[i+1:], 64); err == nil {
+				p = v
+			}
+			s = s[:i]
+		}
+		switch s {
+		case chaosDump, chaosDiskFull, chaosUpload, chaosChecksum:
+			stages[s] = p
+		default:
+			log.Printf("%s: unknown stage %q ignored", chaosEnv, s)
+		}
+	}
+	return stages
+}
+
+// chaos reports whether a failure should be injected at stage.
+func chaos(stage string) bool {
+	p, ok := chaosStages[stage]
+	if !ok || rand.Float64() >= p {
+		return false
+	}
+	log.Printf("CHAOS: injecting %s failure", stage)
+	return true
+}
+
+// chaosError returns the injected error for stage, or nil.
+func chaosError(stage string) error {
+	if !chaos(stage) {
+		return nil
+	}
+	switch stage {
+	case chaosDiskFull:
+		return fmt.Errorf("could not write to output file: %v (injected)", syscall.ENOSPC)
+	case chaosUpload:
+		return fmt.Errorf("curl failed: exit status 28, output: Operation timed out (injected)")
+	default:
+		return fmt.Errorf("%s failed: exit status 1 (injected)", stage)
+	}
+}
+
+func logChaosMode() {
+	if len(chaosStages) == 0 {
+		return
+	}
+	var stages []string
+	for s, p := range chaosStages {
+		stages = append(stages, fmt.Sprintf("%s:%g", s, p))
+	}
+	log.Printf("CHAOS MODE: failures will be injected (%s)", strings.Join(stages, ", "))
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	chunkStateFile      = "upload-chunks.json"
+	minUploadChunkMB    = 5 // Nextcloud's minimum for every chunk but the last
+	chunkRequestTimeout = 30 * time.Minute
+	nextcloudFilesPath  = "/remote.php/dav/files/"
+)
+
+// ChunkedTransfer is the progress of one chunked upload, kept across
+// restarts so a retry continues with the first chunk not yet uploaded.
+type ChunkedTransfer struct {
+	TransferID string
+	Size       int64
+	ModTime    time.Time
+	ChunkSize  int64
+	Done       int // chunks uploaded
+	Started    time.Time
+
+	UploadID   string   `json:",omitempty"` // S3 multipart upload or B2 large file
+	ETags      []string `json:",omitempty"` // of the S3 parts (SHA-1s of the B2 parts) uploaded, in order
+	SessionURI string   `json:",omitempty"` // GCS resumable upload session
+}
+
+var chunkStateMu sync.Mutex
+
+func loadChunkState() map[string]ChunkedTransfer {
+	state := make(map[string]ChunkedTransfer)
+	if data, err := os.ReadFile(chunkStateFile); err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			log.Printf("Ignoring unreadable %s: %v", chunkStateFile, err)
+		}
+	}
+	return state
+}
+
+func saveChunkState(state map[string]ChunkedTransfer) {
+	data, _ := json.MarshalIndent(state, "", "  ")
+	if err := os.WriteFile(chunkStateFile, data, 0644); err != nil {
+		log.Printf("Failed to write %s: %v", chunkStateFile, err)
+	}
+}
+
+// updateChunkState applies fn to the transfer stored under key; fn returning
+// false removes it.
+func updateChunkState(key string, fn func(t *ChunkedTransfer) bool) {
+	chunkStateMu.Lock()
+	defer chunkStateMu.Unlock()
+	state := loadChunkState()
+	t := state[key]
+	if fn(&t) {
+		state[key] = t
+	} else {
+		delete(state, key)
+	}
+	saveChunkState(state)
+}
+
+// nextcloudUploads returns the chunk upload collection of a Nextcloud
+// destination, derived from its files URL; ok is false for other WebDAV
+// servers.
+func nextcloudUploads(d Destination) (string, bool) {
+	i := strings.Index(d.URL, nextcloudFilesPath)
+	if i < 0 {
+		return "", false
+	}
+	user, _, _ := strings.Cut(d.URL[i+len(nextcloudFilesPath):], "/")
+	if user == "" {
+		return "", false
+	}
+	return d.URL[:i] + "/remote.php/dav/uploads/" + user + "/", true
+}
+
+func (m *Monitor) uploadChunkSize() int64 {
+	size := int64(m.config.UploadChunkMB)
+	if size > 0 && size < minUploadChunkMB {
+		size = minUploadChunkMB
+	}
+	return size * mb
+}
+
+// useChunkedUpload reports whether a file goes up in chunks: UploadChunkMB
+// is set, the file is larger than one chunk and the destination is Nextcloud.
+func (m *Monitor) useChunkedUpload(d Destination, size int64) bool {
+	chunk := m.uploadChunkSize()
+	if chunk <= 0 || size <= chunk {
+		return false
+	}
+	_, ok := nextcloudUploads(d)
+	return ok
+}
+
+// uploadChunked uploads a file with the Nextcloud chunking API: the chunks
+// are PUT into a temporary upload collection and assembled with a MOVE onto
+// the target. Each finished chunk is recorded in upload-chunks.json, so a
+// failed upload - retried from the spool, also after a restart - continues
+// where it stopped instead of starting over. A changed file or chunk size,
+// or an upload collection the server has already cleaned up, starts afresh.
+func (m *Monitor) uploadChunked(d Destination, filePath, rate string) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	uploads, _ := nextcloudUploads(d)
+	target := davURL(d, info.Name())
+	key := d.Name + " " + target
+	chunk := m.uploadChunkSize()
+
+	var t ChunkedTransfer
+	updateChunkState(key, func(s *ChunkedTransfer) bool {
+		if s.TransferID == "" || s.Size != info.Size() || !s.ModTime.Equal(info.ModTime()) || s.ChunkSize != chunk {
+			id := make([]byte, 16)
+			rand.Read(id)
+			*s = ChunkedTransfer{TransferID: "pg-monitor-" + hex.EncodeToString(id), Size: info.Size(), ModTime: info.ModTime(), ChunkSize: chunk, Started: time.Now()}
+		}
+		t = *s
+		return true
+	})
+	collection := uploads + t.TransferID + "/"
+	chunks := int((t.Size + chunk - 1) / chunk)
+
+	if t.Done == 0 {
+		if err := m.davRequest(d, "MKCOL", strings.TrimSuffix(collection, "/"), target, nil, 0); err != nil && !isStatus(err, http.StatusMethodNotAllowed) {
+			return err
+		}
+	} else {
+		log.Printf("Resuming upload of %s to %s at chunk %d of %d", info.Name(), d.Name, t.Done+1, chunks)
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for n := t.Done; n < chunks; n++ {
+		offset := int64(n) * chunk
+		length := min64(chunk, t.Size-offset)
+		body := m.chunkBody(io.NewSectionReader(f, offset, length), rate)
+		err := m.davRequest(d, http.MethodPut, fmt.Sprintf("%s%05d", collection, n+1), target, body, length)
+		body.Close()
+		if err != nil {
+			if isStatus(err, http.StatusNotFound) {
+				// The server dropped the upload collection; start over next time
+				updateChunkState(key, func(*ChunkedTransfer) bool { return false })
+			}
+			return fmt.Errorf("chunk %d of %d: %v", n+1, chunks, err)
+		}
+		updateChunkState(key, func(s *ChunkedTransfer) bool {
+			s.Done = n + 1
+			return true
+		})
+	}
+
+	req, err := http.NewRequest("MOVE", collection+".file", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("OC-Total-Length", fmt.Sprintf("%d", t.Size))
+	if err := m.doDAV(d, req, target); err != nil {
+		return fmt.Errorf("assembling chunks: %v", err)
+	}
+	updateChunkState(key, func(*ChunkedTransfer) bool { return false })
+	log.Printf("Uploaded %s to %s in %d chunks", info.Name(), d.Name, chunks)
+	return nil
+}
+
+// chunkBody streams a chunk through the upload limits.
+func (m *Monitor) chunkBody(r io.Reader, rate string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := io.Copy(limitRate(m.throttleUpload(pw), rate), r)
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+func (m *Monitor) davRequest(d Destination, method, url, target string, body io.Reader, length int64) error {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = length
+	return m.doDAV(d, req, target)
+}
+
+// doDAV sends a chunking request; Nextcloud wants the final target in the
+// Destination header of every one of them.
+func (m *Monitor) doDAV(d Destination, req *http.Request, target string) error {
+	req.SetBasicAuth(d.User, d.Pass)
+	req.Header.Set("Destination", target)
+
+	client := &http.Client{Timeout: chunkRequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return davStatusError{Method: req.Method, Status: resp.Status, Code: resp.StatusCode}
+	}
+	return nil
+}
+
+type davStatusError struct {
+	Method string
+	Status string
+	Code   int
+}
+
+func (e davStatusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Method, e.Status)
+}
+
+func isStatus(err error, code int) bool {
+	s, ok := err.(davStatusError)
+	return ok && s.Code == code
+}
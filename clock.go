@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	schedulerTick      = 30 * time.Second
+	clockJumpThreshold = 2 * time.Minute
+	missedBackupGrace  = 5 * time.Minute
+
+	missedRun  = "run"
+	missedSkip = "skip"
+)
+
+// clockHeartbeat compares the wall clock with Go's monotonic clock between
+// ticks. The monotonic clock stops while the machine sleeps and ignores clock
+// changes, so any difference is a wake-up or a clock adjustment.
+type clockHeartbeat struct {
+	wall time.Time
+	mono time.Time
+}
+
+func newClockHeartbeat() *clockHeartbeat {
+	now := time.Now()
+	return &clockHeartbeat{wall: now.Round(0), mono: now}
+}
+
+// beat returns how far the wall clock moved beyond the monotonic clock since
+// the previous beat.
+func (h *clockHeartbeat) beat() time.Duration {
+	now := time.Now()
+	jump := now.Round(0).Sub(h.wall) - now.Sub(h.mono)
+	h.wall, h.mono = now.Round(0), now
+	if jump > clockJumpThreshold || jump < -clockJumpThreshold {
+		log.Printf("Clock jumped by %v (sleep, hibernation or clock change), rechecking schedule", jump.Round(time.Second))
+	}
+	return jump
+}
+
+func (h *clockHeartbeat) jumped(jump time.Duration) bool {
+	return jump > clockJumpThreshold || jump < -clockJumpThreshold
+}
+
+// waitUntil polls the wall clock instead of sleeping on one long timer,
+// which would fire late after hibernation. It returns how late the wake-up
+// was once next has passed, or jumped=true when the clock moved and the
+// caller should recompute next.
+func waitUntil(next time.Time) (late time.Duration, jumped bool) {
+	hb := newClockHeartbeat()
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+
+	for {
+		if now := time.Now(); !now.Before(next) {
+			return now.Sub(next), false
+		}
+		<-ticker.C
+		jump := hb.beat()
+		if now := time.Now(); !now.Before(next) {
+			return now.Sub(next), false
+		}
+		if hb.jumped(jump) {
+			return 0, true
+		}
+	}
+}
+
+// runMissed decides per MissedBackupPolicy whether a backup whose time passed
+// while the machine was asleep (or the app wasn't running) runs now.
+func (m *Monitor) runMissed(what string, late time.Duration) bool {
+	if late < missedBackupGrace {
+		return true
+	}
+	if m.config.MissedBackupPolicy == missedSkip {
+		log.Printf("Skipping %s missed by %v (MissedBackupPolicy=skip)", what, late.Round(time.Minute))
+		return false
+	}
+	log.Printf("Running %s missed by %v", what, late.Round(time.Minute))
+	return true
+}
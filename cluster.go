@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	clusterDumpall = "dumpall"
+	clusterSplit   = "split"
+
+	clusterSizeTimeout = 30 * time.Second
+)
+
+// splitCluster reports whether a cluster backup should be split into globals
+// and per-database dumps instead of one pg_dumpall file.
+func (m *Monitor) splitCluster() bool {
+	if m.config.ClusterBackupStrategy != clusterSplit {
+		return false
+	}
+	if m.config.ClusterSplitThresholdGB <= 0 {
+		return true
+	}
+
+	size, err := m.clusterSize()
+	if err != nil {
+		// Unknown size: splitting is the safe choice for a cluster that may be huge
+		log.Printf("Cluster size unknown, splitting backup: %v", err)
+		return true
+	}
+	split := size > int64(m.config.ClusterSplitThresholdGB)*gb
+	log.Printf("Cluster size %s, threshold %d GB: split=%t", formatBytes(size), m.config.ClusterSplitThresholdGB, split)
+	return split
+}
+
+func (m *Monitor) clusterSize() (int64, error) {
+	db, err := m.openDB(m.config.DBName)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), clusterSizeTimeout)
+	defer cancel()
+
+	var size int64
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(sum(pg_database_size(oid)), 0)::bigint FROM pg_database WHERE datallowconn").Scan(&size)
+	return size, err
+}
+
+func (m *Monitor) clusterDatabases() ([]string, error) {
+	db, err := m.openDB(m.config.DBName)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), clusterSizeTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT datname FROM pg_database WHERE datallowconn AND NOT datistemplate ORDER BY datname")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// splitClusterBackup dumps roles and tablespaces with pg_dumpall
+// --globals-only, then each database as its own custom-format backup. The
+// globals manifest records the parts and the order to restore them in.
+func (m *Monitor) splitClusterBackup(opts backupOptions) (entry CatalogEntry) {
+	entry = CatalogEntry{Database: "all databases", Kind: globalsKind, Label: opts.Label, Started: time.Now()}
+	hooked := false
+	defer func() {
+		entry.Finished = time.Now()
+		entry.Status = m.lastBackupStatus
+		m.catalogAdd(entry)
+		if hooked {
+			m.runPostBackupCommand(entry)
+		}
+	}()
+
+	fail := func(err error) CatalogEntry {
+		log.Printf("Split cluster backup failed: %v", err)
+		tray.SetTooltip(fmt.Sprintf("Backup failed: %v", err))
+		m.lastBackupStatus = "Failed (split cluster)"
+		m.updateBackupStatus()
+		m.notifyBackup(false, "all databases", err.Error())
+		return entry
+	}
+
+	databases, err := m.clusterDatabases()
+	if err != nil {
+		return fail(fmt.Errorf("listing databases: %v", err))
+	}
+	source, err := m.selectBackupSource()
+	if err != nil {
+		return fail(err)
+	}
+	entry.Host = source.Host
+
+	backupDir := filepath.Join(".", "backups")
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return fail(err)
+	}
+	if err := m.checkBackupVolume(backupDir); err != nil {
+		return fail(err)
+	}
+	globalsFile := filepath.Join(backupDir, globalsFileName(time.Now()))
+	entry.File = filepath.Base(globalsFile)
+
+	hooked = true
+	if err := m.runPreBackupCommand(entry); err != nil {
+		return fail(err)
+	}
+
+	log.Printf("Split cluster backup: globals to %s, then %d database(s)", globalsFile, len(databases))
+	tray.SetTooltip("Backing up roles and tablespaces...")
+	if entry.Overrun, err = m.dumpGlobals(source, globalsFile); err != nil {
+		return fail(err)
+	}
+
+	restoreOrder := []string{fmt.Sprintf("psql -d postgres -f %s", entry.File)}
+	var parts, failed []string
+	for _, db := range databases {
+		e := m.backupOne(db, false, backupOptions{Label: opts.Label, Destination: opts.Destination, Custom: true})
+		entry.Overrun = entry.Overrun || e.Overrun
+		if !e.Success {
+			failed = append(failed, db)
+			continue
+		}
+		parts = append(parts, e.File)
+		restoreOrder = append(restoreOrder, fmt.Sprintf("pg_restore --create -d postgres %s", e.File))
+	}
+
+	manifest, err := m.newManifest(globalsFile, "", true, source, "")
+	if err != nil {
+		return fail(err)
+	}
+	manifest.Parts = parts
+	manifest.RestoreOrder = restoreOrder
+	manifestFile, err := m.saveManifest(globalsFile, manifest)
+	if err != nil {
+		log.Printf("Failed to write manifest: %v", err)
+	}
+
+	if dests := m.destinationsFor(opts.Destination); m.config.UploadToCloud && len(dests) > 0 {
+		files, err := m.uploadFiles(globalsFile, manifestFile, &entry)
+		if err == nil {
+			entry.Uploaded, entry.UploadErrors, err = m.uploadWithQuorum(entry.File, files, dests)
+			m.cleanupSpooledFiles([]SpoolEntry{{Files: files}}, loadSpool())
+		}
+		if err != nil {
+			log.Printf("Upload of globals failed: %v", err)
+		}
+	}
+
+	if info, err := os.Stat(globalsFile); err == nil {
+		entry.Size = info.Size()
+	}
+	if len(failed) > 0 {
+		return fail(fmt.Errorf("%d of %d database(s) failed: %s", len(failed), len(databases), strings.Join(failed, ", ")))
+	}
+
+	entry.Success = true
+	m.lastBackupStatus = fmt.Sprintf("split: globals + %d database(s)", len(parts))
+	m.lastBackupTime = time.Now()
+	m.updateBackupStatus()
+	m.notifyBackup(true, "all databases", fmt.Sprintf("%s: %s", entry.File, m.lastBackupStatus))
+	return entry
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const maxZstdLevel = 19 // higher levels need --ultra
+
+// dumpCodec compresses a plain dump on its way to disk and decompresses it
+// again for restore. zstd runs the zstd binary, as the benchmark does, and
+// lz4 the lz4 binary; lz4 only ever comes from pg_dump -Z lz4 but is read
+// back like the others.
+type dumpCodec interface {
+	Ext() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+type rawCodec struct{}
+
+type gzipCodec struct{ level int }
+
+type zstdCodec struct{ level, threads int }
+
+type lz4Codec struct{}
+
+// dumpCodec picks the codec for a plain dump of db. With DumpCompression
+// set, pg_dump compresses single-database dumps itself and they are written
+// as is; pg_dumpall has no -Z, so CompressBackups always applies to it, and
+// no database's overrides do.
+func (m *Monitor) dumpCodec(db string, allDatabases bool) dumpCodec {
+	c := m.config
+	if !allDatabases {
+		c = m.databaseConfig(db)
+	}
+	if !c.CompressBackups || (!allDatabases && c.DumpCompression != "") {
+		return rawCodec{}
+	}
+	if c.CompressionCodec == compressionZstd {
+		return zstdCodec{level: c.CompressionLevel, threads: c.CompressionThreads}
+	}
+	return gzipCodec{level: c.CompressionLevel}
+}
+
+// codecForFile returns the codec a plain dump was written with.
+func codecForFile(file string) dumpCodec {
+	switch {
+	case strings.HasSuffix(file, ".gz"):
+		return gzipCodec{}
+	case strings.HasSuffix(file, ".zst"):
+		return zstdCodec{}
+	case strings.HasSuffix(file, ".lz4"):
+		return lz4Codec{}
+	}
+	return rawCodec{}
+}
+
+// runThroughCodec runs a dump that writes to stdout, passing its output
+// through codec into file in a single pass: the SHA-256 of what lands on
+// disk is computed on the way, so the dump is never read back for its
+// checksum. It returns what the dump wrote to stderr, the resource usage of
+// the dump and of an external codec process, and the checksum.
+func runThroughCodec(cmd *exec.Cmd, file string, codec dumpCodec) ([]byte, []ProcessUsage, string, error) {
+	out, err := os.Create(file)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer out.Close()
+
+	h := sha256.New()
+	w, err := codec.NewWriter(io.MultiWriter(out, h))
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	dumpUsage, err := runMeasured(cmd)
+	closeErr := w.Close()
+	usage := []ProcessUsage{dumpUsage}
+	if p, ok := w.(*codecProcess); ok {
+		usage = append(usage, p.usage)
+	}
+
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	return stderr.Bytes(), usage, hex.EncodeToString(h.Sum(nil)), err
+}
+
+func (rawCodec) Ext() string { return "" }
+
+func (rawCodec) NewWriter(w io.Writer) (io.WriteCloser, error) { return nopWriteCloser{w}, nil }
+
+func (rawCodec) NewReader(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(r), nil }
+
+func (gzipCodec) Ext() string { return ".gz" }
+
+// CompressionLevel applies when it is a valid gzip level.
+func (c gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	level := c.level
+	if level <= 0 || level > gzip.BestCompression {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }
+
+func (zstdCodec) Ext() string { return ".zst" }
+
+func (c zstdCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	args := []string{"-q", "-c"}
+	if level := c.level; level > 0 {
+		if level > maxZstdLevel {
+			level = maxZstdLevel
+		}
+		args = append(args, "-"+strconv.Itoa(level))
+	}
+	if c.threads > 0 {
+		args = append(args, "-T"+strconv.Itoa(c.threads))
+	}
+	cmd := exec.Command("zstd", args...)
+	cmd.Stdout = w
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("zstd: %v", err)
+	}
+	return &codecProcess{WriteCloser: stdin, cmd: cmd, probe: openUsageProbe(cmd.Process.Pid)}, nil
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	cmd := exec.Command("zstd", "-q", "-d", "-c")
+	cmd.Stdin = r
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("zstd: %v", err)
+	}
+	return &codecProcess{ReadCloser: stdout, cmd: cmd, probe: openUsageProbe(cmd.Process.Pid)}, nil
+}
+
+func (lz4Codec) Ext() string { return ".lz4" }
+
+func (lz4Codec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	cmd := exec.Command("lz4", "-q", "-c")
+	cmd.Stdout = w
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("lz4: %v", err)
+	}
+	return &codecProcess{WriteCloser: stdin, cmd: cmd, probe: openUsageProbe(cmd.Process.Pid)}, nil
+}
+
+func (lz4Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	cmd := exec.Command("lz4", "-q", "-d", "-c")
+	cmd.Stdin = r
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("lz4: %v", err)
+	}
+	return &codecProcess{ReadCloser: stdout, cmd: cmd, probe: openUsageProbe(cmd.Process.Pid)}, nil
+}
+
+// codecProcess is one end of a pipe to an external codec; Close waits for
+// the process so its exit status is not lost and records its usage.
+type codecProcess struct {
+	io.WriteCloser
+	io.ReadCloser
+	cmd   *exec.Cmd
+	probe usageProbe
+	usage ProcessUsage
+}
+
+func (p *codecProcess) Close() error {
+	var err error
+	if p.WriteCloser != nil {
+		err = p.WriteCloser.Close()
+	}
+	if p.ReadCloser != nil {
+		// Unblocks the process if the reader stopped early
+		p.ReadCloser.Close()
+	}
+	waitErr := p.cmd.Wait()
+	p.usage = p.probe.collect(p.cmd)
+	if waitErr != nil && err == nil {
+		err = fmt.Errorf("%s: %v", p.cmd.Path, waitErr)
+	}
+	return err
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
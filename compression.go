@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const (
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+	compressionLZ4  = "lz4"
+
+	benchmarkSampleBytes = 64 * mb
+)
+
+func (m *Monitor) dumpCompressionExt(db string) string {
+	switch m.databaseConfig(db).DumpCompression {
+	case compressionGzip:
+		return ".gz"
+	case compressionZstd:
+		return ".zst"
+	case compressionLZ4:
+		return ".lz4"
+	}
+	return ""
+}
+
+// dumpCompressionArgs builds pg_dump's -Z option. Plain gzip uses the bare
+// level so older pg_dump versions keep working; other methods need the
+// method:level form of pg_dump 16+.
+func (m *Monitor) dumpCompressionArgs(db string) []string {
+	c := m.databaseConfig(db)
+	method := c.DumpCompression
+	level := c.CompressionLevel
+
+	switch method {
+	case "":
+		return nil
+	case compressionGzip:
+		if level <= 0 {
+			level = 6
+		}
+		return []string{"-Z", strconv.Itoa(level)}
+	default:
+		if level > 0 {
+			return []string{"-Z", fmt.Sprintf("%s:%d", method, level)}
+		}
+		return []string{"-Z", method}
+	}
+}
+
+type benchmarkResult struct {
+	Method   string
+	Level    int
+	Ratio    float64
+	MBPerSec float64
+}
+
+// benchmarkCompression compresses the first 64 MB of file at several gzip
+// and (when the zstd binary is available) zstd levels and recommends the
+// fastest setting that gets within 5% of the best ratio.
+func (m *Monitor) benchmarkCompression(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	sample, err := io.ReadAll(io.LimitReader(f, benchmarkSampleBytes))
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if len(sample) == 0 {
+		return fmt.Errorf("%s is empty", file)
+	}
+	fmt.Printf("Sample: %s of %s\n", formatBytes(int64(len(sample))), file)
+
+	var results []benchmarkResult
+	for _, level := range []int{1, 3, 6, 9} {
+		start := time.Now()
+		var out bytes.Buffer
+		w, _ := gzip.NewWriterLevel(&out, level)
+		w.Write(sample)
+		w.Close()
+		results = append(results, newBenchmarkResult(compressionGzip, level, len(sample), out.Len(), time.Since(start)))
+	}
+
+	if _, err := exec.LookPath("zstd"); err == nil {
+		threads := m.config.CompressionThreads
+		for _, level := range []int{1, 3, 9, 19} {
+			args := []string{"-q", "-c", "-" + strconv.Itoa(level)}
+			if threads > 0 {
+				args = append(args, "-T"+strconv.Itoa(threads))
+			}
+			cmd := exec.Command("zstd", args...)
+			cmd.Stdin = bytes.NewReader(sample)
+			start := time.Now()
+			out, err := cmd.Output()
+			if err != nil {
+				fmt.Printf("zstd -%d failed: %v\n", level, err)
+				continue
+			}
+			results = append(results, newBenchmarkResult(compressionZstd, level, len(sample), len(out), time.Since(start)))
+		}
+	} else {
+		fmt.Println("zstd not found on PATH, skipping zstd levels")
+	}
+
+	bestRatio := 0.0
+	for _, r := range results {
+		fmt.Printf("%-5s level %-2d  ratio %5.2fx  %7.1f MB/s\n", r.Method, r.Level, r.Ratio, r.MBPerSec)
+		if r.Ratio > bestRatio {
+			bestRatio = r.Ratio
+		}
+	}
+
+	var pick benchmarkResult
+	for _, r := range results {
+		if r.Ratio >= bestRatio*0.95 && r.MBPerSec > pick.MBPerSec {
+			pick = r
+		}
+	}
+	fmt.Printf("\nRecommended: \"DumpCompression\": %q, \"CompressionLevel\": %d (%.2fx at %.1f MB/s)\n",
+		pick.Method, pick.Level, pick.Ratio, pick.MBPerSec)
+	return nil
+}
+
+func newBenchmarkResult(method string, level, in, out int, elapsed time.Duration) benchmarkResult {
+	r := benchmarkResult{Method: method, Level: level}
+	if out > 0 {
+		r.Ratio = float64(in) / float64(out)
+	}
+	if elapsed > 0 {
+		r.MBPerSec = float64(in) / mb / elapsed.Seconds()
+	}
+	return r
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// confirmDestructive checks that the user typed the name of what is about to
+// be destroyed (a database or backup file) and records the outcome in the
+// audit log either way.
+func (m *Monitor) confirmDestructive(actor, action, target, typed string) error {
+	if strings.TrimSpace(typed) != target {
+		m.audit(actor, "confirmation_failed", target, action)
+		return fmt.Errorf("%s not confirmed: type %q to confirm", action, target)
+	}
+	m.audit(actor, "confirmed", target, action)
+	return nil
+}
+
+// promptConfirmation asks on the terminal for the typed confirmation of a CLI
+// action.
+func promptConfirmation(what, target string) string {
+	fmt.Printf("This will %s. Type %q to confirm: ", what, target)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(line)
+}
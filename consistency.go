@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	consistencyHookTimeout = 5 * time.Minute
+
+	eventConsistencyFailed = "consistency_group_failed"
+)
+
+// ConsistencyGroup is a set of databases an application uses together, so
+// they are only useful when restored to the same point.
+type ConsistencyGroup struct {
+	Name          string
+	Databases     []string
+	PauseCommand  string `json:",omitempty"` // quiesces the application, e.g. stops its workers
+	ResumeCommand string `json:",omitempty"` // run once the snapshots are taken, also when that failed
+}
+
+// exportedSnapshot is an open repeatable-read transaction whose snapshot
+// pg_dump --snapshot reuses; it must stay open until the dump has started.
+type exportedSnapshot struct {
+	db   *sql.DB
+	tx   *sql.Tx
+	name string
+}
+
+func (s *exportedSnapshot) Close() {
+	s.tx.Rollback()
+	s.db.Close()
+}
+
+// backupConsistencyGroups dumps every configured group in turn and reports
+// whether all of them succeeded.
+func (m *Monitor) backupConsistencyGroups(opts backupOptions) bool {
+	ok := true
+	for _, g := range m.config.ConsistencyGroups {
+		ok = m.runConsistencyGroup(g, opts) && ok
+	}
+	return ok
+}
+
+func (m *Monitor) runConsistencyGroup(g ConsistencyGroup, opts backupOptions) bool {
+	if err := m.backupConsistencyGroup(g, opts); err != nil {
+		log.Printf("Consistency group %s failed: %v", g.Name, err)
+		m.notify(Notification{
+			Event:    eventConsistencyFailed,
+			Severity: severityCritical,
+			Title:    "Consistency group backup failed",
+			Message:  fmt.Sprintf("%s: %v", g.Name, err),
+			Details:  map[string]string{"group": g.Name},
+		})
+		return false
+	}
+	return true
+}
+
+// backupConsistencyGroup pauses the group's application, exports a snapshot
+// in each of its databases and resumes the application right away - the
+// pause lasts only as long as taking the snapshots. Each database is then
+// dumped from its snapshot, and all dumps record the same group run ID in
+// their manifest and catalog entry.
+func (m *Monitor) backupConsistencyGroup(g ConsistencyGroup, opts backupOptions) error {
+	if len(g.Databases) == 0 {
+		return fmt.Errorf("no databases configured")
+	}
+	source, err := m.selectBackupSource()
+	if err != nil {
+		return err
+	}
+	id := fmt.Sprintf("%s-%s", g.Name, time.Now().Format("20060102_150405"))
+	log.Printf("Consistency group %s: %s (run %s)", g.Name, strings.Join(g.Databases, ", "), id)
+
+	if g.PauseCommand != "" {
+		if err := runGroupHook(g.PauseCommand, g, id); err != nil {
+			if g.ResumeCommand != "" {
+				if rerr := runGroupHook(g.ResumeCommand, g, id); rerr != nil {
+					log.Printf("Consistency group %s: resume failed: %v", g.Name, rerr)
+				}
+			}
+			return fmt.Errorf("pause: %v", err)
+		}
+	}
+	paused := time.Now()
+	snapshots, err := m.exportSnapshots(source, g.Databases)
+	window := time.Since(paused)
+	if g.ResumeCommand != "" {
+		if rerr := runGroupHook(g.ResumeCommand, g, id); rerr != nil {
+			log.Printf("Consistency group %s: resume failed: %v", g.Name, rerr)
+			m.notify(Notification{
+				Event:    eventConsistencyFailed,
+				Severity: severityCritical,
+				Title:    "Application not resumed",
+				Message:  fmt.Sprintf("%s: ResumeCommand failed: %v", g.Name, rerr),
+				Details:  map[string]string{"group": g.Name},
+			})
+		}
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Consistency group %s: snapshots taken in %v", g.Name, window.Round(time.Millisecond))
+
+	var failed []string
+	for _, db := range g.Databases {
+		s := snapshots[db]
+		o := opts
+		o.Snapshot, o.SnapshotSource, o.ConsistencyGroup = s.name, &source, id
+		entry := m.backupOne(db, false, o)
+		s.Close()
+		if !entry.Success {
+			failed = append(failed, db)
+		}
+	}
+	m.audit(localActor(), "consistency_group_backup", g.Name, fmt.Sprintf("run %s, %d of %d databases", id, len(g.Databases)-len(failed), len(g.Databases)))
+	if len(failed) > 0 {
+		return fmt.Errorf("run %s incomplete, failed: %s", id, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// exportSnapshots opens a transaction in each database and exports its
+// snapshot; on error the ones already open are closed.
+func (m *Monitor) exportSnapshots(source backupSource, databases []string) (map[string]*exportedSnapshot, error) {
+	snapshots := make(map[string]*exportedSnapshot)
+	fail := func(err error) (map[string]*exportedSnapshot, error) {
+		for _, s := range snapshots {
+			s.Close()
+		}
+		return nil, err
+	}
+	for _, name := range databases {
+		db, err := m.openDBAt(source.Host, source.Port, name)
+		if err != nil {
+			return fail(fmt.Errorf("%s: %v", name, err))
+		}
+		tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		if err != nil {
+			db.Close()
+			return fail(fmt.Errorf("%s: %v", name, err))
+		}
+		s := &exportedSnapshot{db: db, tx: tx}
+		if err := tx.QueryRow("SELECT pg_export_snapshot()").Scan(&s.name); err != nil {
+			s.Close()
+			return fail(fmt.Errorf("%s: exporting snapshot: %v", name, err))
+		}
+		snapshots[name] = s
+	}
+	return snapshots, nil
+}
+
+// runGroupHook runs a pause or resume command with the group in the
+// environment as PGM_GROUP, PGM_GROUP_RUN and PGM_DATABASES.
+func runGroupHook(command string, g ConsistencyGroup, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), consistencyHookTimeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, command)
+	cmd.Env = append(os.Environ(),
+		"PGM_GROUP="+g.Name,
+		"PGM_GROUP_RUN="+id,
+		"PGM_DATABASES="+strings.Join(g.Databases, ","),
+	)
+	log.Printf("Consistency group %s: running %s", g.Name, command)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s timed out after %v", command, consistencyHookTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", command, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// inConsistencyGroup reports whether db is dumped by a group run.
+func (m *Monitor) inConsistencyGroup(db string) bool {
+	for _, g := range m.config.ConsistencyGroups {
+		if containsString(g.Databases, db) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Monitor) addConsistencyGroupMenu() {
+	menu := tray.AddMenuItem("Backup Consistency Group", "Dump related databases from snapshots taken together")
+	for _, g := range m.config.ConsistencyGroups {
+		item := menu.AddSubMenuItem(fmt.Sprintf("%s (%s)", g.Name, strings.Join(g.Databases, ", ")), "")
+		go func(g ConsistencyGroup, item *MenuItem) {
+			for range item.ClickedCh {
+				item.Disable()
+				m.backupJob(BackupJob{Database: "group " + g.Name, Trigger: triggerManual}, func() CatalogEntry {
+					return CatalogEntry{Success: m.runConsistencyGroup(g, backupOptions{}), Status: m.lastBackupStatus}
+				})
+				item.Enable()
+			}
+		}(g, item)
+	}
+}
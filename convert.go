@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	convertPlain        = "plain"
+	convertCustom       = "custom"
+	convertGzip         = "gzip"
+	convertZstd         = "zstd"
+	convertUncompressed = "uncompressed"
+	convertPGP          = "pgp"
+
+	convertPartSuffix = ".part"
+)
+
+// convertBackup rewrites a backup in the backups directory into another
+// format, compression or PGP recipient without dumping again. The converted
+// file gets its own catalog entry and re-signed manifest and replaces the
+// original locally; the original's entry stays (marked deleted) so copies
+// already uploaded under its name are still tracked.
+func (m *Monitor) convertBackup(file, target string) (string, error) {
+	src := filepath.Join(".", "backups", filepath.Base(file))
+	if _, err := os.Stat(src); err != nil {
+		return "", err
+	}
+	if isOnHold(src) {
+		return "", fmt.Errorf("%s is under legal hold", filepath.Base(src))
+	}
+	if isPGPEncrypted(src) && target != convertPGP {
+		return "", fmt.Errorf("%s is PGP encrypted; only -to %s (re-encrypt) applies to it", filepath.Base(src), convertPGP)
+	}
+
+	var dst string
+	var err error
+	switch target {
+	case convertPlain:
+		dst, err = m.convertToPlain(src)
+	case convertCustom:
+		dst, err = m.convertToCustom(src)
+	case convertGzip:
+		dst, err = recompress(src, gzipCodec{level: m.config.CompressionLevel})
+	case convertZstd:
+		dst, err = recompress(src, zstdCodec{level: m.config.CompressionLevel, threads: m.config.CompressionThreads})
+	case convertUncompressed:
+		dst, err = recompress(src, rawCodec{})
+	case convertPGP:
+		dst, err = m.reencryptPGP(src)
+	default:
+		err = fmt.Errorf("unknown conversion %q (plain, custom, gzip, zstd, uncompressed or pgp)", target)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.recordConversion(src, dst); err != nil {
+		return dst, err
+	}
+	m.audit(localActor(), "backup_converted", filepath.Base(src), fmt.Sprintf("%s -> %s", target, filepath.Base(dst)))
+	log.Printf("Converted %s to %s", filepath.Base(src), filepath.Base(dst))
+	return dst, nil
+}
+
+// plainBase strips the compression extension of a plain dump.
+func plainBase(file string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(file, ".gz"), ".zst"), ".lz4")
+}
+
+// convertToPlain writes an archive as a SQL script with pg_restore -f, which
+// needs no database, compressed the way new plain dumps are.
+func (m *Monitor) convertToPlain(src string) (string, error) {
+	if !isArchiveDump(src) {
+		return "", fmt.Errorf("%s is not a custom or directory archive", filepath.Base(src))
+	}
+	codec := m.dumpCodec("", false)
+	base := strings.TrimSuffix(strings.TrimSuffix(src, customDumpExt), directoryDumpExt)
+	dst := base + ".sql" + codec.Ext()
+
+	cmd := exec.Command(pgTool("pg_restore"), "-f", "-", src)
+	stderr, _, _, err := runThroughCodec(cmd, dst+convertPartSuffix, codec)
+	if err != nil {
+		os.Remove(dst + convertPartSuffix)
+		return "", fmt.Errorf("pg_restore failed: %v, output: %s", err, strings.TrimSpace(string(stderr)))
+	}
+	return dst, os.Rename(dst+convertPartSuffix, dst)
+}
+
+// convertToCustom needs a server: the plain dump is loaded into a scratch
+// database, dumped again with -Fc and the scratch database dropped.
+func (m *Monitor) convertToCustom(src string) (string, error) {
+	if !isPlainDump(src) {
+		return "", fmt.Errorf("%s is not a plain SQL dump", filepath.Base(src))
+	}
+	if isClusterDump(src) {
+		return "", fmt.Errorf("%s is a pg_dumpall backup; the custom format holds a single database", filepath.Base(src))
+	}
+	dst := strings.TrimSuffix(plainBase(src), ".sql") + customDumpExt
+
+	scratch := fmt.Sprintf("pg_monitor_convert_%s", time.Now().Format("20060102150405"))
+	if _, err := m.ensureDatabase(scratch); err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := m.dropDatabase(scratch); err != nil {
+			log.Printf("Failed to drop scratch database %s: %v", scratch, err)
+		}
+	}()
+
+	f, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	input, err := codecForFile(src).NewReader(f)
+	if err != nil {
+		return "", err
+	}
+	defer input.Close()
+
+	env := append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", m.config.Password))
+	load := exec.Command(pgTool("psql"),
+		"-h", m.config.Host,
+		"-p", fmt.Sprintf("%d", m.config.Port),
+		"-U", m.config.User,
+		"-d", scratch,
+		"-v", "ON_ERROR_STOP=1",
+		"-q",
+	)
+	load.Env = env
+	load.Stdin = input
+	if err := runLogged(load, "psql"); err != nil {
+		return "", err
+	}
+
+	dump := exec.Command(pgTool("pg_dump"),
+		"-h", m.config.Host,
+		"-p", fmt.Sprintf("%d", m.config.Port),
+		"-U", m.config.User,
+		"-Fc",
+		"-f", dst+convertPartSuffix,
+		scratch,
+	)
+	dump.Env = env
+	if err := runLogged(dump, "pg_dump"); err != nil {
+		os.Remove(dst + convertPartSuffix)
+		return "", err
+	}
+	return dst, os.Rename(dst+convertPartSuffix, dst)
+}
+
+// recompress rewrites a plain dump with another codec.
+func recompress(src string, codec dumpCodec) (string, error) {
+	if !isPlainDump(src) {
+		return "", fmt.Errorf("%s is not a plain SQL dump; archives are compressed by pg_dump", filepath.Base(src))
+	}
+	dst := plainBase(src) + codec.Ext()
+	if dst == src {
+		return "", fmt.Errorf("%s already uses that compression", filepath.Base(src))
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	r, err := codecForFile(src).NewReader(in)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	if err := writeThroughCodec(dst+convertPartSuffix, codec, r); err != nil {
+		os.Remove(dst + convertPartSuffix)
+		return "", err
+	}
+	return dst, os.Rename(dst+convertPartSuffix, dst)
+}
+
+func writeThroughCodec(file string, codec dumpCodec, r io.Reader) error {
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	w, err := codec.NewWriter(out)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// reencryptPGP decrypts a .gpg backup with the local keyring and encrypts it
+// again to the current PGPRecipient, e.g. after a key rotation. The name
+// stays the same.
+func (m *Monitor) reencryptPGP(src string) (string, error) {
+	if !isPGPEncrypted(src) {
+		return "", fmt.Errorf("%s is not PGP encrypted", filepath.Base(src))
+	}
+	if m.config.PGPRecipient == "" {
+		return "", fmt.Errorf("re-encryption requires PGPRecipient")
+	}
+
+	plain := filepath.Join(filepath.Dir(src), restoringPrefix+strings.TrimSuffix(filepath.Base(src), pgpExt))
+	if err := m.decryptOpenPGP(src, plain); err != nil {
+		return "", err
+	}
+	defer os.Remove(plain)
+
+	if err := m.encryptOpenPGP(plain, src+convertPartSuffix); err != nil {
+		return "", err
+	}
+	return src, os.Rename(src+convertPartSuffix, src)
+}
+
+// recordConversion moves the manifest and catalog over to the converted
+// file and removes the original.
+func (m *Monitor) recordConversion(src, dst string) error {
+	size, err := backupSize(dst)
+	if err != nil {
+		return err
+	}
+
+	if manifest, err := readManifest(src); err == nil {
+		manifest.File = filepath.Base(dst)
+		manifest.Size = size
+		if manifest.SHA256 != "" || m.config.ManifestChecksum {
+			if manifest.SHA256, err = backupSHA256(dst); err != nil {
+				return fmt.Errorf("checksum failed: %v", err)
+			}
+		}
+		if dst != src {
+			os.Remove(manifestPath(src))
+			os.Remove(manifestPath(src) + signatureSuffix)
+		}
+		if _, err := m.saveManifest(dst, manifest); err != nil {
+			log.Printf("Failed to write manifest: %v", err)
+		}
+	}
+
+	from, to := filepath.Base(src), filepath.Base(dst)
+	err = updateCatalog(func(entries []CatalogEntry) ([]CatalogEntry, error) {
+		for i := range entries {
+			if entries[i].File != from {
+				continue
+			}
+			if from == to {
+				entries[i].Size = size
+				continue
+			}
+			converted := entries[i]
+			converted.File = to
+			converted.Size = size
+			converted.Status = fmt.Sprintf("converted from %s", from)
+			converted.Uploaded = nil
+			converted.RemoteName = ""
+			converted.RemoteManifest = ""
+			converted.Encrypted = false
+			entries[i].Deleted = true
+			entries = append(entries, converted)
+			break
+		}
+		return entries, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if dst != src {
+		return os.RemoveAll(src)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// backupDatabaseList dumps each database in Databases as its own file, one
+// after the other, and shows how many of them succeeded in the tray. Each
+// run is cataloged and notified like any single-database backup; the result
+// is whether all of them succeeded.
+func (m *Monitor) backupDatabaseList(opts backupOptions) bool {
+	m.backupItem.SetTitle("Backup Database (Running...)")
+	m.backupItem.Disable()
+	defer func() {
+		m.backupItem.SetTitle("Backup Database")
+		m.backupItem.Enable()
+	}()
+
+	var databases []string
+	for _, db := range m.config.Databases {
+		// A scheduled run dumps these with their group instead
+		if !opts.Scheduled || !m.inConsistencyGroup(db) {
+			databases = append(databases, db)
+		}
+	}
+	log.Printf("Backing up %d database(s): %s", len(databases), strings.Join(databases, ", "))
+
+	var failed []string
+	for i, db := range databases {
+		tray.SetTooltip(fmt.Sprintf("Backing up %s (%d of %d)...", db, i+1, len(databases)))
+		if e := m.backupOne(db, false, opts); !e.Success {
+			failed = append(failed, db)
+		}
+	}
+
+	ok := len(databases) - len(failed)
+	m.lastBackupStatus = fmt.Sprintf("%d/%d databases", ok, len(databases))
+	if len(failed) > 0 {
+		m.lastBackupStatus += " (failed: " + strings.Join(failed, ", ") + ")"
+		log.Printf("Database list backup: %d of %d failed: %s", len(failed), len(databases), strings.Join(failed, ", "))
+	} else {
+		m.lastBackupTime = time.Now()
+	}
+	tray.SetTooltip("Last backup: " + m.lastBackupStatus)
+	m.updateBackupStatus()
+	return len(failed) == 0
+}
+
+// inDatabaseList reports whether the scheduler should back up db: every
+// database when Databases is empty, otherwise only the listed ones.
+func (m *Monitor) inDatabaseList(db string) bool {
+	if len(m.config.Databases) == 0 {
+		return true
+	}
+	for _, d := range m.config.Databases {
+		if d == db {
+			return true
+		}
+	}
+	return false
+}
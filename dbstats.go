@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	metricDatabases = "databases" // per-database stats of MonitoredDatabases
+
+	maxDatabaseMenuItems = 20 // the rest are only counted and exported
+)
+
+// Everything comes from the monitoring connection: pg_stat_database covers
+// all databases of the server, so none of them needs its own connection.
+// Sizes need CONNECT on the database and are -1 without it.
+const databaseStatsQuery = `
+SELECT t, d.oid IS NOT NULL,
+       CASE WHEN d.oid IS NULL OR NOT has_database_privilege(d.oid, 'CONNECT') THEN -1
+            ELSE pg_database_size(d.oid) END,
+       COALESCE(s.numbackends, 0),
+       COALESCE(s.xact_commit + s.xact_rollback, 0)
+FROM unnest(string_to_array($1, ',')) AS t
+LEFT JOIN pg_database d ON d.datname = t
+LEFT JOIN pg_stat_database s ON s.datid = d.oid`
+
+type databaseStats struct {
+	Name        string
+	Exists      bool
+	Size        int64 // -1 when not permitted
+	Connections int
+	Xacts       int64   // committed plus rolled back since the last stats reset
+	XactRate    float64 // per second since the previous check, -1 when unknown
+}
+
+var (
+	xactMu   sync.Mutex
+	lastXact = make(map[string]xactSample)
+)
+
+type xactSample struct {
+	total int64
+	at    time.Time
+}
+
+func (m *Monitor) databaseStatsCollector() metricCollector {
+	return metricCollector{
+		name: metricDatabases,
+		collect: func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
+			rows, err := tx.QueryContext(ctx, databaseStatsQuery, strings.Join(m.config.MonitoredDatabases, ","))
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+
+			var stats []databaseStats
+			for rows.Next() {
+				var s databaseStats
+				if err := rows.Scan(&s.Name, &s.Exists, &s.Size, &s.Connections, &s.Xacts); err != nil {
+					return nil, err
+				}
+				stats = append(stats, s)
+			}
+			return stats, rows.Err()
+		},
+	}
+}
+
+// updateDatabaseStats derives transaction rates from the previous check,
+// fills the per-database submenu and exports one point per database.
+func (m *Monitor) updateDatabaseStats(results map[string]metricResult) {
+	r, ok := results[metricDatabases]
+	if !ok || m.databasesItem == nil {
+		return
+	}
+	if r.err != nil {
+		m.databasesItem.SetTitle("Databases: ? (query failed)")
+		return
+	}
+	stats := r.value.([]databaseStats)
+
+	xactMu.Lock()
+	now := time.Now()
+	for i := range stats {
+		s := &stats[i]
+		s.XactRate = -1
+		prev, seen := lastXact[s.Name]
+		lastXact[s.Name] = xactSample{total: s.Xacts, at: now}
+		// A counter that went backwards was reset; the next check has a rate again
+		if seen && s.Xacts >= prev.total && now.After(prev.at) {
+			s.XactRate = float64(s.Xacts-prev.total) / now.Sub(prev.at).Seconds()
+		}
+	}
+	xactMu.Unlock()
+
+	for i := len(stats); i < len(m.databaseItems); i++ {
+		m.databaseItems[i].Hide()
+	}
+
+	var points []metricPoint
+	missing, conns := 0, 0
+	for i, s := range stats {
+		var item *MenuItem
+		if i < len(m.databaseItems) {
+			item = m.databaseItems[i]
+		}
+		if !s.Exists {
+			missing++
+			if item != nil {
+				item.SetTitle(fmt.Sprintf("%s: not found", s.Name))
+				item.Show()
+			}
+			continue
+		}
+		conns += s.Connections
+
+		parts := []string{fmt.Sprintf("%d conns", s.Connections)}
+		fields := map[string]interface{}{"connections": s.Connections, "xacts": s.Xacts}
+		if s.Size >= 0 {
+			parts = append([]string{formatBytes(s.Size)}, parts...)
+			fields["size_bytes"] = s.Size
+		}
+		if s.XactRate >= 0 {
+			parts = append(parts, fmt.Sprintf("%.1f xact/s", s.XactRate))
+			fields["xact_rate"] = s.XactRate
+		}
+		if item != nil {
+			item.SetTitle(fmt.Sprintf("%s: %s", s.Name, strings.Join(parts, ", ")))
+			item.Show()
+		}
+
+		points = append(points, metricPoint{
+			Measurement: "pg_database_stats",
+			Tags:        map[string]string{"database": s.Name},
+			Fields:      fields,
+			Time:        now,
+		})
+	}
+	m.exportPoints(points...)
+
+	if missing > 0 {
+		m.databasesItem.SetTitle(fmt.Sprintf("Databases: %d not found", missing))
+	} else {
+		m.databasesItem.SetTitle(fmt.Sprintf("Databases: %d, %d conns", len(stats), conns))
+	}
+}
+
+func (m *Monitor) addDatabasesMenu() {
+	m.databasesItem = tray.AddMenuItem("Databases: -", "Size, connections and transaction rate of each monitored database")
+	for i := 0; i < maxDatabaseMenuItems && i < len(m.config.MonitoredDatabases); i++ {
+		item := m.databasesItem.AddSubMenuItem("", "")
+		item.Disable()
+		item.Hide()
+		m.databaseItems = append(m.databaseItems, item)
+	}
+}
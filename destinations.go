@@ -0,0 +1,594 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	uploadSpoolFile    = "upload-spool.json"
+	spoolRetryInterval = 5 * time.Minute
+	spoolMaxBackoff    = 6 * time.Hour
+	eventUploadRetried = "upload_retried"
+	defaultDestination = "nextcloud"
+)
+
+// Destination is a WebDAV folder, object storage bucket, SFTP/FTP folder,
+// Google Drive folder or network share backups are uploaded to; its Type selects the
+// StorageBackend.
+type Destination struct {
+	Name string
+	Type string `json:",omitempty"` // "webdav" (default), "s3", "gcs", "azure", "b2", "sftp", "ftp", "gdrive" or "smb"
+	URL  string `json:",omitempty"` // WebDAV folder URL ending in '/'
+	User string `json:",omitempty"`
+	Pass string `json:",omitempty"`
+
+	Bucket    string `json:",omitempty"` // S3, GCS or B2 bucket
+	Prefix    string `json:",omitempty"` // key prefix ("folder") within the bucket or container, or Drive folder path
+	Region    string `json:",omitempty"` // S3 region (default us-east-1)
+	Endpoint  string `json:",omitempty"` // S3-compatible service, e.g. https://s3.wasabisys.com (default AWS), or Azure account URL
+	AccessKey string `json:",omitempty"` // S3 access key or B2 application key ID
+	SecretKey string `json:",omitempty"` // S3 secret key or B2 application key
+
+	CredentialsFile string `json:",omitempty"` // GCS or Drive service-account key, or authorized_user file (JSON)
+	StorageClass    string `json:",omitempty"` // GCS storage class of new objects, e.g. NEARLINE (default: the bucket's)
+	FolderID        string `json:",omitempty"` // Drive folder or shared drive ID (default: My Drive)
+
+	Container        string `json:",omitempty"` // Azure blob container
+	ConnectionString string `json:",omitempty"` // Azure storage connection string (account key or SAS)
+	SASToken         string `json:",omitempty"` // Azure SAS token, with Endpoint instead of ConnectionString
+
+	Host       string `json:",omitempty"` // SFTP, FTP or SMB server
+	Port       int    `json:",omitempty"` // SFTP or FTP port (default 22, 21 or 990)
+	Path       string `json:",omitempty"` // SFTP or FTP folder; relative to the login directory unless it starts with '/'; SMB folder in the share
+	KeyFile    string `json:",omitempty"` // SFTP private key; Pass is then its passphrase
+	KnownHosts string `json:",omitempty"` // SFTP host key as a known_hosts line (default: ~/.ssh/known_hosts)
+
+	TLS        string `json:",omitempty"` // FTP: "" (plain), "explicit" (AUTH TLS, required) or "implicit" (FTPS)
+	CAFile     string `json:",omitempty"` // FTPS: CA bundle for the server certificate (default: system store)
+	PinnedKey  string `json:",omitempty"` // FTPS: server public key pin, "sha256//<base64>"
+	ActiveMode bool   `json:",omitempty"` // FTP: active instead of passive data connections
+
+	Share  string `json:",omitempty"` // SMB share name on Host
+	Domain string `json:",omitempty"` // SMB: Windows domain of User (default: none, a local account of the server)
+
+	MaxGB       float64 `json:",omitempty"` // quota for backups held here (0 = unlimited)
+	MaxFiles    int     `json:",omitempty"` // quota in number of backups (0 = unlimited)
+	QuotaPolicy string  `json:",omitempty"` // over quota: "stop" (default, alert and stop uploading) or "prune" oldest
+
+	RetentionDays  int `json:",omitempty"` // delete backups here older than this (0 = RemoteRetentionDays)
+	RetentionCount int `json:",omitempty"` // keep at most this many per database (0 = RemoteRetentionCount)
+}
+
+// SpoolEntry is an upload to one destination that failed and is retried in
+// the background.
+type SpoolEntry struct {
+	Backup      string   // catalog file name
+	Files       []string // local files to upload, in order
+	Destination string
+	Sent        int `json:",omitempty"` // destinations the backup run uploaded to, for the quorum
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+}
+
+var spoolMu sync.Mutex
+
+// destinations returns the configured upload targets; the legacy Nextcloud
+// settings act as a single destination.
+func (m *Monitor) destinations() []Destination {
+	destMu.RLock()
+	defer destMu.RUnlock()
+	if len(m.config.Destinations) > 0 {
+		return m.config.Destinations
+	}
+	if m.config.NextcloudURL == "" {
+		return nil
+	}
+	return []Destination{{
+		Name: defaultDestination,
+		URL:  m.config.NextcloudURL,
+		User: m.config.NextcloudUser,
+		Pass: m.config.NextcloudPass,
+	}}
+}
+
+const (
+	destinationWebDAV = "webdav"
+	destinationS3     = "s3"
+	destinationGCS    = "gcs"
+	destinationAzure  = "azure"
+	destinationB2     = "b2"
+	destinationSFTP   = "sftp"
+	destinationFTP    = "ftp"
+	destinationDrive  = "gdrive"
+	destinationSMB    = "smb"
+)
+
+func (d Destination) isWebDAV() bool {
+	return d.Type == "" || strings.EqualFold(d.Type, destinationWebDAV)
+}
+
+func (d Destination) isSFTP() bool {
+	return strings.EqualFold(d.Type, destinationSFTP)
+}
+
+func (d Destination) isFTP() bool {
+	return strings.EqualFold(d.Type, destinationFTP)
+}
+
+func (d Destination) isSMB() bool {
+	return strings.EqualFold(d.Type, destinationSMB)
+}
+
+// subfolder addresses the folder name below d, e.g. the WAL archive; on
+// object storage that is a longer key prefix.
+func (d Destination) subfolder(name string) Destination {
+	switch {
+	case d.isWebDAV():
+		d.URL += name + "/"
+	case d.isSFTP(), d.isFTP(), d.isSMB():
+		d.Path = path.Join(d.Path, name)
+	default:
+		d.Prefix = keyPrefix(d) + name + "/"
+	}
+	return d
+}
+
+func keyPrefix(d Destination) string {
+	p := strings.Trim(d.Prefix, "/")
+	if p == "" {
+		return ""
+	}
+	return p + "/"
+}
+
+// remoteLocation names where file name goes on d, for logging.
+func remoteLocation(d Destination, name string) string {
+	b, err := storageFor(d)
+	if err != nil {
+		return d.Name + ":" + name
+	}
+	return b.Location(d, name)
+}
+
+func (m *Monitor) destination(name string) (Destination, bool) {
+	for _, d := range m.destinations() {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return Destination{}, false
+}
+
+// uploadWithQuorum uploads files to every destination in parallel. It
+// returns the destinations that succeeded, the error of each that failed and
+// an error when fewer than UploadQuorum succeeded; failed destinations are
+// handed to the spooler either way.
+func (m *Monitor) uploadWithQuorum(backup string, files []string, dests []Destination) ([]string, map[string]string, error) {
+	errs := make([]error, len(dests))
+
+	var wg sync.WaitGroup
+	for i, d := range dests {
+		wg.Add(1)
+		go func(i int, d Destination) {
+			defer wg.Done()
+			if errs[i] = m.enforceQuota(d, backup, uploadSize(files)); errs[i] != nil {
+				return
+			}
+			for _, f := range files {
+				if errs[i] = m.uploadTo(d, f); errs[i] != nil {
+					return
+				}
+			}
+		}(i, d)
+	}
+	wg.Wait()
+
+	var uploaded []string
+	var failures map[string]string
+	for i, d := range dests {
+		if errs[i] != nil {
+			log.Printf("Upload to %s failed, spooling for retry: %v", d.Name, errs[i])
+			m.spoolUpload(SpoolEntry{Backup: backup, Files: files, Destination: d.Name, Sent: len(dests), LastError: errs[i].Error()})
+			if failures == nil {
+				failures = make(map[string]string)
+			}
+			failures[d.Name] = errs[i].Error()
+			continue
+		}
+		uploaded = append(uploaded, d.Name)
+	}
+
+	if quorum := m.uploadQuorum(len(dests)); len(uploaded) < quorum {
+		return uploaded, failures, fmt.Errorf("uploaded to %d of %d destinations, %d required", len(uploaded), len(dests), quorum)
+	}
+	return uploaded, failures, nil
+}
+
+// uploadQuorum is how many of n destinations must succeed for a backup to
+// count as successful; 0 keeps the old behaviour where a failed upload still
+// leaves a successful local backup.
+func (m *Monitor) uploadQuorum(n int) int {
+	q := m.config.UploadQuorum
+	if q > n {
+		q = n
+	}
+	return q
+}
+
+// destinationsFor returns the named destination only, or all of them when
+// name is empty.
+func (m *Monitor) destinationsFor(name string) []Destination {
+	if name == "" {
+		return m.destinations()
+	}
+	if d, ok := m.destination(name); ok {
+		return []Destination{d}
+	}
+	return nil
+}
+
+func readSpool() ([]SpoolEntry, error) {
+	var entries []SpoolEntry
+	data, err := os.ReadFile(uploadSpoolFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &entries)
+	return entries, err
+}
+
+func loadSpool() []SpoolEntry {
+	entries, err := readSpool()
+	if err != nil {
+		log.Printf("Ignoring unreadable %s: %v", uploadSpoolFile, err)
+	}
+	return entries
+}
+
+// saveSpool replaces the spool through a temporary file, so a reader never
+// sees it half written.
+func saveSpool(entries []SpoolEntry) {
+	data, _ := json.MarshalIndent(entries, "", "  ")
+	tmp := uploadSpoolFile + ".tmp"
+	err := os.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, uploadSpoolFile)
+	}
+	if err != nil {
+		log.Printf("Failed to write %s: %v", uploadSpoolFile, err)
+	}
+}
+
+// spooledFiles returns the local files of pending spooled uploads.
+func spooledFiles() map[string]bool {
+	spoolMu.Lock()
+	defer spoolMu.Unlock()
+
+	pending := make(map[string]bool)
+	for _, e := range loadSpool() {
+		for _, f := range e.Files {
+			pending[f] = true
+		}
+	}
+	return pending
+}
+
+func (m *Monitor) spoolUpload(e SpoolEntry) {
+	spoolMu.Lock()
+	defer spoolMu.Unlock()
+
+	e.Attempts = 1
+	e.NextAttempt = time.Now().Add(spoolRetryInterval)
+	saveSpool(append(loadSpool(), e))
+}
+
+// spoolLoop retries spooled uploads with a doubling backoff until they
+// succeed or their files disappear.
+func (m *Monitor) spoolLoop() {
+	for {
+		m.retrySpooled()
+		time.Sleep(spoolRetryInterval)
+	}
+}
+
+// retrySpooled retries the spooled uploads that are due. The spool is only
+// locked to pick them up and to write the outcome back, so backups spooling
+// new failures are not held up by a long retry; entries spooled meanwhile
+// are kept as they are.
+func (m *Monitor) retrySpooled() {
+	spoolMu.Lock()
+	var due []SpoolEntry
+	for _, e := range loadSpool() {
+		if !time.Now().Before(e.NextAttempt) {
+			due = append(due, e)
+		}
+	}
+	spoolMu.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	// Outcome per backup and destination: the entry to keep, or nil when
+	// it succeeded or was dropped
+	done := make(map[string]*SpoolEntry)
+	dropped := false
+	for _, e := range due {
+		key := e.Backup + "\x00" + e.Destination
+		d, ok := m.destination(e.Destination)
+		if !ok {
+			log.Printf("Dropping spooled upload of %s: destination %s no longer configured", e.Backup, e.Destination)
+			done[key] = nil
+			dropped = true
+			continue
+		}
+
+		var err error
+		for _, f := range e.Files {
+			if _, err = os.Stat(f); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = m.enforceQuota(d, e.Backup, uploadSize(e.Files))
+		}
+		if err == nil {
+			for _, f := range e.Files {
+				if err = m.uploadTo(d, f); err != nil {
+					break
+				}
+			}
+		}
+		if os.IsNotExist(err) {
+			log.Printf("Dropping spooled upload of %s to %s: %v", e.Backup, d.Name, err)
+			done[key] = nil
+			dropped = true
+			continue
+		}
+		if err != nil {
+			e.Attempts++
+			e.LastError = err.Error()
+			backoff := spoolRetryInterval << uint(e.Attempts-1)
+			if backoff > spoolMaxBackoff || backoff <= 0 {
+				backoff = spoolMaxBackoff
+			}
+			e.NextAttempt = time.Now().Add(backoff)
+			log.Printf("Retry %d of %s to %s failed: %v", e.Attempts, e.Backup, d.Name, err)
+			retry := e
+			done[key] = &retry
+			continue
+		}
+
+		log.Printf("Spooled upload of %s to %s succeeded after %d attempt(s)", e.Backup, d.Name, e.Attempts+1)
+		done[key] = nil
+		m.markUploaded(e.Backup, d.Name, e.Sent)
+		m.notify(Notification{
+			Event:    eventUploadRetried,
+			Severity: severityInfo,
+			Title:    "Upload retry succeeded",
+			Message:  fmt.Sprintf("%s uploaded to %s after %d attempt(s)", e.Backup, d.Name, e.Attempts+1),
+		})
+	}
+
+	spoolMu.Lock()
+	entries := loadSpool()
+	var remaining []SpoolEntry
+	for _, e := range entries {
+		outcome, retried := done[e.Backup+"\x00"+e.Destination]
+		switch {
+		case !retried:
+			remaining = append(remaining, e)
+		case outcome != nil:
+			remaining = append(remaining, *outcome)
+		}
+	}
+	saveSpool(remaining)
+	m.cleanupSpooledFiles(entries, remaining)
+	spoolMu.Unlock()
+
+	if dropped {
+		m.updateDestinationsMenu()
+	}
+}
+
+// markUploaded records a late upload in the catalog and marks the backup
+// successful once the quorum is reached among the sent destinations the
+// backup run uploaded to; 0 counts every configured destination.
+func (m *Monitor) markUploaded(backup, destination string, sent int) {
+	if sent == 0 {
+		sent = len(m.destinations())
+	}
+	err := updateCatalog(func(entries []CatalogEntry) ([]CatalogEntry, error) {
+		for i := range entries {
+			if entries[i].File != backup {
+				continue
+			}
+			entries[i].Uploaded = append(entries[i].Uploaded, destination)
+			delete(entries[i].UploadErrors, destination)
+			if q := m.uploadQuorum(sent); q > 0 && !entries[i].Success && len(entries[i].Uploaded) >= q {
+				entries[i].Success = true
+				entries[i].Status += " (quorum reached on retry)"
+			}
+		}
+		return entries, nil
+	})
+	if err != nil {
+		log.Printf("Failed to update catalog for %s: %v", backup, err)
+	}
+	m.updateDestinationsMenu()
+}
+
+// uploadFiles lists what goes to each destination: the backup, its manifest
+// and signature, or on an untrusted remote their encrypted opaque blobs.
+// Directory dumps are uploaded as a tarball; with EncryptBackups backup and
+// manifest go as .enc copies.
+func (m *Monitor) uploadFiles(backupFile, manifestFile string, entry *CatalogEntry) ([]string, error) {
+	if isDirectoryDump(backupFile) {
+		tarball, err := tarDirectory(backupFile)
+		if err != nil {
+			return nil, err
+		}
+		backupFile = tarball
+	}
+
+	if m.config.UntrustedRemote {
+		name, blob, err := m.encryptOpaque(backupFile)
+		if isDumpTarball(backupFile) {
+			os.Remove(backupFile)
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry.RemoteName = name
+		files := []string{blob}
+		if manifestFile != "" {
+			name, blob, err := m.encryptOpaque(manifestFile)
+			if err != nil {
+				os.Remove(files[0])
+				return nil, err
+			}
+			entry.RemoteManifest = name
+			files = append(files, blob)
+		}
+		return files, nil
+	}
+
+	// The signature covers the plaintext manifest and is uploaded as is
+	signature := manifestFile + signatureSuffix
+	if m.config.EncryptBackups {
+		encrypted, err := m.encryptForUpload(backupFile)
+		if isDumpTarball(backupFile) {
+			os.Remove(backupFile)
+		}
+		if err != nil {
+			return nil, err
+		}
+		backupFile = encrypted
+		if manifestFile != "" {
+			if manifestFile, err = m.encryptForUpload(manifestFile); err != nil {
+				os.Remove(backupFile)
+				return nil, err
+			}
+		}
+		entry.Encrypted = true
+	}
+
+	files := []string{backupFile}
+	if manifestFile != "" {
+		files = append(files, manifestFile)
+		if _, err := os.Stat(signature); err == nil {
+			files = append(files, signature)
+		}
+	}
+	return files, nil
+}
+
+// cleanupSpooledFiles removes encrypted copies and directory dump tarballs
+// that no longer have a pending upload; backup files are never removed here.
+func (m *Monitor) cleanupSpooledFiles(before, after []SpoolEntry) {
+	pending := make(map[string]bool)
+	for _, e := range after {
+		for _, f := range e.Files {
+			pending[f] = true
+		}
+	}
+	for _, e := range before {
+		for _, f := range e.Files {
+			if !pending[f] && (isOpaqueBlob(f) || isDumpTarball(f) || isEncryptedUpload(f)) {
+				os.Remove(f)
+			}
+		}
+	}
+}
+
+const maxDestinationItems = 10
+
+// failedDestinations lists the destinations a backup's upload failed on.
+func failedDestinations(e CatalogEntry) string {
+	var names []string
+	for name := range e.UploadErrors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func (m *Monitor) addDestinationsMenu() {
+	m.destinationsItem = tray.AddMenuItem("Destinations", "Result of the last upload to each destination")
+	for i := 0; i < maxDestinationItems; i++ {
+		item := m.destinationsItem.AddSubMenuItem("", "")
+		item.Disable()
+		item.Hide()
+		m.destinationItems = append(m.destinationItems, item)
+	}
+	m.updateDestinationsMenu()
+}
+
+// updateDestinationsMenu shows, per configured destination, the newest
+// backup that went to it or failed to: "eu: ok (02:14)" or "nas: failed",
+// "retrying" while the spool still has the upload.
+func (m *Monitor) updateDestinationsMenu() {
+	if m.destinationsItem == nil {
+		return
+	}
+	entries, err := loadCatalog()
+	if err != nil {
+		log.Printf("Cannot read catalog for the destinations menu: %v", err)
+		return
+	}
+	spoolMu.Lock()
+	spooled := loadSpool()
+	spoolMu.Unlock()
+	retrying := make(map[string]bool)
+	for _, s := range spooled {
+		retrying[s.Backup+"\x00"+s.Destination] = true
+	}
+
+	dests := m.destinations()
+	failed := 0
+	for i, item := range m.destinationItems {
+		if i >= len(dests) {
+			item.Hide()
+			continue
+		}
+		name := dests[i].Name
+		title, tooltip := fmt.Sprintf("%s: no uploads yet", name), ""
+		for j := len(entries) - 1; j >= 0; j-- {
+			e := entries[j]
+			if reason, ok := e.UploadErrors[name]; ok {
+				title = fmt.Sprintf("%s: failed (%s)", name, e.Finished.Format("Jan 2 15:04"))
+				if retrying[e.File+"\x00"+name] {
+					title = fmt.Sprintf("%s: failed (%s, retrying)", name, e.Finished.Format("Jan 2 15:04"))
+				}
+				tooltip = fmt.Sprintf("%s: %s", e.File, reason)
+				failed++
+				break
+			}
+			if containsString(e.Uploaded, name) {
+				title = fmt.Sprintf("%s: ok (%s)", name, e.Finished.Format("Jan 2 15:04"))
+				tooltip = e.File
+				break
+			}
+		}
+		item.SetTitle(title)
+		item.SetTooltip(tooltip)
+		item.Show()
+	}
+
+	if failed > 0 {
+		m.destinationsItem.SetTitle(fmt.Sprintf("Destinations: %d failing", failed))
+	} else {
+		m.destinationsItem.SetTitle(fmt.Sprintf("Destinations (%d)", len(dests)))
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const diagnosticTimeout = 30 * time.Second
+
+// addDiagnosticsMenu adds the "Settings" submenu with actions that exercise
+// each stage of the pipeline with tiny payloads, so misconfiguration shows
+// up interactively instead of in the nightly run.
+func (m *Monitor) addDiagnosticsMenu() {
+	settings := tray.AddMenuItem("Settings", "Check configuration")
+	testConn := settings.AddSubMenuItem("Test Connection", "Resolve, connect, authenticate and query the server")
+	testUpload := settings.AddSubMenuItem("Test Upload", "Upload and delete a small file on the cloud destination")
+	testBackup := settings.AddSubMenuItem("Run 1-table Test Backup", "Dump the smallest table to a temporary file")
+	explain := settings.AddSubMenuItem("Explain Query...", "EXPLAIN a query against the monitored database")
+	snapshot := settings.AddSubMenuItem("Capture Support Snapshot", "Write activity, locks, settings, sizes and replication to a report file")
+	m.diagResultItem = settings.AddSubMenuItem("Result: -", "Result of the last test")
+	m.diagResultItem.Disable()
+
+	go func() {
+		for {
+			select {
+			case <-testConn.ClickedCh:
+				go m.runDiagnostic("Connection", m.testConnection)
+			case <-testUpload.ClickedCh:
+				go m.runDiagnostic("Upload", m.testUpload)
+			case <-testBackup.ClickedCh:
+				go m.runDiagnostic("Backup", m.testBackup)
+			case <-explain.ClickedCh:
+				go m.openLocalPage("explain")
+			case <-snapshot.ClickedCh:
+				go m.runDiagnostic("Snapshot", m.captureSnapshot)
+			}
+		}
+	}()
+}
+
+func (m *Monitor) runDiagnostic(name string, test func() (string, error)) {
+	m.diagResultItem.SetTitle(fmt.Sprintf("Result: testing %s...", strings.ToLower(name)))
+
+	detail, err := test()
+	if err != nil {
+		log.Printf("Test %s FAILED: %v", name, err)
+		m.diagResultItem.SetTitle(fmt.Sprintf("Result: %s FAILED", name))
+		m.diagResultItem.SetTooltip(err.Error())
+		tray.SetTooltip(fmt.Sprintf("Test %s failed: %v", name, err))
+		return
+	}
+
+	log.Printf("Test %s OK: %s", name, detail)
+	m.diagResultItem.SetTitle(fmt.Sprintf("Result: %s OK", name))
+	m.diagResultItem.SetTooltip(detail)
+	tray.SetTooltip(fmt.Sprintf("Test %s OK: %s", name, detail))
+}
+
+// testConnection checks each step separately so the reported failure says
+// which one broke: name resolution, TCP, authentication/database, or query.
+func (m *Monitor) testConnection() (string, error) {
+	host := m.config.Host
+	if !strings.HasPrefix(host, "/") {
+		if _, err := net.LookupHost(host); err != nil {
+			return "", fmt.Errorf("cannot resolve host %s: %v", host, err)
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(m.config.Port))
+		conn, err := net.DialTimeout("tcp", addr, connTimeout)
+		if err != nil {
+			return "", fmt.Errorf("cannot reach %s (firewall, VPN or server down?): %v", addr, err)
+		}
+		conn.Close()
+	}
+
+	db, err := m.openDB(m.config.DBName)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		msg := err.Error()
+		switch {
+		case strings.Contains(msg, "password authentication failed"):
+			return "", fmt.Errorf("wrong user or password for %s: %v", m.config.User, err)
+		case strings.Contains(msg, "does not exist"):
+			return "", fmt.Errorf("database %s does not exist: %v", m.config.DBName, err)
+		case strings.Contains(msg, "pg_hba.conf"):
+			return "", fmt.Errorf("server rejects this client in pg_hba.conf: %v", err)
+		}
+		return "", fmt.Errorf("login failed: %v", err)
+	}
+
+	var version string
+	var superuser bool
+	if err := db.QueryRowContext(ctx, "SELECT current_setting('server_version'), rolsuper FROM pg_roles WHERE rolname = current_user").Scan(&version, &superuser); err != nil {
+		return "", fmt.Errorf("connected but query failed: %v", err)
+	}
+
+	detail := fmt.Sprintf("PostgreSQL %s as %s", version, m.config.User)
+	if !superuser && m.config.AutoBackupAll {
+		detail += " (not superuser: pg_dumpall may fail)"
+	}
+	if err := m.checkClientVersion(backupSource{Host: m.config.Host, Port: m.config.Port}); err != nil {
+		detail += fmt.Sprintf(" (%v)", err)
+	}
+	return detail, nil
+}
+
+// testUpload PUTs a small file to every cloud destination and deletes it
+// again, reporting the HTTP status on failure.
+func (m *Monitor) testUpload() (string, error) {
+	dests := m.destinations()
+	if len(dests) == 0 {
+		return "", fmt.Errorf("NextcloudURL is not configured")
+	}
+
+	var results []string
+	for _, d := range dests {
+		detail, err := m.testUploadTo(d)
+		if err != nil {
+			if len(dests) > 1 {
+				return "", fmt.Errorf("%s: %v", d.Name, err)
+			}
+			return "", err
+		}
+		results = append(results, detail)
+	}
+	return strings.Join(results, "; "), nil
+}
+
+func (m *Monitor) testUploadTo(d Destination) (string, error) {
+	b, err := storageFor(d)
+	if err != nil {
+		return "", err
+	}
+	return b.Test(d)
+}
+
+// testBackup dumps the smallest user table with pg_dump -t, exercising the
+// client tools, credentials and dump privileges in a few seconds.
+func (m *Monitor) testBackup() (string, error) {
+	db, err := m.openDB(m.config.DBName)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+	defer cancel()
+
+	var table string
+	err = db.QueryRowContext(ctx, `
+		SELECT format('%I.%I', n.nspname, c.relname)
+		FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind = 'r' AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+		ORDER BY c.relpages, c.relname LIMIT 1`).Scan(&table)
+	if err != nil {
+		return "", fmt.Errorf("no user table found to test with: %v", err)
+	}
+
+	tmp := filepath.Join(os.TempDir(), fmt.Sprintf("pg-monitor-test-%d.sql", time.Now().UnixNano()))
+	defer os.Remove(tmp)
+
+	cmd := exec.CommandContext(ctx, pgTool("pg_dump"),
+		"-h", m.config.Host,
+		"-p", fmt.Sprintf("%d", m.config.Port),
+		"-U", m.config.User,
+		"-t", table,
+		"-f", tmp,
+		m.config.DBName,
+	)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", m.config.Password))
+
+	start := time.Now()
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if execErr, ok := err.(*exec.Error); ok {
+			return "", fmt.Errorf("pg_dump not found: %v", execErr)
+		}
+		return "", fmt.Errorf("pg_dump -t %s failed: %s", table, strings.TrimSpace(string(output)))
+	}
+
+	info, err := os.Stat(tmp)
+	if err != nil || info.Size() == 0 {
+		return "", fmt.Errorf("pg_dump produced no output for %s", table)
+	}
+	return fmt.Sprintf("dumped %s (%d bytes) in %v", table, info.Size(), time.Since(start).Round(time.Millisecond)), nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pgDumpReserved are the pg_dump options the backup sets itself: where it
+// connects, what it dumps and where the output goes. Passing them again
+// through PgDumpExtraArgs would silently break the backup.
+var pgDumpReserved = []struct{ short, long string }{
+	{"-h", "--host"},
+	{"-p", "--port"},
+	{"-U", "--username"},
+	{"-d", "--dbname"},
+	{"-f", "--file"},
+	{"-F", "--format"},
+	{"-j", "--jobs"},
+	{"-Z", "--compress"},
+	{"-W", "--password"},
+	{"-w", "--no-password"},
+	{"", "--snapshot"},
+	{"-V", "--version"},
+	{"-?", "--help"},
+}
+
+// pgDumpValueOptions are pg_dump's short options that take a value: in a
+// cluster of short options ("-Ox", "-Of/tmp/x") the rest after one of them
+// is its value, not more options.
+const pgDumpValueOptions = "dEefFhjnNpStTUZ"
+
+// pgDumpExtraArgs returns PgDumpExtraArgs after checking them. Each must
+// be an option, with its value attached ("--exclude-table-data=public.log",
+// "-Tpublic.tmp_*"): a lone value would be taken as the database name.
+// Clusters of short options are checked letter by letter, and since pg_dump
+// (getopt_long) takes any unambiguous abbreviation of a long option,
+// "--form=c" counts as --format.
+func (m *Monitor) pgDumpExtraArgs() ([]string, error) {
+	for _, arg := range m.config.PgDumpExtraArgs {
+		if !strings.HasPrefix(arg, "-") {
+			return nil, fmt.Errorf("PgDumpExtraArgs: %q is not an option; attach values with = (e.g. --lock-wait-timeout=30s)", arg)
+		}
+		var shorts []string
+		name, _, _ := strings.Cut(arg, "=")
+		if !strings.HasPrefix(arg, "--") {
+			name = ""
+			for i := 1; i < len(arg); i++ {
+				shorts = append(shorts, "-"+arg[i:i+1])
+				if strings.IndexByte(pgDumpValueOptions, arg[i]) >= 0 {
+					break
+				}
+			}
+		}
+		for _, r := range pgDumpReserved {
+			long := r.long != "" && name != "" && strings.HasPrefix(r.long, name)
+			short := r.short != "" && containsString(shorts, r.short)
+			if long || short {
+				return nil, fmt.Errorf("PgDumpExtraArgs: %s is set by the backup itself", arg)
+			}
+		}
+	}
+	return m.config.PgDumpExtraArgs, nil
+}
+
+// commandLine renders args for the log, quoting the ones a shell would
+// split.
+func commandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = a
+		if a == "" || strings.ContainsAny(a, " \t\"'*?$") {
+			quoted[i] = strconv.Quote(a)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	dumpFormatPlain     = "plain"
+	dumpFormatCustom    = "custom"
+	dumpFormatDirectory = "directory"
+
+	customDumpExt    = ".dump"
+	directoryDumpExt = ".dir"
+	tarballExt       = ".tar"
+)
+
+// dumpFormat returns the pg_dump format of a single-database backup of db:
+// custom when the run asks for it (split cluster parts), otherwise
+// DumpFormat.
+func (m *Monitor) dumpFormat(db string, opts backupOptions) string {
+	if opts.Custom {
+		return dumpFormatCustom
+	}
+	c := m.databaseConfig(db)
+	if c.DumpFormat == "" {
+		return dumpFormatPlain
+	}
+	return c.DumpFormat
+}
+
+func (m *Monitor) dumpJobs(db string) int {
+	c := m.databaseConfig(db)
+	if c.DumpJobs < 1 {
+		return 1
+	}
+	return c.DumpJobs
+}
+
+func isCustomDump(file string) bool {
+	return strings.HasSuffix(file, customDumpExt)
+}
+
+func isDirectoryDump(file string) bool {
+	return strings.HasSuffix(file, directoryDumpExt)
+}
+
+// isArchiveDump reports whether file is read by pg_restore rather than psql.
+func isArchiveDump(file string) bool {
+	return isCustomDump(file) || isDirectoryDump(file)
+}
+
+func isDumpTarball(file string) bool {
+	return strings.HasSuffix(file, directoryDumpExt+tarballExt)
+}
+
+// verifyArchive reads the table of contents of a custom or directory format
+// dump with pg_restore -l, which fails on truncated or corrupt archives.
+func verifyArchive(file string) (int, error) {
+	output, err := exec.Command(pgTool("pg_restore"), "-l", file).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return 0, fmt.Errorf("pg_restore -l failed: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return 0, fmt.Errorf("pg_restore -l failed: %v", err)
+	}
+
+	entries := 0
+	for _, line := range strings.Split(string(output), "\n") {
+		if line != "" && !strings.HasPrefix(line, ";") {
+			entries++
+		}
+	}
+	if entries == 0 {
+		return 0, fmt.Errorf("archive has an empty table of contents")
+	}
+	return entries, nil
+}
+
+// backupSize is the size of a dump file, or the total of a directory dump.
+func backupSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if info.IsDir() {
+		return dirSize(path)
+	}
+	return info.Size(), nil
+}
+
+// backupSHA256 hashes a dump file; for a directory dump it hashes every
+// file's relative path and content in lexical order.
+func backupSHA256(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return fileSHA256(path)
+	}
+
+	h := sha256.New()
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(path, p)
+		fmt.Fprintf(h, "%s\x00", filepath.ToSlash(rel))
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// tarDirectory packs a directory dump into <dir>.tar for upload. The data
+// files are already compressed by pg_dump, so the tarball is not.
+func tarDirectory(dir string) (string, error) {
+	tarball := dir + tarballExt
+	out, err := os.Create(tarball)
+	if err != nil {
+		return "", err
+	}
+
+	tw := tar.NewWriter(out)
+	base := filepath.Base(dir)
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		hdr.Name = filepath.ToSlash(filepath.Join(base, rel))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tarball)
+		return "", fmt.Errorf("tar %s: %v", base, err)
+	}
+	return tarball, nil
+}
+
+// untarDirectory unpacks a tarball written by tarDirectory into dir. The
+// top-level directory in the tarball is replaced by dir.
+func untarDirectory(tarball, dir string) error {
+	in, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tr := tar.NewReader(in)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("untar %s: %v", filepath.Base(tarball), err)
+		}
+		name := filepath.FromSlash(hdr.Name)
+		if i := strings.IndexRune(name, filepath.Separator); i >= 0 {
+			name = name[i+1:]
+		} else {
+			name = ""
+		}
+		target := filepath.Join(dir, name)
+		if rel, err := filepath.Rel(dir, target); err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("untar %s: invalid path %q", filepath.Base(tarball), hdr.Name)
+		}
+
+		if hdr.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+			continue
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
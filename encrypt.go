@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Encrypted files are a header (magic and a random 12-byte nonce) followed by
+// chunks of up to 1 MiB: a final flag byte, the ciphertext length and the
+// AES-256-GCM ciphertext. Each chunk's nonce is the file nonce with the chunk
+// number XORed into its last 8 bytes and the flag is authenticated, so
+// reordered, dropped or truncated chunks fail to decrypt.
+const (
+	encryptedExt   = ".enc"
+	encryptMagic   = "PGMENC01"
+	encryptChunk   = 1 << 20
+	encryptKeySize = 32
+)
+
+// encryptionKey returns the AES-256 key, base64 encoded in EncryptionKey or
+// in the OS keyring entry named by EncryptionKeyring.
+func (m *Monitor) encryptionKey() ([]byte, error) {
+	encoded := m.config.EncryptionKey
+	if m.config.EncryptionKeyring != "" {
+		secret, err := keyringSecret(m.config.EncryptionKeyring)
+		if err != nil {
+			return nil, fmt.Errorf("keyring entry %s: %v", m.config.EncryptionKeyring, err)
+		}
+		encoded = secret
+	}
+	if encoded == "" {
+		return nil, fmt.Errorf("EncryptBackups requires EncryptionKey or EncryptionKeyring")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not base64: %v", err)
+	}
+	if len(key) != encryptKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", encryptKeySize, len(key))
+	}
+	return key, nil
+}
+
+// encryptForUpload writes an encrypted copy of file next to it; the copy is
+// removed once every destination has it.
+func (m *Monitor) encryptForUpload(file string) (string, error) {
+	key, err := m.encryptionKey()
+	if err != nil {
+		return "", err
+	}
+	dst := file + encryptedExt
+	if err := encryptFile(file, dst, key); err != nil {
+		os.Remove(dst)
+		return "", fmt.Errorf("encrypt %s: %v", file, err)
+	}
+	return dst, nil
+}
+
+func isEncryptedUpload(file string) bool {
+	return strings.HasSuffix(file, encryptedExt)
+}
+
+func chunkNonce(base []byte, n uint64) []byte {
+	nonce := append([]byte(nil), base...)
+	var ctr [8]byte
+	binary.BigEndian.PutUint64(ctr[:], n)
+	for i := range ctr {
+		nonce[len(nonce)-8+i] ^= ctr[i]
+	}
+	return nonce
+}
+
+func encryptFile(src, dst string, key []byte) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := encryptStream(in, f, key); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// encryptStream encrypts everything read from in to w, e.g. a dump on its
+// way to a destination.
+func encryptStream(in io.Reader, w io.Writer, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(w)
+
+	base := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(base); err != nil {
+		return err
+	}
+	out.WriteString(encryptMagic)
+	out.Write(base)
+
+	// Reading one chunk ahead tells whether the current one is the last
+	buf := make([]byte, encryptChunk)
+	next := make([]byte, encryptChunk)
+	n, err := io.ReadFull(in, buf)
+	for chunk := uint64(0); ; chunk++ {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		final := err != nil
+		var nextN int
+		if !final {
+			nextN, err = io.ReadFull(in, next)
+			final = err == io.EOF
+		}
+
+		flag := []byte{0}
+		if final {
+			flag[0] = 1
+		}
+		sealed := gcm.Seal(nil, chunkNonce(base, chunk), buf[:n], flag)
+		var hdr [5]byte
+		hdr[0] = flag[0]
+		binary.BigEndian.PutUint32(hdr[1:], uint32(len(sealed)))
+		out.Write(hdr[:])
+		if _, werr := out.Write(sealed); werr != nil {
+			return werr
+		}
+		if final {
+			break
+		}
+		buf, next, n = next, buf, nextN
+	}
+	return out.Flush()
+}
+
+func decryptFile(src, dst string, key []byte) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err := decryptStream(f, out, key); err != nil {
+		return fmt.Errorf("%s: %v", filepath.Base(src), err)
+	}
+	return out.Close()
+}
+
+// decryptingReader decrypts an encrypted upload while it is read, e.g.
+// straight from a download. Authentication errors surface as read errors;
+// closing the reader stops the decryption early.
+func decryptingReader(r io.Reader, key []byte) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(decryptStream(r, pw, key))
+	}()
+	return pr
+}
+
+func decryptStream(r io.Reader, out io.Writer, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	in := bufio.NewReader(r)
+	magic := make([]byte, len(encryptMagic))
+	base := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(in, magic); err != nil || string(magic) != encryptMagic {
+		return fmt.Errorf("not an encrypted backup")
+	}
+	if _, err := io.ReadFull(in, base); err != nil {
+		return fmt.Errorf("truncated header: %v", err)
+	}
+
+	for chunk := uint64(0); ; chunk++ {
+		var hdr [5]byte
+		if _, err := io.ReadFull(in, hdr[:]); err != nil {
+			return fmt.Errorf("truncated at chunk %d", chunk)
+		}
+		size := binary.BigEndian.Uint32(hdr[1:])
+		if size > encryptChunk+uint32(gcm.Overhead()) {
+			return fmt.Errorf("corrupt chunk %d", chunk)
+		}
+		sealed := make([]byte, size)
+		if _, err := io.ReadFull(in, sealed); err != nil {
+			return fmt.Errorf("truncated at chunk %d", chunk)
+		}
+		plain, err := gcm.Open(nil, chunkNonce(base, chunk), sealed, hdr[:1])
+		if err != nil {
+			return fmt.Errorf("chunk %d fails authentication (wrong key or modified file)", chunk)
+		}
+		if _, err := out.Write(plain); err != nil {
+			return err
+		}
+		if hdr[0] == 1 {
+			return nil
+		}
+	}
+}
+
+// decryptCLI restores the plaintext of a downloaded .enc file for -decrypt.
+func (m *Monitor) decryptCLI(file, output string) error {
+	key, err := m.encryptionKey()
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		output = strings.TrimSuffix(file, encryptedExt)
+		if output == file {
+			return fmt.Errorf("-output is required for files without the %s extension", encryptedExt)
+		}
+	}
+	if err := decryptFile(file, output, key); err != nil {
+		os.Remove(output)
+		return err
+	}
+	return nil
+}
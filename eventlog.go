@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+const (
+	systemLogSource  = "PG Monitor"
+	eventConfigError = "config_error"
+)
+
+// defaultSystemLogEvents are shipped to the system log when SystemLogEvents
+// is empty: what a host monitoring agent would alert on.
+var defaultSystemLogEvents = []string{
+	eventBackupSuccess, eventBackupFailed,
+	eventConnectionLost, eventConnectionRestored,
+	eventConfigError,
+}
+
+// shipToSystemLog writes a notification to syslog or the Windows Event Log
+// when SystemLog is set, independently of the notification channels.
+func (m *Monitor) shipToSystemLog(n Notification) {
+	if !m.config.SystemLog {
+		return
+	}
+	events := m.config.SystemLogEvents
+	if len(events) == 0 {
+		events = defaultSystemLogEvents
+	}
+	if !channelWants(NotificationChannel{Events: events}, n.Event) {
+		return
+	}
+
+	msg := fmt.Sprintf("%s: %s", n.Title, n.Message)
+	if n.Database != "" {
+		msg = fmt.Sprintf("%s (%s): %s", n.Title, n.Database, n.Message)
+	}
+	if err := writeSystemLog(n.Severity, fmt.Sprintf("[%s] %s", n.Event, msg)); err != nil {
+		log.Printf("System log write failed: %v", err)
+	}
+}
+
+// configError reports a setting that was ignored because it is invalid.
+func (m *Monitor) configError(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("Config error: %s", msg)
+	m.notify(Notification{
+		Event:    eventConfigError,
+		Severity: severityWarning,
+		Title:    "Configuration error",
+		Message:  msg,
+	})
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package main
+
+import (
+	"log/syslog"
+	"sync"
+)
+
+var (
+	syslogMu     sync.Mutex
+	syslogWriter *syslog.Writer
+)
+
+// writeSystemLog sends msg to the local syslog daemon (facility daemon),
+// connecting on first use.
+func writeSystemLog(severity, msg string) error {
+	syslogMu.Lock()
+	defer syslogMu.Unlock()
+
+	if syslogWriter == nil {
+		w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "pg-monitor")
+		if err != nil {
+			return err
+		}
+		syslogWriter = w
+	}
+
+	switch severity {
+	case severityCritical:
+		return syslogWriter.Err(msg)
+	case severityWarning:
+		return syslogWriter.Warning(msg)
+	default:
+		return syslogWriter.Info(msg)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	eventlogError       = 0x0001
+	eventlogWarning     = 0x0002
+	eventlogInformation = 0x0004
+)
+
+var (
+	eventSourceMu sync.Mutex
+	eventSource   uintptr
+)
+
+// writeSystemLog reports msg to the Application event log. The source isn't
+// registered with a message file, so Event Viewer shows the text after its
+// "description cannot be found" preamble; agents reading the raw event get
+// it as the first insertion string.
+func writeSystemLog(severity, msg string) error {
+	advapi32 := syscall.NewLazyDLL("advapi32.dll")
+
+	eventSourceMu.Lock()
+	defer eventSourceMu.Unlock()
+
+	if eventSource == 0 {
+		source, _ := syscall.UTF16PtrFromString(systemLogSource)
+		h, _, err := advapi32.NewProc("RegisterEventSourceW").Call(0, uintptr(unsafe.Pointer(source)))
+		if h == 0 {
+			return err
+		}
+		eventSource = h
+	}
+
+	eventType := eventlogInformation
+	switch severity {
+	case severityCritical:
+		eventType = eventlogError
+	case severityWarning:
+		eventType = eventlogWarning
+	}
+
+	text, err := syscall.UTF16PtrFromString(msg)
+	if err != nil {
+		return err
+	}
+	inserts := []*uint16{text}
+	ok, _, err := advapi32.NewProc("ReportEventW").Call(
+		eventSource, uintptr(eventType), 0, 1, 0,
+		uintptr(len(inserts)), 0, uintptr(unsafe.Pointer(&inserts[0])), 0)
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
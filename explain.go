@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	explainTimeout     = 60 * time.Second
+	maxExplainQueryLen = 100000
+)
+
+var explainPage = template.Must(template.New("explain").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Explain - {{.Database}}</title>
+<style>body{font-family:sans-serif;margin:1em}textarea{width:100%;height:12em;font-family:monospace}
+pre{background:#f4f4f4;padding:.5em;overflow:auto}.err{color:#b00}</style></head>
+<body><h3>EXPLAIN on {{.Database}} @ {{.Host}}</h3>
+<form method="post">
+<textarea name="query" placeholder="SELECT ...">{{.Query}}</textarea><br>
+<label><input type="checkbox" name="analyze" {{if .Analyze}}checked{{end}} {{if not .AllowAnalyze}}disabled{{end}}>
+ANALYZE (executes the query in a read-only transaction that is rolled back{{if not .AllowAnalyze}}; disabled by ExplainAllowAnalyze{{end}})</label>
+<button type="submit">Explain</button>
+</form>
+{{if .Error}}<p class="err">{{.Error}}</p>{{end}}
+{{if .Plan}}<pre>{{.Plan}}</pre>{{end}}
+</body></html>`))
+
+type explainView struct {
+	Database     string
+	Host         string
+	Query        string
+	Analyze      bool
+	AllowAnalyze bool
+	Plan         string
+	Error        string
+}
+
+func (m *Monitor) handleExplain(w http.ResponseWriter, r *http.Request) {
+	view := explainView{Database: m.config.DBName, Host: m.config.Host, AllowAnalyze: m.config.ExplainAllowAnalyze}
+
+	if r.Method == http.MethodPost {
+		view.Query = r.FormValue("query")
+		view.Analyze = r.FormValue("analyze") != "" && view.AllowAnalyze
+		plan, err := m.explainQuery(view.Query, view.Analyze)
+		if err != nil {
+			view.Error = err.Error()
+		}
+		view.Plan = plan
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	explainPage.Execute(w, view)
+}
+
+// explainQuery runs EXPLAIN of a single statement inside a read-only
+// transaction that is always rolled back, so even ANALYZE cannot change
+// data.
+func (m *Monitor) explainQuery(query string, analyze bool) (string, error) {
+	query = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(query), ";"))
+	if query == "" {
+		return "", fmt.Errorf("enter a query")
+	}
+	if len(query) > maxExplainQueryLen {
+		return "", fmt.Errorf("query too long")
+	}
+
+	db, err := m.openDB(m.config.DBName)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET TRANSACTION READ ONLY; SET LOCAL statement_timeout = %d", explainTimeout.Milliseconds())); err != nil {
+		return "", err
+	}
+
+	options := "VERBOSE"
+	if analyze {
+		options = "ANALYZE, BUFFERS, VERBOSE"
+		m.audit(localActor(), "explain_analyze", m.config.DBName, firstLine(query))
+	}
+
+	// A prepared statement goes through the extended protocol, which takes a
+	// single statement: "SELECT 1; COMMIT; DROP ..." is rejected instead of
+	// leaving the read-only transaction
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("EXPLAIN (%s) %s", options, query))
+	if err != nil {
+		return "", err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var plan strings.Builder
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return plan.String(), err
+		}
+		plan.WriteString(line)
+		plan.WriteByte('\n')
+	}
+	return plan.String(), rows.Err()
+}
+
+func firstLine(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > 200 {
+		s = s[:200] + "..."
+	}
+	return s
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	fdwQueryTimeout = 10 * time.Second
+
+	eventForeignData = "foreign_data_warning"
+)
+
+// foreignDataArgs adds --include-foreign-data for each configured server
+// pattern (pg_dump 13+); by default only the foreign table definitions are
+// dumped, not their remote rows.
+func (m *Monitor) foreignDataArgs() []string {
+	var args []string
+	for _, p := range m.config.IncludeForeignData {
+		args = append(args, "--include-foreign-data="+p)
+	}
+	return args
+}
+
+// checkForeignData warns before a dump when the database uses foreign
+// servers: user mappings are dumped without their passwords unless the dump
+// runs as superuser, so a restore fails until the mappings are recreated.
+func (m *Monitor) checkForeignData(source backupSource, dbName string) {
+	if m.config.SkipForeignDataCheck {
+		return
+	}
+
+	db, err := m.openDBAt(source.Host, source.Port, dbName)
+	if err != nil {
+		log.Printf("Foreign data check skipped: %v", err)
+		return
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), fdwQueryTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT s.srvname, w.fdwname,
+		       (SELECT count(*) FROM pg_foreign_table t WHERE t.ftserver = s.oid),
+		       COALESCE((SELECT string_agg(um.usename, ', ' ORDER BY um.usename)
+		                 FROM pg_user_mappings um WHERE um.srvid = s.oid), ''),
+		       (SELECT rolsuper FROM pg_roles WHERE rolname = current_user)
+		FROM pg_foreign_server s JOIN pg_foreign_data_wrapper w ON w.oid = s.srvfdw
+		ORDER BY s.srvname`)
+	if err != nil {
+		log.Printf("Foreign data check failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	var warnings []string
+	for rows.Next() {
+		var server, wrapper, mappings string
+		var tables int
+		var superuser bool
+		if err := rows.Scan(&server, &wrapper, &tables, &mappings, &superuser); err != nil {
+			log.Printf("Foreign data check failed: %v", err)
+			return
+		}
+
+		msg := fmt.Sprintf("server %s (%s, %d foreign tables)", server, wrapper, tables)
+		if mappings != "" {
+			msg += "; user mappings for " + mappings
+			if !superuser {
+				msg += " are dumped without passwords"
+			}
+		}
+		if tables > 0 && !m.includesForeignData(server) {
+			msg += "; remote rows not included"
+		}
+		warnings = append(warnings, msg)
+	}
+	if len(warnings) == 0 {
+		return
+	}
+
+	for _, w := range warnings {
+		log.Printf("Foreign data in %s: %s", dbName, w)
+	}
+	m.notify(Notification{
+		Event:    eventForeignData,
+		Severity: severityWarning,
+		Title:    "Backup contains foreign servers",
+		Message:  fmt.Sprintf("Recreate user mappings after restoring %s: %s", dbName, strings.Join(warnings, " | ")),
+		Database: dbName,
+	})
+}
+
+func (m *Monitor) includesForeignData(server string) bool {
+	for _, p := range m.config.IncludeForeignData {
+		if p == "*" || p == server {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const flightRecorderPrefix = "support-snapshot_"
+
+// flightRecorderSections are the queries of a support snapshot, in report
+// order. They only read statistics views, so capturing is safe on a server
+// that is already struggling.
+var flightRecorderSections = []struct {
+	Title string
+	Query string
+}{
+	{"Server", `SELECT version(), now(), pg_postmaster_start_time(), pg_is_in_recovery(),
+		(SELECT count(*) FROM pg_stat_activity) AS connections, current_setting('max_connections') AS max_connections`},
+	{"Activity", `SELECT pid, usename, datname, application_name, client_addr, state, wait_event_type, wait_event,
+		now() - xact_start AS xact_age, now() - query_start AS query_age, left(regexp_replace(query, '\s+', ' ', 'g'), 200) AS query
+		FROM pg_stat_activity WHERE pid <> pg_backend_pid() ORDER BY xact_start NULLS LAST`},
+	{"Locks", `SELECT l.pid, l.locktype, l.mode, l.granted, COALESCE(l.relation::regclass::text, '') AS relation,
+		pg_blocking_pids(l.pid) AS blocked_by
+		FROM pg_locks l WHERE l.pid <> pg_backend_pid() ORDER BY l.granted, l.pid`},
+	{"Settings (changed from default)", `SELECT name, setting, COALESCE(unit, '') AS unit, source FROM pg_settings
+		WHERE source NOT IN ('default', 'override', 'client', 'session') ORDER BY name`},
+	{"Database sizes", `SELECT datname, pg_size_pretty(pg_database_size(datname)) AS size, numbackends, xact_commit, xact_rollback,
+		deadlocks, temp_files, pg_size_pretty(temp_bytes) AS temp_bytes
+		FROM pg_stat_database JOIN pg_database USING (datname) WHERE datallowconn ORDER BY pg_database_size(datname) DESC`},
+	{"Largest tables", `SELECT schemaname, relname, pg_size_pretty(pg_total_relation_size(relid)) AS total_size,
+		n_live_tup, n_dead_tup, last_autovacuum, last_autoanalyze
+		FROM pg_stat_user_tables ORDER BY pg_total_relation_size(relid) DESC LIMIT 20`},
+	{"Replication", `SELECT application_name, client_addr, state, sync_state, sent_lsn, replay_lsn, write_lag, flush_lag, replay_lag
+		FROM pg_stat_replication`},
+	{"Replication slots", `SELECT slot_name, slot_type, active, restart_lsn,
+		pg_size_pretty(pg_wal_lsn_diff(CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END, restart_lsn)) AS retained_wal
+		FROM pg_replication_slots`},
+}
+
+// captureSnapshot writes a "flight recorder" report of the monitored server
+// - activity, locks, changed settings, sizes and replication - to a
+// timestamped file for a support ticket. Sections that fail, e.g. for lack
+// of privileges, are reported as such and don't stop the rest.
+func (m *Monitor) captureSnapshot() (string, error) {
+	db, err := m.openDB(m.config.DBName)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+	defer cancel()
+
+	now := time.Now()
+	var report strings.Builder
+	fmt.Fprintf(&report, "PG Monitor support snapshot of %s:%d/%s\n", m.config.Host, m.config.Port, m.config.DBName)
+	machine, _ := os.Hostname()
+	fmt.Fprintf(&report, "Captured %s on %s\n", now.Format("2006-01-02 15:04:05 -0700"), machine)
+	fmt.Fprintf(&report, "Last backup: %s\n", m.lastBackupStatus)
+
+	failed := 0
+	for _, s := range flightRecorderSections {
+		fmt.Fprintf(&report, "\n== %s ==\n", s.Title)
+		if err := writeQueryTable(ctx, db, &report, s.Query); err != nil {
+			fmt.Fprintf(&report, "(unavailable: %v)\n", err)
+			failed++
+		}
+	}
+
+	file := flightRecorderPrefix + now.Format("20060102_150405") + ".txt"
+	if err := os.WriteFile(file, []byte(report.String()), 0600); err != nil {
+		return "", err
+	}
+	m.audit(localActor(), "snapshot_captured", file, "")
+	if failed > 0 {
+		return fmt.Sprintf("written to %s (%d of %d sections unavailable)", file, failed, len(flightRecorderSections)), nil
+	}
+	return "written to " + file, nil
+}
+
+// writeQueryTable writes the result of query as an aligned table.
+func writeQueryTable(ctx context.Context, db *sql.DB, w *strings.Builder, query string) error {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(columns, "\t"))
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	n := 0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		cells := make([]string, len(values))
+		for i, v := range values {
+			cells[i] = strings.ReplaceAll(v.String, "\t", " ")
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "(%d rows)\n", n)
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ftpTLSExplicit = "explicit" // AUTH TLS on the normal port, required
+	ftpTLSImplicit = "implicit" // TLS from the start, ftps:// on port 990
+
+	curlFTPAccessDenied = 9 // CURLE_REMOTE_ACCESS_DENIED, also a missing folder
+)
+
+// ftpPath is name below the destination's Path, for FTP commands sent right
+// after login; a relative Path starts in the login directory.
+func ftpPath(d Destination, name string) string {
+	return path.Join(d.Path, name)
+}
+
+// ftpURL addresses name below the destination's Path for curl; a name of ""
+// addresses the folder itself, which curl lists. An absolute Path is written
+// with a leading %2F, as FTP URLs are relative to the login directory.
+func ftpURL(d Destination, name string) string {
+	scheme := "ftp"
+	if strings.EqualFold(d.TLS, ftpTLSImplicit) {
+		scheme = "ftps"
+	}
+	host := d.Host
+	if d.Port != 0 {
+		host = fmt.Sprintf("%s:%d", d.Host, d.Port)
+	}
+	p := ftpPath(d, name)
+	escaped := (&url.URL{Path: strings.TrimPrefix(p, "/")}).EscapedPath()
+	if strings.HasPrefix(p, "/") {
+		escaped = "%2F" + escaped
+	}
+	if name == "" && p != "" && !strings.HasSuffix(escaped, "/") {
+		escaped += "/"
+	}
+	return scheme + "://" + host + "/" + escaped
+}
+
+// ftpCurl prepares curl for one FTP request: passive mode unless ActiveMode,
+// and with TLS the server certificate checked against CAFile (default: the
+// system store) or pinned to PinnedKey.
+func ftpCurl(ctx context.Context, d Destination, args ...string) *exec.Cmd {
+	base := []string{"--fail", "-sS", "-u", d.User + ":" + d.Pass}
+	switch strings.ToLower(d.TLS) {
+	case ftpTLSExplicit:
+		base = append(base, "--ssl-reqd")
+	case ftpTLSImplicit:
+	case "":
+		if d.CAFile != "" || d.PinnedKey != "" {
+			log.Printf("Destination %s: CAFile/PinnedKey ignored without TLS", d.Name)
+		}
+	default:
+		log.Printf("Destination %s: unknown TLS %q, using explicit", d.Name, d.TLS)
+		base = append(base, "--ssl-reqd")
+	}
+	if d.CAFile != "" {
+		base = append(base, "--cacert", d.CAFile)
+	}
+	if d.PinnedKey != "" {
+		base = append(base, "--pinnedpubkey", d.PinnedKey)
+	}
+	if d.ActiveMode {
+		base = append(base, "--ftp-port", "-")
+	}
+	return exec.CommandContext(ctx, "curl", append(base, args...)...)
+}
+
+// ftpUploadArgs writes name as name.part, creating missing folders, and
+// renames it once the transfer is complete, replacing an older copy. The
+// rename runs in the file's folder, where curl is after the transfer.
+func ftpUploadArgs(d Destination, name string) []string {
+	part := name + sftpPartSuffix
+	return []string{
+		"--ftp-create-dirs",
+		"-Q", "-*DELE " + name,
+		"-Q", "-RNFR " + part,
+		"-Q", "-RNTO " + name,
+		ftpURL(d, part),
+	}
+}
+
+// uploadFTP uploads a file with curl, continuing an interrupted upload with
+// APPE.
+func (m *Monitor) uploadFTP(d Destination, filePath, limitRate string) error {
+	args := []string{"-C", "-"}
+	throttled := m.config.MaxUploadRateKBps > 0
+	if throttled {
+		args = append(args, "-T", "-")
+	} else {
+		args = append(args, "-T", filePath)
+	}
+	if limitRate != "" {
+		args = append(args, "--limit-rate", limitRate)
+	}
+	cmd := ftpCurl(context.Background(), d, append(args, ftpUploadArgs(d, filepath.Base(filePath))...)...)
+
+	var output []byte
+	var err error
+	if throttled {
+		output, err = m.feedThrottled(cmd, filePath)
+	} else {
+		output, err = cmd.CombinedOutput()
+	}
+	if err != nil {
+		return fmt.Errorf("curl failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// openFTP looks the file up in the folder listing, for its size and to
+// report a missing file as os.ErrNotExist, and then streams it.
+func openFTP(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	objects, err := listFTP(d)
+	if err != nil {
+		return nil, 0, err
+	}
+	size := int64(-1)
+	for _, o := range objects {
+		if o.Name == name {
+			size = o.Size
+		}
+	}
+	if size < 0 {
+		return nil, 0, os.ErrNotExist
+	}
+	body, err := startCurlDownload(ftpCurl(ctx, d, ftpURL(d, name)))
+	return body, size, err
+}
+
+func deleteFTP(d Destination, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteDeleteTimeout)
+	defer cancel()
+
+	// DELE runs before curl lists the folder, which is discarded
+	cmd := ftpCurl(ctx, d, "-o", os.DevNull, "-Q", "DELE "+ftpPath(d, name), ftpURL(d, ""))
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if curlExitCode(err) == curlQuoteError && strings.Contains(string(output), "550") {
+		return nil
+	}
+	return fmt.Errorf("curl failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+}
+
+// listFTP lists the files in the destination folder. Servers answer LIST
+// in their own format; the ls -l style of Unix servers and most NAS devices
+// is understood. A folder that doesn't exist yet is empty.
+func listFTP(d Destination) ([]remoteObject, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteListTimeout)
+	defer cancel()
+
+	cmd := ftpCurl(ctx, d, ftpURL(d, ""))
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if curlExitCode(err) == curlFTPAccessDenied {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("FTP list %s: %v, output: %s", d.Host, err, strings.TrimSpace(stderr.String()))
+	}
+	return parseListing(string(output)), nil
+}
+
+// openFTPSink streams into name with curl -T -; a killed curl never runs
+// the rename, so an aborted dump stays a .part file.
+func openFTPSink(d Destination, name string) (streamSink, error) {
+	return startCurlSink(ftpCurl(context.Background(), d, append([]string{"-T", "-"}, ftpUploadArgs(d, name)...)...))
+}
+
+// testFTPUpload is the FTP counterpart of testUploadTo.
+func testFTPUpload(d Destination) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+	defer cancel()
+
+	name := fmt.Sprintf("pg-monitor-test-%s.txt", time.Now().Format("20060102_150405"))
+	cmd := ftpCurl(ctx, d, append([]string{"-T", "-"}, ftpUploadArgs(d, name)...)...)
+	cmd.Stdin = strings.NewReader("pg-monitor upload test\n")
+	start := time.Now()
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("upload rejected: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	elapsed := time.Since(start)
+
+	if err := deleteFTP(d, name); err != nil {
+		log.Printf("Test upload: could not delete %s from %s: %v", name, d.Host, err)
+	}
+	return fmt.Sprintf("%s: uploaded and deleted %s in %v", d.Name, name, elapsed.Round(time.Millisecond)), nil
+}
+
+// ftpBackend stores "ftp" destinations.
+type ftpBackend struct{}
+
+func init() {
+	registerStorageBackend(destinationFTP, ftpBackend{})
+}
+
+func (ftpBackend) Put(m *Monitor, d Destination, filePath, rate string) error {
+	return m.uploadFTP(d, filePath, rate)
+}
+
+func (ftpBackend) Stream(m *Monitor, d Destination, name string) (streamSink, error) {
+	return openFTPSink(d, name)
+}
+
+func (ftpBackend) Open(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	return openFTP(ctx, d, name)
+}
+
+func (ftpBackend) Stat(d Destination, name string) (remoteObject, error) {
+	return statListed(listFTP, d, name)
+}
+
+func (ftpBackend) List(d Destination) ([]remoteObject, error) {
+	return listFTP(d)
+}
+
+func (ftpBackend) Delete(d Destination, name string) error {
+	return deleteFTP(d, name)
+}
+
+func (ftpBackend) Test(d Destination) (string, error) {
+	return testFTPUpload(d)
+}
+
+func (ftpBackend) Location(d Destination, name string) string {
+	return ftpURL(d, name)
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcsAPI            = "https://storage.googleapis.com/storage/v1/b/"
+	gcsUploadAPI      = "https://storage.googleapis.com/upload/storage/v1/b/"
+	gcsScope          = "https://www.googleapis.com/auth/devstorage.read_write"
+	gcsDefaultChunkMB = 16
+	gcsRequestTimeout = 30 * time.Minute
+)
+
+// googleCredentials is the part of a Google credentials file needed to get
+// access tokens: a service-account key, or an "authorized_user" file holding
+// an OAuth client and a refresh token.
+type googleCredentials struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type googleToken struct {
+	value   string
+	expires time.Time
+}
+
+var (
+	googleTokenMu sync.Mutex
+	googleTokens  = make(map[string]googleToken) // by credentials file and scope
+)
+
+// googleAccessToken returns an OAuth access token for the destination's
+// CredentialsFile, obtained with a signed JWT assertion (service account) or
+// the refresh token (authorized user) and cached until shortly before it
+// expires.
+func googleAccessToken(d Destination, scope string) (string, error) {
+	if d.CredentialsFile == "" {
+		return "", fmt.Errorf("destination %s needs a CredentialsFile", d.Name)
+	}
+	googleTokenMu.Lock()
+	defer googleTokenMu.Unlock()
+	cacheKey := d.CredentialsFile + " " + scope
+	if t, ok := googleTokens[cacheKey]; ok && time.Until(t.expires) > time.Minute {
+		return t.value, nil
+	}
+
+	data, err := os.ReadFile(d.CredentialsFile)
+	if err != nil {
+		return "", err
+	}
+	var creds googleCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return "", fmt.Errorf("%s is not a Google credentials file", d.CredentialsFile)
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	var form url.Values
+	switch {
+	case creds.Type == "authorized_user" && creds.RefreshToken != "":
+		// The scope was fixed when the refresh token was granted
+		form = url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {creds.ClientID},
+			"client_secret": {creds.ClientSecret},
+			"refresh_token": {creds.RefreshToken},
+		}
+	case creds.ClientEmail != "" && creds.PrivateKey != "":
+		assertion, err := googleAssertion(creds, scope, time.Now())
+		if err != nil {
+			return "", err
+		}
+		form = url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		}
+	default:
+		return "", fmt.Errorf("%s is neither a service-account key nor an authorized_user file", d.CredentialsFile)
+	}
+
+	client := &http.Client{Timeout: diagnosticTimeout}
+	resp, err := client.PostForm(creds.TokenURI, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error_description"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&token)
+	if resp.StatusCode >= 300 || token.AccessToken == "" {
+		return "", fmt.Errorf("Google token request: %s %s", resp.Status, token.Error)
+	}
+	googleTokens[cacheKey] = googleToken{value: token.AccessToken, expires: time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)}
+	return token.AccessToken, nil
+}
+
+// googleAssertion builds the RS256-signed JWT exchanged for an access token.
+func googleAssertion(sa googleCredentials, scope string, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("service-account private key is not PEM")
+	}
+	var key *rsa.PrivateKey
+	if parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		key, _ = parsed.(*rsa.PrivateKey)
+	} else if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return "", fmt.Errorf("service-account private key: %v", err)
+	}
+	if key == nil {
+		return "", fmt.Errorf("service-account private key is not RSA")
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   sa.ClientEmail,
+		"scope": scope,
+		"aud":   sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(signature), nil
+}
+
+func gcsObjectURL(d Destination, name string) string {
+	return gcsAPI + url.PathEscape(d.Bucket) + "/o/" + url.PathEscape(keyPrefix(d)+name)
+}
+
+// gcsDo sends req with the destination's access token; a missing object or
+// bucket is reported as os.ErrNotExist. A 308 is a resumable upload that
+// wants more data, not an error.
+func gcsDo(d Destination, req *http.Request) (*http.Response, error) {
+	if d.Bucket == "" {
+		return nil, fmt.Errorf("destination %s: GCS needs a Bucket", d.Name)
+	}
+	token, err := googleAccessToken(d, gcsScope)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: gcsRequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 || resp.StatusCode == http.StatusPermanentRedirect {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, &os.PathError{Op: req.Method, Path: req.URL.Path, Err: os.ErrNotExist}
+	}
+	var gcsErr struct {
+		Error struct {
+			Message string
+		}
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&gcsErr)
+	return nil, fmt.Errorf("GCS %s: %s %s", req.Method, resp.Status, gcsErr.Error.Message)
+}
+
+// resumableChunkSize is whole megabytes, so always the multiple of 256 KiB
+// that resumable uploads require.
+func (m *Monitor) resumableChunkSize() int64 {
+	if size := m.uploadChunkSize(); size > 0 {
+		return size
+	}
+	return gcsDefaultChunkMB * mb
+}
+
+// gcsStartSession opens a resumable upload of name and returns its session
+// URI. The object gets the destination's storage class and a customTime of
+// now, so bucket lifecycle rules can go by daysSinceCustomTime.
+func gcsStartSession(d Destination, name string) (string, error) {
+	metadata := map[string]string{
+		"name":       keyPrefix(d) + name,
+		"customTime": time.Now().UTC().Format(time.RFC3339),
+	}
+	if d.StorageClass != "" {
+		metadata["storageClass"] = strings.ToUpper(d.StorageClass)
+	}
+	body, _ := json.Marshal(metadata)
+
+	req, err := http.NewRequest(http.MethodPost, gcsUploadAPI+url.PathEscape(d.Bucket)+"/o?uploadType=resumable", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	resp, err := gcsDo(d, req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return "", fmt.Errorf("GCS returned no upload session")
+	}
+	return session, nil
+}
+
+// resumableDo sends an authorized request to a service with Google-style
+// resumable uploads, GCS or Drive.
+type resumableDo func(d Destination, req *http.Request) (*http.Response, error)
+
+// putResumableChunk sends the bytes at offset of an upload of total bytes (-1
+// while unknown) and returns the offset the server has persisted up to; done
+// is set when the object is complete.
+func putResumableChunk(do resumableDo, d Destination, session string, offset int64, body io.Reader, length, total int64) (next int64, done bool, err error) {
+	req, err := http.NewRequest(http.MethodPut, session, body)
+	if err != nil {
+		return 0, false, err
+	}
+	req.ContentLength = length
+	size := "*"
+	if total >= 0 {
+		size = strconv.FormatInt(total, 10)
+	}
+	if length > 0 {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", offset, offset+length-1, size))
+	} else {
+		req.Header.Set("Content-Range", "bytes */"+size)
+	}
+	resp, err := do(d, req)
+	if err != nil {
+		return 0, false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPermanentRedirect {
+		return total, true, nil
+	}
+	return resumablePersisted(resp), false, nil
+}
+
+// resumablePersisted reads the Range header of a 308: "bytes=0-<last>", absent
+// when nothing has been stored yet.
+func resumablePersisted(resp *http.Response) int64 {
+	_, last, ok := strings.Cut(resp.Header.Get("Range"), "-")
+	if !ok {
+		return 0
+	}
+	n, err := strconv.ParseInt(last, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n + 1
+}
+
+// uploadResumable uploads a file as a resumable upload in chunks of
+// UploadChunkMB (default 16 MB), opening the session with start. The session
+// is kept in upload-chunks.json, so a retried upload asks the server how much
+// it already has and continues from there; an expired session (a week old)
+// starts over.
+func (m *Monitor) uploadResumable(d Destination, filePath, rate string, do resumableDo, start func(Destination, string) (string, error)) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	key := d.Name + " " + remoteLocation(d, info.Name())
+	chunk := m.resumableChunkSize()
+	var t ChunkedTransfer
+	updateChunkState(key, func(s *ChunkedTransfer) bool {
+		if s.SessionURI == "" || s.Size != info.Size() || !s.ModTime.Equal(info.ModTime()) {
+			*s = ChunkedTransfer{Size: info.Size(), ModTime: info.ModTime(), ChunkSize: chunk, Started: time.Now()}
+		}
+		t = *s
+		return true
+	})
+
+	var offset int64
+	if t.SessionURI != "" {
+		next, done, err := putResumableChunk(do, d, t.SessionURI, 0, nil, 0, t.Size)
+		switch {
+		case os.IsNotExist(err):
+			t.SessionURI = ""
+		case err != nil:
+			return err
+		case done:
+			updateChunkState(key, func(*ChunkedTransfer) bool { return false })
+			return nil
+		default:
+			offset = next
+			log.Printf("Resuming upload of %s to %s at %s", info.Name(), d.Name, formatBytes(offset))
+		}
+	}
+	if t.SessionURI == "" {
+		if t.SessionURI, err = start(d, info.Name()); err != nil {
+			return err
+		}
+		updateChunkState(key, func(s *ChunkedTransfer) bool {
+			s.SessionURI = t.SessionURI
+			return true
+		})
+	}
+
+	for {
+		length := min64(chunk, t.Size-offset)
+		body := m.chunkBody(io.NewSectionReader(f, offset, length), rate)
+		next, done, err := putResumableChunk(do, d, t.SessionURI, offset, body, length, t.Size)
+		body.Close()
+		if err != nil {
+			if os.IsNotExist(err) {
+				updateChunkState(key, func(*ChunkedTransfer) bool { return false })
+			}
+			return fmt.Errorf("at %s of %s: %v", formatBytes(offset), formatBytes(t.Size), err)
+		}
+		if done {
+			break
+		}
+		offset = next
+	}
+	updateChunkState(key, func(*ChunkedTransfer) bool { return false })
+	return nil
+}
+
+func openGCS(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcsObjectURL(d, name)+"?alt=media", nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := gcsDo(d, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+func deleteGCS(d Destination, name string) error {
+	req, err := http.NewRequest(http.MethodDelete, gcsObjectURL(d, name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := gcsDo(d, req)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listGCS lists the objects directly below the prefix.
+func listGCS(d Destination) ([]remoteObject, error) {
+	prefix := keyPrefix(d)
+	var objects []remoteObject
+	page := ""
+	for {
+		query := url.Values{"prefix": {prefix}, "delimiter": {"/"}, "fields": {"items(name,size,updated),nextPageToken"}}
+		if page != "" {
+			query.Set("pageToken", page)
+		}
+		req, err := http.NewRequest(http.MethodGet, gcsAPI+url.PathEscape(d.Bucket)+"/o?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := gcsDo(d, req)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Items []struct {
+				Name    string
+				Size    string // int64 as a JSON string
+				Updated time.Time
+			}
+			NextPageToken string
+		}
+		err = json.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("GCS list %s: %v", d.Bucket, err)
+		}
+		for _, item := range result.Items {
+			if name := strings.TrimPrefix(item.Name, prefix); name != "" {
+				size, _ := strconv.ParseInt(item.Size, 10, 64)
+				objects = append(objects, remoteObject{Name: name, Size: size, Modified: item.Updated})
+			}
+		}
+		if result.NextPageToken == "" {
+			return objects, nil
+		}
+		page = result.NextPageToken
+	}
+}
+
+// resumableWriter streams into a resumable upload whose size is only known
+// when Finish sends the last chunk.
+type resumableWriter struct {
+	d       Destination
+	do      resumableDo
+	session string
+	chunk   int64
+	offset  int64
+	buf     []byte
+}
+
+func (m *Monitor) newResumableWriter(d Destination, name string, do resumableDo, start func(Destination, string) (string, error)) (*resumableWriter, error) {
+	session, err := start(d, name)
+	if err != nil {
+		return nil, err
+	}
+	return &resumableWriter{d: d, do: do, session: session, chunk: m.resumableChunkSize()}, nil
+}
+
+func (w *resumableWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for int64(len(w.buf)) >= w.chunk {
+		next, _, err := putResumableChunk(w.do, w.d, w.session, w.offset, bytes.NewReader(w.buf[:w.chunk]), w.chunk, -1)
+		if err != nil {
+			return 0, err
+		}
+		// The server may keep less than it was sent; resend the rest
+		kept := next - w.offset
+		w.buf = append(w.buf[:0], w.buf[kept:]...)
+		w.offset = next
+	}
+	return len(p), nil
+}
+
+func (w *resumableWriter) Finish() error {
+	total := w.offset + int64(len(w.buf))
+	_, done, err := putResumableChunk(w.do, w.d, w.session, w.offset, bytes.NewReader(w.buf), int64(len(w.buf)), total)
+	if err == nil && !done {
+		err = fmt.Errorf("the server did not accept the last %s", formatBytes(int64(len(w.buf))))
+	}
+	return err
+}
+
+// Abort cancels the session, so nothing is stored.
+func (w *resumableWriter) Abort() {
+	req, err := http.NewRequest(http.MethodDelete, w.session, nil)
+	if err != nil {
+		return
+	}
+	if resp, err := w.do(w.d, req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// testGCSUpload is the GCS counterpart of testUploadTo.
+func testGCSUpload(d Destination) (string, error) {
+	name := fmt.Sprintf("pg-monitor-test-%s.txt", time.Now().Format("20060102_150405"))
+	body := []byte("pg-monitor upload test\n")
+	start := time.Now()
+
+	req, err := http.NewRequest(http.MethodPost, gcsUploadAPI+url.PathEscape(d.Bucket)+"/o?uploadType=media&name="+url.QueryEscape(keyPrefix(d)+name), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := gcsDo(d, req)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("bucket %s does not exist", d.Bucket)
+		}
+		return "", fmt.Errorf("upload rejected: %v", err)
+	}
+	resp.Body.Close()
+	elapsed := time.Since(start)
+
+	if err := deleteGCS(d, name); err != nil {
+		log.Printf("Test upload: could not delete %s from %s: %v", name, d.Bucket, err)
+	}
+	return fmt.Sprintf("%s: uploaded and deleted %s in %v", d.Name, name, elapsed.Round(time.Millisecond)), nil
+}
+
+// gcsBackend stores "gcs" destinations.
+type gcsBackend struct{}
+
+func init() {
+	registerStorageBackend(destinationGCS, gcsBackend{})
+}
+
+func (gcsBackend) Put(m *Monitor, d Destination, filePath, rate string) error {
+	return m.uploadResumable(d, filePath, rate, gcsDo, gcsStartSession)
+}
+
+func (gcsBackend) Stream(m *Monitor, d Destination, name string) (streamSink, error) {
+	return m.newResumableWriter(d, name, gcsDo, gcsStartSession)
+}
+
+func (gcsBackend) Open(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	return openGCS(ctx, d, name)
+}
+
+func (gcsBackend) Stat(d Destination, name string) (remoteObject, error) {
+	return statListed(listGCS, d, name)
+}
+
+func (gcsBackend) List(d Destination) ([]remoteObject, error) {
+	return listGCS(d)
+}
+
+func (gcsBackend) Delete(d Destination, name string) error {
+	return deleteGCS(d, name)
+}
+
+func (gcsBackend) Test(d Destination) (string, error) {
+	return testGCSUpload(d)
+}
+
+func (gcsBackend) Location(d Destination, name string) string {
+	return fmt.Sprintf("gs://%s/%s%s", d.Bucket, keyPrefix(d), name)
+}
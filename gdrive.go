@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	driveAPI            = "https://www.googleapis.com/drive/v3/files"
+	driveUploadAPI      = "https://www.googleapis.com/upload/drive/v3/files"
+	driveScope          = "https://www.googleapis.com/auth/drive"
+	driveFolderMimeType = "application/vnd.google-apps.folder"
+	driveRequestTimeout = 30 * time.Minute
+)
+
+// driveFile is a file or folder as returned by files.list.
+type driveFile struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Size         string    `json:"size"` // int64 as a JSON string
+	ModifiedTime time.Time `json:"modifiedTime"`
+}
+
+// driveURL addresses the files API; every request supports shared drives.
+func driveURL(base, id string, query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("supportsAllDrives", "true")
+	if id != "" {
+		base += "/" + url.PathEscape(id)
+	}
+	return base + "?" + query.Encode()
+}
+
+// driveDo sends req with the destination's access token; a missing file or
+// folder is reported as os.ErrNotExist. A 308 is a resumable upload that
+// wants more data, not an error.
+func driveDo(d Destination, req *http.Request) (*http.Response, error) {
+	token, err := googleAccessToken(d, driveScope)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: driveRequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 || resp.StatusCode == http.StatusPermanentRedirect {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &os.PathError{Op: req.Method, Path: req.URL.Path, Err: os.ErrNotExist}
+	}
+	var driveErr struct {
+		Error struct {
+			Message string
+		}
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&driveErr)
+	return nil, fmt.Errorf("Google Drive %s: %s %s", req.Method, resp.Status, driveErr.Error.Message)
+}
+
+// driveQuote makes s a string literal of the files.list query language.
+func driveQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// driveList returns every file matching the files.list query q, across
+// pages.
+func driveList(d Destination, q string) ([]driveFile, error) {
+	var files []driveFile
+	page := ""
+	for {
+		query := url.Values{
+			"q":                         {q},
+			"fields":                    {"nextPageToken,files(id,name,size,modifiedTime)"},
+			"pageSize":                  {"1000"},
+			"corpora":                   {"allDrives"},
+			"includeItemsFromAllDrives": {"true"},
+		}
+		if page != "" {
+			query.Set("pageToken", page)
+		}
+		req, err := http.NewRequest(http.MethodGet, driveURL(driveAPI, "", query), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := driveDo(d, req)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Files         []driveFile
+			NextPageToken string
+		}
+		err = json.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Google Drive list: %v", err)
+		}
+		files = append(files, result.Files...)
+		if result.NextPageToken == "" {
+			return files, nil
+		}
+		page = result.NextPageToken
+	}
+}
+
+// driveFolder resolves the destination's folder: FolderID (default the
+// account's My Drive), then each element of Prefix below it by name. Drive
+// addresses folders by ID only, so a missing one is created when create is
+// set and reported as os.ErrNotExist otherwise.
+func driveFolder(d Destination, create bool) (string, error) {
+	id := d.FolderID
+	if id == "" {
+		id = "root"
+	}
+	for _, name := range strings.Split(strings.Trim(d.Prefix, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		found, err := driveList(d, fmt.Sprintf("name = %s and %s in parents and mimeType = '%s' and trashed = false", driveQuote(name), driveQuote(id), driveFolderMimeType))
+		if err != nil {
+			return "", err
+		}
+		if len(found) > 0 {
+			id = found[0].ID
+			continue
+		}
+		if !create {
+			return "", &os.PathError{Op: "find", Path: name, Err: os.ErrNotExist}
+		}
+		if id, err = driveCreateFolder(d, name, id); err != nil {
+			return "", err
+		}
+	}
+	return id, nil
+}
+
+func driveCreateFolder(d Destination, name, parent string) (string, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"name":     name,
+		"mimeType": driveFolderMimeType,
+		"parents":  []string{parent},
+	})
+	req, err := http.NewRequest(http.MethodPost, driveURL(driveAPI, "", url.Values{"fields": {"id"}}), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	resp, err := driveDo(d, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var folder driveFile
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&folder); err != nil || folder.ID == "" {
+		return "", fmt.Errorf("Google Drive created folder %s without an ID", name)
+	}
+	return folder.ID, nil
+}
+
+// driveFiles returns the IDs of the files called name in folder. Drive
+// allows several files of the same name in one folder.
+func driveFiles(d Destination, folder, name string) ([]string, error) {
+	files, err := driveList(d, fmt.Sprintf("name = %s and %s in parents and mimeType != '%s' and trashed = false", driveQuote(name), driveQuote(folder), driveFolderMimeType))
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, f := range files {
+		ids = append(ids, f.ID)
+	}
+	return ids, nil
+}
+
+// driveStartSession opens a resumable upload of name and returns its session
+// URI. A file of that name already in the folder gets the new content, so
+// names stay unique the way they are on every other destination.
+func driveStartSession(d Destination, name string) (string, error) {
+	folder, err := driveFolder(d, true)
+	if err != nil {
+		return "", err
+	}
+	ids, err := driveFiles(d, folder, name)
+	if err != nil {
+		return "", err
+	}
+
+	method, target := http.MethodPost, driveURL(driveUploadAPI, "", url.Values{"uploadType": {"resumable"}})
+	metadata := map[string]interface{}{"name": name, "parents": []string{folder}}
+	if len(ids) > 0 {
+		method, target = http.MethodPatch, driveURL(driveUploadAPI, ids[0], url.Values{"uploadType": {"resumable"}})
+		metadata = map[string]interface{}{}
+	}
+	body, _ := json.Marshal(metadata)
+
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	resp, err := driveDo(d, req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return "", fmt.Errorf("Google Drive returned no upload session")
+	}
+	return session, nil
+}
+
+// driveLocation names a file by its folder ID and Prefix path, for logging.
+func driveLocation(d Destination, name string) string {
+	folder := d.FolderID
+	if folder == "" {
+		folder = "root"
+	}
+	return fmt.Sprintf("gdrive://%s/%s%s", folder, keyPrefix(d), name)
+}
+
+func openDrive(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	folder, err := driveFolder(d, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	ids, err := driveFiles(d, folder, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(ids) == 0 {
+		return nil, 0, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, driveURL(driveAPI, ids[0], url.Values{"alt": {"media"}}), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := driveDo(d, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// deleteDrive deletes every file called name for good rather than moving it
+// to the trash, where it would still count against the storage quota. In a
+// shared drive that takes the Manager role.
+func deleteDrive(d Destination, name string) error {
+	folder, err := driveFolder(d, false)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ids, err := driveFiles(d, folder, name)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		req, err := http.NewRequest(http.MethodDelete, driveURL(driveAPI, id, nil), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := driveDo(d, req)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// listDrive lists the files directly in the destination's folder.
+func listDrive(d Destination) ([]remoteObject, error) {
+	folder, err := driveFolder(d, false)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files, err := driveList(d, fmt.Sprintf("%s in parents and mimeType != '%s' and trashed = false", driveQuote(folder), driveFolderMimeType))
+	if err != nil {
+		return nil, err
+	}
+	var objects []remoteObject
+	for _, f := range files {
+		size, _ := strconv.ParseInt(f.Size, 10, 64)
+		objects = append(objects, remoteObject{Name: f.Name, Size: size, Modified: f.ModifiedTime})
+	}
+	return objects, nil
+}
+
+// testDriveUpload is the Google Drive counterpart of testUploadTo.
+func testDriveUpload(d Destination) (string, error) {
+	name := fmt.Sprintf("pg-monitor-test-%s.txt", time.Now().Format("20060102_150405"))
+	body := []byte("pg-monitor upload test\n")
+	start := time.Now()
+
+	session, err := driveStartSession(d, name)
+	if err == nil {
+		_, _, err = putResumableChunk(driveDo, d, session, 0, bytes.NewReader(body), int64(len(body)), int64(len(body)))
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("folder %s does not exist or is not shared with these credentials", d.FolderID)
+		}
+		return "", fmt.Errorf("upload rejected: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	if err := deleteDrive(d, name); err != nil {
+		log.Printf("Test upload: could not delete %s from Google Drive: %v", name, err)
+	}
+	return fmt.Sprintf("%s: uploaded and deleted %s in %v", d.Name, name, elapsed.Round(time.Millisecond)), nil
+}
+
+// driveBackend stores "gdrive" destinations.
+type driveBackend struct{}
+
+func init() {
+	registerStorageBackend(destinationDrive, driveBackend{})
+}
+
+func (driveBackend) Put(m *Monitor, d Destination, filePath, rate string) error {
+	return m.uploadResumable(d, filePath, rate, driveDo, driveStartSession)
+}
+
+func (driveBackend) Stream(m *Monitor, d Destination, name string) (streamSink, error) {
+	return m.newResumableWriter(d, name, driveDo, driveStartSession)
+}
+
+func (driveBackend) Open(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	return openDrive(ctx, d, name)
+}
+
+func (driveBackend) Stat(d Destination, name string) (remoteObject, error) {
+	return statListed(listDrive, d, name)
+}
+
+func (driveBackend) List(d Destination) ([]remoteObject, error) {
+	return listDrive(d)
+}
+
+func (driveBackend) Delete(d Destination, name string) error {
+	return deleteDrive(d, name)
+}
+
+func (driveBackend) Test(d Destination) (string, error) {
+	return testDriveUpload(d)
+}
+
+func (driveBackend) Location(d Destination, name string) string {
+	return driveLocation(d, name)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	globalsKind = "globals"
+
+	// Not "vindija-bl_", which is followed by a database name: a database
+	// called "globals" would share its backups' names and retention
+	globalsFilePrefix = "vindija-bl-globals_"
+)
+
+// globalsFileName names a globals dump taken at t.
+func globalsFileName(t time.Time) string {
+	return globalsFilePrefix + t.Format("20060102_150405") + ".sql"
+}
+
+func isGlobalsDump(file string) bool {
+	return strings.HasPrefix(filepath.Base(file), globalsFilePrefix)
+}
+
+// dumpGlobals writes the roles, their memberships and settings, and the
+// tablespace definitions of the cluster to file with pg_dumpall
+// --globals-only, within BackupWindowMinutes; overrun reports whether it
+// ran past it.
+func (m *Monitor) dumpGlobals(source backupSource, file string) (overrun bool, err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, pgTool("pg_dumpall"),
+		"-h", source.Host,
+		"-p", fmt.Sprintf("%d", source.Port),
+		"-U", m.config.User,
+		"--globals-only",
+		"-f", file,
+	)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", m.config.Password))
+	window := m.watchBackupWindow(cancel, cmd, globalsKind, m.config.BackupWindowMinutes)
+	output, err := cmd.CombinedOutput()
+	window.Stop()
+	if err != nil {
+		os.Remove(file)
+		return window.Overrun(), fmt.Errorf("pg_dumpall --globals-only: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return window.Overrun(), nil
+}
+
+// backupGlobals dumps the globals as a backup of their own. Per-database
+// dumps don't contain roles, so restoring one onto a fresh server needs
+// this file first (psql -d postgres -f <file>).
+func (m *Monitor) backupGlobals(opts backupOptions) (entry CatalogEntry) {
+	entry = CatalogEntry{Database: globalsKind, Kind: globalsKind, Label: opts.Label, Started: time.Now()}
+	hooked := false
+	defer func() {
+		entry.Finished = time.Now()
+		m.catalogAdd(entry)
+		if hooked {
+			m.runPostBackupCommand(entry)
+		}
+	}()
+
+	fail := func(err error) CatalogEntry {
+		log.Printf("Globals backup failed: %v", err)
+		entry.Status = "Failed (globals)"
+		m.notifyBackup(false, "roles and tablespaces", err.Error())
+		return entry
+	}
+
+	source, err := m.selectBackupSource()
+	if err != nil {
+		return fail(err)
+	}
+	entry.Host = source.Host
+
+	backupDir := filepath.Join(".", "backups")
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return fail(err)
+	}
+	if err := m.checkBackupVolume(backupDir); err != nil {
+		return fail(err)
+	}
+	globalsFile := filepath.Join(backupDir, globalsFileName(time.Now()))
+	entry.File = filepath.Base(globalsFile)
+
+	hooked = true
+	if err := m.runPreBackupCommand(entry); err != nil {
+		return fail(err)
+	}
+
+	log.Printf("Backing up roles and tablespaces to %s", globalsFile)
+	if entry.Overrun, err = m.dumpGlobals(source, globalsFile); err != nil {
+		return fail(err)
+	}
+	if info, err := os.Stat(globalsFile); err == nil {
+		entry.Size = info.Size()
+	}
+
+	manifestFile := ""
+	manifest, err := m.newManifest(globalsFile, "", true, source, "")
+	if err == nil {
+		manifestFile, err = m.saveManifest(globalsFile, manifest)
+	}
+	if err != nil {
+		log.Printf("Failed to write manifest: %v", err)
+	}
+
+	if dests := m.destinationsFor(opts.Destination); m.config.UploadToCloud && len(dests) > 0 {
+		files, err := m.uploadFiles(globalsFile, manifestFile, &entry)
+		if err == nil {
+			entry.Uploaded, entry.UploadErrors, err = m.uploadWithQuorum(entry.File, files, dests)
+			m.cleanupSpooledFiles([]SpoolEntry{{Files: files}}, loadSpool())
+		}
+		if err != nil {
+			return fail(fmt.Errorf("upload: %v", err))
+		}
+	}
+
+	entry.Success = true
+	entry.Status = fmt.Sprintf("globals %s", formatBytes(entry.Size))
+	log.Printf("Globals backup complete: %s (%s)", entry.File, formatBytes(entry.Size))
+	return entry
+}
+
+// scheduledGlobals backs up the globals after a scheduled run when
+// BackupGlobals is set and the run dumped databases one by one; a
+// pg_dumpall backup includes them already. It reports false only when the
+// globals backup failed.
+func (m *Monitor) scheduledGlobals(wholeCluster bool) bool {
+	if !m.config.BackupGlobals || wholeCluster {
+		return true
+	}
+	return m.backupGlobals(backupOptions{Scheduled: true}).Success
+}
+
+// lastGlobalsBackup returns when the globals were last backed up on their
+// own, from the catalog.
+func lastGlobalsBackup() time.Time {
+	var last time.Time
+	entries, err := loadCatalog()
+	if err != nil {
+		return last
+	}
+	for _, e := range entries {
+		if e.Success && e.Kind == globalsKind && e.Database == globalsKind && e.Finished.After(last) {
+			last = e.Finished
+		}
+	}
+	return last
+}
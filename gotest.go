@@ -1,17 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/getlantern/systray"
 	_ "github.com/lib/pq"
 )
 
@@ -20,40 +24,336 @@ const (
 	connTimeout   = 5 * time.Second
 )
 
+// While the database stays unreachable, e.g. behind a VPN link that is down,
+// checks slow down in steps instead of failing every checkInterval; the
+// first successful check returns to checkInterval.
+var disconnectedBackoff = []struct {
+	after    time.Duration // unreachable for at least this long
+	interval time.Duration
+}{
+	{10 * time.Minute, 5 * time.Minute},
+	{time.Hour, 15 * time.Minute},
+}
+
 type Config struct {
-	Host              string
-	Port              int
-	User              string
-	Password          string
-	DBName            string
-	NextcloudURL      string // e.g., https://cloud.example.com/remote.php/dav/files/username/backups/
-	NextcloudUser     string
-	NextcloudPass     string
-	UploadToCloud     bool
-	AutoBackupEnabled bool
-	AutoBackupTime    string // Format: "15:04" (24-hour time, e.g., "02:30" for 2:30 AM)
-	AutoBackupAll     bool   // true = backup all databases, false = backup single database
+	Host               string
+	Port               int
+	User               string
+	Password           string
+	DBName             string
+	NextcloudURL       string // e.g., https://cloud.example.com/remote.php/dav/files/username/backups/
+	NextcloudUser      string
+	NextcloudPass      string
+	UploadToCloud      bool
+	LocalCopy          *bool // false streams plain dumps straight to the destinations (default true)
+	AutoBackupEnabled  bool
+	AutoBackupTime     string   // Format: "15:04" (24-hour time, e.g., "02:30" for 2:30 AM)
+	AutoBackupAll      bool     // true = backup all databases, false = backup single database
+	Databases          []string // scheduled backups dump each of these to its own file instead (AutoBackupAll is ignored)
+	MissedBackupPolicy string   // "run" (default) or "skip" a backup missed while asleep or not running
+
+	ConsistencyGroups []ConsistencyGroup // related databases dumped from snapshots taken together, after the scheduled backup
+	BackupGlobals     bool               // scheduled per-database backups also dump roles and tablespaces (pg_dumpall --globals-only)
+
+	BackupPreconditions      []Precondition // mounts, hosts or interfaces scheduled backups wait for
+	PreconditionWaitMinutes  int            // give up and skip the run after this long (default 30)
+	PreconditionRetrySeconds int            // recheck interval while waiting (default 60)
+
+	RetentionDays  int // delete local backups older than this after a successful run (0 = keep)
+	RetentionCount int // keep at most this many local backups per database (0 = no limit)
+
+	RetentionDaily   int // GFS, local and remote: keep the newest backup of each of the last N days
+	RetentionWeekly  int // ... of the last N ISO weeks
+	RetentionMonthly int // ... of the last N months
+
+	SizeAnomalyPercent int // alert when a backup's size deviates this much from the rolling average (0 = off)
+	SizeAnomalyWindow  int // number of previous backups averaged (default 7)
+
+	ClusterBackupStrategy   string // "dumpall" (default) or "split": globals + one custom-format dump per database
+	ClusterSplitThresholdGB int    // with "split", only split clusters larger than this (0 = always)
+
+	BackupVolumePolicy string // local server with backups on the PGDATA filesystem: "warn" (default), "refuse" or "off"
+	SignManifests      bool   // sign each backup manifest with an Ed25519 key
+	SigningKeyFile     string // PEM private key, generated on first use (public key written to <file>.pub)
+	ManifestChecksum   bool   // include the SHA-256 of the backup file in the manifest
+
+	VerifyBackups bool   // restore each dump into a scratch database and sanity-check it
+	VerifyHost    string // server for test restores, e.g. a disposable container (default: Host)
+	VerifyPort    int
+
+	PostBackupCheckCommand        string // run with the manifest path after each dump; non-zero exit fails the run
+	PostBackupCheckTimeoutMinutes int
+
+	PreBackupCommand            string // run before each dump, e.g. to quiesce an application; non-zero exit cancels the backup
+	PostBackupCommand           string // run after each backup that reached PreBackupCommand, successful or not, with its result in PGM_* variables
+	BackupCommandTimeoutMinutes int
+
+	BackupOverlapPolicy string // scheduled backup while another one runs: "queue" (default) or "skip"
+
+	ManifestServerSnapshot bool // record extensions, non-default settings and pg_hba rules in the manifest
+
+	IncrementalBackups      bool   // physical backups use pg_basebackup --incremental (PostgreSQL 17+, summarize_wal = on)
+	FullBackupEvery         int    // start a new chain after this many incremental backups
+	PhysicalRetentionChains int    // number of full backup chains to keep
+	PhysicalBackupFormat    string // "plain" (default, a data directory) or "tar" (gzipped tarballs; always full)
+	PhysicalBackupTime      string // take a physical backup daily at this time, e.g. "01:00" ("" = manual only)
+	PhysicalBackupWeekday   string // only on this day, e.g. "Sunday" ("" = daily)
+
+	// Staging refresh: the latest backup restored onto a staging server
+	StagingHost              string // staging server ("" = off)
+	StagingPort              int    // default 5432
+	StagingUser              string // default: User
+	StagingPassword          string // default: Password (with StagingUser unset)
+	StagingDatabase          string // production database to copy (default: DBName)
+	StagingTargetDatabase    string // database replaced on the staging server (default: StagingDatabase)
+	StagingRefreshTime       string // refresh daily at this time, e.g. "06:00" ("" = manual only)
+	StagingRefreshWeekday    string // only on this day, e.g. "Monday" ("" = daily)
+	StagingMaxBackupAgeHours int    // reuse a local backup this recent, else take one (default 24)
+	StagingMaskCommand       string // run against the restored copy before it goes live, e.g. to anonymize it
+	StagingSmokeTestCommand  string // run after masking; a non-zero exit keeps the previous copy
+
+	WALArchiving     bool   // stream WAL with pg_receivewal into backups/wal and upload it, for point-in-time recovery
+	WALSlot          string // replication slot pg_receivewal uses (default "pg_monitor_wal")
+	WALCompress      bool   // gzip segments as they are received
+	WALRetentionDays int    // keep uploaded segments locally this long (default 7)
+
+	TuningHintsEnabled bool // periodically compare key settings against simple heuristics
+	TuningReportHours  int  // how often the tuning report is regenerated
+	ServerMemoryMB     int  // RAM of the database server (0 = auto-detect for local servers only)
+
+	SecurityReportEnabled bool // periodically check pg_hba, password hashing, superusers and SSL
+	SecurityReportHours   int  // how often the security report is regenerated
+	SecurityMaxSuperusers int  // more login superusers than this is a finding (default 1)
+
+	MetricTimeoutSeconds int      // statement timeout for each monitoring query
+	DisabledMetrics      []string // collectors not run and hidden from the menu: "activity", "uptime", "dbsize", "replication"
+
+	Hosts              []string // additional HA cluster members ("host" or "host:port")
+	BackupSourcePolicy string   // "primary" (default) or "prefer-standby"
+
+	MaxStandbyLagSeconds int // don't dump from a standby lagging more than this (0 = no limit)
+
+	APIEnabled          bool              // serve the HTTP status/control API
+	APIListen           string            // listen address, e.g. "127.0.0.1:8765"
+	APIUsers            map[string]string // basic auth user -> token (empty = no authentication)
+	APITLSCert          string            // serve HTTPS with this certificate/key
+	APITLSKey           string
+	APIClientCA         string // require client certificates signed by this CA (mutual TLS)
+	RestoreDropOnCancel bool   // drop the target database when a restore is cancelled
+	WebhookSecret       string // HMAC key for POST /api/webhook/backup (empty = disabled)
+
+	Tags          map[string]string     // added to every notification, e.g. {"customer": "acme"}
+	Notifications []NotificationChannel // Slack, webhook and email channels with optional templates
+	QuietHours    string                // e.g. "22:00-07:00": non-critical notifications are held for a digest
+
+	BackupWindowMinutes int    // expected maximum duration of a backup (0 = unlimited); see DatabaseOverrides and ScheduleOverrides
+	BackupOverrunPolicy string // "alert", "throttle" (lower process priority) or "cancel"
+
+	SequenceCheckEnabled    bool // scan sequences and int4 primary keys for overflow risk
+	SequenceCheckMinutes    int
+	SequenceWarnPercent     int
+	SequenceCriticalPercent int
+
+	CriticalTables []string // tables whose row counts are tracked per check, e.g. "public.orders"
+	RowDropPercent int      // alert when one loses this share of its rows between checks (default 30)
+
+	MonitoredDatabases []string // databases whose size, connections and transaction rate are shown per check
+
+	SlotCheckEnabled bool // list replication slots and alert on inactive ones retaining WAL
+	SlotCheckMinutes int
+	SlotRetainedGB   float64 // retained WAL that makes an inactive slot alert
+
+	UntrustedRemote   bool   // encrypt uploads with OpenPGP and store them under opaque names
+	PGPRecipient      string // gpg key ID/email to encrypt to (public key only needed here)
+	PGPPassphraseFile string // alternative: symmetric encryption with this passphrase
+	PGPEncryptBackups bool   // encrypt every dump to PGPRecipient and keep only the .gpg file
+
+	EncryptBackups    bool   // AES-256-GCM encrypt backup and manifest before upload (not needed with UntrustedRemote)
+	EncryptionKey     string // base64 32-byte key
+	EncryptionKeyring string // alternative: OS keyring entry holding the base64 key
+
+	MetricsSink      string // "", "influxdb" or "timescale"
+	MetricsSinkURL   string // InfluxDB write URL, e.g. http://influx:8086/api/v2/write?org=ops&bucket=pg
+	MetricsSinkToken string // InfluxDB API token
+	MetricsSinkDSN   string // TimescaleDB/PostgreSQL connection string
+	MetricsSinkTable string // TimescaleDB table (default pg_monitor_metrics)
+
+	AutoSchedule      bool              // derive per-database frequency (hourly/daily/weekly) from size and change rate
+	ScheduleOverrides map[string]string // database -> "hourly", "daily", "weekly" or "off", optionally ",window=<minutes>"
+
+	DatabaseOverrides map[string]DatabaseOverride // database -> its own format, compression, schedule, retention and destinations
+
+	PgBinDir        string   // directory of pg_dump, pg_restore and psql ("" = PATH, else the usual install locations)
+	PgDumpExtraArgs []string // passed to every pg_dump, e.g. ["--no-owner", "--lock-wait-timeout=30s"]
+
+	DumpFormat         string // single-database dumps: "plain" (SQL, default), "custom" (pg_dump -Fc) or "directory" (-Fd)
+	DumpJobs           int    // parallel pg_dump/pg_restore jobs (-j) for the directory format (0 = 1)
+	CompressBackups    bool   // compress plain dumps (including pg_dumpall) while they are written
+	CompressionCodec   string // codec for CompressBackups: "gzip" (default) or "zstd" (needs the zstd binary)
+	DumpCompression    string // pg_dump -Z method: "" (none), "gzip", "zstd" or "lz4" (zstd/lz4 need pg_dump 16+)
+	CompressionLevel   int    // compression level (0 = method default)
+	CompressionThreads int    // worker threads for zstd (0 = single-threaded)
+
+	Destinations []Destination // upload targets; when empty the Nextcloud settings above are the only one
+	UploadQuorum int           // destinations that must succeed for the backup to count (0 = upload failures are not fatal)
+
+	BackfillCount     int    // offer to upload this many recent backups to a newly added destination (0 = don't offer)
+	BackfillLimitRate string // curl --limit-rate for backfill and catalog sync uploads, e.g. "2M" ("" = unlimited)
+	MaxUploadRateKBps int    // all uploads together stay below this many KB/s (0 = unlimited)
+	UploadChunkMB     int    // upload larger files to Nextcloud in resumable chunks of this size (0 = off, minimum 5)
+
+	CatalogSyncDestination string // upload the encrypted backup catalog to this destination ("" = off)
+	CatalogSyncMinutes     int    // at most this often, and only when it changed (default 60)
+
+	RemoteRetentionDays  int // delete uploaded backups older than this from each destination (0 = keep)
+	RemoteRetentionCount int // keep at most this many uploaded backups per database and destination (0 = no limit)
+
+	IconMode               string // "connection" (default) or "backup-age": green/yellow/red by last backup age
+	BackupAgeWarnHours     int    // yellow from this age (default 24)
+	BackupAgeCriticalHours int    // red from this age (default 72)
+
+	ExplainAllowAnalyze bool // allow EXPLAIN ANALYZE in the explain window (runs the query, then rolls back)
+
+	IncludeSchemas []string // dump only these schemas of DBName (pg_dump -n, patterns allowed)
+	IncludeTables  []string // dump only these tables of DBName (pg_dump -t, patterns allowed)
+
+	RecentPartitionsDays    int    // scheduled dumps skip the rows of range partitions older than this (0 = off)
+	RecentPartitionsFullDay string // ... except on this day, e.g. "Sunday" (default)
+
+	IncludeForeignData   []string // foreign server patterns whose remote rows are dumped (pg_dump --include-foreign-data, 13+)
+	SkipForeignDataCheck bool     // don't warn about foreign servers and user mappings before a dump
+
+	QuitProtection string // "", "passcode" or "admin": required to quit or pause auto backups
+	QuitPasscode   string
+
+	LockWatchEnabled bool // warn when a running dump blocks other sessions
+	LockWarnSeconds  int  // how long a session must wait before warning (default 30)
+	LockAbortSeconds int  // cancel the dump when a session waits this long (0 = never)
+
+	QuickActionsHotkey string // global hotkey opening the quick action palette, e.g. "Ctrl+Alt+B" (Windows; "" = none)
+
+	SystemLog       bool     // also write events to syslog (Unix) or the Windows Event Log
+	SystemLogEvents []string // events written there (empty = backup, connectivity and config events)
 }
 
 type Monitor struct {
 	config            Config
 	db                *sql.DB
-	statusItem        *systray.MenuItem
-	uptimeItem        *systray.MenuItem
-	connsItem         *systray.MenuItem
-	lastCheck         *systray.MenuItem
-	lastBackupItem    *systray.MenuItem
-	nextBackupItem    *systray.MenuItem
-	backupItem        *systray.MenuItem
-	backupAllItem     *systray.MenuItem
+	statusItem        *MenuItem
+	uptimeItem        *MenuItem
+	connsItem         *MenuItem
+	sizeItem          *MenuItem
+	lastCheck         *MenuItem
+	lastBackupItem    *MenuItem
+	historyItem       *MenuItem
+	historyItems      []*MenuItem
+	destinationsItem  *MenuItem
+	destinationItems  []*MenuItem
+	nextBackupItem    *MenuItem
+	backupItem        *MenuItem
+	backupAllItem     *MenuItem
+	baseBackupItem    *MenuItem
+	autoBackupItem    *MenuItem
+	tuningItem        *MenuItem
+	tuningHintItems   []*MenuItem
+	securityItem      *MenuItem
+	securityItems     []*MenuItem
+	sequenceItem      *MenuItem
+	rowCountItem      *MenuItem
+	databasesItem     *MenuItem
+	databaseItems     []*MenuItem
+	slotItem          *MenuItem
+	slotItems         []*MenuItem
+	walItem           *MenuItem
+	queueItem         *MenuItem
+	schemaItems       []*MenuItem
+	backfillItem      *MenuItem
+	apiKeyItem        *MenuItem
+	restoreItem       *MenuItem
+	cancelRestoreItem *MenuItem
+	diagResultItem    *MenuItem
+	planItem          *MenuItem
+	planItems         []*MenuItem
 	isConnected       bool
+	checked           bool
+	downSince         time.Time     // first failed check of the current outage
+	reconnected       chan struct{} // wakes monitorLoop when a manual check succeeds
 	startTime         time.Time
 	lastBackupTime    time.Time
 	lastBackupStatus  string
 	nextScheduledTime time.Time
+
+	mu          sync.Mutex
+	restore     *RestoreJob
+	paused      bool
+	pausedUntil time.Time // zero while paused until resumed or restarted
+	pauseTimer  *time.Timer
+
+	backfillOffers []string // new destinations offered a backfill
+	backfilling    bool
 }
 
 func main() {
+	verifyFile := flag.String("verify", "", "verify a backup file against its manifest and exit")
+	combineTarget := flag.String("combine", "", "reconstruct a physical backup (and its incremental chain) and exit")
+	combineOutput := flag.String("output", "", "output data directory for -combine and -pitr, or file for -decrypt")
+	pitrTarget := flag.String("pitr", "", "prepare -output for point-in-time recovery to this local time (\"2006-01-02 15:04[:05]\") and exit")
+	decryptFile := flag.String("decrypt", "", "decrypt a downloaded .enc backup or manifest and exit")
+	restoreFile := flag.String("restore", "", "restore a backup file and exit")
+	restoreTarget := flag.String("target", "", "target database for -restore")
+	restoreFrom := flag.String("from", "", "stream the -restore file from this upload destination instead of the backups directory; with -simulate-retention, simulate that destination's backups")
+	restoreDrop := flag.Bool("drop", false, "drop and recreate the -target database before restoring (asks for confirmation)")
+	holdFile := flag.String("hold", "", "place a legal hold on a backup and exit")
+	releaseFile := flag.String("release", "", "lift the legal hold from a backup and exit")
+	holdReason := flag.String("reason", "", "reason recorded in the audit log for -hold/-release")
+	recoverFrom := flag.String("recover-catalog", "", "merge the catalog synced to this destination into the local one and exit")
+	convertFile := flag.String("convert", "", "convert a backup to the -to format and exit")
+	convertTo := flag.String("to", "", "target of -convert: plain, custom, gzip, zstd, uncompressed or pgp (re-encrypt)")
+	benchFile := flag.String("benchmark-compression", "", "time compression levels on a sample of this file and exit")
+	simulatePolicy := flag.String("simulate-retention", "", "report what a retention policy (\"days=30,count=10,daily=7,weekly=4,monthly=12\", or \"current\") would delete now and keep over the next 12 months, and exit")
+	icsFile := flag.String("ics", "", "write the upcoming backup schedule as an iCalendar file and exit")
+	createKey := flag.String("create-api-key", "", "create an HTTP API key with this name and -scopes, print its token and exit")
+	keyScopes := flag.String("scopes", "", "comma-separated scopes for -create-api-key: read-status, trigger-backup, restore")
+	revokeKey := flag.String("revoke-api-key", "", "revoke the HTTP API key with this ID or name and exit")
+	listKeys := flag.Bool("list-api-keys", false, "list HTTP API keys and exit")
+	selfTestRun := flag.Bool("selftest", false, "run backup, verification, restore and retention against a disposable PostgreSQL cluster and exit")
+	installSvc := flag.Bool("install-service", false, "start pg-monitor from the current directory at every login and exit")
+	uninstallSvc := flag.Bool("uninstall-service", false, "stop starting pg-monitor at login and exit")
+	flag.Parse()
+
+	// App bundles keep their state outside the bundle
+	if dir := appDataDir(); dir != "" {
+		if err := os.MkdirAll(dir, 0700); err == nil {
+			os.Chdir(dir)
+		}
+	}
+
+	if *installSvc {
+		var dir, path string
+		exe, err := os.Executable()
+		if err == nil {
+			dir, err = os.Getwd()
+		}
+		if err == nil {
+			path, err = installService(exe, dir)
+		}
+		if err != nil {
+			fmt.Printf("Service installation FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("pg-monitor will start at login in %s (%s)\n", dir, path)
+		return
+	}
+
+	if *uninstallSvc {
+		if err := uninstallService(); err != nil {
+			fmt.Printf("Service removal FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("pg-monitor no longer starts at login")
+		return
+	}
+
 	// Setup logging to file
 	logFile, err := os.OpenFile("pg-monitor.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err == nil {
@@ -61,6 +361,11 @@ func main() {
 		defer logFile.Close()
 	}
 	log.Printf("=== PostgreSQL Monitor Started ===")
+	logChaosMode()
+
+	if *selfTestRun {
+		os.Exit(runSelfTest())
+	}
 
 	// Load configuration from file
 	config, err := loadConfig("config.json")
@@ -69,19 +374,191 @@ func main() {
 		log.Printf("Creating default config.json file...")
 
 		// Create default config
+		keepLocal := true
 		defaultConfig := Config{
-			Host:              "localhost",
-			Port:              5432,
-			User:              "postgres",
-			Password:          "your_password",
-			DBName:            "your_database",
-			NextcloudURL:      "", // e.g., "https://cloud.example.com/remote.php/dav/files/username/backups/"
-			NextcloudUser:     "",
-			NextcloudPass:     "",
-			UploadToCloud:     false,
-			AutoBackupEnabled: true,
-			AutoBackupTime:    "02:00",
-			AutoBackupAll:     true,
+			Host:               "localhost",
+			Port:               5432,
+			User:               "postgres",
+			Password:           "your_password",
+			DBName:             "your_database",
+			NextcloudURL:       "", // e.g., "https://cloud.example.com/remote.php/dav/files/username/backups/"
+			NextcloudUser:      "",
+			NextcloudPass:      "",
+			UploadToCloud:      false,
+			LocalCopy:          &keepLocal,
+			AutoBackupEnabled:  true,
+			AutoBackupTime:     "02:00",
+			AutoBackupAll:      true,
+			Databases:          []string{},
+			MissedBackupPolicy: missedRun,
+
+			ConsistencyGroups: []ConsistencyGroup{},
+			BackupGlobals:     false,
+
+			BackupPreconditions:      []Precondition{},
+			PreconditionWaitMinutes:  30,
+			PreconditionRetrySeconds: 60,
+
+			RetentionDays:  0,
+			RetentionCount: 0,
+
+			RetentionDaily:   0,
+			RetentionWeekly:  0,
+			RetentionMonthly: 0,
+
+			SizeAnomalyPercent: 50,
+			SizeAnomalyWindow:  defaultSizeAnomalyWindow,
+
+			ClusterBackupStrategy:   clusterDumpall,
+			ClusterSplitThresholdGB: 50,
+
+			BackupVolumePolicy: volumeWarn,
+			SignManifests:      false,
+			SigningKeyFile:     defaultSigningKey,
+			ManifestChecksum:   true,
+
+			VerifyBackups: false,
+
+			PostBackupCheckCommand:        "",
+			PostBackupCheckTimeoutMinutes: 30,
+
+			PreBackupCommand:            "",
+			PostBackupCommand:           "",
+			BackupCommandTimeoutMinutes: 10,
+
+			BackupOverlapPolicy: overlapQueue,
+
+			ManifestServerSnapshot: true,
+
+			IncrementalBackups:       false,
+			FullBackupEvery:          defaultFullBackupEvery,
+			PhysicalRetentionChains:  defaultPhysicalChains,
+			PhysicalBackupFormat:     physicalFormatPlain,
+			PhysicalBackupTime:       "",
+			PhysicalBackupWeekday:    "",
+			StagingMaxBackupAgeHours: defaultStagingBackupAge,
+
+			WALArchiving:     false,
+			WALSlot:          defaultWALSlot,
+			WALCompress:      true,
+			WALRetentionDays: defaultWALRetention,
+
+			TuningHintsEnabled: true,
+			TuningReportHours:  defaultTuningHours,
+			ServerMemoryMB:     0,
+
+			SecurityReportEnabled: false,
+			SecurityReportHours:   defaultSecurityHours,
+			SecurityMaxSuperusers: 1,
+
+			MetricTimeoutSeconds: int(defaultMetricTimeout.Seconds()),
+			DisabledMetrics:      []string{},
+
+			Hosts:              []string{},
+			BackupSourcePolicy: sourcePrimary,
+
+			MaxStandbyLagSeconds: 300,
+
+			APIEnabled:          false,
+			APIListen:           defaultAPIListen,
+			APIUsers:            map[string]string{},
+			APITLSCert:          "",
+			APITLSKey:           "",
+			APIClientCA:         "",
+			RestoreDropOnCancel: false,
+			WebhookSecret:       "",
+
+			Tags:          map[string]string{},
+			Notifications: []NotificationChannel{},
+			QuietHours:    "",
+
+			BackupWindowMinutes: 0,
+			BackupOverrunPolicy: overrunAlert,
+
+			SequenceCheckEnabled:    true,
+			SequenceCheckMinutes:    defaultSequenceCheckMinutes,
+			SequenceWarnPercent:     defaultSequenceWarnPct,
+			SequenceCriticalPercent: defaultSequenceCriticalPct,
+
+			CriticalTables: []string{},
+			RowDropPercent: defaultRowDropPercent,
+
+			MonitoredDatabases: []string{},
+
+			SlotCheckEnabled: true,
+			SlotCheckMinutes: defaultSlotCheckMinutes,
+			SlotRetainedGB:   defaultSlotRetainedGB,
+
+			UntrustedRemote:   false,
+			PGPRecipient:      "",
+			PGPPassphraseFile: "",
+			PGPEncryptBackups: false,
+
+			EncryptBackups:    false,
+			EncryptionKey:     "",
+			EncryptionKeyring: "",
+
+			MetricsSink:      "",
+			MetricsSinkURL:   "",
+			MetricsSinkToken: "",
+			MetricsSinkDSN:   "",
+			MetricsSinkTable: defaultSinkTable,
+
+			AutoSchedule:      false,
+			ScheduleOverrides: map[string]string{},
+			DatabaseOverrides: map[string]DatabaseOverride{},
+
+			PgBinDir:        "",
+			PgDumpExtraArgs: []string{},
+
+			DumpFormat:         dumpFormatPlain,
+			DumpJobs:           0,
+			CompressBackups:    false,
+			CompressionCodec:   compressionGzip,
+			DumpCompression:    "",
+			CompressionLevel:   0,
+			CompressionThreads: 0,
+
+			Destinations: []Destination{},
+			UploadQuorum: 0,
+
+			BackfillCount:     3,
+			BackfillLimitRate: "",
+			MaxUploadRateKBps: 0,
+			UploadChunkMB:     0,
+
+			CatalogSyncDestination: "",
+			CatalogSyncMinutes:     60,
+
+			RemoteRetentionDays:  0,
+			RemoteRetentionCount: 0,
+
+			IconMode:               iconModeConnection,
+			BackupAgeWarnHours:     defaultBackupAgeWarnHours,
+			BackupAgeCriticalHours: defaultBackupAgeCriticalHours,
+
+			ExplainAllowAnalyze: false,
+
+			IncludeSchemas: []string{},
+			IncludeTables:  []string{},
+
+			RecentPartitionsDays:    0,
+			RecentPartitionsFullDay: defaultPartitionFullDay,
+
+			IncludeForeignData:   []string{},
+			SkipForeignDataCheck: false,
+
+			QuitProtection: "",
+			QuitPasscode:   "",
+
+			LockWatchEnabled: false,
+			LockWarnSeconds:  30,
+			LockAbortSeconds: 0,
+
+			QuickActionsHotkey: "Ctrl+Alt+B",
+
+			SystemLog:       false,
+			SystemLogEvents: []string{},
 		}
 
 		if err := saveConfig("config.json", defaultConfig); err != nil {
@@ -93,11 +570,172 @@ func main() {
 	}
 
 	monitor := &Monitor{
-		config:    config,
-		startTime: time.Now(),
+		config:      config,
+		startTime:   time.Now(),
+		reconnected: make(chan struct{}, 1),
+	}
+	monitor.findPgTools()
+
+	if *decryptFile != "" {
+		if err := monitor.decryptCLI(*decryptFile, *combineOutput); err != nil {
+			fmt.Printf("Decryption FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Decryption OK")
+		return
+	}
+
+	if *verifyFile != "" {
+		if err := monitor.verifyBackup(*verifyFile); err != nil {
+			fmt.Printf("Verification FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Verification OK")
+		return
+	}
+
+	if *combineTarget != "" {
+		if *combineOutput == "" {
+			fmt.Println("-combine requires -output")
+			os.Exit(2)
+		}
+		if err := monitor.combineBaseBackup(*combineTarget, *combineOutput); err != nil {
+			fmt.Printf("Combine FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Data directory written to %s\n", *combineOutput)
+		return
 	}
 
-	systray.Run(monitor.onReady, monitor.onExit)
+	if *pitrTarget != "" {
+		if *combineOutput == "" {
+			fmt.Println("-pitr requires -output")
+			os.Exit(2)
+		}
+		target, err := parsePITRTime(*pitrTarget)
+		if err != nil {
+			fmt.Printf("PITR FAILED: %v\n", err)
+			os.Exit(2)
+		}
+		if err := monitor.pointInTimeRestore(target, *combineOutput); err != nil {
+			fmt.Printf("PITR FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Data directory prepared in %s; start PostgreSQL on it (pg_ctl -D %s start) to replay WAL up to %s\n", *combineOutput, *combineOutput, target.Format("2006-01-02 15:04:05"))
+		return
+	}
+
+	if *restoreFile != "" {
+		if err := monitor.restoreCLI(*restoreFile, *restoreFrom, *restoreTarget, *restoreDrop); err != nil {
+			fmt.Printf("Restore FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Restore completed")
+		return
+	}
+
+	if *recoverFrom != "" {
+		added, err := monitor.recoverCatalog(*recoverFrom)
+		if err != nil {
+			fmt.Printf("Catalog recovery FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Catalog recovered: %d entries added\n", added)
+		return
+	}
+
+	if *convertFile != "" {
+		converted, err := monitor.convertBackup(*convertFile, *convertTo)
+		if err != nil {
+			fmt.Printf("Conversion FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Converted to %s\n", converted)
+		return
+	}
+
+	if *benchFile != "" {
+		if err := monitor.benchmarkCompression(*benchFile); err != nil {
+			fmt.Printf("Benchmark FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *simulatePolicy != "" {
+		policy := monitor.localRetention()
+		if d, ok := monitor.destination(*restoreFrom); ok {
+			policy = monitor.remoteRetention(d)
+		}
+		if *simulatePolicy != "current" {
+			var err error
+			if policy, err = parseRetentionPolicy(*simulatePolicy); err != nil {
+				fmt.Printf("Retention simulation FAILED: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		report, err := monitor.simulateRetention(*restoreFrom, policy, time.Now())
+		if err != nil {
+			fmt.Printf("Retention simulation FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		printRetentionReport(report)
+		return
+	}
+
+	if *icsFile != "" {
+		if err := monitor.writeScheduleICS(*icsFile); err != nil {
+			fmt.Printf("Calendar export FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Backup schedule written to %s\n", *icsFile)
+		return
+	}
+
+	if *createKey != "" {
+		scopes, err := parseScopes(*keyScopes)
+		if err != nil {
+			fmt.Printf("API key creation FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		key, token, err := monitor.createAPIKey(*createKey, scopes, localActor())
+		if err != nil {
+			fmt.Printf("API key creation FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("API key %s (%s) created with scopes %s\n", key.ID, key.Name, strings.Join(key.Scopes, ", "))
+		fmt.Printf("Token (shown only now): %s\n", token)
+		return
+	}
+
+	if *revokeKey != "" {
+		if err := monitor.revokeAPIKey(*revokeKey, localActor()); err != nil {
+			fmt.Printf("API key revocation FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("API key revoked")
+		return
+	}
+
+	if *listKeys {
+		printAPIKeys()
+		return
+	}
+
+	if *holdFile != "" || *releaseFile != "" {
+		file, hold := *holdFile, true
+		if file == "" {
+			file, hold = *releaseFile, false
+		}
+		if err := monitor.setLegalHold(file, hold, *holdReason, localActor()); err != nil {
+			fmt.Printf("Legal hold FAILED: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Legal hold updated")
+		return
+	}
+
+	tray.Run(monitor.onReady, monitor.onExit)
 }
 
 func loadConfig(filename string) (Config, error) {
@@ -122,37 +760,120 @@ func saveConfig(filename string, config Config) error {
 }
 
 func (m *Monitor) onReady() {
-	systray.SetIcon(getIcon(false))
-	systray.SetTitle("PG Monitor")
-	systray.SetTooltip("PostgreSQL Monitor")
+	m.seedLastBackup()
+	m.refreshIcon()
+	tray.SetTitle("PG Monitor")
+	tray.SetTooltip("PostgreSQL Monitor")
 
-	m.statusItem = systray.AddMenuItem("Status: Checking...", "Current connection status")
+	m.statusItem = tray.AddMenuItem("Status: Checking...", "Current connection status")
 	m.statusItem.Disable()
 
-	m.connsItem = systray.AddMenuItem("Active Connections: -", "Number of active connections")
+	m.connsItem = tray.AddMenuItem("Active Connections: -", "Number of active connections")
 	m.connsItem.Disable()
 
-	m.uptimeItem = systray.AddMenuItem("Uptime: -", "Database uptime")
+	m.uptimeItem = tray.AddMenuItem("Uptime: -", "Database uptime")
 	m.uptimeItem.Disable()
 
-	m.lastCheck = systray.AddMenuItem("Last Check: -", "Last check timestamp")
+	m.sizeItem = tray.AddMenuItem("DB Size: -", "Size of the monitored database")
+	m.sizeItem.Disable()
+
+	m.checkDisabledMetrics()
+	for name, item := range map[string]*MenuItem{metricActivity: m.connsItem, metricUptime: m.uptimeItem, metricDBSize: m.sizeItem} {
+		if !m.metricEnabled(name) {
+			item.Hide()
+		}
+	}
+
+	m.lastCheck = tray.AddMenuItem("Last Check: -", "Last check timestamp")
 	m.lastCheck.Disable()
 
-	systray.AddSeparator()
+	if m.config.SequenceCheckEnabled {
+		m.addSequenceMenu()
+	}
+
+	if len(m.config.CriticalTables) > 0 && m.metricEnabled(metricRowCounts) {
+		m.addRowCountMenu()
+	}
+
+	if len(m.config.MonitoredDatabases) > 0 && m.metricEnabled(metricDatabases) {
+		m.addDatabasesMenu()
+	}
+
+	if m.slotCheckEnabled() {
+		m.addSlotMenu()
+	}
+
+	if m.config.WALArchiving {
+		m.addWALMenu()
+	}
 
-	m.lastBackupItem = systray.AddMenuItem("Last Backup: Never", "Last successful backup")
+	tray.AddSeparator()
+
+	m.lastBackupItem = tray.AddMenuItem("Last Backup: Never", "Last successful backup")
 	m.lastBackupItem.Disable()
+	m.updateBackupStatus()
+	m.addHistoryMenu()
+	if m.config.UploadToCloud {
+		m.addDestinationsMenu()
+	}
 
-	m.nextBackupItem = systray.AddMenuItem("Next Backup: -", "Next scheduled backup")
+	m.nextBackupItem = tray.AddMenuItem("Next Backup: -", "Next scheduled backup")
 	m.nextBackupItem.Disable()
+	m.addQueueMenu()
+
+	if m.config.AutoBackupEnabled && m.config.AutoSchedule {
+		m.addPlanMenu()
+	}
+
+	m.restoreItem = tray.AddMenuItem("Restore: -", "Restore progress")
+	m.restoreItem.Disable()
+	m.restoreItem.Hide()
+	m.cancelRestoreItem = tray.AddMenuItem("Cancel Restore", "Stop the running restore")
+	m.cancelRestoreItem.Hide()
 
-	systray.AddSeparator()
+	if m.config.TuningHintsEnabled || m.config.SecurityReportEnabled {
+		tray.AddSeparator()
+	}
+	if m.config.TuningHintsEnabled {
+		m.addTuningMenu()
+	}
+	if m.config.SecurityReportEnabled {
+		m.addSecurityMenu()
+	}
 
-	refreshItem := systray.AddMenuItem("Refresh Now", "Check database status now")
-	m.backupItem = systray.AddMenuItem("Backup Database", "Create database backup")
-	m.backupAllItem = systray.AddMenuItem("Backup All Databases", "Create full server backup")
-	systray.AddSeparator()
-	quitItem := systray.AddMenuItem("Quit", "Exit the application")
+	tray.AddSeparator()
+
+	refreshItem := tray.AddMenuItem("Refresh Now", "Check database status now")
+	m.backupItem = tray.AddMenuItem("Backup Database", "Create database backup")
+	m.backupAllItem = tray.AddMenuItem("Backup All Databases", "Create full server backup")
+	m.baseBackupItem = tray.AddMenuItem("Physical Backup", "pg_basebackup of the whole cluster")
+	browseItem := tray.AddMenuItem("Browse Backups...", "Retained backups by database and month")
+	m.addSchemaMenu()
+	if len(m.config.ConsistencyGroups) > 0 {
+		m.addConsistencyGroupMenu()
+	}
+	if m.config.StagingHost != "" {
+		m.addStagingMenu()
+	}
+	restoreDBItem := tray.AddMenuItem("Restore Database...", "Restore a backup into a chosen database")
+	if m.config.UploadToCloud {
+		m.addBackfillMenu()
+	}
+	if m.config.AutoBackupEnabled {
+		m.autoBackupItem = tray.AddMenuItemCheckbox("Auto Backups", "Pause or resume scheduled backups", true)
+		m.addPauseMenu()
+		m.restorePause()
+	} else {
+		m.autoBackupItem = tray.AddMenuItem("Auto Backups", "")
+		m.autoBackupItem.Hide()
+	}
+	if m.config.APIEnabled {
+		m.apiKeyItem = tray.AddMenuItem("Approve API key", "Allow a key requested over the HTTP API")
+		m.apiKeyItem.Hide()
+	}
+	tray.AddSeparator()
+	m.addDiagnosticsMenu()
+	quitItem := tray.AddMenuItem("Quit", "Exit the application")
 
 	// Initial check
 	go m.checkDatabase()
@@ -161,10 +882,64 @@ func (m *Monitor) onReady() {
 	go m.monitorLoop()
 
 	// Start scheduled backup scheduler
-	if m.config.AutoBackupEnabled {
+	m.checkDatabaseOverrides()
+	if m.config.AutoBackupEnabled && m.config.AutoSchedule {
+		go m.autoScheduleLoop()
+	} else if m.config.AutoBackupEnabled {
 		go m.scheduleBackups()
 	}
 
+	if m.config.PhysicalBackupTime != "" {
+		go m.physicalScheduleLoop()
+	}
+
+	if m.config.StagingHost != "" && m.config.StagingRefreshTime != "" {
+		go m.stagingLoop()
+	}
+
+	if m.config.WALArchiving {
+		go m.walArchiveLoop()
+	}
+
+	if m.config.TuningHintsEnabled {
+		go m.tuningLoop()
+	}
+
+	if m.config.SecurityReportEnabled {
+		go m.securityLoop()
+	}
+
+	if m.config.SequenceCheckEnabled {
+		go m.sequenceLoop()
+	}
+
+	if m.slotCheckEnabled() {
+		go m.slotLoop()
+	}
+
+	if m.config.QuietHours != "" && len(m.config.Notifications) > 0 {
+		m.checkQuietHours()
+		go m.digestLoop()
+	}
+
+	go m.schemaMenuLoop()
+
+	if m.config.APIEnabled {
+		go m.startAPI()
+	}
+
+	if m.config.UploadToCloud {
+		go m.spoolLoop()
+		go m.destinationWatchLoop()
+		if m.config.CatalogSyncDestination != "" {
+			go m.catalogSyncLoop()
+		}
+	}
+
+	if m.config.QuickActionsHotkey != "" {
+		m.startHotkey()
+	}
+
 	// Handle menu clicks
 	go func() {
 		for {
@@ -172,25 +947,69 @@ func (m *Monitor) onReady() {
 			case <-refreshItem.ClickedCh:
 				go m.checkDatabase()
 			case <-m.backupItem.ClickedCh:
-				go m.backupDatabase(false)
+				go m.manualBackup(false)
 			case <-m.backupAllItem.ClickedCh:
-				go m.backupDatabase(true)
+				go m.manualBackup(true)
+			case <-m.baseBackupItem.ClickedCh:
+				go m.physicalBackupJob(triggerPhysical)
+			case <-browseItem.ClickedCh:
+				go m.openLocalPage("backups")
+			case <-restoreDBItem.ClickedCh:
+				go m.openLocalPage("restore")
+			case <-m.autoBackupItem.ClickedCh:
+				go m.toggleAutoBackups()
+			case <-m.cancelRestoreItem.ClickedCh:
+				if err := m.cancelRestore(); err != nil {
+					log.Printf("Cancel restore: %v", err)
+				}
 			case <-quitItem.ClickedCh:
-				systray.Quit()
+				go func() {
+					if m.authorize("Quit") {
+						tray.Quit()
+					}
+				}()
 			}
 		}
 	}()
 }
 
 func (m *Monitor) monitorLoop() {
-	ticker := time.NewTicker(checkInterval)
-	defer ticker.Stop()
+	interval := checkInterval
+	for {
+		select {
+		case <-time.After(interval):
+			m.checkDatabase()
+		case <-m.reconnected:
+		}
 
-	for range ticker.C {
-		m.checkDatabase()
+		next := m.monitorInterval()
+		switch {
+		case next == interval:
+		case next == checkInterval:
+			log.Printf("Database reachable again, checking every %v", next)
+		default:
+			log.Printf("Database unreachable for %v, checking every %v", time.Since(m.downSince).Round(time.Minute), next)
+		}
+		interval = next
 	}
 }
 
+// monitorInterval is the time until the next check, backed off by how long
+// the database has been unreachable.
+func (m *Monitor) monitorInterval() time.Duration {
+	interval := checkInterval
+	if m.isConnected || m.downSince.IsZero() {
+		return interval
+	}
+	down := time.Since(m.downSince)
+	for _, b := range disconnectedBackoff {
+		if down >= b.after {
+			interval = b.interval
+		}
+	}
+	return interval
+}
+
 func (m *Monitor) scheduleBackups() {
 	log.Printf("Scheduled backups enabled at %s", m.config.AutoBackupTime)
 
@@ -203,11 +1022,38 @@ func (m *Monitor) scheduleBackups() {
 		duration := time.Until(nextRun)
 		log.Printf("Next scheduled backup in %v (at %s)", duration, nextRun.Format("2006-01-02 15:04:05"))
 
-		timer := time.NewTimer(duration)
-		<-timer.C
+		late, jumped := waitUntil(nextRun)
+		if jumped {
+			continue
+		}
 
-		log.Printf("Running scheduled backup...")
-		m.backupDatabase(m.config.AutoBackupAll)
+		switch {
+		case !m.runMissed("scheduled backup", late):
+		case m.autoBackupPaused():
+			log.Printf("Scheduled backup skipped: auto backups paused")
+		case !m.waitForPreconditions(scheduleLogical):
+		case len(m.config.Databases) > 0:
+			job := BackupJob{Database: fmt.Sprintf("%d databases", len(m.config.Databases)), Trigger: triggerScheduled}
+			m.backupJob(job, func() CatalogEntry {
+				log.Printf("Running scheduled backup of %d database(s)...", len(m.config.Databases))
+				ok := m.backupDatabaseList(backupOptions{Scheduled: true})
+				ok = m.backupConsistencyGroups(backupOptions{Scheduled: true}) && ok
+				ok = m.scheduledGlobals(false) && ok
+				return CatalogEntry{Success: ok, Status: m.lastBackupStatus}
+			})
+		default:
+			job := BackupJob{Database: m.config.DBName, Trigger: triggerScheduled}
+			if m.config.AutoBackupAll {
+				job.Database = "all databases"
+			}
+			m.backupJob(job, func() CatalogEntry {
+				log.Printf("Running scheduled backup...")
+				entry := m.backupDatabase(m.config.AutoBackupAll, backupOptions{Scheduled: true})
+				entry.Success = m.backupConsistencyGroups(backupOptions{Scheduled: true}) && entry.Success
+				entry.Success = m.scheduledGlobals(m.config.AutoBackupAll) && entry.Success
+				return entry
+			})
+		}
 
 		// Update next backup time after completion
 		m.nextScheduledTime = m.calculateNextBackupTime(time.Now())
@@ -241,6 +1087,11 @@ func (m *Monitor) updateNextBackupStatus() {
 		return
 	}
 
+	if m.autoBackupPaused() {
+		m.nextBackupItem.SetTitle(m.pausedTitle())
+		return
+	}
+
 	if m.nextScheduledTime.IsZero() {
 		m.nextBackupItem.SetTitle("Next Backup: Calculating...")
 		return
@@ -262,78 +1113,127 @@ func (m *Monitor) updateNextBackupStatus() {
 	}
 
 	backupType := "DB"
-	if m.config.AutoBackupAll {
+	if len(m.config.Databases) > 0 {
+		backupType = fmt.Sprintf("%d DBs", len(m.config.Databases))
+	} else if m.config.AutoBackupAll {
 		backupType = "All DBs"
 	}
 
 	m.nextBackupItem.SetTitle(fmt.Sprintf("Next Backup: %s (%s)", timeStr, backupType))
 }
 
-func (m *Monitor) checkDatabase() {
+func (m *Monitor) openDB(dbName string) (*sql.DB, error) {
+	return m.openDBAt(m.config.Host, m.config.Port, dbName)
+}
+
+func (m *Monitor) openDBAt(host string, port int, dbName string) (*sql.DB, error) {
+	return openDBAs(host, port, m.config.User, m.config.Password, dbName)
+}
+
+// openDBAs connects with other credentials than the monitored server's.
+func openDBAs(host string, port int, user, password, dbName string) (*sql.DB, error) {
 	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable connect_timeout=%d",
-		m.config.Host, m.config.Port, m.config.User, m.config.Password, m.config.DBName, int(connTimeout.Seconds()))
+		host, port, user, password, dbName, int(connTimeout.Seconds()))
 
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
-		m.updateStatus(false, err)
-		return
+		return nil, err
 	}
-	defer db.Close()
 
 	db.SetConnMaxLifetime(connTimeout)
 	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+func (m *Monitor) checkDatabase() {
+	db, err := m.openDB(m.config.DBName)
+	if err != nil {
+		m.updateStatus(false, err)
+		m.exportPoints(statusPoint(false, 0, nil))
+		return
+	}
+	defer db.Close()
 
 	ctx, cancel := context.WithTimeout(context.Background(), connTimeout)
 	defer cancel()
 
+	start := time.Now()
 	if err := db.PingContext(ctx); err != nil {
 		m.updateStatus(false, err)
+		m.exportPoints(statusPoint(false, 0, nil))
 		return
 	}
+	latency := time.Since(start)
 
-	// Get active connections
-	var activeConns int
-	err = db.QueryRowContext(ctx, "SELECT count(*) FROM pg_stat_activity WHERE state = 'active'").Scan(&activeConns)
-	if err != nil {
-		log.Printf("Error getting active connections: %v", err)
-		activeConns = -1
-	}
-
-	// Get database uptime
-	var uptime string
-	err = db.QueryRowContext(ctx, "SELECT NOW() - pg_postmaster_start_time()").Scan(&uptime)
-	if err != nil {
-		log.Printf("Error getting uptime: %v", err)
-		uptime = "unknown"
-	}
+	results := m.collectMetrics(db, m.metricCollectors())
 
 	m.updateStatus(true, nil)
-	m.updateMetrics(activeConns, uptime)
+	m.updateMetrics(results)
+	m.checkRowDrift(results)
+	m.updateDatabaseStats(results)
+	m.exportPoints(statusPoint(true, latency, results))
 }
 
 func (m *Monitor) updateStatus(connected bool, err error) {
+	if m.checked && connected != m.isConnected {
+		if connected {
+			m.notify(Notification{Event: eventConnectionRestored, Severity: severityInfo, Title: "Database reachable again", Database: m.config.DBName})
+		} else {
+			m.notify(Notification{Event: eventConnectionLost, Severity: severityCritical, Title: "Database unreachable", Message: fmt.Sprint(err), Database: m.config.DBName})
+		}
+	}
+	switch {
+	case connected && !m.downSince.IsZero():
+		m.downSince = time.Time{}
+		select {
+		case m.reconnected <- struct{}{}:
+		default:
+		}
+	case !connected && m.downSince.IsZero():
+		m.downSince = time.Now()
+	}
+	m.checked = true
 	m.isConnected = connected
 
+	m.refreshIcon()
 	if connected {
-		systray.SetIcon(getIcon(true))
-		systray.SetTooltip("PostgreSQL Monitor - Connected")
+		tray.SetTooltip("PostgreSQL Monitor - Connected")
 		m.statusItem.SetTitle("Status: ✓ Connected")
 	} else {
-		systray.SetIcon(getIcon(false))
-		systray.SetTooltip(fmt.Sprintf("PostgreSQL Monitor - Disconnected: %v", err))
-		m.statusItem.SetTitle("Status: ✗ Disconnected")
+		tray.SetTooltip(fmt.Sprintf("PostgreSQL Monitor - Disconnected: %v", err))
+		if interval := m.monitorInterval(); interval > checkInterval {
+			m.statusItem.SetTitle(fmt.Sprintf("Status: ✗ Disconnected for %s (checking every %s)", formatCountdown(time.Since(m.downSince)), formatCountdown(interval)))
+		} else {
+			m.statusItem.SetTitle("Status: ✗ Disconnected")
+		}
 		m.connsItem.SetTitle("Active Connections: -")
 		m.uptimeItem.SetTitle("Uptime: -")
+		m.sizeItem.SetTitle("DB Size: -")
 	}
 
 	m.lastCheck.SetTitle(fmt.Sprintf("Last Check: %s", time.Now().Format("15:04:05")))
 }
 
-func (m *Monitor) updateMetrics(activeConns int, uptime string) {
-	if activeConns >= 0 {
-		m.connsItem.SetTitle(fmt.Sprintf("Active Connections: %d", activeConns))
+func (m *Monitor) updateMetrics(results map[string]metricResult) {
+	if r, ok := results[metricActivity]; ok {
+		if r.err != nil {
+			m.connsItem.SetTitle("Active Connections: ? (query failed)")
+		} else {
+			m.connsItem.SetTitle(fmt.Sprintf("Active Connections: %d", r.value.(int)))
+		}
+	}
+
+	if r, ok := results[metricUptime]; ok {
+		uptime := "unknown"
+		if r.err == nil {
+			uptime = r.value.(string)
+		}
+		m.uptimeItem.SetTitle(fmt.Sprintf("DB Uptime: %s", formatUptime(uptime)))
+	}
+
+	if r, ok := results[metricDBSize]; ok && r.err == nil {
+		m.sizeItem.SetTitle(fmt.Sprintf("DB Size: %s", formatBytes(r.value.(int64))))
 	}
-	m.uptimeItem.SetTitle(fmt.Sprintf("DB Uptime: %s", formatUptime(uptime)))
 }
 
 func (m *Monitor) onExit() {
@@ -342,7 +1242,19 @@ func (m *Monitor) onExit() {
 	}
 }
 
-func (m *Monitor) backupDatabase(allDatabases bool) {
+// manualBackup runs a backup clicked in the tray or the palette through the
+// backup queue.
+func (m *Monitor) manualBackup(allDatabases bool) {
+	job := BackupJob{Database: m.config.DBName, Trigger: triggerManual}
+	if allDatabases {
+		job.Database = "all databases"
+	}
+	m.backupJob(job, func() CatalogEntry {
+		return m.backupDatabase(allDatabases, backupOptions{})
+	})
+}
+
+func (m *Monitor) backupDatabase(allDatabases bool, opts backupOptions) CatalogEntry {
 	m.backupItem.SetTitle("Backup Database (Running...)")
 	m.backupItem.Disable()
 	if allDatabases {
@@ -358,111 +1270,404 @@ func (m *Monitor) backupDatabase(allDatabases bool) {
 		}
 	}()
 
+	return m.backupOne(m.config.DBName, allDatabases, opts)
+}
+
+// backupOptions carries the per-run parameters of a triggered backup.
+type backupOptions struct {
+	Label       string // recorded in the catalog and notifications
+	Destination string // upload only to this destination (default: all)
+	Custom      bool   // pg_dump custom format (-Fc) instead of plain SQL
+	Schema      string // dump only this schema (ad-hoc "Backup Schema")
+	Scheduled   bool   // run by the schedule rather than triggered
+
+	// Consistency group runs dump from a snapshot exported beforehand
+	Snapshot         string        // pg_dump --snapshot
+	SnapshotSource   *backupSource // server the snapshot was exported on
+	ConsistencyGroup string        // run ID recorded in manifest and catalog
+}
+
+// backupOne dumps dbName (or the whole cluster when allDatabases is set),
+// records the run in the catalog and uploads the result.
+func (m *Monitor) backupOne(dbName string, allDatabases bool, opts backupOptions) (entry CatalogEntry) {
+	if allDatabases && m.splitCluster() {
+		return m.splitClusterBackup(opts)
+	}
+
 	timestamp := time.Now().Format("20060102_150405")
 	backupDir := filepath.Join(".", "backups")
 
+	dbLabel := dbName
+	if allDatabases {
+		dbLabel = "all databases"
+	}
+
+	entry = CatalogEntry{Database: dbLabel, Kind: "database", Label: opts.Label, ConsistencyGroup: opts.ConsistencyGroup, Started: time.Now()}
+	if allDatabases {
+		entry.Kind = "cluster"
+	}
+	hooked := false
+	defer func() {
+		entry.Finished = time.Now()
+		entry.Status = m.lastBackupStatus
+		m.catalogAdd(entry)
+		if hooked {
+			m.runPostBackupCommand(entry)
+		}
+	}()
+
 	// Create backups directory if it doesn't exist
 	if err := os.MkdirAll(backupDir, 0755); err != nil {
 		errMsg := fmt.Sprintf("Failed to create backup directory: %v", err)
 		log.Printf(errMsg)
-		systray.SetTooltip(errMsg)
+		tray.SetTooltip(errMsg)
 		return
 	}
 
+	if err := m.checkBackupVolume(backupDir); err != nil {
+		log.Printf("Backup failed: %v", err)
+		tray.SetTooltip("Backup refused: backup directory is on the data volume")
+		m.lastBackupStatus = "Failed (data volume)"
+		m.updateBackupStatus()
+		m.notifyBackup(false, dbLabel, err.Error())
+		return
+	}
+
+	source, err := m.selectBackupSource()
+	if opts.SnapshotSource != nil {
+		source, err = *opts.SnapshotSource, nil
+	}
+	if err != nil {
+		log.Printf("Backup failed: %v", err)
+		tray.SetTooltip(fmt.Sprintf("Backup failed: %v", err))
+		m.lastBackupStatus = "Failed (no source)"
+		m.updateBackupStatus()
+		m.notifyBackup(false, dbLabel, err.Error())
+		return
+	}
+
+	entry.Host = source.Host
+	m.checkClientVersion(source)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	var backupFile string
 	var cmd *exec.Cmd
 
-	// Set password in environment
+	// Set password in environment; the application name lets the lock watch
+	// find the dump's sessions
+	appName := backupApplicationPrefix + timestamp
 	env := os.Environ()
-	env = append(env, fmt.Sprintf("PGPASSWORD=%s", m.config.Password))
+	env = append(env, fmt.Sprintf("PGPASSWORD=%s", m.config.Password), "PGAPPNAME="+appName)
+
+	format := dumpFormatPlain
+	if !allDatabases {
+		format = m.dumpFormat(dbName, opts)
+	}
+	// Plain dumps are written to stdout and stored through the codec in one
+	// pass. Archives keep -f: pg_restore needs the data offsets pg_dump can
+	// only write into a seekable file for parallel and selective restores
+	streamed := format == dumpFormatPlain
+	codec := m.dumpCodec(dbName, allDatabases)
+	dests := m.destinationsFor(opts.Destination)
+	if !allDatabases {
+		dests = m.backupDestinations(dbName, opts.Destination)
+	}
+	direct := len(dests) > 0 && m.directUpload(format)
 
 	if allDatabases {
 		// Full server backup using pg_dumpall
-		backupFile = filepath.Join(backupDir, fmt.Sprintf("vindija-bl_all_databases_backup_%s.sql", timestamp))
+		backupFile = filepath.Join(backupDir, fmt.Sprintf("vindija-bl_all_databases_backup_%s.sql%s", timestamp, codec.Ext()))
 		log.Printf("Starting full server backup to: %s", backupFile)
 
-		cmd = exec.Command("pg_dumpall",
-			"-h", m.config.Host,
-			"-p", fmt.Sprintf("%d", m.config.Port),
+		args := []string{
+			"-h", source.Host,
+			"-p", fmt.Sprintf("%d", source.Port),
 			"-U", m.config.User,
-			"-f", backupFile,
-		)
+		}
+		cmd = exec.CommandContext(ctx, pgTool("pg_dumpall"), args...)
 	} else {
 		// Single database backup
-		backupFile = filepath.Join(backupDir, fmt.Sprintf("vindija-bl_%s_backup_%s.sql", m.config.DBName, timestamp))
+		backupFile = filepath.Join(backupDir, fmt.Sprintf("vindija-bl_%s_backup_%s.sql%s%s", dbName, timestamp, m.dumpCompressionExt(dbName), codec.Ext()))
+		switch format {
+		case dumpFormatCustom:
+			backupFile = filepath.Join(backupDir, fmt.Sprintf("vindija-bl_%s_backup_%s%s", dbName, timestamp, customDumpExt))
+		case dumpFormatDirectory:
+			backupFile = filepath.Join(backupDir, fmt.Sprintf("vindija-bl_%s_backup_%s%s", dbName, timestamp, directoryDumpExt))
+		}
 		log.Printf("Starting backup to: %s", backupFile)
 
-		cmd = exec.Command("pg_dump",
-			"-h", m.config.Host,
-			"-p", fmt.Sprintf("%d", m.config.Port),
+		args := []string{
+			"-h", source.Host,
+			"-p", fmt.Sprintf("%d", source.Port),
 			"-U", m.config.User,
-			"-f", backupFile,
-			m.config.DBName,
-		)
+		}
+		if !streamed {
+			args = append(args, "-f", backupFile)
+		}
+		switch format {
+		case dumpFormatCustom:
+			args = append(args, "-Fc")
+		case dumpFormatDirectory:
+			args = append(args, "-Fd", "-j", strconv.Itoa(m.dumpJobs(dbName)))
+		}
+		args = append(args, m.dumpCompressionArgs(dbName)...)
+		args = append(args, m.foreignDataArgs()...)
+		if opts.Snapshot != "" {
+			args = append(args, "--snapshot="+opts.Snapshot)
+		}
+		schemas, tables := m.dumpFilter(dbName, opts)
+		args = append(args, dumpFilterArgs(schemas, tables)...)
+		entry.Scope = dumpScope(schemas, tables)
+		recent, recentScope := m.recentPartitionArgs(source, dbName, opts, entry.Scope)
+		args = append(args, recent...)
+		if recentScope != "" {
+			entry.Scope = strings.TrimPrefix(entry.Scope+"; "+recentScope, "; ")
+		}
+		if entry.Scope != "" {
+			log.Printf("Partial dump: %s", entry.Scope)
+		}
+		extra, err := m.pgDumpExtraArgs()
+		if err != nil {
+			m.configError("%v", err)
+			tray.SetTooltip("Backup failed: invalid PgDumpExtraArgs")
+			m.lastBackupStatus = "Failed (PgDumpExtraArgs)"
+			m.updateBackupStatus()
+			m.notifyBackup(false, dbLabel, err.Error())
+			return
+		}
+		args = append(args, extra...)
+		m.checkForeignData(source, dbName)
+		cmd = exec.CommandContext(ctx, pgTool("pg_dump"), append(args, dbName)...)
 	}
 
-	log.Printf("Connection: host=%s port=%d user=%s", m.config.Host, m.config.Port, m.config.User)
-	systray.SetTooltip("Creating database backup...")
+	log.Printf("Connection: host=%s port=%d user=%s (%s)", source.Host, source.Port, m.config.User, source)
+	log.Printf("Command: %s", commandLine(cmd.Args))
+	tray.SetTooltip("Creating database backup...")
 
 	cmd.Env = env
+	entry.File = filepath.Base(backupFile)
+
+	hooked = true
+	if err := m.runPreBackupCommand(entry); err != nil {
+		log.Printf("Backup cancelled: %v", err)
+		tray.SetTooltip("Backup cancelled: pre-backup command failed")
+		m.lastBackupStatus = "Failed (pre-backup command)"
+		m.updateBackupStatus()
+		m.notifyBackup(false, dbLabel, err.Error())
+		return
+	}
+
+	windowMinutes := m.config.BackupWindowMinutes
+	if !allDatabases {
+		windowMinutes = m.backupWindowMinutes(dbName)
+	}
+	window := m.watchBackupWindow(cancel, cmd, dbLabel, windowMinutes)
+	lockDB := dbName
+	if allDatabases {
+		lockDB = m.config.DBName
+	}
+	locks := m.watchDumpLocks(cancel, source, lockDB, appName, dbLabel)
 
 	// Capture stdout and stderr separately
 	var stdout, stderr []byte
-	var err error
-
-	stdout, err = cmd.Output()
+	var usage []ProcessUsage
+	var streamedSum string
+	var up streamedUpload
+
+	if err = chaosError(chaosDump); err == nil {
+		if direct {
+			up, err = m.streamToDestinations(cmd, entry, codec, dests)
+			stderr, usage = up.Stderr, up.Usage
+		} else if streamed {
+			stderr, usage, streamedSum, err = runThroughCodec(cmd, backupFile, codec)
+		} else {
+			var outBuf, errBuf bytes.Buffer
+			cmd.Stdout, cmd.Stderr = &outBuf, &errBuf
+			var u ProcessUsage
+			u, err = runMeasured(cmd)
+			usage = append(usage, u)
+			stdout, stderr = outBuf.Bytes(), errBuf.Bytes()
+		}
+	}
+	if err == nil {
+		err = chaosError(chaosDiskFull)
+	}
+	window.Stop()
+	locks.Stop()
+	entry.Overrun = window.Overrun()
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
+		if exitErr, ok := err.(*exec.ExitError); ok && stderr == nil {
 			stderr = exitErr.Stderr
 		}
 		errMsg := fmt.Sprintf("Backup failed: %v\nStderr: %s\nStdout: %s", err, string(stderr), string(stdout))
 		log.Printf(errMsg)
-		systray.SetTooltip(fmt.Sprintf("Backup failed - check console"))
+		tray.SetTooltip(fmt.Sprintf("Backup failed - check console"))
 
 		// Clean up empty file
-		os.Remove(backupFile)
+		os.RemoveAll(backupFile)
 		m.lastBackupStatus = "Failed"
+		if locks.Aborted() {
+			m.lastBackupStatus = "Cancelled (blocking other sessions)"
+		} else if ctx.Err() != nil {
+			m.lastBackupStatus = "Cancelled (window exceeded)"
+		}
 		m.updateBackupStatus()
+		m.notifyBackup(false, dbLabel, fmt.Sprintf("%v: %s", err, strings.TrimSpace(string(stderr))))
 		return
 	}
 
 	log.Printf("Backup output: %s", string(stdout))
 
+	if direct {
+		logUsage(usage)
+		m.finishStreamedBackup(&entry, up, backupFile, dbName, allDatabases, source, dests)
+		if m.config.AutoBackupEnabled {
+			m.nextScheduledTime = m.calculateNextBackupTime(time.Now())
+			m.updateNextBackupStatus()
+		}
+		return
+	}
+
 	// Check file was created and has content
-	if info, err := os.Stat(backupFile); err == nil {
-		if info.Size() == 0 {
+	if size, err := backupSize(backupFile); err == nil {
+		if size == 0 {
 			log.Printf("WARNING: Backup file is empty (0 bytes)")
-			systray.SetTooltip("Backup failed: file is empty")
-			os.Remove(backupFile)
+			tray.SetTooltip("Backup failed: file is empty")
+			os.RemoveAll(backupFile)
 			m.lastBackupStatus = "Failed (empty file)"
 			m.updateBackupStatus()
+			m.notifyBackup(false, dbLabel, "backup file is empty (0 bytes)")
 			return
 		}
-		sizeKB := float64(info.Size()) / 1024.0
+		if isArchiveDump(backupFile) {
+			if _, err := verifyArchive(backupFile); err != nil {
+				log.Printf("WARNING: Backup archive is unreadable: %v", err)
+				tray.SetTooltip("Backup failed: archive is unreadable")
+				m.lastBackupStatus = "Failed (unreadable archive)"
+				m.updateBackupStatus()
+				m.notifyBackup(false, dbLabel, err.Error())
+				return
+			}
+		}
+		if m.config.VerifyBackups {
+			m.verifyByRestore(backupFile, dbName, &entry)
+		}
+		if m.config.PGPEncryptBackups {
+			encrypted, err := m.encryptBackupPGP(backupFile)
+			if err == nil {
+				size, err = backupSize(encrypted)
+			}
+			if err != nil {
+				log.Printf("Backup encryption failed: %v", err)
+				tray.SetTooltip("Backup failed: encryption failed")
+				os.RemoveAll(backupFile)
+				m.lastBackupStatus = "Failed (encryption)"
+				m.updateBackupStatus()
+				m.notifyBackup(false, dbLabel, fmt.Sprintf("encryption failed: %v", err))
+				return
+			}
+			backupFile = encrypted
+			entry.File = filepath.Base(backupFile)
+			streamedSum = ""
+		}
+		sizeKB := float64(size) / 1024.0
 		successMsg := fmt.Sprintf("Backup complete: %.2f KB", sizeKB)
 		log.Printf("Backup completed successfully: %s (%.2f KB)", backupFile, sizeKB)
 
-		// Upload to Nextcloud if configured
-		if m.config.UploadToCloud && m.config.NextcloudURL != "" {
-			log.Printf("Uploading to Nextcloud...")
-			systray.SetTooltip("Uploading backup to Nextcloud...")
-			if err := m.uploadToNextcloud(backupFile); err != nil {
-				log.Printf("Nextcloud upload failed: %v", err)
-				systray.SetTooltip(fmt.Sprintf("Backup saved locally (%.2f KB), upload failed", sizeKB))
+		logUsage(usage)
+		manifestFile := ""
+		manifest, err := m.newManifest(backupFile, dbName, allDatabases, source, streamedSum)
+		if err == nil {
+			manifest.Usage = usage
+			manifest.Scope = entry.Scope
+			manifest.ConsistencyGroup = entry.ConsistencyGroup
+			manifest.Duration = time.Since(entry.Started).Seconds()
+			entry.SHA256 = manifest.SHA256
+			manifestFile, err = m.saveManifest(backupFile, manifest)
+		}
+		if err != nil {
+			log.Printf("Failed to write manifest: %v", err)
+		}
+
+		// Organization-specific validation decides whether the run succeeded;
+		// a failed check keeps the file locally but doesn't upload it
+		if m.config.PostBackupCheckCommand != "" {
+			err := fmt.Errorf("no manifest to check")
+			if manifestFile != "" {
+				err = m.runPostBackupCheck(backupFile, manifestFile, dbLabel)
+			}
+			if err != nil {
+				log.Printf("Backup rejected: %v", err)
+				tray.SetTooltip("Backup failed: post-backup check failed")
+				m.lastBackupStatus = "Failed (post-backup check)"
+				m.updateBackupStatus()
+				m.notifyBackup(false, dbLabel, fmt.Sprintf("%s: %v", filepath.Base(backupFile), err))
+				return
+			}
+		}
+
+		// Upload to the cloud destinations if configured
+		var quorumErr error
+		if m.config.UploadToCloud && len(dests) > 0 {
+			log.Printf("Uploading to %d destination(s)...", len(dests))
+			tray.SetTooltip("Uploading backup to cloud...")
+			var files []string
+			// A checksum taken while the dump was written is still fresh,
+			// unless an external check has had the file since
+			var err error
+			if streamedSum == "" || m.config.PostBackupCheckCommand != "" {
+				err = verifyChecksum(backupFile)
+			}
+			if err == nil {
+				files, err = m.uploadFiles(backupFile, manifestFile, &entry)
+			}
+			if err == nil {
+				entry.Uploaded, entry.UploadErrors, err = m.uploadWithQuorum(entry.File, files, dests)
+				m.cleanupSpooledFiles([]SpoolEntry{{Files: files}}, loadSpool())
+			}
+			if err != nil {
+				log.Printf("Upload failed: %v", err)
+				if m.uploadQuorum(len(dests)) > 0 {
+					quorumErr = err
+				}
+			}
+
+			switch n := len(entry.Uploaded); {
+			case n == 0:
+				tray.SetTooltip(fmt.Sprintf("Backup saved locally (%.2f KB), upload failed", sizeKB))
 				m.lastBackupStatus = fmt.Sprintf("%.2f KB (local only)", sizeKB)
-			} else {
-				log.Printf("Successfully uploaded to Nextcloud")
-				systray.SetTooltip(fmt.Sprintf("Backup complete: %.2f KB (uploaded to cloud)", sizeKB))
+			case n < len(dests):
+				log.Printf("Uploaded to %v, failed: %s", entry.Uploaded, failedDestinations(entry))
+				tray.SetTooltip(fmt.Sprintf("Backup complete: %.2f KB (uploaded to %d of %d destinations, failed: %s)", sizeKB, n, len(dests), failedDestinations(entry)))
+				m.lastBackupStatus = fmt.Sprintf("%.2f KB (cloud %d/%d)", sizeKB, n, len(dests))
+			default:
+				log.Printf("Successfully uploaded to %v", entry.Uploaded)
+				tray.SetTooltip(fmt.Sprintf("Backup complete: %.2f KB (uploaded to cloud)", sizeKB))
 				m.lastBackupStatus = fmt.Sprintf("%.2f KB (cloud)", sizeKB)
 			}
 		} else {
-			systray.SetTooltip(successMsg)
+			tray.SetTooltip(successMsg)
 			m.lastBackupStatus = fmt.Sprintf("%.2f KB", sizeKB)
 		}
 
+		entry.Size = size
+		if quorumErr != nil {
+			m.lastBackupStatus = fmt.Sprintf("Failed (%v)", quorumErr)
+			m.updateBackupStatus()
+			m.notifyBackup(false, dbLabel, fmt.Sprintf("%s: %v; failed destinations are retried", filepath.Base(backupFile), quorumErr))
+			return
+		}
+		entry.Success = true
+		m.checkSizeAnomaly(entry)
+
 		// Update last backup info
 		m.lastBackupTime = time.Now()
 		m.updateBackupStatus()
+		m.notifyBackup(true, dbLabel, fmt.Sprintf("%s: %s", filepath.Base(backupFile), m.lastBackupStatus))
+		m.pruneLocalBackups()
+		m.pruneRemoteBackups(entry.Uploaded)
 
 		// Update next backup time if this was a scheduled backup
 		if m.config.AutoBackupEnabled {
@@ -471,36 +1676,37 @@ func (m *Monitor) backupDatabase(allDatabases bool) {
 		}
 	} else {
 		log.Printf("Backup file not found: %v", err)
-		systray.SetTooltip("Backup status unclear - check logs")
+		tray.SetTooltip("Backup status unclear - check logs")
 		m.lastBackupStatus = "Status unclear"
 		m.updateBackupStatus()
+		m.notifyBackup(false, dbLabel, fmt.Sprintf("backup file not found: %v", err))
 	}
+	return
 }
 
-func (m *Monitor) uploadToNextcloud(filePath string) error {
-	fileName := filepath.Base(filePath)
-	uploadURL := m.config.NextcloudURL + fileName
-
-	log.Printf("Uploading to: %s", uploadURL)
-
-	// Prepare curl command
-	cmd := exec.Command("curl",
-		"-X", "PUT",
-		"-u", fmt.Sprintf("%s:%s", m.config.NextcloudUser, m.config.NextcloudPass),
-		"--data-binary", "@"+filePath,
-		uploadURL,
-	)
+// uploadTo uploads a file to dest through its storage backend.
+func (m *Monitor) uploadTo(dest Destination, filePath string) error {
+	return m.uploadRateLimited(dest, filePath, "")
+}
 
-	output, err := cmd.CombinedOutput()
+// uploadRateLimited uploads at most at limitRate, a curl style rate (e.g.
+// "2M"), unless it is empty. MaxUploadRateKBps applies on top of it, shared
+// with every other upload.
+func (m *Monitor) uploadRateLimited(dest Destination, filePath, limitRate string) error {
+	b, err := storageFor(dest)
 	if err != nil {
-		return fmt.Errorf("curl failed: %v, output: %s", err, string(output))
+		return err
 	}
+	log.Printf("Uploading to: %s", b.Location(dest, filepath.Base(filePath)))
 
-	log.Printf("Upload response: %s", string(output))
-	return nil
+	if err := chaosError(chaosUpload); err != nil {
+		return err
+	}
+	return b.Put(m, dest, filePath, limitRate)
 }
 
 func (m *Monitor) updateBackupStatus() {
+	m.refreshIcon()
 	if m.lastBackupTime.IsZero() {
 		m.lastBackupItem.SetTitle("Last Backup: Never")
 	} else {
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+const maxHistoryItems = 15
+
+func (m *Monitor) addHistoryMenu() {
+	m.historyItem = tray.AddMenuItem("Backup History", "Most recent backup runs from the catalog")
+	for i := 0; i < maxHistoryItems; i++ {
+		item := m.historyItem.AddSubMenuItem("", "")
+		item.Disable()
+		item.Hide()
+		m.historyItems = append(m.historyItems, item)
+	}
+	browse := m.historyItem.AddSubMenuItem("Browse All...", "All retained backups by database and month")
+	go func() {
+		for range browse.ClickedCh {
+			m.openLocalPage("backups")
+		}
+	}()
+	m.updateHistoryMenu()
+}
+
+// updateHistoryMenu lists the newest runs, failed ones included.
+func (m *Monitor) updateHistoryMenu() {
+	if m.historyItem == nil {
+		return
+	}
+	entries, err := loadCatalog()
+	if err != nil {
+		log.Printf("Cannot read catalog for the history menu: %v", err)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Finished.After(entries[j].Finished) })
+
+	for i, item := range m.historyItems {
+		if i >= len(entries) {
+			item.Hide()
+			continue
+		}
+		item.SetTitle(historyTitle(entries[i]))
+		item.SetTooltip(entries[i].File)
+		item.Show()
+	}
+}
+
+func historyTitle(e CatalogEntry) string {
+	mark := "✓"
+	if !e.Success {
+		mark = "✗"
+	}
+	title := fmt.Sprintf("%s %s %s (%s)", mark, e.Finished.Format("2006-01-02 15:04"), e.Database, e.Kind)
+	if !e.Success {
+		return title + ": " + e.Status
+	}
+
+	details := []string{formatBytes(e.Size)}
+	switch {
+	case e.Verified:
+		details = append(details, "verified")
+	case e.VerifyError != "":
+		details = append(details, "verify FAILED")
+	}
+	if len(e.Uploaded) > 0 {
+		details = append(details, "on "+strings.Join(e.Uploaded, ", "))
+	}
+	if e.Deleted {
+		details = append(details, "deleted")
+	}
+	return title + ": " + strings.Join(details, ", ")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// setLegalHold places or lifts a legal hold on every catalog entry for file.
+func (m *Monitor) setLegalHold(file string, hold bool, reason, actor string) error {
+	file = filepath.Base(file)
+	found := false
+
+	err := updateCatalog(func(entries []CatalogEntry) ([]CatalogEntry, error) {
+		for i := range entries {
+			if entries[i].File != file || !entries[i].Success {
+				continue
+			}
+			found = true
+			entries[i].LegalHold = hold
+			if hold {
+				entries[i].HoldReason = reason
+				entries[i].HoldSince = time.Now()
+			} else {
+				entries[i].HoldReason = ""
+				entries[i].HoldSince = time.Time{}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s is not in the backup catalog", file)
+		}
+		return entries, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if hold {
+		m.audit(actor, "legal_hold_placed", file, reason)
+	} else {
+		m.audit(actor, "legal_hold_lifted", file, reason)
+	}
+	return nil
+}
+
+// isOnHold reports whether file is under legal hold. An unreadable catalog
+// counts as held: retention must never delete on incomplete information.
+func isOnHold(file string) bool {
+	entries, err := loadCatalog()
+	if err != nil {
+		log.Printf("Catalog unreadable, treating %s as held: %v", file, err)
+		return true
+	}
+	for _, e := range entries {
+		if e.File == filepath.Base(file) && e.LegalHold {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteBackup removes a backup and its manifest from the backups directory
+// unless it is on legal hold.
+func (m *Monitor) deleteBackup(file, actor string) error {
+	name := filepath.Base(file)
+	if isOnHold(name) {
+		m.audit(actor, "delete_refused", name, "legal hold")
+		return fmt.Errorf("%s is on legal hold", name)
+	}
+
+	path := filepath.Join(".", "backups", name)
+	remove := os.Remove
+	if isDirectoryDump(name) {
+		remove = os.RemoveAll
+	}
+	if err := remove(path); err != nil {
+		return err
+	}
+	os.Remove(manifestPath(path))
+	os.Remove(manifestPath(path) + signatureSuffix)
+
+	m.markDeleted(name)
+	m.audit(actor, "backup_deleted", name, "")
+	return nil
+}
+
+func (m *Monitor) markDeleted(file string) {
+	err := updateCatalog(func(entries []CatalogEntry) ([]CatalogEntry, error) {
+		for i := range entries {
+			if entries[i].File == file {
+				entries[i].Deleted = true
+			}
+		}
+		return entries, nil
+	})
+	if err != nil {
+		log.Printf("Failed to mark %s deleted in catalog: %v", file, err)
+	}
+}
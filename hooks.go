@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultBackupCommandTimeout = 10 * time.Minute
+
+// backupHookEnv describes a backup to PreBackupCommand and
+// PostBackupCommand. PGM_BACKUP_STATUS is "starting" before the dump and
+// "success" or "failed" after it; size, result and destinations are only
+// known by then.
+func (m *Monitor) backupHookEnv(entry CatalogEntry, status string) []string {
+	dir := filepath.Join(".", "backups")
+	if entry.Kind == "physical" {
+		dir = physicalBackupDir()
+	}
+	file := ""
+	if entry.File != "" {
+		file = filepath.Join(dir, entry.File)
+	}
+	env := append(os.Environ(),
+		"PGM_BACKUP_FILE="+file,
+		"PGM_BACKUP_STATUS="+status,
+		"PGM_BACKUP_KIND="+entry.Kind,
+		"PGM_DATABASE="+entry.Database,
+	)
+	if status != "starting" {
+		env = append(env,
+			"PGM_BACKUP_SIZE="+strconv.FormatInt(entry.Size, 10),
+			"PGM_BACKUP_RESULT="+entry.Status,
+			"PGM_BACKUP_UPLOADED="+strings.Join(entry.Uploaded, ","),
+		)
+	}
+	return env
+}
+
+// runBackupCommand runs PreBackupCommand or PostBackupCommand through the
+// shell, at most BackupCommandTimeoutMinutes (default 10).
+func (m *Monitor) runBackupCommand(what, command string, env []string) error {
+	timeout := time.Duration(m.config.BackupCommandTimeoutMinutes) * time.Minute
+	if timeout <= 0 {
+		timeout = defaultBackupCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, command)
+	cmd.Env = env
+	log.Printf("Running %s: %s", what, command)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s timed out after %v", what, timeout)
+	}
+	if err != nil {
+		out := strings.TrimSpace(string(output))
+		if len(out) > 500 {
+			out = out[len(out)-500:]
+		}
+		return fmt.Errorf("%s failed: %v: %s", what, err, out)
+	}
+	if out := strings.TrimSpace(string(output)); out != "" {
+		log.Printf("%s: %s", what, out)
+	}
+	return nil
+}
+
+// runPreBackupCommand runs PreBackupCommand before the dump of entry starts;
+// an error cancels the backup. The caller runs runPostBackupCommand once
+// the backup has ended, whether this succeeded or not, so a command that
+// quiesced an application before failing still gets to resume it.
+func (m *Monitor) runPreBackupCommand(entry CatalogEntry) error {
+	if m.config.PreBackupCommand == "" {
+		return nil
+	}
+	return m.runBackupCommand("pre-backup command", m.config.PreBackupCommand, m.backupHookEnv(entry, "starting"))
+}
+
+// runPostBackupCommand reports a finished backup, successful or not, to
+// PostBackupCommand. Its failure is logged but doesn't change the result.
+func (m *Monitor) runPostBackupCommand(entry CatalogEntry) {
+	if m.config.PostBackupCommand == "" {
+		return
+	}
+	status := "failed"
+	if entry.Success {
+		status = "success"
+	}
+	if err := m.runBackupCommand("post-backup command", m.config.PostBackupCommand, m.backupHookEnv(entry, status)); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+// Global hotkeys need a desktop-specific API outside Windows.
+func registerHotkey(mods, key uint32, fn func()) error {
+	return fmt.Errorf("global hotkeys are only supported on Windows")
+}
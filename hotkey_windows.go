@@ -0,0 +1,52 @@
+package main
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	modNoRepeat = 0x4000
+	wmHotkey    = 0x0312
+	hotkeyID    = 1
+)
+
+type winMsg struct {
+	Hwnd    uintptr
+	Message uint32
+	WParam  uintptr
+	LParam  uintptr
+	Time    uint32
+	Pt      struct{ X, Y int32 }
+}
+
+// registerHotkey registers a system-wide hotkey and calls fn each time it is
+// pressed. WM_HOTKEY is posted to the registering thread, so registration
+// and the message loop share one locked OS thread.
+func registerHotkey(mods, key uint32, fn func()) error {
+	user32 := syscall.NewLazyDLL("user32.dll")
+	result := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		ret, _, err := user32.NewProc("RegisterHotKey").Call(0, hotkeyID, uintptr(mods|modNoRepeat), uintptr(key))
+		if ret == 0 {
+			result <- err
+			return
+		}
+		result <- nil
+
+		getMessage := user32.NewProc("GetMessageW")
+		var msg winMsg
+		for {
+			ret, _, _ := getMessage.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
+			if int32(ret) <= 0 {
+				return
+			}
+			if msg.Message == wmHotkey && msg.WParam == hotkeyID {
+				go fn()
+			}
+		}
+	}()
+	return <-result
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+// platformIcon returns the PNG as is; the macOS menu bar and Linux tray
+// hosts load PNG directly.
+func platformIcon(data []byte) []byte {
+	return data
+}
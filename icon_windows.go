@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+)
+
+// platformIcon wraps a PNG tray icon in an ICO container, which is what the
+// Windows notification area loads; ICO has carried PNG images since Vista.
+func platformIcon(data []byte) []byte {
+	cfg, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return data
+	}
+	dim := func(n int) byte {
+		if n >= 256 {
+			return 0 // 0 means 256
+		}
+		return byte(n)
+	}
+
+	var buf bytes.Buffer
+	// ICONDIR: reserved, type 1 (icon), one image
+	binary.Write(&buf, binary.LittleEndian, [3]uint16{0, 1, 1})
+	// ICONDIRENTRY: size, no palette, 1 plane, 32 bpp, data length and offset
+	buf.Write([]byte{dim(cfg.Width), dim(cfg.Height), 0, 0})
+	binary.Write(&buf, binary.LittleEndian, [2]uint16{1, 32})
+	binary.Write(&buf, binary.LittleEndian, [2]uint32{uint32(len(data)), 6 + 16})
+	buf.Write(data)
+	return buf.Bytes()
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxJobsKept  = 100
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobSkipped   = "skipped"
+
+	triggerManual    = "manual"
+	triggerScheduled = "scheduled"
+	triggerWebhook   = "webhook"
+	triggerAPI       = "api"
+	triggerStaging   = "staging"
+
+	// Physical backups queue with the logical ones under their own triggers
+	triggerPhysical          = "physical"
+	triggerPhysicalScheduled = "physical-scheduled"
+
+	overlapQueue = "queue"
+	overlapSkip  = "skip"
+)
+
+// BackupJob is a backup in the backup queue: triggered from the tray, the
+// schedule or the API. Only one job runs at a time, so two pg_dumps, or a
+// pg_dump and a pg_basebackup, never compete for the same server.
+type BackupJob struct {
+	ID          string
+	Database    string
+	Trigger     string
+	Label       string `json:",omitempty"`
+	Destination string `json:",omitempty"`
+	State       string
+	Created     time.Time
+	Started     time.Time `json:",omitempty"`
+	Finished    time.Time `json:",omitempty"`
+	File        string    `json:",omitempty"`
+	Status      string    `json:",omitempty"`
+}
+
+var (
+	jobsMu   sync.Mutex
+	jobs     = make(map[string]*BackupJob)
+	jobQueue []string // IDs of the running job, first, and the queued ones
+	jobTurn  = sync.NewCond(&jobsMu)
+)
+
+// queueBackupJob adds job to the backup queue. A scheduled job that would
+// have to wait is skipped instead when BackupOverlapPolicy is "skip"; it is
+// still recorded, with the reason, and ok is false.
+func (m *Monitor) queueBackupJob(job BackupJob) (queued BackupJob, ok bool) {
+	job.ID, _ = opaqueName()
+	job.State = jobQueued
+	job.Created = time.Now()
+
+	jobsMu.Lock()
+	ahead := len(jobQueue)
+	scheduled := job.Trigger == triggerScheduled || job.Trigger == triggerPhysicalScheduled
+	if ahead > 0 && scheduled && strings.EqualFold(m.config.BackupOverlapPolicy, overlapSkip) {
+		busy := jobs[jobQueue[0]]
+		job.State = jobSkipped
+		job.Finished = job.Created
+		job.Status = fmt.Sprintf("%s backup of %s still running", busy.Trigger, busy.Database)
+	} else {
+		jobQueue = append(jobQueue, job.ID)
+	}
+	jobs[job.ID] = &job
+	pruneJobs()
+	queued = job
+	jobsMu.Unlock()
+
+	switch {
+	case queued.State == jobSkipped:
+		log.Printf("Scheduled backup of %s skipped: %s", queued.Database, queued.Status)
+	case ahead > 0:
+		log.Printf("Backup of %s (%s) queued behind %d other backup(s)", queued.Database, queued.Trigger, ahead)
+	}
+	m.updateQueueItem()
+	return queued, queued.State != jobSkipped
+}
+
+// runBackupJob waits until the queued job id is first in line, runs backup
+// and records its result.
+func (m *Monitor) runBackupJob(id string, backup func() CatalogEntry) CatalogEntry {
+	jobsMu.Lock()
+	for jobQueue[0] != id {
+		jobTurn.Wait()
+	}
+	jobs[id].State = jobRunning
+	jobs[id].Started = time.Now()
+	jobsMu.Unlock()
+	m.updateQueueItem()
+
+	var entry CatalogEntry
+	defer func() {
+		jobsMu.Lock()
+		j := jobs[id]
+		j.Finished = time.Now()
+		j.File = entry.File
+		j.Status = entry.Status
+		j.State = jobFailed
+		if entry.Success {
+			j.State = jobSucceeded
+		}
+		jobQueue = jobQueue[1:]
+		jobTurn.Broadcast()
+		jobsMu.Unlock()
+		m.updateQueueItem()
+	}()
+	entry = backup()
+	return entry
+}
+
+// backupJob queues job and runs backup in its turn; ok is false when the
+// job was skipped. backup must not start another job itself.
+func (m *Monitor) backupJob(job BackupJob, backup func() CatalogEntry) (CatalogEntry, bool) {
+	queued, ok := m.queueBackupJob(job)
+	if !ok {
+		return CatalogEntry{Database: queued.Database, Status: queued.Status}, false
+	}
+	return m.runBackupJob(queued.ID, backup), true
+}
+
+// updateQueueItem shows the running job and how many wait behind it.
+func (m *Monitor) updateQueueItem() {
+	if m.queueItem == nil {
+		return
+	}
+	jobsMu.Lock()
+	title := "Backup Queue: idle"
+	if len(jobQueue) > 0 {
+		j := jobs[jobQueue[0]]
+		state := "waiting"
+		if j.State == jobRunning {
+			state = "running"
+		}
+		title = fmt.Sprintf("Backup Queue: %s %s (%s)", state, j.Database, j.Trigger)
+		if len(jobQueue) > 1 {
+			title += fmt.Sprintf(", %d queued", len(jobQueue)-1)
+		}
+	}
+	jobsMu.Unlock()
+	m.queueItem.SetTitle(title)
+}
+
+func (m *Monitor) addQueueMenu() {
+	m.queueItem = tray.AddMenuItem("Backup Queue: idle", "Running and waiting backups")
+	m.queueItem.Disable()
+}
+
+// pruneJobs keeps the newest maxJobsKept jobs; callers hold jobsMu.
+func pruneJobs() {
+	if len(jobs) <= maxJobsKept {
+		return
+	}
+	all := make([]*BackupJob, 0, len(jobs))
+	for _, j := range jobs {
+		all = append(all, j)
+	}
+	sort.Slice(all, func(i, k int) bool { return all[i].Created.Before(all[k].Created) })
+	for _, j := range all[:len(all)-maxJobsKept] {
+		if j.State != jobQueued && j.State != jobRunning {
+			delete(jobs, j.ID)
+		}
+	}
+}
+
+// handleJobs serves GET /api/jobs, the kept jobs newest first.
+func (m *Monitor) handleJobs(w http.ResponseWriter, r *http.Request) {
+	jobsMu.Lock()
+	list := make([]BackupJob, 0, len(jobs))
+	for _, j := range jobs {
+		list = append(list, *j)
+	}
+	jobsMu.Unlock()
+
+	sort.Slice(list, func(i, k int) bool { return list[i].Created.After(list[k].Created) })
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleJob serves GET /api/jobs/<id>.
+func (m *Monitor) handleJob(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/jobs/")
+
+	jobsMu.Lock()
+	j, ok := jobs[id]
+	var job BackupJob
+	if ok {
+		job = *j
+	}
+	jobsMu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown job")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// keyringSecret reads a generic password from the macOS Keychain by service
+// name.
+func keyringSecret(name string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", name, "-w").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
@@ -0,0 +1,18 @@
+//go:build !windows && !darwin
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// keyringSecret reads a secret from the Secret Service via secret-tool
+// (attribute service=<name>).
+func keyringSecret(name string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", name).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
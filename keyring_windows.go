@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const credTypeGeneric = 1
+
+// credential mirrors the leading fields of CREDENTIALW up to the blob.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+}
+
+// keyringSecret reads a generic credential from the Windows Credential
+// Manager (cmdkey /generic:<name> /user:pg-monitor /pass:<secret>).
+func keyringSecret(name string) (string, error) {
+	target, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return "", err
+	}
+
+	advapi := syscall.NewLazyDLL("advapi32.dll")
+	var cred *credential
+	ret, _, err := advapi.NewProc("CredReadW").Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ret == 0 {
+		return "", fmt.Errorf("CredRead: %v", err)
+	}
+	defer advapi.NewProc("CredFree").Call(uintptr(unsafe.Pointer(cred)))
+
+	blob := unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)
+	// cmdkey stores the password as UTF-16
+	u16 := make([]uint16, len(blob)/2)
+	for i := range u16 {
+		u16[i] = uint16(blob[2*i]) | uint16(blob[2*i+1])<<8
+	}
+	return syscall.UTF16ToString(u16), nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+)
+
+var (
+	localUIOnce sync.Once
+	localUIBase string
+)
+
+// openLocalPage opens one of the local utility pages in the browser. They are
+// served on a random loopback port under a per-run token, so only the user
+// who clicked the menu item gets the URL.
+func (m *Monitor) openLocalPage(page string) {
+	localUIOnce.Do(func() {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			log.Printf("Local pages unavailable: %v", err)
+			return
+		}
+		b := make([]byte, 16)
+		rand.Read(b)
+		prefix := "/" + hex.EncodeToString(b)
+
+		mux := http.NewServeMux()
+		mux.HandleFunc(prefix+"/explain", m.handleExplain)
+		mux.HandleFunc(prefix+"/backups", m.handleBackupBrowser)
+		mux.HandleFunc(prefix+"/restore", m.handleRestorePage)
+		mux.HandleFunc(prefix+"/actions", m.handlePalette)
+		go http.Serve(ln, mux)
+		localUIBase = fmt.Sprintf("http://%s%s", ln.Addr(), prefix)
+	})
+
+	if localUIBase == "" {
+		return
+	}
+	url := localUIBase + "/" + page
+	if err := openBrowser(url); err != nil {
+		log.Printf("Failed to open browser for %s: %v", url, err)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	lockWatchInterval       = 10 * time.Second
+	defaultLockWarnSeconds  = 30
+	lockWatchQueryTimeout   = 5 * time.Second
+	backupApplicationPrefix = "pg-monitor-backup-"
+
+	eventBackupBlocking = "backup_blocking"
+)
+
+// Sessions waiting on a lock held by the dump. The dump's backends are found
+// by the application_name passed to pg_dump through PGAPPNAME.
+const blockedByDumpQuery = `
+SELECT w.pid, w.usename, COALESCE(w.datname, ''), left(w.query, 200),
+       EXTRACT(EPOCH FROM now() - COALESCE(w.query_start, w.state_change))::bigint,
+       COALESCE(string_agg(DISTINCT CASE WHEN l.database = d.oid THEN l.relation::regclass::text
+                                         ELSE l.relation::text END, ', '), '')
+FROM pg_stat_activity w
+JOIN pg_database d ON d.datname = current_database()
+LEFT JOIN pg_locks l ON l.pid = w.pid AND NOT l.granted AND l.relation IS NOT NULL
+WHERE EXISTS (SELECT 1 FROM pg_stat_activity b
+              WHERE b.application_name = $1 AND b.pid = ANY (pg_blocking_pids(w.pid)))
+GROUP BY w.pid, w.usename, w.datname, w.query, w.query_start, w.state_change`
+
+type blockedSession struct {
+	PID       int
+	User      string
+	Database  string
+	Query     string
+	Seconds   int64
+	Relations string
+}
+
+// lockWatch polls for sessions blocked by a running dump, warns once per
+// blocked session and, with LockAbortSeconds set, cancels the dump when a
+// session has been waiting longer than that.
+type lockWatch struct {
+	stop    chan struct{}
+	aborted int32
+}
+
+func (m *Monitor) watchDumpLocks(cancel context.CancelFunc, source backupSource, dbName, appName, label string) *lockWatch {
+	w := &lockWatch{stop: make(chan struct{})}
+	if !m.config.LockWatchEnabled {
+		return w
+	}
+
+	warnAfter := int64(m.config.LockWarnSeconds)
+	if warnAfter <= 0 {
+		warnAfter = defaultLockWarnSeconds
+	}
+	abortAfter := int64(m.config.LockAbortSeconds)
+
+	go func() {
+		ticker := time.NewTicker(lockWatchInterval)
+		defer ticker.Stop()
+
+		warned := make(map[int]bool)
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+			}
+
+			blocked, err := m.sessionsBlockedBy(source, dbName, appName)
+			if err != nil {
+				log.Printf("Lock watch: %v", err)
+				continue
+			}
+
+			for _, s := range blocked {
+				if s.Seconds < warnAfter || warned[s.PID] {
+					continue
+				}
+				warned[s.PID] = true
+				log.Printf("Backup of %s is blocking pid %d (%s) for %ds on %s: %s",
+					label, s.PID, s.User, s.Seconds, s.Relations, s.Query)
+				tray.SetTooltip(fmt.Sprintf("Backup is blocking session %d on %s", s.PID, s.Relations))
+				m.notify(Notification{
+					Event:    eventBackupBlocking,
+					Severity: severityWarning,
+					Title:    "Backup is blocking other sessions",
+					Message:  fmt.Sprintf("Session %d (%s) has waited %ds for a lock held by the backup on %s", s.PID, s.User, s.Seconds, s.Relations),
+					Database: label,
+					Details:  map[string]string{"pid": fmt.Sprint(s.PID), "relations": s.Relations, "query": s.Query},
+				})
+			}
+
+			if abortAfter <= 0 {
+				continue
+			}
+			for _, s := range blocked {
+				if s.Seconds >= abortAfter {
+					log.Printf("Aborting backup of %s: pid %d blocked for %ds (limit %ds)", label, s.PID, s.Seconds, abortAfter)
+					atomic.StoreInt32(&w.aborted, 1)
+					m.notify(Notification{
+						Event:    eventBackupBlocking,
+						Severity: severityCritical,
+						Title:    "Backup aborted: blocking other sessions",
+						Message:  fmt.Sprintf("Session %d was blocked for %ds on %s; the backup was cancelled", s.PID, s.Seconds, s.Relations),
+						Database: label,
+					})
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return w
+}
+
+func (m *Monitor) sessionsBlockedBy(source backupSource, dbName, appName string) ([]blockedSession, error) {
+	db, err := m.openDBAt(source.Host, source.Port, dbName)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), lockWatchQueryTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, blockedByDumpQuery, appName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocked []blockedSession
+	for rows.Next() {
+		var s blockedSession
+		if err := rows.Scan(&s.PID, &s.User, &s.Database, &s.Query, &s.Seconds, &s.Relations); err != nil {
+			return nil, err
+		}
+		s.Query = strings.Join(strings.Fields(s.Query), " ")
+		blocked = append(blocked, s)
+	}
+	return blocked, rows.Err()
+}
+
+func (w *lockWatch) Stop() {
+	close(w.stop)
+}
+
+func (w *lockWatch) Aborted() bool {
+	return atomic.LoadInt32(&w.aborted) == 1
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	manifestSuffix        = ".manifest.json"
+	signatureSuffix       = ".sig"
+	defaultSigningKey     = "manifest-signing.key"
+	publicKeyFileSuffix   = ".pub"
+	privateKeyPEMType     = "PRIVATE KEY"
+	publicKeyPEMType      = "PUBLIC KEY"
+	manifestFormatVersion = 1
+)
+
+// BackupManifest describes a single backup file. It is written next to the
+// dump as <file>.manifest.json and, when signing is enabled, accompanied by a
+// detached Ed25519 signature in <file>.manifest.json.sig.
+type BackupManifest struct {
+	Version       int
+	File          string
+	Size          int64
+	SHA256        string `json:",omitempty"`
+	Database      string
+	AllDatabases  bool
+	Scope         string `json:",omitempty"` // schemas/tables of a partial dump
+	Host          string
+	Standby       bool    `json:",omitempty"` // dumped from a hot standby
+	ReplayLSN     string  `json:",omitempty"` // standby replay position when the dump started
+	LagSeconds    float64 `json:",omitempty"` // standby replication lag when the dump started
+	CreatedAt     time.Time
+	Duration      float64 `json:",omitempty"` // seconds the dump took
+	ServerVersion string  `json:",omitempty"`
+	Kind          string  `json:",omitempty"` // "", "full" or "incremental" (physical backups)
+	Parent        string  `json:",omitempty"` // backup an incremental was taken against
+
+	ConsistencyGroup string `json:",omitempty"` // run ID shared with the other dumps of a consistency group
+
+	Server *ServerSnapshot `json:",omitempty"` // extensions, settings and pg_hba rules at backup time
+	Usage  []ProcessUsage  `json:",omitempty"` // CPU, memory and I/O of the dump and codec processes
+
+	// Split cluster backups: the globals manifest lists the per-database
+	// dumps and the commands to restore them, in order.
+	Parts        []string `json:",omitempty"`
+	RestoreOrder []string `json:",omitempty"`
+}
+
+func manifestPath(backupFile string) string {
+	return backupFile + manifestSuffix
+}
+
+// newManifest describes a finished backup. sum is the checksum computed while
+// the dump was streamed to disk; without one the file is read to compute it.
+func (m *Monitor) newManifest(backupFile, dbName string, allDatabases bool, source backupSource, sum string) (BackupManifest, error) {
+	size, err := backupSize(backupFile)
+	if err != nil {
+		return BackupManifest{}, err
+	}
+
+	manifest := BackupManifest{
+		Version:      manifestFormatVersion,
+		File:         filepath.Base(backupFile),
+		Size:         size,
+		Database:     dbName,
+		AllDatabases: allDatabases,
+		Host:         source.Host,
+		Standby:      source.Standby,
+		ReplayLSN:    source.ReplayLSN,
+		LagSeconds:   source.LagSeconds,
+		CreatedAt:    time.Now(),
+	}
+	if allDatabases {
+		manifest.Database = ""
+		manifest.Server = m.serverSnapshot(source, m.config.DBName, true)
+	} else {
+		manifest.Server = m.serverSnapshot(source, dbName, false)
+	}
+	if manifest.Server != nil {
+		manifest.ServerVersion = manifest.Server.ServerVersion
+	} else {
+		manifest.ServerVersion = m.serverVersion(source)
+	}
+
+	if m.config.ManifestChecksum {
+		if sum == "" {
+			if sum, err = backupSHA256(backupFile); err != nil {
+				return manifest, fmt.Errorf("checksum failed: %v", err)
+			}
+		}
+		manifest.SHA256 = sum
+		if chaos(chaosChecksum) {
+			manifest.SHA256 = strings.Repeat("0", len(sum))
+		}
+	}
+
+	return manifest, nil
+}
+
+// serverVersion returns the version of the source server, or "" when it
+// cannot be read.
+func (m *Monitor) serverVersion(source backupSource) string {
+	db, err := m.openDBAt(source.Host, source.Port, m.config.DBName)
+	if err != nil {
+		return ""
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), connTimeout)
+	defer cancel()
+	var version string
+	db.QueryRowContext(ctx, "SHOW server_version").Scan(&version)
+	return version
+}
+
+// verifyChecksum re-reads a backup before it is uploaded or restored and
+// compares it with the SHA-256 in its manifest. Backups without a manifest
+// or checksum pass.
+func verifyChecksum(backupFile string) error {
+	manifest, err := readManifest(backupFile)
+	if err != nil || manifest.SHA256 == "" {
+		return nil
+	}
+	sum, err := backupSHA256(backupFile)
+	if err != nil {
+		return err
+	}
+	if sum != manifest.SHA256 {
+		return fmt.Errorf("checksum mismatch: %s was modified after the backup", filepath.Base(backupFile))
+	}
+	return nil
+}
+
+func (m *Monitor) saveManifest(backupFile string, manifest BackupManifest) (string, error) {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	path := manifestPath(backupFile)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+
+	if m.config.SignManifests {
+		key, err := loadOrCreateSigningKey(m.signingKeyPath())
+		if err != nil {
+			return path, fmt.Errorf("signing key unavailable: %v", err)
+		}
+		sig := ed25519.Sign(key, data)
+		if err := os.WriteFile(path+signatureSuffix, []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0644); err != nil {
+			return path, err
+		}
+		log.Printf("Manifest signed: %s", path+signatureSuffix)
+	}
+
+	return path, nil
+}
+
+func (m *Monitor) signingKeyPath() string {
+	if m.config.SigningKeyFile != "" {
+		return m.config.SigningKeyFile
+	}
+	return defaultSigningKey
+}
+
+func readManifest(backupFile string) (BackupManifest, error) {
+	var manifest BackupManifest
+
+	data, err := os.ReadFile(manifestPath(backupFile))
+	if err != nil {
+		return manifest, err
+	}
+
+	err = json.Unmarshal(data, &manifest)
+	return manifest, err
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadOrCreateSigningKey reads a PKCS#8 PEM Ed25519 private key, generating a
+// new key pair (and the matching .pub file) on first use.
+func loadOrCreateSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Printf("Generating new manifest signing key: %s", path)
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: privateKeyPEMType, Bytes: der}), 0600); err != nil {
+			return nil, err
+		}
+		if err := writePublicKey(path+publicKeyFileSuffix, pub); err != nil {
+			return nil, err
+		}
+		return priv, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return priv, nil
+}
+
+func writePublicKey(path string, pub ed25519.PublicKey) error {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: publicKeyPEMType, Bytes: der}), 0644)
+}
+
+func loadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return pub, nil
+}
+
+// verifyBackup checks a backup against its manifest: the signature (required
+// when a public key is available or signing is enabled), the recorded size and, if
+// present, the SHA-256 checksum. Custom and directory format dumps must also
+// have a readable table of contents.
+func (m *Monitor) verifyBackup(backupFile string) error {
+	backupFile = strings.TrimSuffix(backupFile, manifestSuffix)
+	path := manifestPath(backupFile)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("manifest not readable: %v", err)
+	}
+
+	pubPath := m.signingKeyPath() + publicKeyFileSuffix
+	sigData, sigErr := os.ReadFile(path + signatureSuffix)
+	_, pubErr := os.Stat(pubPath)
+	switch {
+	case sigErr == nil:
+		pub, err := loadPublicKey(pubPath)
+		if err != nil {
+			return fmt.Errorf("cannot load public key: %v", err)
+		}
+		sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigData)))
+		if err != nil {
+			return fmt.Errorf("malformed signature: %v", err)
+		}
+		if !ed25519.Verify(pub, data, sig) {
+			return fmt.Errorf("manifest signature is INVALID (catalog tampered or wrong key)")
+		}
+	case m.config.SignManifests:
+		return fmt.Errorf("manifest is not signed but signing is required")
+	case pubErr == nil:
+		// Otherwise deleting the .sig next to a tampered manifest would pass
+		return fmt.Errorf("manifest is not signed but %s exists, so a signature is required", pubPath)
+	}
+
+	var manifest BackupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("manifest not parseable: %v", err)
+	}
+	if manifest.File != filepath.Base(backupFile) {
+		return fmt.Errorf("manifest describes %s, not %s", manifest.File, filepath.Base(backupFile))
+	}
+
+	info, err := os.Stat(backupFile)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() && !isDirectoryDump(backupFile) {
+		return m.verifyBaseBackup(backupFile)
+	}
+	size, err := backupSize(backupFile)
+	if err != nil {
+		return err
+	}
+	if size != manifest.Size {
+		return fmt.Errorf("size mismatch: manifest %d bytes, file %d bytes", manifest.Size, size)
+	}
+
+	if manifest.SHA256 != "" {
+		sum, err := backupSHA256(backupFile)
+		if err != nil {
+			return err
+		}
+		if sum != manifest.SHA256 {
+			return fmt.Errorf("checksum mismatch: backup file was modified or substituted")
+		}
+	}
+
+	if isArchiveDump(backupFile) {
+		if _, err := verifyArchive(backupFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
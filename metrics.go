@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMetricTimeout = 10 * time.Second
+	maxMetricConns       = 4
+)
+
+const (
+	metricActivity    = "activity"
+	metricUptime      = "uptime"
+	metricDBSize      = "dbsize"
+	metricReplication = "replication" // replication slot watch
+)
+
+var metricNames = []string{metricActivity, metricUptime, metricDBSize, metricReplication, metricRowCounts, metricDatabases}
+
+// metricCollector runs one monitoring query. Collectors run concurrently,
+// each in its own read-only transaction with a statement_timeout, so a slow
+// catalog query only costs its own result instead of the whole check cycle.
+type metricCollector struct {
+	name    string
+	timeout time.Duration
+	collect func(ctx context.Context, tx *sql.Tx) (interface{}, error)
+}
+
+type metricResult struct {
+	value    interface{}
+	err      error
+	duration time.Duration
+}
+
+// metricEnabled reports whether a collector may run; DisabledMetrics turns
+// off the ones whose catalogs are restricted on hardened servers.
+func (m *Monitor) metricEnabled(name string) bool {
+	for _, d := range m.config.DisabledMetrics {
+		if d == name {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Monitor) checkDisabledMetrics() {
+	for _, d := range m.config.DisabledMetrics {
+		known := false
+		for _, n := range metricNames {
+			known = known || d == n
+		}
+		if !known {
+			m.configError("unknown entry %q in DisabledMetrics (known: %v)", d, metricNames)
+		}
+	}
+}
+
+func (m *Monitor) metricCollectors() []metricCollector {
+	var enabled []metricCollector
+	for _, c := range m.allMetricCollectors() {
+		if m.metricEnabled(c.name) {
+			enabled = append(enabled, c)
+		}
+	}
+	return enabled
+}
+
+func (m *Monitor) allMetricCollectors() []metricCollector {
+	collectors := []metricCollector{
+		{
+			name: metricActivity,
+			collect: func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
+				var activeConns int
+				err := tx.QueryRowContext(ctx, "SELECT count(*) FROM pg_stat_activity WHERE state = 'active'").Scan(&activeConns)
+				return activeConns, err
+			},
+		},
+		{
+			name: metricUptime,
+			collect: func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
+				var uptime string
+				err := tx.QueryRowContext(ctx, "SELECT NOW() - pg_postmaster_start_time()").Scan(&uptime)
+				return uptime, err
+			},
+		},
+		{
+			name: metricDBSize,
+			collect: func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
+				var size int64
+				err := tx.QueryRowContext(ctx, "SELECT pg_database_size(current_database())").Scan(&size)
+				return size, err
+			},
+		},
+	}
+	if len(m.config.CriticalTables) > 0 {
+		collectors = append(collectors, m.rowCountCollector())
+	}
+	if len(m.config.MonitoredDatabases) > 0 {
+		collectors = append(collectors, m.databaseStatsCollector())
+	}
+	return collectors
+}
+
+func (m *Monitor) metricTimeout() time.Duration {
+	if m.config.MetricTimeoutSeconds > 0 {
+		return time.Duration(m.config.MetricTimeoutSeconds) * time.Second
+	}
+	return defaultMetricTimeout
+}
+
+// collectMetrics runs all collectors concurrently and returns whatever
+// finished; failed or timed-out collectors are reported with their error.
+func (m *Monitor) collectMetrics(db *sql.DB, collectors []metricCollector) map[string]metricResult {
+	conns := len(collectors)
+	if conns > maxMetricConns {
+		conns = maxMetricConns
+	}
+	db.SetMaxOpenConns(conns)
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]metricResult)
+	)
+
+	for _, c := range collectors {
+		wg.Add(1)
+		go func(c metricCollector) {
+			defer wg.Done()
+
+			timeout := c.timeout
+			if timeout <= 0 {
+				timeout = m.metricTimeout()
+			}
+
+			start := time.Now()
+			value, err := runCollector(db, c, timeout)
+			if err != nil {
+				log.Printf("Metric %s failed after %v: %v", c.name, time.Since(start).Round(time.Millisecond), err)
+			}
+
+			mu.Lock()
+			results[c.name] = metricResult{value: value, err: err, duration: time.Since(start)}
+			mu.Unlock()
+		}(c)
+	}
+
+	wg.Wait()
+	return results
+}
+
+func runCollector(db *sql.DB, c metricCollector, timeout time.Duration) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout.Milliseconds())); err != nil {
+		return nil, err
+	}
+
+	return c.collect(ctx, tx)
+}
+
+// statusPoint converts one check cycle into a sink measurement. Failed
+// collectors are simply left out.
+func statusPoint(connected bool, latency time.Duration, results map[string]metricResult) metricPoint {
+	fields := map[string]interface{}{"connected": connected}
+	if connected {
+		fields["latency_ms"] = float64(latency.Microseconds()) / 1000
+	}
+	if r, ok := results[metricActivity]; ok && r.err == nil {
+		fields["active_connections"] = r.value.(int)
+	}
+	if r, ok := results[metricDBSize]; ok && r.err == nil {
+		fields["db_size_bytes"] = r.value.(int64)
+	}
+	return metricPoint{Measurement: "pg_status", Fields: fields, Time: time.Now()}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	channelSlack   = "slack"
+	channelWebhook = "webhook"
+	channelEmail   = "email"
+
+	notifyTimeout = 15 * time.Second
+)
+
+const (
+	eventBackupSuccess      = "backup_success"
+	eventBackupFailed       = "backup_failed"
+	eventConnectionLost     = "connection_lost"
+	eventConnectionRestored = "connection_restored"
+)
+
+const (
+	severityInfo     = "info"
+	severityWarning  = "warning"
+	severityCritical = "critical"
+)
+
+// NotificationChannel is one configured destination for notifications.
+// Templates use Go text/template syntax with a Notification as data, e.g.
+// "[{{.Tags.customer}}] {{.Title}} on {{.Host}}: {{.Message}}".
+type NotificationChannel struct {
+	Type            string   // "slack", "webhook" or "email"
+	URL             string   // Slack incoming webhook or generic webhook URL
+	Events          []string // only send these events (empty = all)
+	Template        string   // message body template (inline)
+	TemplateFile    string   // message body template loaded from a file
+	SubjectTemplate string   // email subject template
+	ContentType     string   // webhook Content-Type when a template is used
+	Tags            map[string]string
+
+	SMTPHost string
+	SMTPPort int
+	SMTPUser string
+	SMTPPass string
+	From     string
+	To       []string
+}
+
+// Notification is the data passed to channel templates.
+type Notification struct {
+	Event    string
+	Severity string
+	Title    string
+	Message  string
+	Host     string
+	Database string
+	Time     time.Time
+	Tags     map[string]string
+	Details  map[string]string
+}
+
+var defaultTemplates = map[string]string{
+	channelSlack:   "{{if eq .Severity \"critical\"}}:red_circle:{{else if eq .Severity \"warning\"}}:warning:{{else}}:white_check_mark:{{end}} *{{.Title}}* ({{.Host}}{{if .Database}}/{{.Database}}{{end}})\n{{.Message}}",
+	channelEmail:   "{{.Title}}\n\nHost: {{.Host}}\n{{if .Database}}Database: {{.Database}}\n{{end}}Time: {{formatTime .Time}}\n\n{{.Message}}\n{{range $k, $v := .Details}}{{$k}}: {{$v}}\n{{end}}",
+	channelWebhook: "",
+}
+
+const defaultSubjectTemplate = "[PG Monitor] {{.Title}} ({{.Host}})"
+
+var templateFuncs = template.FuncMap{
+	"formatTime": func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+}
+
+func (m *Monitor) notify(n Notification) {
+	m.shipToSystemLog(n)
+	if len(m.config.Notifications) == 0 {
+		return
+	}
+
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	if n.Host == "" {
+		n.Host = m.config.Host
+	}
+	if m.holdForDigest(n) {
+		return
+	}
+
+	for _, ch := range m.config.Notifications {
+		if !channelWants(ch, n.Event) {
+			continue
+		}
+
+		msg := n
+		msg.Tags = mergeTags(m.config.Tags, ch.Tags)
+		go func(ch NotificationChannel, msg Notification) {
+			if err := sendNotification(ch, msg); err != nil {
+				log.Printf("Notification via %s failed: %v", ch.Type, err)
+			}
+		}(ch, msg)
+	}
+}
+
+func channelWants(ch NotificationChannel, event string) bool {
+	if len(ch.Events) == 0 {
+		return true
+	}
+	for _, e := range ch.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeTags(global, channel map[string]string) map[string]string {
+	tags := make(map[string]string)
+	for k, v := range global {
+		tags[k] = v
+	}
+	for k, v := range channel {
+		tags[k] = v
+	}
+	return tags
+}
+
+func channelTemplate(ch NotificationChannel) (string, error) {
+	if ch.TemplateFile != "" {
+		data, err := os.ReadFile(ch.TemplateFile)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	if ch.Template != "" {
+		return ch.Template, nil
+	}
+	return defaultTemplates[ch.Type], nil
+}
+
+func renderTemplate(name, text string, n Notification) (string, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s template: %v", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, n); err != nil {
+		return "", fmt.Errorf("%s template failed: %v", name, err)
+	}
+	return buf.String(), nil
+}
+
+func sendNotification(ch NotificationChannel, n Notification) error {
+	text, err := channelTemplate(ch)
+	if err != nil {
+		return err
+	}
+
+	body := ""
+	if text != "" {
+		body, err = renderTemplate(ch.Type, text, n)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch ch.Type {
+	case channelSlack:
+		payload, _ := json.Marshal(map[string]string{"text": body})
+		return postNotification(ch.URL, "application/json", payload)
+
+	case channelWebhook:
+		if body == "" {
+			payload, err := json.Marshal(n)
+			if err != nil {
+				return err
+			}
+			return postNotification(ch.URL, "application/json", payload)
+		}
+		contentType := ch.ContentType
+		if contentType == "" {
+			contentType = "text/plain; charset=utf-8"
+		}
+		return postNotification(ch.URL, contentType, []byte(body))
+
+	case channelEmail:
+		subjectText := ch.SubjectTemplate
+		if subjectText == "" {
+			subjectText = defaultSubjectTemplate
+		}
+		subject, err := renderTemplate("subject", subjectText, n)
+		if err != nil {
+			return err
+		}
+		return sendEmail(ch, strings.TrimSpace(subject), body)
+
+	default:
+		return fmt.Errorf("unknown notification channel type %q", ch.Type)
+	}
+}
+
+func postNotification(url, contentType string, payload []byte) error {
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(url, contentType, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return nil
+}
+
+func sendEmail(ch NotificationChannel, subject, body string) error {
+	port := ch.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	addr := fmt.Sprintf("%s:%d", ch.SMTPHost, port)
+
+	var auth smtp.Auth
+	if ch.SMTPUser != "" {
+		auth = smtp.PlainAuth("", ch.SMTPUser, ch.SMTPPass, ch.SMTPHost)
+	}
+
+	// The subject can carry a database name from a webhook: a line break in
+	// it must not start headers of its own
+	subject = strings.NewReplacer("\r", " ", "\n", " ").Replace(subject)
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		ch.From, strings.Join(ch.To, ", "), mime.QEncoding.Encode("utf-8", subject), time.Now().Format(time.RFC1123Z), body)
+	return smtp.SendMail(addr, auth, ch.From, ch.To, []byte(msg))
+}
+
+func (m *Monitor) notifyBackup(success bool, database, message string) {
+	n := Notification{
+		Event:    eventBackupFailed,
+		Severity: severityCritical,
+		Title:    "Backup failed",
+		Message:  message,
+		Database: database,
+	}
+	if success {
+		n.Event = eventBackupSuccess
+		n.Severity = severityInfo
+		n.Title = "Backup completed"
+	}
+	m.notify(n)
+}
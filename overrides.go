@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DatabaseOverride replaces global settings for the backups of one
+// database, e.g. weekly parallel custom-format dumps of a large reporting
+// database next to hourly plain dumps of a small OLTP one. Fields left unset
+// keep the global value.
+type DatabaseOverride struct {
+	DumpFormat         string // "plain", "custom" or "directory"
+	DumpJobs           int
+	CompressBackups    *bool
+	CompressionCodec   string
+	DumpCompression    string
+	CompressionLevel   int
+	CompressionThreads int
+
+	Schedule            string // "hourly", "daily", "weekly" or "off" with AutoSchedule, like ScheduleOverrides
+	BackupWindowMinutes int    // like BackupWindowMinutes, for this database's backups
+
+	RetentionDays    int // local, like RetentionDays
+	RetentionCount   int // local, like RetentionCount
+	RetentionDaily   int // GFS, local and remote
+	RetentionWeekly  int
+	RetentionMonthly int
+
+	Destinations []string // upload only to these destinations
+}
+
+// databaseConfig returns the configuration the backups of db use: Config
+// with the DatabaseOverrides entry of db applied.
+func (m *Monitor) databaseConfig(db string) Config {
+	c := m.config
+	o, ok := m.config.DatabaseOverrides[db]
+	if !ok {
+		return c
+	}
+	if o.DumpFormat != "" {
+		c.DumpFormat = o.DumpFormat
+	}
+	if o.DumpJobs > 0 {
+		c.DumpJobs = o.DumpJobs
+	}
+	if o.CompressBackups != nil {
+		c.CompressBackups = *o.CompressBackups
+	}
+	if o.CompressionCodec != "" {
+		c.CompressionCodec = o.CompressionCodec
+	}
+	if o.DumpCompression != "" {
+		c.DumpCompression = o.DumpCompression
+	}
+	if o.CompressionLevel > 0 {
+		c.CompressionLevel = o.CompressionLevel
+	}
+	if o.CompressionThreads > 0 {
+		c.CompressionThreads = o.CompressionThreads
+	}
+	if o.RetentionDays > 0 {
+		c.RetentionDays = o.RetentionDays
+	}
+	if o.RetentionCount > 0 {
+		c.RetentionCount = o.RetentionCount
+	}
+	if o.RetentionDaily > 0 {
+		c.RetentionDaily = o.RetentionDaily
+	}
+	if o.RetentionWeekly > 0 {
+		c.RetentionWeekly = o.RetentionWeekly
+	}
+	if o.RetentionMonthly > 0 {
+		c.RetentionMonthly = o.RetentionMonthly
+	}
+	return c
+}
+
+// scheduleOverride returns the frequency forced for db, from its
+// DatabaseOverrides entry or else ScheduleOverrides.
+func (m *Monitor) scheduleOverride(db string) (string, bool) {
+	if o, ok := m.config.DatabaseOverrides[db]; ok && o.Schedule != "" {
+		return o.Schedule, true
+	}
+	s, ok := m.config.ScheduleOverrides[db]
+	freq, _, _ := parseScheduleOverride(s)
+	return freq, ok && freq != ""
+}
+
+// parseScheduleOverride splits a ScheduleOverrides value such as
+// "weekly,window=240" into the frequency and the backup window in minutes
+// (0 when not given).
+func parseScheduleOverride(s string) (freq string, window int, err error) {
+	parts := strings.Split(s, ",")
+	freq = strings.TrimSpace(parts[0])
+	for _, p := range parts[1:] {
+		v, ok := strings.CutPrefix(strings.TrimSpace(p), "window=")
+		if !ok {
+			return freq, 0, fmt.Errorf("unknown setting %q", p)
+		}
+		if window, err = strconv.Atoi(v); err != nil || window < 0 {
+			return freq, 0, fmt.Errorf("invalid window %q", v)
+		}
+	}
+	return freq, window, nil
+}
+
+// backupWindowMinutes is the window of a backup of db: its DatabaseOverrides
+// entry's, else its ScheduleOverrides entry's, else BackupWindowMinutes.
+func (m *Monitor) backupWindowMinutes(db string) int {
+	if o, ok := m.config.DatabaseOverrides[db]; ok && o.BackupWindowMinutes > 0 {
+		return o.BackupWindowMinutes
+	}
+	if _, window, err := parseScheduleOverride(m.config.ScheduleOverrides[db]); err == nil && window > 0 {
+		return window
+	}
+	return m.config.BackupWindowMinutes
+}
+
+// checkDatabaseOverrides reports per-database schedules, which only the
+// automatic scheduler follows, and unreadable ScheduleOverrides.
+func (m *Monitor) checkDatabaseOverrides() {
+	for db, s := range m.config.ScheduleOverrides {
+		if _, _, err := parseScheduleOverride(s); err != nil {
+			m.configError("ScheduleOverrides[%q] %q: %v", db, s, err)
+		}
+	}
+	if m.config.AutoSchedule {
+		return
+	}
+	for db, o := range m.config.DatabaseOverrides {
+		if o.Schedule != "" {
+			m.configError("DatabaseOverrides[%q].Schedule %q is ignored: it needs AutoSchedule", db, o.Schedule)
+		}
+	}
+}
+
+// backupDestinations returns where a backup of db goes: the destination
+// the run asks for, else those of db's override, else all of them.
+func (m *Monitor) backupDestinations(db, name string) []Destination {
+	o := m.config.DatabaseOverrides[db]
+	if name != "" || len(o.Destinations) == 0 {
+		return m.destinationsFor(name)
+	}
+	var dests []Destination
+	for _, n := range o.Destinations {
+		if d, ok := m.destination(n); ok {
+			dests = append(dests, d)
+		} else {
+			log.Printf("Database %s: unknown destination %q in DatabaseOverrides ignored", db, n)
+		}
+	}
+	return dests
+}
+
+// databaseRetention is p for the backups of db: locally with all of db's
+// retention overrides applied, on a destination only its GFS tiers, as
+// destinations keep their own age and count limits.
+func (m *Monitor) databaseRetention(db string, p retentionPolicy, local bool) retentionPolicy {
+	c := m.databaseConfig(db)
+	if local {
+		p.Days, p.Count = c.RetentionDays, c.RetentionCount
+	}
+	p.Daily, p.Weekly, p.Monthly = c.RetentionDaily, c.RetentionWeekly, c.RetentionMonthly
+	return p
+}
+
+// retentionOverridden reports whether any database has a retention override,
+// so retention runs even when the global policy keeps everything.
+func (m *Monitor) retentionOverridden() bool {
+	for _, o := range m.config.DatabaseOverrides {
+		if o.RetentionDays > 0 || o.RetentionCount > 0 || o.RetentionDaily > 0 || o.RetentionWeekly > 0 || o.RetentionMonthly > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneByDatabase is pruneCandidates with the retention override of each
+// database applied to its own backups; pg_dumpall backups and databases
+// without an override follow p.
+func (m *Monitor) pruneByDatabase(backups []restoreCandidate, p retentionPolicy, local bool, skip map[string]bool, now time.Time) []restoreCandidate {
+	var rest []restoreCandidate
+	overridden := make(map[string][]restoreCandidate)
+	for _, b := range backups {
+		if _, ok := m.config.DatabaseOverrides[b.Database]; ok && !b.AllDatabases {
+			overridden[b.Database] = append(overridden[b.Database], b)
+		} else {
+			rest = append(rest, b)
+		}
+	}
+
+	prune := pruneCandidates(rest, p, skip, now)
+	for db, list := range overridden {
+		prune = append(prune, pruneCandidates(list, m.databaseRetention(db, p, local), skip, now)...)
+	}
+	return prune
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const quickPauseDuration = 24 * time.Hour
+
+// Modifier flags as RegisterHotKey expects them.
+const (
+	hotkeyAlt   = 0x1
+	hotkeyCtrl  = 0x2
+	hotkeyShift = 0x4
+	hotkeyWin   = 0x8
+)
+
+type paletteView struct {
+	Status     string
+	LastBackup string
+	NextBackup string
+	Scheduled  bool
+	Paused     bool
+	Result     string
+}
+
+var palettePage = template.Must(template.New("actions").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>PG Monitor</title>
+<style>body{font-family:sans-serif;margin:1em;width:18em}button{display:block;width:100%;margin:4px 0;padding:6px;text-align:left}
+.err{color:#b00}</style></head>
+<body><form method="post">
+<p>{{.Status}}<br>{{.LastBackup}}<br>{{.NextBackup}}</p>
+{{if .Result}}<p class="err">{{.Result}}</p>{{end}}
+<button name="action" value="backup" accesskey="b" autofocus><u>B</u>ackup now</button>
+<button name="action" value="status" accesskey="s">Refresh <u>s</u>tatus</button>
+<button name="action" value="dashboard" accesskey="d">Open <u>d</u>ashboard</button>
+{{if .Scheduled}}{{if .Paused}}<button name="action" value="resume" accesskey="p">Resume schedule</button>
+{{else}}<button name="action" value="pause" accesskey="p"><u>P</u>ause schedule 24h</button>{{end}}{{end}}
+</form></body></html>`))
+
+// startHotkey registers QuickActionsHotkey; pressing it opens the quick
+// action palette.
+func (m *Monitor) startHotkey() {
+	mods, key, err := parseHotkey(m.config.QuickActionsHotkey)
+	if err == nil {
+		err = registerHotkey(mods, key, func() { m.openLocalPage("actions") })
+	}
+	if err != nil {
+		log.Printf("Quick actions hotkey %q not registered: %v", m.config.QuickActionsHotkey, err)
+		return
+	}
+	log.Printf("Quick actions hotkey: %s", m.config.QuickActionsHotkey)
+}
+
+// handlePalette serves the quick action palette: the handful of things done
+// daily, without opening the tray menu.
+func (m *Monitor) handlePalette(w http.ResponseWriter, r *http.Request) {
+	var view paletteView
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		switch r.FormValue("action") {
+		case "backup":
+			go m.manualBackup(false)
+			view.Result = "Backup started."
+		case "status":
+			m.checkDatabase()
+		case "dashboard":
+			go m.openLocalPage("backups")
+		case "pause":
+			if m.config.AutoBackupEnabled && !m.pauseAutoBackups(quickPauseDuration) {
+				view.Result = "Pause not authorized."
+			}
+		case "resume":
+			m.resumeAutoBackups()
+		}
+	}
+
+	view.Status = "Status: disconnected"
+	if m.isConnected {
+		view.Status = "Status: connected"
+	}
+	view.LastBackup = "Last backup: never"
+	if !m.lastBackupTime.IsZero() {
+		view.LastBackup = fmt.Sprintf("Last backup: %s (%s)", m.lastBackupTime.Format("2006-01-02 15:04"), m.lastBackupStatus)
+	}
+	view.Scheduled = m.config.AutoBackupEnabled
+	view.Paused = m.autoBackupPaused()
+	switch {
+	case !m.config.AutoBackupEnabled:
+		view.NextBackup = "Next backup: disabled"
+	case view.Paused:
+		view.NextBackup = "Next backup: paused"
+	case !m.nextScheduledTime.IsZero():
+		view.NextBackup = "Next backup: " + m.nextScheduledTime.Format("2006-01-02 15:04")
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	palettePage.Execute(w, view)
+}
+
+// parseHotkey parses a key combination like "Ctrl+Alt+B" or "Win+Shift+F9"
+// into RegisterHotKey modifiers and a virtual-key code.
+func parseHotkey(spec string) (uint32, uint32, error) {
+	parts := strings.Split(spec, "+")
+	var mods uint32
+	for _, p := range parts[:len(parts)-1] {
+		switch strings.ToLower(strings.TrimSpace(p)) {
+		case "ctrl", "control":
+			mods |= hotkeyCtrl
+		case "alt":
+			mods |= hotkeyAlt
+		case "shift":
+			mods |= hotkeyShift
+		case "win", "super":
+			mods |= hotkeyWin
+		default:
+			return 0, 0, fmt.Errorf("unknown modifier %q", p)
+		}
+	}
+	if mods == 0 {
+		return 0, 0, fmt.Errorf("a global hotkey needs at least one modifier")
+	}
+
+	key := strings.ToUpper(strings.TrimSpace(parts[len(parts)-1]))
+	switch {
+	case len(key) == 1 && (key[0] >= 'A' && key[0] <= 'Z' || key[0] >= '0' && key[0] <= '9'):
+		return mods, uint32(key[0]), nil
+	case len(key) > 1 && key[0] == 'F':
+		if n, err := strconv.Atoi(key[1:]); err == nil && n >= 1 && n <= 24 {
+			return mods, 0x70 + uint32(n-1), nil
+		}
+	}
+	return 0, 0, fmt.Errorf("unsupported key %q", key)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxSchemaMenuItems = 30
+	schemaMenuRefresh  = time.Hour
+
+	schemaListQuery = `SELECT nspname FROM pg_namespace
+WHERE nspname <> 'information_schema' AND nspname NOT LIKE 'pg\_%'
+ORDER BY nspname`
+)
+
+var (
+	schemaMenuMu sync.Mutex
+	schemaNames  []string
+)
+
+// dumpFilter returns the schemas and tables a dump of dbName is limited to:
+// the schema picked for an ad-hoc backup, or IncludeSchemas/IncludeTables
+// for the monitored database. Both empty means the whole database.
+func (m *Monitor) dumpFilter(dbName string, opts backupOptions) ([]string, []string) {
+	if opts.Schema != "" {
+		return []string{opts.Schema}, nil
+	}
+	if dbName != m.config.DBName {
+		return nil, nil
+	}
+	return m.config.IncludeSchemas, m.config.IncludeTables
+}
+
+func dumpFilterArgs(schemas, tables []string) []string {
+	var args []string
+	for _, s := range schemas {
+		args = append(args, "-n", s)
+	}
+	for _, t := range tables {
+		args = append(args, "-t", t)
+	}
+	return args
+}
+
+// dumpScope describes a partial dump for the manifest and catalog, so
+// retention and size checks never compare it with full dumps.
+func dumpScope(schemas, tables []string) string {
+	var parts []string
+	if len(schemas) > 0 {
+		parts = append(parts, "schemas "+strings.Join(schemas, ","))
+	}
+	if len(tables) > 0 {
+		parts = append(parts, "tables "+strings.Join(tables, ","))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// addSchemaMenu adds "Backup Schema" with one pre-allocated entry per schema
+// of the monitored database, filled in by schemaMenuLoop.
+func (m *Monitor) addSchemaMenu() {
+	menu := tray.AddMenuItem("Backup Schema", "Back up a single schema of the monitored database")
+	for i := 0; i < maxSchemaMenuItems; i++ {
+		item := menu.AddSubMenuItem("", "")
+		item.Hide()
+		go func(i int, item *MenuItem) {
+			for range item.ClickedCh {
+				schemaMenuMu.Lock()
+				schema := ""
+				if i < len(schemaNames) {
+					schema = schemaNames[i]
+				}
+				schemaMenuMu.Unlock()
+				if schema != "" {
+					go m.backupSchema(schema)
+				}
+			}
+		}(i, item)
+		m.schemaItems = append(m.schemaItems, item)
+	}
+}
+
+func (m *Monitor) schemaMenuLoop() {
+	for {
+		if err := m.updateSchemaMenu(); err != nil {
+			log.Printf("Failed to list schemas: %v", err)
+		}
+		time.Sleep(schemaMenuRefresh)
+	}
+}
+
+func (m *Monitor) updateSchemaMenu() error {
+	db, err := m.openDB(m.config.DBName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.metricTimeout())
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, schemaListQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(names) > maxSchemaMenuItems {
+		log.Printf("Backup Schema menu shows the first %d of %d schemas", maxSchemaMenuItems, len(names))
+		names = names[:maxSchemaMenuItems]
+	}
+
+	schemaMenuMu.Lock()
+	schemaNames = names
+	schemaMenuMu.Unlock()
+
+	for i, item := range m.schemaItems {
+		if i >= len(names) {
+			item.Hide()
+			continue
+		}
+		item.SetTitle(names[i])
+		item.SetTooltip(fmt.Sprintf("pg_dump -n %s %s", names[i], m.config.DBName))
+		item.Show()
+	}
+	return nil
+}
+
+func (m *Monitor) backupSchema(schema string) {
+	job := BackupJob{Database: m.config.DBName + " schema " + schema, Trigger: triggerManual}
+	m.backupJob(job, func() CatalogEntry {
+		log.Printf("Ad-hoc backup of schema %s in %s", schema, m.config.DBName)
+		return m.backupOne(m.config.DBName, false, backupOptions{Schema: schema})
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPartitionFullDay = "Sunday"
+	partitionFullMaxAge     = 7 * 24 * time.Hour
+
+	// Leaf partitions of single-column range-partitioned tables with their
+	// bounds, e.g. FOR VALUES FROM ('2024-01-01') TO ('2024-02-01')
+	partitionBoundsQuery = `SELECT format('%I.%I', n.nspname, c.relname), pg_get_expr(c.relpartbound, c.oid)
+FROM pg_inherits i
+JOIN pg_class c ON c.oid = i.inhrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+JOIN pg_partitioned_table p ON p.partrelid = i.inhparent
+WHERE c.relkind = 'r' AND p.partstrat = 'r' AND p.partnatts = 1`
+)
+
+var partitionUpperBound = regexp.MustCompile(`TO \('([^']+)'\)$`)
+
+// recentPartitionArgs limits a scheduled dump to the data of partitions that
+// reach into the last RecentPartitionsDays: older partitions of range
+// partitioned tables keep their definition but are dumped without rows
+// (--exclude-table-data). Scheduled dumps on RecentPartitionsFullDay, and
+// any when the last full dump is more than a week old, stay full. It returns
+// the pg_dump arguments and the scope to record, or nothing for a full dump.
+func (m *Monitor) recentPartitionArgs(source backupSource, dbName string, opts backupOptions, scope string) ([]string, string) {
+	days := m.config.RecentPartitionsDays
+	if days <= 0 || !opts.Scheduled {
+		return nil, ""
+	}
+	fullDay := m.config.RecentPartitionsFullDay
+	if fullDay == "" {
+		fullDay = defaultPartitionFullDay
+	}
+	if strings.EqualFold(time.Now().Weekday().String(), fullDay) {
+		log.Printf("Recent partitions: full dump on %s", fullDay)
+		return nil, ""
+	}
+	if last := lastFullBackup(dbName, scope); time.Since(last) > partitionFullMaxAge {
+		log.Printf("Recent partitions: full dump, no full backup of %s since %s", dbName, last.Format("2006-01-02"))
+		return nil, ""
+	}
+
+	old, total, err := m.oldPartitions(source, dbName, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		log.Printf("Recent partitions: full dump, partition bounds unreadable: %v", err)
+		return nil, ""
+	}
+	if len(old) == 0 {
+		return nil, ""
+	}
+	log.Printf("Recent partitions: skipping the data of %d of %d partition(s) older than %d days", len(old), total, days)
+
+	var args []string
+	for _, p := range old {
+		args = append(args, "--exclude-table-data="+p)
+	}
+	return args, fmt.Sprintf("partitions of the last %d days", days)
+}
+
+// oldPartitions returns the range partitions of dbName whose upper bound is
+// at or before cutoff, and how many range partitions there are. Bounds that
+// aren't a single date or timestamp (MAXVALUE, DEFAULT, numbers) count as
+// recent.
+func (m *Monitor) oldPartitions(source backupSource, dbName string, cutoff time.Time) ([]string, int, error) {
+	db, err := m.openDBAt(source.Host, source.Port, dbName)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.metricTimeout())
+	defer cancel()
+	rows, err := db.QueryContext(ctx, partitionBoundsQuery)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var old []string
+	total := 0
+	for rows.Next() {
+		var name, bound string
+		if err := rows.Scan(&name, &bound); err != nil {
+			return nil, 0, err
+		}
+		total++
+		if upper, ok := partitionUpper(bound); ok && !upper.After(cutoff) {
+			old = append(old, name)
+		}
+	}
+	return old, total, rows.Err()
+}
+
+// partitionUpper parses the exclusive upper bound of a range partition.
+// Fractional seconds are accepted by every layout.
+func partitionUpper(bound string) (time.Time, bool) {
+	match := partitionUpperBound.FindStringSubmatch(bound)
+	if match == nil {
+		return time.Time{}, false
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05-07", "2006-01-02 15:04:05-07:00", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, match[1], time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// lastFullBackup is when the newest successful dump of dbName with the
+// given scope and no partition limit finished.
+func lastFullBackup(dbName, scope string) time.Time {
+	entries, err := loadCatalog()
+	if err != nil {
+		return time.Time{}
+	}
+	var last time.Time
+	for _, e := range entries {
+		if e.Success && e.Kind == "database" && e.Database == dbName && e.Scope == scope && e.Finished.After(last) {
+			last = e.Finished
+		}
+	}
+	return last
+}
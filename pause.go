@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+const pauseStateFile = "pause-state.json"
+
+// pauseDurations are offered in the "Pause Auto Backups" submenu.
+var pauseDurations = []time.Duration{time.Hour, 8 * time.Hour, 24 * time.Hour}
+
+// pauseState is persisted for timed pauses only, so a restart during
+// maintenance neither forgets the pause nor keeps it past its end.
+type pauseState struct {
+	PausedUntil time.Time
+}
+
+func (m *Monitor) autoBackupPaused() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.paused
+}
+
+// toggleAutoBackups pauses or resumes scheduled backups until the next start;
+// only pausing needs authorization.
+func (m *Monitor) toggleAutoBackups() {
+	if m.autoBackupPaused() {
+		m.resumeAutoBackups()
+		return
+	}
+	m.pauseAutoBackups(0)
+}
+
+// pauseAutoBackups pauses scheduled backups for d, or until resumed when d
+// is zero. It reports whether the pause was authorized.
+func (m *Monitor) pauseAutoBackups(d time.Duration) bool {
+	if !m.authorize("Pause auto backups") {
+		return false
+	}
+
+	var until time.Time
+	if d > 0 {
+		until = time.Now().Add(d)
+		log.Printf("Auto backups paused for %s (until %s)", d, until.Format("2006-01-02 15:04"))
+	} else {
+		log.Printf("Auto backups paused")
+	}
+	m.setPause(until)
+	savePauseState(until)
+	return true
+}
+
+// setPause pauses scheduled backups until the given time, or indefinitely
+// when it is zero, and arranges the automatic resume.
+func (m *Monitor) setPause(until time.Time) {
+	m.mu.Lock()
+	m.paused = true
+	m.pausedUntil = until
+	if m.pauseTimer != nil {
+		m.pauseTimer.Stop()
+		m.pauseTimer = nil
+	}
+	var t *time.Timer
+	if !until.IsZero() {
+		t = time.AfterFunc(time.Until(until), func() {
+			// A later pause or resume replaces the timer
+			if m.currentPause(t) {
+				m.resumeAutoBackups()
+			}
+		})
+		m.pauseTimer = t
+	}
+	m.mu.Unlock()
+
+	m.autoBackupItem.Uncheck()
+	m.updateNextBackupStatus()
+	if t != nil {
+		go m.pauseCountdown(t)
+	}
+}
+
+func (m *Monitor) resumeAutoBackups() {
+	m.mu.Lock()
+	m.paused = false
+	m.pausedUntil = time.Time{}
+	if m.pauseTimer != nil {
+		m.pauseTimer.Stop()
+		m.pauseTimer = nil
+	}
+	m.mu.Unlock()
+
+	savePauseState(time.Time{})
+	m.autoBackupItem.Check()
+	log.Printf("Auto backups resumed")
+	m.updateNextBackupStatus()
+}
+
+func (m *Monitor) currentPause(t *time.Timer) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pauseTimer == t
+}
+
+// pauseCountdown keeps "Next Backup: Paused (resumes in ...)" current while
+// the timed pause t lasts.
+func (m *Monitor) pauseCountdown(t *time.Timer) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !m.currentPause(t) {
+			return
+		}
+		m.updateNextBackupStatus()
+	}
+}
+
+// pausedTitle is the "Next Backup" title while paused.
+func (m *Monitor) pausedTitle() string {
+	m.mu.Lock()
+	until := m.pausedUntil
+	m.mu.Unlock()
+	if until.IsZero() {
+		return "Next Backup: Paused"
+	}
+	return fmt.Sprintf("Next Backup: Paused (resumes in %s)", formatCountdown(time.Until(until)))
+}
+
+func formatCountdown(d time.Duration) string {
+	if d < time.Minute {
+		return "< 1 min"
+	}
+	d = d.Round(time.Minute)
+	if d < time.Hour {
+		return fmt.Sprintf("%d min", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%dh %02dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// restorePause resumes a timed pause that was active when the app stopped.
+func (m *Monitor) restorePause() {
+	data, err := os.ReadFile(pauseStateFile)
+	if err != nil {
+		return
+	}
+	var state pauseState
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("Ignoring unreadable %s: %v", pauseStateFile, err)
+		return
+	}
+	if !state.PausedUntil.After(time.Now()) {
+		savePauseState(time.Time{})
+		return
+	}
+	log.Printf("Auto backups still paused until %s", state.PausedUntil.Format("2006-01-02 15:04"))
+	m.setPause(state.PausedUntil)
+}
+
+// savePauseState records a timed pause, or removes the record when until is
+// zero (resumed, or paused until restart).
+func savePauseState(until time.Time) {
+	if until.IsZero() {
+		if err := os.Remove(pauseStateFile); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %s: %v", pauseStateFile, err)
+		}
+		return
+	}
+	data, _ := json.MarshalIndent(pauseState{PausedUntil: until}, "", "  ")
+	if err := os.WriteFile(pauseStateFile, data, 0644); err != nil {
+		log.Printf("Failed to write %s: %v", pauseStateFile, err)
+	}
+}
+
+func (m *Monitor) addPauseMenu() {
+	pause := tray.AddMenuItem("Pause Auto Backups", "Suspend scheduled backups, resuming automatically")
+	items := make([]*MenuItem, len(pauseDurations))
+	for i, d := range pauseDurations {
+		items[i] = pause.AddSubMenuItem(fmt.Sprintf("for %s", formatPauseDuration(d)), "")
+	}
+
+	for i := range items {
+		go func(item *MenuItem, d time.Duration) {
+			for range item.ClickedCh {
+				m.pauseAutoBackups(d)
+			}
+		}(items[i], pauseDurations[i])
+	}
+}
+
+func formatPauseDuration(d time.Duration) string {
+	if d == time.Hour {
+		return "1 hour"
+	}
+	return fmt.Sprintf("%d hours", int(d.Hours()))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	opaqueNameBytes = 16
+	pgpExt          = ".gpg"
+	restoringPrefix = "restoring_"
+)
+
+// encryptOpenPGP encrypts src into dst with gpg, either to PGPRecipient's
+// public key or symmetrically with the passphrase in PGPPassphraseFile. The
+// plaintext is fed on stdin so no file name ends up in the literal packet,
+// and --throw-keyids hides which key the message is for.
+func (m *Monitor) encryptOpenPGP(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	args := []string{"--batch", "--yes", "--no-tty", "--output", dst}
+	switch {
+	case m.config.PGPRecipient != "":
+		args = append(args, "--throw-keyids", "--encrypt", "--recipient", m.config.PGPRecipient)
+	case m.config.PGPPassphraseFile != "":
+		args = append(args, "--pinentry-mode", "loopback", "--passphrase-file", m.config.PGPPassphraseFile,
+			"--symmetric", "--cipher-algo", "AES256")
+	default:
+		return fmt.Errorf("untrusted destination requires PGPRecipient or PGPPassphraseFile")
+	}
+
+	cmd := exec.Command("gpg", args...)
+	cmd.Stdin = in
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("gpg failed: %v, output: %s", err, string(output))
+	}
+	return nil
+}
+
+func (m *Monitor) decryptOpenPGP(src, dst string) error {
+	args := []string{"--batch", "--yes", "--no-tty", "--output", dst}
+	if m.config.PGPPassphraseFile != "" {
+		args = append(args, "--pinentry-mode", "loopback", "--passphrase-file", m.config.PGPPassphraseFile)
+	}
+	args = append(args, "--decrypt", src)
+
+	if output, err := exec.Command("gpg", args...).CombinedOutput(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("gpg failed: %v, output: %s", err, string(output))
+	}
+	return nil
+}
+
+func opaqueName() (string, error) {
+	b := make([]byte, opaqueNameBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// encryptOpaque encrypts file into a randomly named blob next to it, so the
+// remote side learns nothing but the blob's size. The returned name is the
+// only link between blob and backup and is kept in the local catalog; the
+// blob is removed once every destination has it.
+func (m *Monitor) encryptOpaque(file string) (string, string, error) {
+	name, err := opaqueName()
+	if err != nil {
+		return "", "", err
+	}
+
+	encrypted := filepath.Join(filepath.Dir(file), name)
+	if err := m.encryptOpenPGP(file, encrypted); err != nil {
+		return "", "", err
+	}
+	log.Printf("Encrypted %s as opaque object %s", filepath.Base(file), name)
+	return name, encrypted, nil
+}
+
+func isOpaqueBlob(path string) bool {
+	name := filepath.Base(path)
+	if len(name) != 2*opaqueNameBytes {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+func isPGPEncrypted(file string) bool {
+	return strings.HasSuffix(file, pgpExt)
+}
+
+// dumpName returns the name of the dump inside an encrypted backup:
+// x.sql.gpg holds x.sql and x.dir.tar.gpg holds the directory dump x.dir.
+func dumpName(file string) string {
+	file = strings.TrimSuffix(file, pgpExt)
+	if isDumpTarball(file) {
+		file = strings.TrimSuffix(file, tarballExt)
+	}
+	return file
+}
+
+// encryptBackupPGP encrypts a finished dump to PGPRecipient's public key and
+// removes the plaintext, so the backup machine keeps nothing it could decrypt
+// with. Directory dumps are tarred first.
+func (m *Monitor) encryptBackupPGP(backupFile string) (string, error) {
+	if m.config.PGPRecipient == "" {
+		return "", fmt.Errorf("PGPEncryptBackups requires PGPRecipient")
+	}
+
+	src := backupFile
+	if isDirectoryDump(backupFile) {
+		tarball, err := tarDirectory(backupFile)
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(tarball)
+		src = tarball
+	}
+
+	encrypted := src + pgpExt
+	if err := m.encryptOpenPGP(src, encrypted); err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(backupFile); err != nil {
+		log.Printf("Failed to remove plaintext dump %s: %v", backupFile, err)
+	}
+	log.Printf("Encrypted %s to %s", filepath.Base(backupFile), m.config.PGPRecipient)
+	return encrypted, nil
+}
+
+// decryptForRestore decrypts an encrypted backup next to it for a restore;
+// it needs the recipient's private key in the local keyring. The caller
+// removes the returned file or directory.
+func (m *Monitor) decryptForRestore(file string) (string, error) {
+	plain := filepath.Join(filepath.Dir(file), restoringPrefix+strings.TrimSuffix(filepath.Base(file), pgpExt))
+	if err := m.decryptOpenPGP(file, plain); err != nil {
+		return "", err
+	}
+	if !isDumpTarball(plain) {
+		return plain, nil
+	}
+
+	defer os.Remove(plain)
+	dir := strings.TrimSuffix(plain, tarballExt)
+	if err := untarDirectory(plain, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// pg_dump --version: "pg_dump (PostgreSQL) 16.2" or "... 17beta1"
+var pgToolVersion = regexp.MustCompile(`\(PostgreSQL\) (\d+)`)
+
+var (
+	pgToolsMu     sync.Mutex
+	pgBinDir      string   // directory the client tools run from ("" = PATH)
+	pgDumpMajor   int      // major version of that pg_dump (0 = unknown)
+	serverMajors  sync.Map // "host:port" -> major version of the server
+	versionWarned sync.Map
+)
+
+// pgTool returns the command that runs a PostgreSQL client tool: the file
+// in the detected bin directory, or the bare name looked up on PATH.
+func pgTool(name string) string {
+	pgToolsMu.Lock()
+	dir := pgBinDir
+	pgToolsMu.Unlock()
+	if dir == "" {
+		return name
+	}
+	return toolFile(dir, name)
+}
+
+func toolFile(dir, name string) string {
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(dir, name)
+}
+
+// pgBinCandidates are the bin directories of the usual PostgreSQL
+// installations: the EDB installer on Windows, the distribution packages on
+// Linux, Postgres.app, Homebrew and the EDB installer on macOS.
+func pgBinCandidates() []string {
+	var patterns []string
+	switch runtime.GOOS {
+	case "windows":
+		for _, env := range []string{"ProgramFiles", "ProgramFiles(x86)", "ProgramW6432"} {
+			if dir := os.Getenv(env); dir != "" {
+				patterns = append(patterns, filepath.Join(dir, "PostgreSQL", "*", "bin"))
+			}
+		}
+	case "darwin":
+		patterns = []string{
+			"/Applications/Postgres.app/Contents/Versions/*/bin",
+			"/opt/homebrew/opt/postgresql@*/bin",
+			"/opt/homebrew/opt/libpq/bin",
+			"/usr/local/opt/postgresql@*/bin",
+			"/usr/local/opt/libpq/bin",
+			"/Library/PostgreSQL/*/bin",
+		}
+	default:
+		patterns = []string{
+			"/usr/lib/postgresql/*/bin",
+			"/usr/pgsql-*/bin",
+			"/usr/local/pgsql/bin",
+			"/opt/postgresql*/bin",
+		}
+	}
+
+	var dirs []string
+	for _, p := range patterns {
+		matches, _ := filepath.Glob(p)
+		dirs = append(dirs, matches...)
+	}
+	return dirs
+}
+
+// pgDumpVersion runs pg_dump --version and returns its major version.
+func pgDumpVersion(command string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, command, "--version").Output()
+	if err != nil {
+		return 0, err
+	}
+	match := pgToolVersion.FindStringSubmatch(string(output))
+	if match == nil {
+		return 0, fmt.Errorf("unexpected version output %q", output)
+	}
+	return strconv.Atoi(match[1])
+}
+
+// installedPgDumps returns the bin directories of the installations found
+// in pgBinCandidates by the major version of their pg_dump, newest first.
+func installedPgDumps() (dirs []string, majors []int) {
+	type install struct {
+		dir   string
+		major int
+	}
+	var found []install
+	for _, dir := range pgBinCandidates() {
+		if major, err := pgDumpVersion(toolFile(dir, "pg_dump")); err == nil {
+			found = append(found, install{dir, major})
+		}
+	}
+	sort.SliceStable(found, func(i, k int) bool { return found[i].major > found[k].major })
+	for _, f := range found {
+		dirs = append(dirs, f.dir)
+		majors = append(majors, f.major)
+	}
+	return dirs, majors
+}
+
+// findPgTools decides where pg_dump, pg_restore, psql and the other client
+// tools are run from: PgBinDir when set, else PATH, else the newest
+// installation in a usual location. Without any of them backups fail with
+// "executable file not found" as before.
+func (m *Monitor) findPgTools() {
+	if dir := m.config.PgBinDir; dir != "" {
+		if major, err := pgDumpVersion(toolFile(dir, "pg_dump")); err == nil {
+			pgBinDir, pgDumpMajor = dir, major
+			log.Printf("PostgreSQL client tools: %s (pg_dump %d)", dir, major)
+			return
+		}
+		log.Printf("Warning: PgBinDir %s has no working pg_dump, looking elsewhere", dir)
+	}
+	if path, err := exec.LookPath("pg_dump"); err == nil {
+		pgDumpMajor, _ = pgDumpVersion(path)
+		log.Printf("PostgreSQL client tools: %s from PATH (pg_dump %d)", filepath.Dir(path), pgDumpMajor)
+		return
+	}
+	dirs, majors := installedPgDumps()
+	if len(dirs) == 0 {
+		log.Printf("Warning: pg_dump not found on PATH or in the usual install locations; set PgBinDir")
+		return
+	}
+	pgBinDir, pgDumpMajor = dirs[0], majors[0]
+	log.Printf("PostgreSQL client tools: %s (pg_dump %d, not on PATH)", pgBinDir, pgDumpMajor)
+}
+
+// serverMajor returns the major version of the server at host:port. It is
+// asked once per server and run; 0 means unknown.
+func (m *Monitor) serverMajor(host string, port int) int {
+	key := fmt.Sprintf("%s:%d", host, port)
+	if major, ok := serverMajors.Load(key); ok {
+		return major.(int)
+	}
+	db, err := m.openDBAt(host, port, m.config.DBName)
+	if err != nil {
+		return 0
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), connTimeout)
+	defer cancel()
+	var num int
+	if err := db.QueryRowContext(ctx, "SELECT current_setting('server_version_num')::int").Scan(&num); err != nil {
+		return 0
+	}
+	serverMajors.Store(key, num/10000)
+	return num / 10000
+}
+
+// checkClientVersion reports pg_dump being older than the server it dumps,
+// which pg_dump refuses, so every backup would fail. Without PgBinDir a
+// new enough installation found elsewhere is switched to instead; with it,
+// or without one, it warns once per server.
+func (m *Monitor) checkClientVersion(source backupSource) error {
+	pgToolsMu.Lock()
+	client := pgDumpMajor
+	pgToolsMu.Unlock()
+	if client == 0 {
+		return nil
+	}
+	server := m.serverMajor(source.Host, source.Port)
+	if server <= client {
+		return nil
+	}
+
+	err := fmt.Errorf("pg_dump %d is older than PostgreSQL %d on %s:%d", client, server, source.Host, source.Port)
+	if _, warned := versionWarned.LoadOrStore(fmt.Sprintf("%s:%d/%d", source.Host, source.Port, server), true); warned {
+		return err
+	}
+	dirs, majors := installedPgDumps()
+	if m.config.PgBinDir == "" && len(dirs) > 0 && majors[0] >= server {
+		pgToolsMu.Lock()
+		pgBinDir, pgDumpMajor = dirs[0], majors[0]
+		pgToolsMu.Unlock()
+		log.Printf("PostgreSQL client tools: %s (pg_dump %d), as %v", dirs[0], majors[0], err)
+		return nil
+	}
+	hint := "install the PostgreSQL " + strconv.Itoa(server) + " client tools and set PgBinDir"
+	for i, dir := range dirs {
+		if majors[i] >= server {
+			hint = fmt.Sprintf("set PgBinDir to %s (pg_dump %d)", dir, majors[i])
+			break
+		}
+	}
+	m.configError("%v; %s", err, hint)
+	return err
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pitrWALFolder    = "pitr_wal"
+	walSegmentBytes  = 16 << 20 // initdb default; other --wal-segsize values aren't supported
+	pitrFetchTimeout = 30 * time.Minute
+)
+
+// pgBackupManifest is the part of pg_basebackup's backup_manifest needed to
+// find where the backup's WAL starts.
+type pgBackupManifest struct {
+	WALRanges []struct {
+		Timeline int    `json:"Timeline"`
+		StartLSN string `json:"Start-LSN"`
+	} `json:"WAL-Ranges"`
+}
+
+// pointInTimeRestore prepares outputDir for recovery to target: the newest
+// physical backup finished before target is reconstructed there, the WAL
+// from its start up to target is fetched from the archive (locally, or from
+// a destination holding it) and recovery_target_time is configured. Starting
+// PostgreSQL on outputDir then replays up to target and promotes.
+func (m *Monitor) pointInTimeRestore(target time.Time, outputDir string) error {
+	if entries, err := os.ReadDir(outputDir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", outputDir)
+	}
+
+	backups, err := listBaseBackups()
+	if err != nil {
+		return err
+	}
+	var base *BackupManifest
+	for i := range backups {
+		if !backups[i].CreatedAt.After(target) {
+			base = &backups[i]
+		}
+	}
+	if base == nil {
+		return fmt.Errorf("no physical backup finished before %s", target.Format("2006-01-02 15:04:05"))
+	}
+	fmt.Printf("Base backup: %s (%s, finished %s)\n", base.File, base.Kind, base.CreatedAt.Format("2006-01-02 15:04:05"))
+
+	baseDir := filepath.Join(physicalBackupDir(), base.File)
+	start, err := walStartSegment(baseDir)
+	if err != nil {
+		return err
+	}
+
+	if err := m.combineBaseBackup(baseDir, outputDir); err != nil {
+		return err
+	}
+
+	segments, err := pitrSegments(loadWALArchive(), start, target)
+	if err != nil {
+		return err
+	}
+	walTarget := filepath.Join(outputDir, pitrWALFolder)
+	if err := os.MkdirAll(walTarget, 0700); err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if err := m.fetchWALSegment(s, walTarget); err != nil {
+			return fmt.Errorf("WAL segment %s: %v", s.Name, err)
+		}
+	}
+	fmt.Printf("WAL: %d segment(s) from %s\n", len(segments), start)
+
+	if err := writeRecoveryConfig(outputDir, walTarget, target); err != nil {
+		return err
+	}
+	m.audit(localActor(), "pitr_prepared", base.File, fmt.Sprintf("target %s into %s", target.Format(time.RFC3339), outputDir))
+	return nil
+}
+
+// walStartSegment returns the name of the WAL segment a base backup starts
+// in, from its backup_manifest.
+func walStartSegment(baseDir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(baseDir, pgBackupManifestName))
+	if err != nil {
+		return "", err
+	}
+	var manifest pgBackupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", fmt.Errorf("%s unreadable: %v", pgBackupManifestName, err)
+	}
+	if len(manifest.WALRanges) == 0 {
+		return "", fmt.Errorf("%s lists no WAL range", pgBackupManifestName)
+	}
+	r := manifest.WALRanges[0]
+
+	hi, lo, ok := strings.Cut(r.StartLSN, "/")
+	if !ok {
+		return "", fmt.Errorf("invalid start LSN %q", r.StartLSN)
+	}
+	logID, err1 := strconv.ParseUint(hi, 16, 32)
+	offset, err2 := strconv.ParseUint(lo, 16, 32)
+	if err1 != nil || err2 != nil {
+		return "", fmt.Errorf("invalid start LSN %q", r.StartLSN)
+	}
+	return fmt.Sprintf("%08X%08X%08X", r.Timeline, logID, offset/walSegmentBytes), nil
+}
+
+// pitrSegments picks the archived segments from start up to the first one
+// completed after target, plus history files. Segment names sort in WAL
+// order within a timeline.
+func pitrSegments(archive WALArchive, start string, target time.Time) ([]WALSegment, error) {
+	var segments []WALSegment
+	covered := false
+	for _, s := range archive.Segments {
+		name := strings.TrimSuffix(s.Name, ".gz")
+		if strings.HasSuffix(name, ".history") {
+			segments = append(segments, s)
+			continue
+		}
+		if name < start || covered {
+			continue
+		}
+		segments = append(segments, s)
+		covered = s.Archived.After(target)
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Name < segments[j].Name })
+	if len(segments) == 0 || strings.TrimSuffix(segments[len(segments)-1].Name, ".gz") < start {
+		return nil, fmt.Errorf("the WAL archive has nothing from %s on", start)
+	}
+	if !covered {
+		log.Printf("PITR: the archive ends before the target; recovery stops at the end of the archived WAL")
+		fmt.Println("Warning: the archived WAL ends before the target time; recovery will stop at its end")
+	}
+	return segments, nil
+}
+
+// fetchWALSegment copies a segment into dir uncompressed, under the name
+// PostgreSQL asks restore_command for, downloading and decrypting it when
+// the local copy has already been pruned.
+func (m *Monitor) fetchWALSegment(s WALSegment, dir string) (err error) {
+	// gpg's exit status only shows up on Close, so the stages are checked
+	var stages []io.Closer
+	defer func() {
+		for i := len(stages) - 1; i >= 0; i-- {
+			if closeErr := stages[i].Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	}()
+
+	var src io.ReadCloser
+	remote := false
+	if f, err := os.Open(filepath.Join(walDir(), s.Name)); err == nil {
+		src = f
+	} else {
+		for _, name := range s.Uploaded {
+			d, ok := m.destination(name)
+			if !ok {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), pitrFetchTimeout)
+			defer cancel()
+			if body, _, err := openRemote(ctx, d.subfolder(walSubfolder), s.remoteObject()); err == nil {
+				src, remote = body, true
+				break
+			}
+		}
+		if src == nil {
+			return fmt.Errorf("neither local nor on a destination")
+		}
+	}
+	stages = append(stages, src)
+
+	var r io.Reader = src
+	if remote && s.Encrypted {
+		key, err := m.encryptionKey()
+		if err != nil {
+			return err
+		}
+		decrypted := decryptingReader(r, key)
+		stages = append(stages, decrypted)
+		r = decrypted
+	}
+	for _, layer := range []bool{remote && s.RemoteName != "", remote && s.PGP} {
+		if !layer {
+			continue
+		}
+		pgp, err := m.openPGPReader(r)
+		if err != nil {
+			return err
+		}
+		stages = append(stages, pgp)
+		r = pgp
+	}
+	if strings.HasSuffix(s.Name, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		stages = append(stages, gz)
+		r = gz
+	}
+
+	out, err := os.Create(filepath.Join(dir, strings.TrimSuffix(s.Name, ".gz")))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// extractBaseTar unpacks a tar-format base backup: base.tar.gz into the data
+// directory and pg_wal.tar.gz into its pg_wal.
+func extractBaseTar(baseDir, outputDir string) error {
+	if err := extractTarGz(filepath.Join(baseDir, "base.tar.gz"), outputDir); err != nil {
+		return err
+	}
+	walTar := filepath.Join(baseDir, "pg_wal.tar.gz")
+	if _, err := os.Stat(walTar); err != nil {
+		return nil
+	}
+	return extractTarGz(walTar, filepath.Join(outputDir, "pg_wal"))
+}
+
+func extractTarGz(file, dir string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("untar %s: %v", filepath.Base(file), err)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if rel, err := filepath.Rel(dir, target); err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("untar %s: invalid path %q", filepath.Base(file), hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0700)
+		case tar.TypeReg:
+			err = writeTarFile(tr, target, hdr.FileInfo().Mode())
+		default:
+			// Tablespace links (pg_tblspc) have to be recreated by hand
+			log.Printf("PITR: skipping %s (tar type %c)", hdr.Name, hdr.Typeflag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeTarFile(r io.Reader, target string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeRecoveryConfig sets restore_command and the recovery target in
+// postgresql.auto.conf and creates recovery.signal.
+func writeRecoveryConfig(dataDir, walDir string, target time.Time) error {
+	walDir, err := filepath.Abs(walDir)
+	if err != nil {
+		return err
+	}
+	restore := fmt.Sprintf(`cp "%s/%%f" "%%p"`, filepath.ToSlash(walDir))
+	if runtime.GOOS == "windows" {
+		restore = fmt.Sprintf(`copy "%s\\%%f" "%%p"`, walDir)
+	}
+
+	settings := fmt.Sprintf("\n# Point-in-time recovery prepared by pg-monitor\n"+
+		"restore_command = '%s'\n"+
+		"recovery_target_time = '%s'\n"+
+		"recovery_target_action = 'promote'\n",
+		strings.ReplaceAll(restore, "'", "''"), target.Format("2006-01-02 15:04:05-07:00"))
+
+	f, err := os.OpenFile(filepath.Join(dataDir, "postgresql.auto.conf"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(settings); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dataDir, "recovery.signal"), nil, 0600)
+}
+
+// parsePITRTime reads a -pitr target in local time.
+func parsePITRTime(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected \"YYYY-MM-DD HH:MM[:SS]\"", s)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultPostBackupCheckTimeout = 30 * time.Minute
+
+// runPostBackupCheck runs PostBackupCheckCommand with the manifest path as
+// its argument; a non-zero exit (or a timeout) fails the backup before it is
+// uploaded. The backup file and manifest are also passed in the environment
+// as PGM_BACKUP_FILE, PGM_MANIFEST and PGM_DATABASE.
+func (m *Monitor) runPostBackupCheck(backupFile, manifestFile, database string) error {
+	timeout := time.Duration(m.config.PostBackupCheckTimeoutMinutes) * time.Minute
+	if timeout <= 0 {
+		timeout = defaultPostBackupCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, m.config.PostBackupCheckCommand, manifestFile)
+	cmd.Env = append(os.Environ(),
+		"PGM_BACKUP_FILE="+backupFile,
+		"PGM_MANIFEST="+manifestFile,
+		"PGM_DATABASE="+database,
+	)
+	log.Printf("Running post-backup check: %s %s", m.config.PostBackupCheckCommand, manifestFile)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("post-backup check timed out after %v", timeout)
+	}
+	if err != nil {
+		out := strings.TrimSpace(string(output))
+		if len(out) > 500 {
+			out = out[len(out)-500:]
+		}
+		return fmt.Errorf("post-backup check failed: %v: %s", err, out)
+	}
+	if out := strings.TrimSpace(string(output)); out != "" {
+		log.Printf("Post-backup check: %s", out)
+	}
+	return nil
+}
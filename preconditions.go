@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	preconditionMounted   = "mounted"
+	preconditionReachable = "reachable"
+	preconditionInterface = "interface"
+
+	scheduleLogical  = "logical"
+	schedulePhysical = "physical"
+
+	defaultPreconditionWait  = 30 * time.Minute
+	defaultPreconditionRetry = time.Minute
+	reachableTimeout         = 5 * time.Second
+
+	eventPreconditionFailed = "backup_precondition_failed"
+)
+
+// Precondition must hold before a scheduled backup starts, e.g. the NAS
+// share mounted or the VPN up.
+type Precondition struct {
+	Type     string // "mounted" (path), "reachable" (host or host:port) or "interface" (network interface up)
+	Target   string
+	Schedule string `json:",omitempty"` // "logical" or "physical"; empty applies to both
+}
+
+// checkPrecondition returns why p doesn't hold, or nil.
+func checkPrecondition(p Precondition) error {
+	switch p.Type {
+	case preconditionMounted:
+		info, err := os.Stat(p.Target)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", p.Target)
+		}
+		// An unmounted mount point is an empty directory on the parent's
+		// filesystem; Windows shares and drives have no such parent
+		if parent := filepath.Dir(p.Target); runtime.GOOS != "windows" && parent != p.Target {
+			if same, err := sameFilesystem(p.Target, parent); err == nil && same {
+				return fmt.Errorf("%s is not mounted", p.Target)
+			}
+		}
+		_, err = os.ReadDir(p.Target)
+		return err
+
+	case preconditionReachable:
+		if _, _, err := net.SplitHostPort(p.Target); err == nil {
+			conn, err := net.DialTimeout("tcp", p.Target, reachableTimeout)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}
+		// Without a port, ICMP through the system ping (raw sockets need
+		// privileges). Only Linux takes -W in seconds; the BSDs' -W is in
+		// milliseconds or missing
+		var args []string
+		switch runtime.GOOS {
+		case "windows":
+			args = []string{"-n", "1", "-w", "5000", p.Target}
+		case "darwin", "freebsd", "dragonfly":
+			args = []string{"-c", "1", "-t", "5", p.Target}
+		case "openbsd", "netbsd":
+			args = []string{"-c", "1", "-w", "5", p.Target}
+		default:
+			args = []string{"-c", "1", "-W", "5", p.Target}
+		}
+		if err := exec.Command("ping", args...).Run(); err != nil {
+			return fmt.Errorf("%s does not answer ping", p.Target)
+		}
+		return nil
+
+	case preconditionInterface:
+		iface, err := net.InterfaceByName(p.Target)
+		if err != nil {
+			return err
+		}
+		if iface.Flags&net.FlagUp == 0 {
+			return fmt.Errorf("interface %s is down", p.Target)
+		}
+		if addrs, err := iface.Addrs(); err != nil || len(addrs) == 0 {
+			return fmt.Errorf("interface %s has no address", p.Target)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown precondition type %q", p.Type)
+}
+
+// waitForPreconditions blocks until every precondition of the schedule
+// holds, rechecking every PreconditionRetrySeconds for up to
+// PreconditionWaitMinutes, so a VPN renegotiating at night delays the backup
+// instead of failing it. It reports whether the backup may run.
+func (m *Monitor) waitForPreconditions(schedule string) bool {
+	var checks []Precondition
+	for _, p := range m.config.BackupPreconditions {
+		if p.Schedule == "" || p.Schedule == schedule {
+			checks = append(checks, p)
+		}
+	}
+	if len(checks) == 0 {
+		return true
+	}
+
+	wait := time.Duration(m.config.PreconditionWaitMinutes) * time.Minute
+	if wait <= 0 {
+		wait = defaultPreconditionWait
+	}
+	retry := time.Duration(m.config.PreconditionRetrySeconds) * time.Second
+	if retry <= 0 {
+		retry = defaultPreconditionRetry
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		var failed []string
+		for _, p := range checks {
+			if err := checkPrecondition(p); err != nil {
+				failed = append(failed, fmt.Sprintf("%s %s: %v", p.Type, p.Target, err))
+			}
+		}
+		if len(failed) == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			msg := strings.Join(failed, "; ")
+			log.Printf("Scheduled %s backup skipped, preconditions not met after %v: %s", schedule, wait, msg)
+			m.lastBackupStatus = "Skipped (precondition)"
+			m.updateBackupStatus()
+			m.notify(Notification{
+				Event:    eventPreconditionFailed,
+				Severity: severityCritical,
+				Title:    "Scheduled backup skipped",
+				Message:  fmt.Sprintf("Preconditions of the %s backup still not met after %v: %s", schedule, wait, msg),
+				Database: m.config.DBName,
+				Details:  map[string]string{"schedule": schedule},
+			})
+			return false
+		}
+		log.Printf("Waiting for backup preconditions (%s), retrying in %v", strings.Join(failed, "; "), retry)
+		tray.SetTooltip("Backup waiting: " + failed[0])
+		time.Sleep(retry)
+	}
+}
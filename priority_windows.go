@@ -0,0 +1,23 @@
+package main
+
+import "syscall"
+
+const (
+	processSetInformation = 0x0200
+	idlePriorityClass     = 0x0040
+)
+
+func lowerPriority(pid int) error {
+	handle, err := syscall.OpenProcess(processSetInformation, false, uint32(pid))
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(handle)
+
+	proc := syscall.NewLazyDLL("kernel32.dll").NewProc("SetPriorityClass")
+	ret, _, err := proc.Call(uintptr(handle), idlePriorityClass)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
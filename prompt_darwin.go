@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func promptPasscode(title string) (string, error) {
+	script := fmt.Sprintf(`text returned of (display dialog %q default answer "" with hidden answer with title %q)`, "Passcode:", title)
+	out, err := exec.Command("osascript", "-e", script).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// requestElevation asks for administrator credentials and fails when they
+// are not given.
+func requestElevation() error {
+	return exec.Command("osascript", "-e", `do shell script "true" with administrator privileges`).Run()
+}
+
+func openBrowser(url string) error {
+	return exec.Command("open", url).Start()
+}
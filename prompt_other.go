@@ -0,0 +1,26 @@
+//go:build !windows && !darwin
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+func promptPasscode(title string) (string, error) {
+	out, err := exec.Command("zenity", "--password", "--title", title).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// requestElevation asks polkit for administrator credentials and fails when
+// they are not given.
+func requestElevation() error {
+	return exec.Command("pkexec", "true").Run()
+}
+
+func openBrowser(url string) error {
+	return exec.Command("xdg-open", url).Start()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const passcodeDialog = `
+Add-Type -AssemblyName System.Windows.Forms
+$f = New-Object Windows.Forms.Form -Property @{Text=$env:PROMPT_TITLE; Width=320; Height=140; TopMost=$true; StartPosition='CenterScreen'; FormBorderStyle='FixedDialog'; MaximizeBox=$false; MinimizeBox=$false}
+$t = New-Object Windows.Forms.TextBox -Property @{Left=12; Top=14; Width=280; UseSystemPasswordChar=$true}
+$b = New-Object Windows.Forms.Button -Property @{Text='OK'; Left=216; Top=50; DialogResult='OK'}
+$f.Controls.AddRange(@($t, $b)); $f.AcceptButton = $b
+if ($f.ShowDialog() -eq 'OK') { [Console]::Out.Write($t.Text) } else { exit 1 }`
+
+const elevationCheck = `try { Start-Process cmd -ArgumentList '/c','exit' -Verb RunAs -Wait -WindowStyle Hidden; exit 0 } catch { exit 1 }`
+
+func promptPasscode(title string) (string, error) {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", passcodeDialog)
+	cmd.Env = append(os.Environ(), "PROMPT_TITLE="+title)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// requestElevation shows the UAC prompt; it fails when the user cancels or
+// cannot supply administrator credentials.
+func requestElevation() error {
+	return exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", elevationCheck).Run()
+}
+
+func openBrowser(url string) error {
+	return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+)
+
+const (
+	protectPasscode = "passcode"
+	protectAdmin    = "admin"
+)
+
+// authorize guards quitting and pausing auto backups on machines where users
+// tend to close tray icons. Every attempt ends up in the audit log.
+func (m *Monitor) authorize(action string) bool {
+	var err error
+	switch m.config.QuitProtection {
+	case "":
+		return true
+	case protectPasscode:
+		var code string
+		code, err = promptPasscode("PG Monitor: " + action)
+		if err == nil && (m.config.QuitPasscode == "" ||
+			subtle.ConstantTimeCompare([]byte(code), []byte(m.config.QuitPasscode)) != 1) {
+			err = fmt.Errorf("wrong passcode")
+		}
+	case protectAdmin:
+		err = requestElevation()
+	default:
+		err = fmt.Errorf("unknown QuitProtection %q", m.config.QuitProtection)
+	}
+
+	if err != nil {
+		log.Printf("%s denied: %v", action, err)
+		m.audit(localActor(), "denied", action, err.Error())
+		tray.SetTooltip(fmt.Sprintf("%s requires authorization", action))
+		return false
+	}
+	m.audit(localActor(), "authorized", action, m.config.QuitProtection)
+	return true
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	digestFile          = "notification-digest.json"
+	digestCheckInterval = time.Minute
+
+	eventNotificationDigest = "notification_digest"
+)
+
+// Notifications held back during QuietHours; kept in a file so a restart
+// during the night doesn't lose them.
+var digestMu sync.Mutex
+
+// quietHours parses QuietHours ("22:00-07:00") into minutes after midnight.
+// A window may wrap past midnight.
+func (m *Monitor) quietHours() (from, to int, ok bool) {
+	if m.config.QuietHours == "" {
+		return 0, 0, false
+	}
+	start, end, found := strings.Cut(m.config.QuietHours, "-")
+	a, err1 := time.Parse("15:04", strings.TrimSpace(start))
+	b, err2 := time.Parse("15:04", strings.TrimSpace(end))
+	if !found || err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	return a.Hour()*60 + a.Minute(), b.Hour()*60 + b.Minute(), true
+}
+
+func (m *Monitor) inQuietHours(t time.Time) bool {
+	from, to, ok := m.quietHours()
+	if !ok || from == to {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	if from < to {
+		return now >= from && now < to
+	}
+	return now >= from || now < to
+}
+
+func (m *Monitor) checkQuietHours() {
+	if _, _, ok := m.quietHours(); m.config.QuietHours != "" && !ok {
+		m.configError("invalid QuietHours %q, expected \"HH:MM-HH:MM\"", m.config.QuietHours)
+	}
+}
+
+// holdForDigest queues a non-critical notification raised during quiet
+// hours. Critical ones are never held.
+func (m *Monitor) holdForDigest(n Notification) bool {
+	if n.Severity == severityCritical || !m.inQuietHours(n.Time) {
+		return false
+	}
+	digestMu.Lock()
+	defer digestMu.Unlock()
+	held := loadDigest()
+	held = append(held, n)
+	saveDigest(held)
+	return true
+}
+
+func loadDigest() []Notification {
+	var held []Notification
+	if data, err := os.ReadFile(digestFile); err == nil {
+		if err := json.Unmarshal(data, &held); err != nil {
+			log.Printf("Ignoring unreadable %s: %v", digestFile, err)
+		}
+	}
+	return held
+}
+
+func saveDigest(held []Notification) {
+	if len(held) == 0 {
+		os.Remove(digestFile)
+		return
+	}
+	data, _ := json.MarshalIndent(held, "", "  ")
+	if err := os.WriteFile(digestFile, data, 0644); err != nil {
+		log.Printf("Failed to write %s: %v", digestFile, err)
+	}
+}
+
+// digestLoop sends the held notifications as one digest per channel once
+// the quiet hours are over.
+func (m *Monitor) digestLoop() {
+	for {
+		if !m.inQuietHours(time.Now()) {
+			m.sendDigest()
+		}
+		time.Sleep(digestCheckInterval)
+	}
+}
+
+func (m *Monitor) sendDigest() {
+	digestMu.Lock()
+	held := loadDigest()
+	saveDigest(nil)
+	digestMu.Unlock()
+	if len(held) == 0 {
+		return
+	}
+	log.Printf("Quiet hours over: sending digest of %d notification(s)", len(held))
+
+	for _, ch := range m.config.Notifications {
+		var lines []string
+		severity := severityInfo
+		for _, n := range held {
+			if !channelWants(ch, n.Event) {
+				continue
+			}
+			line := fmt.Sprintf("%s %s", n.Time.Format("15:04"), n.Title)
+			if n.Database != "" {
+				line += " (" + n.Database + ")"
+			}
+			if n.Message != "" {
+				line += ": " + n.Message
+			}
+			lines = append(lines, line)
+			if n.Severity == severityWarning {
+				severity = severityWarning
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		msg := Notification{
+			Event:    eventNotificationDigest,
+			Severity: severity,
+			Title:    fmt.Sprintf("%d notification(s) during quiet hours", len(lines)),
+			Message:  strings.Join(lines, "\n"),
+			Host:     m.config.Host,
+			Time:     time.Now(),
+			Tags:     mergeTags(m.config.Tags, ch.Tags),
+			Details:  map[string]string{"count": fmt.Sprint(len(lines))},
+		}
+		go func(ch NotificationChannel, msg Notification) {
+			if err := sendNotification(ch, msg); err != nil {
+				log.Printf("Notification via %s failed: %v", ch.Type, err)
+			}
+		}(ch, msg)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	quotaPrune = "prune"
+	quotaStop  = "stop"
+
+	eventQuotaExceeded  = "quota_exceeded"
+	remoteDeleteTimeout = 60 * time.Second
+)
+
+var (
+	quotaMu      sync.Mutex
+	quotaAlerted = make(map[string]bool)
+)
+
+// enforceQuota makes room on d for a backup of size bytes. With QuotaPolicy
+// "prune" the oldest backups on the destination that are not on legal hold
+// are deleted there; otherwise the upload is refused and an alert raised
+// once until the destination is back under its quota.
+func (m *Monitor) enforceQuota(d Destination, backup string, size int64) error {
+	if d.MaxGB <= 0 && d.MaxFiles <= 0 {
+		return nil
+	}
+
+	held, err := destinationBackups(d.Name)
+	if err != nil {
+		return fmt.Errorf("quota check: %v", err)
+	}
+	var used int64
+	for _, e := range held {
+		used += e.Size
+	}
+	count := len(held)
+	maxBytes := int64(d.MaxGB * float64(gb))
+	over := func() bool {
+		return (maxBytes > 0 && used+size > maxBytes) || (d.MaxFiles > 0 && count+1 > d.MaxFiles)
+	}
+
+	if over() && d.QuotaPolicy == quotaPrune {
+		for _, e := range held {
+			if !over() {
+				break
+			}
+			if e.LegalHold || e.File == backup {
+				continue
+			}
+			if err := m.pruneRemote(d, e); err != nil {
+				log.Printf("Quota: failed to delete %s from %s: %v", e.File, d.Name, err)
+				continue
+			}
+			used -= e.Size
+			count--
+		}
+	}
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	if !over() {
+		quotaAlerted[d.Name] = false
+		return nil
+	}
+
+	var limits []string
+	if maxBytes > 0 {
+		limits = append(limits, formatBytes(maxBytes))
+	}
+	if d.MaxFiles > 0 {
+		limits = append(limits, fmt.Sprintf("%d backups", d.MaxFiles))
+	}
+	err = fmt.Errorf("quota of %s exceeded: %s in %d backup(s), limit %s",
+		d.Name, formatBytes(used), count, strings.Join(limits, ", "))
+	if !quotaAlerted[d.Name] {
+		quotaAlerted[d.Name] = true
+		m.notify(Notification{
+			Event:    eventQuotaExceeded,
+			Severity: severityCritical,
+			Title:    "Destination quota exceeded",
+			Message:  fmt.Sprintf("Uploads to %s are stopped: %v", d.Name, err),
+			Details:  map[string]string{"destination": d.Name, "backup": backup},
+		})
+	}
+	return err
+}
+
+// destinationBackups lists the catalog's backups held on a destination,
+// oldest first.
+func destinationBackups(dest string) ([]CatalogEntry, error) {
+	entries, err := loadCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	var held []CatalogEntry
+	for _, e := range entries {
+		for _, u := range e.Uploaded {
+			if u == dest {
+				held = append(held, e)
+				break
+			}
+		}
+	}
+	sort.Slice(held, func(i, j int) bool { return held[i].Finished.Before(held[j].Finished) })
+	return held, nil
+}
+
+// pruneRemote deletes a backup and its manifest from a destination and drops
+// the destination from the backup's catalog entry.
+func (m *Monitor) pruneRemote(d Destination, e CatalogEntry) error {
+	for _, name := range remoteFiles(e) {
+		if err := deleteRemote(d, name); err != nil {
+			return err
+		}
+	}
+
+	if err := dropUploaded(e.File, d.Name); err != nil {
+		return err
+	}
+	m.audit("quota:"+d.Name, "remote_pruned", e.File, formatBytes(e.Size))
+	return nil
+}
+
+// dropUploaded removes dest from the destinations holding file.
+func dropUploaded(file, dest string) error {
+	return updateCatalog(func(entries []CatalogEntry) ([]CatalogEntry, error) {
+		for i := range entries {
+			if entries[i].File != file {
+				continue
+			}
+			var kept []string
+			for _, u := range entries[i].Uploaded {
+				if u != dest {
+					kept = append(kept, u)
+				}
+			}
+			entries[i].Uploaded = kept
+		}
+		return entries, nil
+	})
+}
+
+// remoteFiles names the objects a backup was uploaded as.
+func remoteFiles(e CatalogEntry) []string {
+	if e.RemoteName != "" {
+		files := []string{e.RemoteName}
+		if e.RemoteManifest != "" {
+			files = append(files, e.RemoteManifest)
+		}
+		return files
+	}
+
+	name := e.File
+	if isDirectoryDump(name) {
+		name += tarballExt
+	}
+	manifest := manifestPath(e.File)
+	signature := manifest + signatureSuffix
+	if e.Encrypted {
+		name += encryptedExt
+		manifest += encryptedExt
+	}
+	return []string{name, manifest, signature}
+}
+
+// deleteRemote removes one object from a destination; objects that are
+// already gone count as deleted.
+func deleteRemote(d Destination, name string) error {
+	b, err := storageFor(d)
+	if err != nil {
+		return err
+	}
+	return b.Delete(d, name)
+}
+
+func uploadSize(files []string) int64 {
+	var size int64
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// startRemoteRestore restores a backup straight from a destination. The
+// download is piped through decryption and decompression into psql or
+// pg_restore, so the restore host never needs room for the whole artifact.
+func (m *Monitor) startRemoteRestore(dest, file, database string, drop bool) (*RestoreJob, error) {
+	if _, ok := m.destination(dest); !ok {
+		return nil, fmt.Errorf("unknown destination %q", dest)
+	}
+	file = strings.TrimSuffix(filepath.Base(file), encryptedExt)
+	if name := dumpName(file); isDirectoryDump(name) || !isPlainDump(name) && !isCustomDump(name) {
+		return nil, fmt.Errorf("%s cannot be streamed: only plain and custom format dumps can (download directory dumps first)", file)
+	}
+
+	allDatabases := strings.Contains(file, "_all_databases_")
+	if e, ok := catalogEntry(file); ok {
+		allDatabases = e.Kind == "cluster"
+	}
+	return m.beginRestore(&RestoreJob{File: file, Remote: dest, Database: database, Drop: drop}, allDatabases)
+}
+
+// catalogEntry returns the latest successful catalog entry for file.
+func catalogEntry(file string) (CatalogEntry, bool) {
+	entries, err := loadCatalog()
+	if err != nil {
+		return CatalogEntry{}, false
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].File == file && entries[i].Success {
+			return entries[i], true
+		}
+	}
+	return CatalogEntry{}, false
+}
+
+// restoreRemote streams job.File from job.Remote. Which layers to undo comes
+// from the catalog: opaque OpenPGP blobs on untrusted remotes or .enc
+// uploads, then PGPEncryptBackups' own .gpg layer, then the codec. Without a
+// catalog entry the object is looked up as <file>.enc first, then <file>.
+func (m *Monitor) restoreRemote(ctx context.Context, job *RestoreJob, allDatabases bool) (err error) {
+	d, _ := m.destination(job.Remote)
+	e, known := catalogEntry(job.File)
+
+	// Each stage is closed after the restore; gpg's exit status only shows
+	// up there, so a failed decryption can't pass for a short dump
+	var stages []io.Closer
+	defer func() {
+		for i := len(stages) - 1; i >= 0; i-- {
+			if closeErr := stages[i].Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	}()
+
+	var body io.ReadCloser
+	var size int64
+	opaque, encrypted := known && e.RemoteName != "", known && e.Encrypted
+	switch {
+	case opaque:
+		body, size, err = openRemote(ctx, d, e.RemoteName)
+	case known:
+		object := job.File
+		if encrypted {
+			object += encryptedExt
+		}
+		body, size, err = openRemote(ctx, d, object)
+	default:
+		encrypted = true
+		if body, size, err = openRemote(ctx, d, job.File+encryptedExt); os.IsNotExist(err) {
+			encrypted = false
+			body, size, err = openRemote(ctx, d, job.File)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	stages = append(stages, body)
+	log.Printf("Streaming %s from %s (%s)", job.File, d.Name, formatBytes(size))
+
+	// Progress counts downloaded bytes against the object's size
+	var read int64
+	var input io.Reader = &countingReader{r: body, n: &read}
+	defer m.watchProgress(job, &read, size)()
+
+	if encrypted {
+		key, err := m.encryptionKey()
+		if err != nil {
+			return err
+		}
+		decrypted := decryptingReader(input, key)
+		stages = append(stages, decrypted)
+		input = decrypted
+	}
+	for _, layer := range []bool{opaque, isPGPEncrypted(job.File)} {
+		if !layer {
+			continue
+		}
+		pgp, err := m.openPGPReader(input)
+		if err != nil {
+			return err
+		}
+		stages = append(stages, pgp)
+		input = pgp
+	}
+
+	name := dumpName(job.File)
+	if isPlainDump(name) {
+		plain, err := codecForFile(name).NewReader(input)
+		if err != nil {
+			return err
+		}
+		stages = append(stages, plain)
+		return m.psqlRestore(ctx, job, plain, allDatabases)
+	}
+	return m.pgRestoreStream(ctx, job, input)
+}
+
+// openRemote starts downloading one object; a missing object is reported as
+// os.ErrNotExist.
+func openRemote(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	b, err := storageFor(d)
+	if err != nil {
+		return nil, 0, err
+	}
+	return b.Open(ctx, d, name)
+}
+
+// openPGPReader decrypts r with gpg as it is read; the private key (or
+// PGPPassphraseFile) must be available here.
+func (m *Monitor) openPGPReader(r io.Reader) (io.ReadCloser, error) {
+	args := []string{"--batch", "--no-tty"}
+	if m.config.PGPPassphraseFile != "" {
+		args = append(args, "--pinentry-mode", "loopback", "--passphrase-file", m.config.PGPPassphraseFile)
+	}
+	cmd := exec.Command("gpg", append(args, "--decrypt")...)
+	cmd.Stdin = r
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("gpg: %v", err)
+	}
+	return &codecProcess{ReadCloser: stdout, cmd: cmd, probe: openUsageProbe(cmd.Process.Pid)}, nil
+}
+
+// pgRestoreStream feeds a custom format archive to pg_restore on stdin.
+// Parallel jobs need a seekable file, so this always runs single-threaded.
+func (m *Monitor) pgRestoreStream(ctx context.Context, job *RestoreJob, input io.Reader) error {
+	if err := m.ensureRestoreTarget(job); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, pgTool("pg_restore"),
+		"-h", m.config.Host,
+		"-p", fmt.Sprintf("%d", m.config.Port),
+		"-U", m.config.User,
+		"-d", job.Database,
+	)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", m.config.Password))
+	cmd.Stdin = input
+	return runLogged(cmd, "pg_restore")
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const remoteListTimeout = 60 * time.Second
+
+// remoteObject is one file in a destination folder.
+type remoteObject struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+// listRemote lists the files in a destination folder.
+func listRemote(d Destination) ([]remoteObject, error) {
+	b, err := storageFor(d)
+	if err != nil {
+		return nil, err
+	}
+	return b.List(d)
+}
+
+// remoteRetention returns the retention of d: its own RetentionDays and
+// RetentionCount, or RemoteRetentionDays/RemoteRetentionCount, plus the GFS
+// scheme shared with local storage.
+func (m *Monitor) remoteRetention(d Destination) retentionPolicy {
+	p := m.localRetention()
+	p.Days, p.Count = d.RetentionDays, d.RetentionCount
+	if p.Days == 0 {
+		p.Days = m.config.RemoteRetentionDays
+	}
+	if p.Count == 0 {
+		p.Count = m.config.RemoteRetentionCount
+	}
+	return p
+}
+
+// remoteBackups maps the listing of a destination to the backups it holds,
+// newest first. Objects are recognized by name, opaque blobs through the
+// catalog; manifests and signatures are not backups of their own.
+func remoteBackups(objects []remoteObject, entries []CatalogEntry) []restoreCandidate {
+	opaque := make(map[string]CatalogEntry)
+	scopes := make(map[string]string)
+	for _, e := range entries {
+		if e.RemoteName != "" {
+			opaque[e.RemoteName] = e
+		}
+		if e.Scope != "" {
+			scopes[e.File] = e.Scope
+		}
+	}
+
+	var backups []restoreCandidate
+	for _, o := range objects {
+		b := restoreCandidate{Size: o.Size, Modified: o.Modified}
+		if e, ok := opaque[o.Name]; ok {
+			b.File, b.Database, b.AllDatabases = e.File, e.Database, e.Kind == "cluster"
+		} else {
+			// Catalog name: directory dumps are uploaded as <file>.tar
+			file := strings.TrimSuffix(o.Name, encryptedExt)
+			if isDumpTarball(file) {
+				file = strings.TrimSuffix(file, tarballExt)
+			}
+			if inner := dumpName(file); !isPlainDump(inner) && !isArchiveDump(inner) {
+				continue
+			}
+			b.File = file
+			b.Database = databaseFromFileName(file)
+			b.AllDatabases = strings.Contains(file, "_all_databases_")
+		}
+		b.Scope = scopes[b.File]
+		backups = append(backups, b)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Modified.After(backups[j].Modified) })
+	return backups
+}
+
+// pruneRemoteBackups applies remote retention to the destinations a backup
+// was just uploaded to. Backups on legal hold are never deleted, and a
+// destination that cannot be listed is left alone.
+func (m *Monitor) pruneRemoteBackups(dests []string) {
+	for _, name := range dests {
+		d, ok := m.destination(name)
+		if !ok {
+			continue
+		}
+		policy := m.remoteRetention(d)
+		if !policy.active() && !m.retentionOverridden() {
+			continue
+		}
+
+		objects, err := listRemote(d)
+		if err != nil {
+			log.Printf("Remote retention on %s skipped: %v", d.Name, err)
+			continue
+		}
+		entries, err := loadCatalog()
+		if err != nil {
+			log.Printf("Remote retention on %s skipped: catalog unreadable, holds unknown: %v", d.Name, err)
+			continue
+		}
+		held := make(map[string]bool)
+		for _, e := range entries {
+			if e.LegalHold {
+				held[e.File] = true
+			}
+		}
+
+		for _, b := range m.pruneByDatabase(remoteBackups(objects, entries), policy, false, held, time.Now()) {
+			log.Printf("Remote retention: deleting %s from %s (%s)", b.File, d.Name, b.Modified.Format("2006-01-02 15:04"))
+			if err := m.deleteRemoteBackup(d, b, objects, entries); err != nil {
+				log.Printf("Remote retention: failed to delete %s from %s: %v", b.File, d.Name, err)
+			}
+		}
+	}
+}
+
+// deleteRemoteBackup deletes a backup's objects (dump, manifest, signature,
+// encrypted or opaque variants) from d and records it in the catalog.
+func (m *Monitor) deleteRemoteBackup(d Destination, b restoreCandidate, objects []remoteObject, entries []CatalogEntry) error {
+	names := make(map[string]bool)
+	for _, e := range entries {
+		if e.File == b.File && e.RemoteName != "" {
+			for _, f := range remoteFiles(e) {
+				names[f] = true
+			}
+		}
+	}
+	for _, o := range objects {
+		if strings.HasPrefix(o.Name, b.File) {
+			names[o.Name] = true
+		}
+	}
+
+	for _, o := range objects {
+		if !names[o.Name] {
+			continue
+		}
+		if err := deleteRemote(d, o.Name); err != nil {
+			return err
+		}
+	}
+
+	if err := dropUploaded(b.File, d.Name); err != nil {
+		return err
+	}
+	m.audit("retention:"+d.Name, "remote_pruned", b.File, formatBytes(b.Size))
+	return nil
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	restoreRunning   = "running"
+	restoreDone      = "done"
+	restoreFailed    = "failed"
+	restoreCancelled = "cancelled"
+)
+
+// RestoreJob tracks a running or finished restore. Progress is a percentage,
+// or -1 when it cannot be determined.
+type RestoreJob struct {
+	File     string
+	Remote   string `json:",omitempty"` // destination the backup is streamed from
+	Database string
+	Drop     bool `json:",omitempty"` // target dropped and recreated first
+	Status   string
+	Progress float64
+	Started  time.Time
+	Finished time.Time `json:",omitempty"`
+	Error    string    `json:",omitempty"`
+
+	cancel  context.CancelFunc
+	created bool // the restore created Database, so a cancel may drop it
+}
+
+func (j RestoreJob) Elapsed() time.Duration {
+	if j.Finished.IsZero() {
+		return time.Since(j.Started)
+	}
+	return j.Finished.Sub(j.Started)
+}
+
+// startRestore launches a restore in the background. Only one restore may run
+// at a time. With drop the target database is dropped and recreated first;
+// callers must have confirmed that with confirmDestructive.
+func (m *Monitor) startRestore(file, database string, drop bool) (*RestoreJob, error) {
+	if _, err := os.Stat(file); err != nil {
+		return nil, err
+	}
+	return m.beginRestore(&RestoreJob{File: file, Database: database, Drop: drop}, isClusterDump(file))
+}
+
+// beginRestore validates job and runs it in the background unless another
+// restore is still running.
+func (m *Monitor) beginRestore(job *RestoreJob, allDatabases bool) (*RestoreJob, error) {
+	if job.Database == "" && !allDatabases {
+		return nil, fmt.Errorf("target database is required")
+	}
+	if job.Drop && allDatabases {
+		return nil, fmt.Errorf("drop is not supported for cluster dumps")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.restore != nil && m.restore.Status == restoreRunning {
+		return nil, fmt.Errorf("a restore of %s is already running", m.restore.File)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job.Status = restoreRunning
+	job.Progress = -1
+	job.Started = time.Now()
+	job.cancel = cancel
+	m.restore = job
+
+	go m.runRestore(ctx, job, allDatabases)
+	if m.restoreItem != nil {
+		go m.restoreStatusLoop(job)
+	}
+	return job, nil
+}
+
+func (m *Monitor) cancelRestore() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.restore == nil || m.restore.Status != restoreRunning {
+		return fmt.Errorf("no restore is running")
+	}
+	log.Printf("Cancelling restore of %s", m.restore.File)
+	m.restore.cancel()
+	return nil
+}
+
+// restoreSnapshot returns a copy of the current restore job, if any.
+func (m *Monitor) restoreSnapshot() (RestoreJob, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.restore == nil {
+		return RestoreJob{}, false
+	}
+	return *m.restore, true
+}
+
+// restoreCLI runs a restore in the foreground for the -restore flag, printing
+// progress until it finishes. With from set the backup is streamed from that
+// destination. Ctrl+C cancels it.
+func (m *Monitor) restoreCLI(file, from, database string, drop bool) error {
+	if drop {
+		typed := promptConfirmation("drop and recreate database "+database, database)
+		if err := m.confirmDestructive(localActor(), "restore_drop", database, typed); err != nil {
+			return err
+		}
+	}
+	var err error
+	if from != "" {
+		_, err = m.startRemoteRestore(from, file, database, drop)
+	} else {
+		_, err = m.startRestore(file, database, drop)
+	}
+	if err != nil {
+		return err
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-interrupt:
+			m.cancelRestore()
+		case <-ticker.C:
+		}
+
+		snap, _ := m.restoreSnapshot()
+		if snap.Status != restoreRunning {
+			if snap.Status != restoreDone {
+				return fmt.Errorf("restore %s: %s", snap.Status, snap.Error)
+			}
+			return nil
+		}
+		if snap.Progress >= 0 {
+			fmt.Printf("Restoring %s: %.1f%% (%v)\n", file, snap.Progress, snap.Elapsed().Round(time.Second))
+		} else {
+			fmt.Printf("Restoring %s: running (%v)\n", file, snap.Elapsed().Round(time.Second))
+		}
+	}
+}
+
+func (m *Monitor) setRestoreProgress(job *RestoreJob, progress float64) {
+	m.mu.Lock()
+	job.Progress = progress
+	m.mu.Unlock()
+}
+
+func (m *Monitor) runRestore(ctx context.Context, job *RestoreJob, allDatabases bool) {
+	log.Printf("Starting restore of %s into %s", job.File, job.Database)
+
+	var err error
+	file := job.File
+	if job.Remote == "" {
+		if err = verifyChecksum(job.File); err != nil {
+			err = fmt.Errorf("refusing to restore: %v", err)
+		}
+	}
+	if err == nil && job.Remote == "" && isPGPEncrypted(file) {
+		if file, err = m.decryptForRestore(job.File); err == nil {
+			defer os.RemoveAll(file)
+		} else {
+			err = fmt.Errorf("decrypt %s: %v", filepath.Base(job.File), err)
+		}
+	}
+	if err == nil && job.Drop {
+		log.Printf("Dropping database %s before restore", job.Database)
+		if err = m.dropDatabase(job.Database); err != nil {
+			err = fmt.Errorf("drop %s: %v", job.Database, err)
+		}
+	}
+	if err == nil {
+		switch {
+		case job.Remote != "":
+			err = m.restoreRemote(ctx, job, allDatabases)
+		case isPlainDump(file):
+			err = m.restorePlain(ctx, job, file, allDatabases)
+		default:
+			err = m.restoreArchive(ctx, job, file)
+		}
+	}
+
+	status := restoreDone
+	switch {
+	case ctx.Err() != nil:
+		status = restoreCancelled
+		log.Printf("Restore of %s cancelled", job.File)
+		if m.config.RestoreDropOnCancel && !allDatabases && job.created {
+			if dropErr := m.dropDatabase(job.Database); dropErr != nil {
+				log.Printf("Failed to drop partially restored database %s: %v", job.Database, dropErr)
+			} else {
+				log.Printf("Dropped partially restored database %s", job.Database)
+			}
+		}
+	case err != nil:
+		status = restoreFailed
+		log.Printf("Restore of %s failed: %v", job.File, err)
+	default:
+		log.Printf("Restore of %s completed in %v", job.File, time.Since(job.Started).Round(time.Second))
+	}
+
+	m.mu.Lock()
+	job.Status = status
+	job.Finished = time.Now()
+	if err != nil && status == restoreFailed {
+		job.Error = err.Error()
+	}
+	if status == restoreDone {
+		job.Progress = 100
+	}
+	m.mu.Unlock()
+}
+
+// restorePlain feeds a plain SQL dump to psql through a counting reader so
+// progress is the fraction of the file consumed.
+func (m *Monitor) restorePlain(ctx context.Context, job *RestoreJob, file string, allDatabases bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Progress counts compressed bytes read, so it stays accurate for .gz/.zst
+	var read int64
+	input, err := codecForFile(file).NewReader(&countingReader{r: f, n: &read})
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	defer m.watchProgress(job, &read, info.Size())()
+
+	return m.psqlRestore(ctx, job, input, allDatabases)
+}
+
+// psqlRestore runs a plain SQL dump read from input through psql.
+func (m *Monitor) psqlRestore(ctx context.Context, job *RestoreJob, input io.Reader, allDatabases bool) error {
+	database := job.Database
+	if allDatabases {
+		database = "postgres"
+	} else if err := m.ensureRestoreTarget(job); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, pgTool("psql"),
+		"-h", m.config.Host,
+		"-p", fmt.Sprintf("%d", m.config.Port),
+		"-U", m.config.User,
+		"-d", database,
+		"-v", "ON_ERROR_STOP=1",
+		"-q",
+	)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", m.config.Password))
+	cmd.Stdin = input
+	return runLogged(cmd, "psql")
+}
+
+// watchProgress sets the job's progress to the share of total bytes read
+// every second until the returned function is called.
+func (m *Monitor) watchProgress(job *RestoreJob, read *int64, total int64) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if total > 0 {
+					m.setRestoreProgress(job, float64(atomic.LoadInt64(read))*100/float64(total))
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// restoreArchive runs pg_restore --verbose and estimates progress from the
+// number of processed TOC entries reported on stderr.
+func (m *Monitor) restoreArchive(ctx context.Context, job *RestoreJob, file string) error {
+	total := 0
+	if out, err := exec.CommandContext(ctx, pgTool("pg_restore"), "-l", file).Output(); err == nil {
+		for _, line := range strings.Split(string(out), "\n") {
+			if line != "" && !strings.HasPrefix(line, ";") {
+				total++
+			}
+		}
+	}
+
+	if err := m.ensureRestoreTarget(job); err != nil {
+		return err
+	}
+
+	args := []string{
+		"-h", m.config.Host,
+		"-p", fmt.Sprintf("%d", m.config.Port),
+		"-U", m.config.User,
+		"-d", job.Database,
+		"--verbose",
+	}
+	if isDirectoryDump(file) {
+		// As many jobs as the database the backup is of dumps with
+		args = append(args, "-j", strconv.Itoa(m.dumpJobs(backupDatabase(job.File))))
+	}
+	cmd := exec.CommandContext(ctx, pgTool("pg_restore"), append(args, file)...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", m.config.Password))
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	processed := 0
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, "processing item") || strings.Contains(line, "creating ") || strings.Contains(line, "processing data for table") {
+			processed++
+			if total > 0 {
+				m.setRestoreProgress(job, float64(min64(int64(processed), int64(total)))*100/float64(total))
+			}
+		}
+		if strings.Contains(line, "error:") {
+			log.Printf("pg_restore: %s", line)
+		}
+	}
+
+	return cmd.Wait()
+}
+
+// ensureRestoreTarget creates the job's database when it is missing and
+// remembers that it did.
+func (m *Monitor) ensureRestoreTarget(job *RestoreJob) error {
+	created, err := m.ensureDatabase(job.Database)
+	if created {
+		m.mu.Lock()
+		job.created = true
+		m.mu.Unlock()
+	}
+	return err
+}
+
+// ensureDatabase creates name unless it exists; created reports whether it
+// did.
+func (m *Monitor) ensureDatabase(name string) (created bool, err error) {
+	db, err := m.openDB("postgres")
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), connTimeout)
+	defer cancel()
+
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", name).Scan(&exists); err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+
+	log.Printf("Creating database %s for restore", name)
+	if _, err := db.ExecContext(ctx, "CREATE DATABASE "+quoteIdent(name)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (m *Monitor) dropDatabase(name string) error {
+	db, err := m.openDB("postgres")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err = db.ExecContext(ctx, "DROP DATABASE IF EXISTS "+quoteIdent(name)+" WITH (FORCE)")
+	return err
+}
+
+// restoreStatusLoop mirrors the job state into the tray until it finishes.
+func (m *Monitor) restoreStatusLoop(job *RestoreJob) {
+	m.restoreItem.Show()
+	m.cancelRestoreItem.Show()
+	m.cancelRestoreItem.Enable()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		snap, _ := m.restoreSnapshot()
+		elapsed := snap.Elapsed().Round(time.Second)
+
+		if snap.Status == restoreRunning {
+			if snap.Progress >= 0 {
+				m.restoreItem.SetTitle(fmt.Sprintf("Restore: %.0f%% (%v)", snap.Progress, elapsed))
+			} else {
+				m.restoreItem.SetTitle(fmt.Sprintf("Restore: running (%v)", elapsed))
+			}
+			continue
+		}
+
+		m.restoreItem.SetTitle(fmt.Sprintf("Restore: %s (%v)", snap.Status, elapsed))
+		tray.SetTooltip(fmt.Sprintf("Restore of %s %s", snap.Database, snap.Status))
+		m.cancelRestoreItem.Hide()
+		return
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+func runLogged(cmd *exec.Cmd, name string) error {
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v, output: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// backupDatabase returns the database a backup was taken of, from its
+// manifest or else its file name.
+func backupDatabase(file string) string {
+	if manifest, err := readManifest(file); err == nil && manifest.Database != "" {
+		return manifest.Database
+	}
+	return databaseFromFileName(filepath.Base(file))
+}
+
+func isPlainDump(file string) bool {
+	return strings.HasSuffix(file, ".sql") || strings.HasSuffix(file, ".sql.gz") || strings.HasSuffix(file, ".sql.zst") || strings.HasSuffix(file, ".sql.lz4")
+}
+
+func isClusterDump(file string) bool {
+	if manifest, err := readManifest(file); err == nil {
+		return manifest.AllDatabases
+	}
+	return strings.Contains(file, "_all_databases_")
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type restoreCandidate struct {
+	File         string
+	Database     string // suggested target, from the manifest or file name
+	AllDatabases bool
+	Scope        string // schemas/tables of a partial dump
+	Size         int64
+	Modified     time.Time
+}
+
+type restoreView struct {
+	Backups []restoreCandidate
+	Job     *RestoreJob
+	Result  string
+}
+
+var restorePage = template.Must(template.New("restore").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+	"time":  func(t time.Time) string { return t.Format("2006-01-02 15:04") },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Restore Database</title>
+<style>body{font-family:sans-serif;margin:1em}table{border-collapse:collapse}td,th{padding:2px 8px;text-align:left}
+td.n{text-align:right}.err{color:#b00}</style></head>
+<body><h3>Restore Database</h3>
+{{if .Result}}<p class="err">{{.Result}}</p>{{end}}
+{{with .Job}}<p>Last restore: {{.File}} into {{.Database}} - <b>{{.Status}}</b>{{if ge .Progress 0.0}} ({{printf "%.0f" .Progress}}%){{end}}
+{{if .Error}}<br><span class="err">{{.Error}}</span>{{end}}</p>{{end}}
+<p>To drop and recreate an existing target first, tick "drop" and type the database name again.</p>
+<table><tr><th>Backup</th><th>Size</th><th>Created</th><th>Restore into</th><th>Drop first</th><th></th></tr>
+{{range $i, $b := .Backups}}<tr><td>{{.File}}{{if .Scope}}<br><small>{{.Scope}}</small>{{end}}</td><td class="n">{{bytes .Size}}</td><td>{{time .Modified}}</td>
+<td>{{if .AllDatabases}}all databases{{else}}<input name="database" form="f{{$i}}" value="{{.Database}}" required>{{end}}</td>
+<td>{{if not .AllDatabases}}<input type="checkbox" name="drop" form="f{{$i}}" value="1">
+<input name="confirm" form="f{{$i}}" placeholder="type database name" autocomplete="off">{{end}}</td>
+<td><form method="post" id="f{{$i}}"><input type="hidden" name="file" value="{{.File}}">
+<button type="submit" onclick="return confirm('Restore {{.File}}?')">Restore</button></form></td></tr>
+{{else}}<tr><td colspan="6">No backups found in the backups directory.</td></tr>
+{{end}}</table></body></html>`))
+
+// handleRestorePage lists the dumps in the backups directory and starts a
+// restore of the chosen one through startRestore, so progress and "Cancel
+// Restore" work the same as for API and CLI restores.
+func (m *Monitor) handleRestorePage(w http.ResponseWriter, r *http.Request) {
+	var view restoreView
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+		file := filepath.Join(".", "backups", filepath.Base(r.FormValue("file")))
+		database := strings.TrimSpace(r.FormValue("database"))
+		drop := r.FormValue("drop") != ""
+		var err error
+		if drop {
+			err = m.confirmDestructive(localActor(), "restore_drop", database, r.FormValue("confirm"))
+		}
+		if err == nil {
+			_, err = m.startRestore(file, database, drop)
+		}
+		if err != nil {
+			view.Result = fmt.Sprintf("Restore of %s not started: %v", filepath.Base(file), err)
+		} else {
+			m.audit(localActor(), "restore_started", filepath.Base(file), database)
+		}
+	}
+
+	backups, err := restoreCandidates()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	view.Backups = backups
+	if job, ok := m.restoreSnapshot(); ok {
+		view.Job = &job
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	restorePage.Execute(w, view)
+}
+
+// restoreCandidates returns the restorable dumps in the backups directory,
+// newest first.
+func restoreCandidates() ([]restoreCandidate, error) {
+	dir := filepath.Join(".", "backups")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var backups []restoreCandidate
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, restoringPrefix) {
+			continue
+		}
+		if e.IsDir() != isDirectoryDump(name) || !(isPlainDump(dumpName(name)) || isArchiveDump(dumpName(name))) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		size, err := backupSize(path)
+		if err != nil {
+			continue
+		}
+		c := restoreCandidate{File: name, Size: size, Modified: info.ModTime()}
+		if manifest, err := readManifest(path); err == nil {
+			c.Database = manifest.Database
+			c.AllDatabases = manifest.AllDatabases
+			c.Scope = manifest.Scope
+		} else {
+			c.Database = databaseFromFileName(name)
+			c.AllDatabases = isClusterDump(path)
+		}
+		backups = append(backups, c)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Modified.After(backups[j].Modified) })
+	return backups, nil
+}
+
+// databaseFromFileName extracts <db> from vindija-bl_<db>_backup_<ts>.<ext>.
+func databaseFromFileName(name string) string {
+	name = strings.TrimPrefix(name, "vindija-bl_")
+	if i := strings.LastIndex(name, "_backup_"); i > 0 {
+		return name[:i]
+	}
+	return ""
+}
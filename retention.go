@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// retentionPolicy decides which backups of a location are kept: an age
+// cutoff, a count per database and a grandfather-father-son scheme keeping
+// the newest backup of each of the last Daily days, Weekly ISO weeks and
+// Monthly months.
+type retentionPolicy struct {
+	Days  int
+	Count int
+
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+func (p retentionPolicy) gfs() bool {
+	return p.Daily > 0 || p.Weekly > 0 || p.Monthly > 0
+}
+
+func (p retentionPolicy) active() bool {
+	return p.Days > 0 || p.Count > 0 || p.gfs()
+}
+
+func (m *Monitor) localRetention() retentionPolicy {
+	return retentionPolicy{
+		Days:    m.config.RetentionDays,
+		Count:   m.config.RetentionCount,
+		Daily:   m.config.RetentionDaily,
+		Weekly:  m.config.RetentionWeekly,
+		Monthly: m.config.RetentionMonthly,
+	}
+}
+
+// pruneCandidates applies p per database to backups (newest first, as
+// restoreCandidates returns them). A backup survives when the age and count
+// limits keep it or when the GFS scheme selects it; with only GFS set,
+// everything it does not select goes. The newest backup of every database is
+// always kept, and held or still spooled files neither count nor get pruned.
+func pruneCandidates(backups []restoreCandidate, p retentionPolicy, skip map[string]bool, now time.Time) []restoreCandidate {
+	var keys []string
+	groups := make(map[string][]restoreCandidate)
+	for _, b := range backups {
+		if skip[b.File] {
+			continue
+		}
+		key := retentionGroup(b)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], b)
+	}
+
+	limits := p.Days > 0 || p.Count > 0
+	var prune []restoreCandidate
+	for _, key := range keys {
+		group := groups[key]
+		keep := gfsKeep(group, p)
+		for i, b := range group {
+			if i == 0 || keep[i] {
+				continue
+			}
+			expired := p.Days > 0 && now.Sub(b.Modified) > time.Duration(p.Days)*24*time.Hour
+			over := p.Count > 0 && i >= p.Count
+			if !limits && p.gfs() || limits && (expired || over) {
+				prune = append(prune, b)
+			}
+		}
+	}
+	return prune
+}
+
+// retentionGroup is what limits are counted per: a database (and scope of a
+// partial dump), all globals backups, or all pg_dumpall backups together.
+func retentionGroup(b restoreCandidate) string {
+	if isGlobalsDump(b.File) {
+		return "\x00" + globalsKind
+	}
+	if b.AllDatabases {
+		return "*"
+	}
+	return b.Database + "\x00" + b.Scope
+}
+
+// gfsKeep marks the newest backup of each of the most recent days, weeks and
+// months in group, as many of each as p asks for.
+func gfsKeep(group []restoreCandidate, p retentionPolicy) map[int]bool {
+	tiers := []struct {
+		keep   int
+		period func(t time.Time) string
+	}{
+		{p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+
+	keep := make(map[int]bool)
+	for _, tier := range tiers {
+		seen := make(map[string]bool)
+		for i, b := range group {
+			if len(seen) >= tier.keep {
+				break
+			}
+			if period := tier.period(b.Modified); !seen[period] {
+				seen[period] = true
+				keep[i] = true
+			}
+		}
+	}
+	return keep
+}
+
+// pruneLocalBackups deletes backups past the local retention policy after a
+// successful run, through deleteBackup so deletes are audited and marked in
+// the catalog.
+func (m *Monitor) pruneLocalBackups() {
+	policy := m.localRetention()
+	if !policy.active() && !m.retentionOverridden() {
+		return
+	}
+
+	backups, err := restoreCandidates()
+	if err != nil {
+		log.Printf("Retention skipped: %v", err)
+		return
+	}
+	skip, err := heldBackups()
+	if err != nil {
+		log.Printf("Retention skipped: catalog unreadable, holds unknown: %v", err)
+		return
+	}
+	spoolMu.Lock()
+	spooled, err := readSpool()
+	spoolMu.Unlock()
+	if err != nil {
+		log.Printf("Retention skipped: upload spool unreadable, pending uploads unknown: %v", err)
+		return
+	}
+	for _, s := range spooled {
+		for _, f := range s.Files {
+			skip[filepath.Base(f)] = true
+		}
+		skip[s.Backup] = true
+	}
+
+	for _, b := range m.pruneByDatabase(backups, policy, true, skip, time.Now()) {
+		log.Printf("Retention: deleting %s (%s, %s)", b.File, b.Modified.Format("2006-01-02 15:04"), formatBytes(b.Size))
+		if err := m.deleteBackup(b.File, "retention"); err != nil {
+			log.Printf("Retention: failed to delete %s: %v", b.File, err)
+		}
+	}
+}
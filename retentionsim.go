@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	simulationMonths  = 12
+	simulationHistory = 30 // recent backups per database the projection learns from
+)
+
+var retentionPolicyKeys = []string{"days", "count", "daily", "weekly", "monthly"}
+
+// RetentionReport is the outcome of applying a proposed policy to the
+// backups that exist now, and of running it for the next months.
+type RetentionReport struct {
+	Location    string // "local" or a destination name
+	Policy      retentionPolicy
+	Current     retentionPolicy // the policy configured for the location
+	Keep        []restoreCandidate
+	Delete      []restoreCandidate
+	KeepBytes   int64
+	DeleteBytes int64
+	Projection  []RetentionMonth
+}
+
+// RetentionMonth is what the location holds at the end of a future month.
+type RetentionMonth struct {
+	Month          string
+	Backups        int
+	Bytes          int64
+	CurrentBackups int // under the configured policy, for comparison
+	CurrentBytes   int64
+}
+
+// parseRetentionPolicy reads "days=30,count=10,daily=7,weekly=4,monthly=12";
+// unnamed limits are off.
+func parseRetentionPolicy(spec string) (retentionPolicy, error) {
+	values := make(map[string]string)
+	for _, field := range strings.Split(spec, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !ok || !containsString(retentionPolicyKeys, key) {
+			return retentionPolicy{}, fmt.Errorf("invalid %q, expected one of %s=<n>", field, strings.Join(retentionPolicyKeys, ", "))
+		}
+		values[key] = strings.TrimSpace(value)
+	}
+	return retentionPolicyFrom(values)
+}
+
+func retentionPolicyFrom(values map[string]string) (retentionPolicy, error) {
+	var p retentionPolicy
+	targets := map[string]*int{"days": &p.Days, "count": &p.Count, "daily": &p.Daily, "weekly": &p.Weekly, "monthly": &p.Monthly}
+	for key, value := range values {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("%s: %q is not a non-negative number", key, value)
+		}
+		*targets[key] = n
+	}
+	return p, nil
+}
+
+func (p retentionPolicy) String() string {
+	if !p.active() {
+		return "keep everything"
+	}
+	return fmt.Sprintf("days=%d,count=%d,daily=%d,weekly=%d,monthly=%d", p.Days, p.Count, p.Daily, p.Weekly, p.Monthly)
+}
+
+// simulateRetention applies policy to the local backups, or those on the
+// named destination, without deleting anything. The projection continues
+// every database's recent backup interval and size growth for the next
+// twelve months and prunes at the end of each month, once with policy and
+// once with the configured one. DatabaseOverrides apply on top of both, as
+// they do in retention itself.
+func (m *Monitor) simulateRetention(dest string, policy retentionPolicy, now time.Time) (RetentionReport, error) {
+	report := RetentionReport{Location: "local", Policy: policy, Current: m.localRetention()}
+
+	var backups []restoreCandidate
+	var err error
+	if dest == "" {
+		backups, err = restoreCandidates()
+	} else {
+		d, ok := m.destination(dest)
+		if !ok {
+			return report, fmt.Errorf("unknown destination %q", dest)
+		}
+		report.Location, report.Current = d.Name, m.remoteRetention(d)
+		backups, err = remoteBackupsOf(d)
+	}
+	if err != nil {
+		return report, err
+	}
+	skip, err := heldBackups()
+	if err != nil {
+		return report, fmt.Errorf("catalog unreadable, holds unknown: %v", err)
+	}
+
+	pruned := make(map[string]bool)
+	local := dest == ""
+	for _, b := range m.pruneByDatabase(backups, policy, local, skip, now) {
+		pruned[b.File] = true
+	}
+	for _, b := range backups {
+		if pruned[b.File] {
+			report.Delete = append(report.Delete, b)
+			report.DeleteBytes += b.Size
+		} else {
+			report.Keep = append(report.Keep, b)
+			report.KeepBytes += b.Size
+		}
+	}
+
+	future := projectBackups(backups, now, now.AddDate(0, simulationMonths, 0))
+	proposed, current := backups, backups
+	for i := 1; i <= simulationMonths; i++ {
+		end := now.AddDate(0, i, 0)
+		var added []restoreCandidate
+		for _, b := range future {
+			if b.Modified.After(end.AddDate(0, -1, 0)) && !b.Modified.After(end) {
+				added = append(added, b)
+			}
+		}
+		proposed = m.simulateMonth(proposed, added, policy, local, skip, end)
+		current = m.simulateMonth(current, added, report.Current, local, skip, end)
+
+		month := RetentionMonth{Month: end.Format("2006-01"), Backups: len(proposed), CurrentBackups: len(current)}
+		for _, b := range proposed {
+			month.Bytes += b.Size
+		}
+		for _, b := range current {
+			month.CurrentBytes += b.Size
+		}
+		report.Projection = append(report.Projection, month)
+	}
+	return report, nil
+}
+
+// remoteBackupsOf lists the backups a destination holds, like remote
+// retention sees them.
+func remoteBackupsOf(d Destination) ([]restoreCandidate, error) {
+	objects, err := listRemote(d)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := loadCatalog()
+	if err != nil {
+		return nil, err
+	}
+	return remoteBackups(objects, entries), nil
+}
+
+// simulateMonth adds a month's backups to held and returns what policy
+// leaves of them at end.
+func (m *Monitor) simulateMonth(held, added []restoreCandidate, policy retentionPolicy, local bool, skip map[string]bool, end time.Time) []restoreCandidate {
+	all := append(append([]restoreCandidate{}, held...), added...)
+	sort.Slice(all, func(i, j int) bool { return all[i].Modified.After(all[j].Modified) })
+
+	pruned := make(map[string]bool)
+	for _, b := range m.pruneByDatabase(all, policy, local, skip, end) {
+		pruned[b.File] = true
+	}
+	var kept []restoreCandidate
+	for _, b := range all {
+		if !pruned[b.File] {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+// projectBackups continues each database's backups from its newest one up to
+// until, at the average interval of its recent backups (daily when there is
+// only one) and with their average size growth; shrinking is not projected.
+func projectBackups(backups []restoreCandidate, now, until time.Time) []restoreCandidate {
+	groups := make(map[string][]restoreCandidate)
+	var keys []string
+	for _, b := range backups {
+		key := retentionGroup(b)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		if len(groups[key]) < simulationHistory {
+			groups[key] = append(groups[key], b)
+		}
+	}
+
+	var future []restoreCandidate
+	for _, key := range keys {
+		recent := groups[key]
+		newest, oldest := recent[0], recent[len(recent)-1]
+		interval, growth := 24*time.Hour, 0.0
+		if span := newest.Modified.Sub(oldest.Modified); len(recent) > 1 && span > 0 {
+			interval = span / time.Duration(len(recent)-1)
+			growth = float64(newest.Size-oldest.Size) / span.Hours()
+		}
+		if interval < time.Hour {
+			interval = time.Hour
+		}
+		if growth < 0 {
+			growth = 0
+		}
+
+		next := newest.Modified.Add(interval)
+		for next.Before(now) {
+			next = next.Add(interval)
+		}
+		for ; !next.After(until); next = next.Add(interval) {
+			b := newest
+			b.File = fmt.Sprintf("projected %q %s", key, next.Format(time.RFC3339))
+			b.Modified = next
+			b.Size = newest.Size + int64(growth*next.Sub(newest.Modified).Hours())
+			future = append(future, b)
+		}
+	}
+	return future
+}
+
+// printRetentionReport writes a report for -simulate-retention.
+func printRetentionReport(r RetentionReport) {
+	fmt.Printf("Retention simulation for %s backups\n", r.Location)
+	fmt.Printf("  Proposed: %s\n  Current:  %s\n\n", r.Policy, r.Current)
+	fmt.Printf("Keep   %4d backup(s)  %s\n", len(r.Keep), formatBytes(r.KeepBytes))
+	fmt.Printf("Delete %4d backup(s)  %s\n", len(r.Delete), formatBytes(r.DeleteBytes))
+	for _, b := range r.Delete {
+		fmt.Printf("  %-60s %s  %s\n", b.File, b.Modified.Format("2006-01-02 15:04"), formatBytes(b.Size))
+	}
+
+	fmt.Printf("\nProjected storage at the end of each month (current policy -> proposed):\n")
+	for _, p := range r.Projection {
+		fmt.Printf("  %s  %10s (%d) -> %10s (%d)\n", p.Month, formatBytes(p.CurrentBytes), p.CurrentBackups, formatBytes(p.Bytes), p.Backups)
+	}
+}
+
+// handleRetentionSimulation serves GET /api/retention/simulate?days=&count=&
+// daily=&weekly=&monthly=[&destination=]; without any limit the configured
+// policy is simulated.
+func (m *Monitor) handleRetentionSimulation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	query := r.URL.Query()
+	values := make(map[string]string)
+	for _, key := range retentionPolicyKeys {
+		if v := query.Get(key); v != "" {
+			values[key] = v
+		}
+	}
+	policy, err := retentionPolicyFrom(values)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	dest := query.Get("destination")
+	if len(values) == 0 {
+		policy = m.localRetention()
+		if d, ok := m.destination(dest); ok {
+			policy = m.remoteRetention(d)
+		}
+	}
+
+	report, err := m.simulateRetention(dest, policy, time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	metricRowCounts = "rowcounts" // row count drift of CriticalTables
+
+	defaultRowDropPercent = 30
+	minDriftRows          = 1000 // smaller tables swing too much to alert on
+
+	eventRowCountDrop = "row_count_drop"
+)
+
+// n_live_tup follows committed deletes within seconds, while reltuples only
+// moves on VACUUM/ANALYZE; it is the fallback for tables the statistics
+// collector hasn't seen yet, or has forgotten after a stats reset (which
+// would otherwise look like every row vanished).
+const rowCountQuery = `
+SELECT t, CASE WHEN c.oid IS NULL THEN -1
+               WHEN s.n_live_tup > 0 OR c.reltuples <= 0 THEN COALESCE(s.n_live_tup, 0)
+               ELSE c.reltuples::bigint END
+FROM unnest(string_to_array($1, ',')) AS t
+LEFT JOIN pg_class c ON c.oid = to_regclass(t)
+LEFT JOIN pg_stat_user_tables s ON s.relid = c.oid`
+
+var (
+	rowCountMu   sync.Mutex
+	lastRowCount = make(map[string]int64)
+)
+
+func (m *Monitor) rowCountCollector() metricCollector {
+	return metricCollector{
+		name: metricRowCounts,
+		collect: func(ctx context.Context, tx *sql.Tx) (interface{}, error) {
+			rows, err := tx.QueryContext(ctx, rowCountQuery, strings.Join(m.config.CriticalTables, ","))
+			if err != nil {
+				return nil, err
+			}
+			defer rows.Close()
+
+			counts := make(map[string]int64)
+			for rows.Next() {
+				var table string
+				var n int64
+				if err := rows.Scan(&table, &n); err != nil {
+					return nil, err
+				}
+				counts[table] = n
+			}
+			return counts, rows.Err()
+		},
+	}
+}
+
+// checkRowDrift compares each critical table with the previous check and
+// alerts when it lost more than RowDropPercent of its rows in between.
+func (m *Monitor) checkRowDrift(results map[string]metricResult) {
+	r, ok := results[metricRowCounts]
+	if !ok {
+		return
+	}
+	if r.err != nil {
+		m.rowCountItem.SetTitle("Row Counts: ? (query failed)")
+		return
+	}
+	counts := r.value.(map[string]int64)
+
+	dropPct := float64(m.config.RowDropPercent)
+	if dropPct <= 0 {
+		dropPct = defaultRowDropPercent
+	}
+
+	rowCountMu.Lock()
+	defer rowCountMu.Unlock()
+
+	var dropped, missing []string
+	var points []metricPoint
+	for _, table := range m.config.CriticalTables {
+		n, ok := counts[table]
+		if !ok || n < 0 {
+			missing = append(missing, table)
+			continue
+		}
+		points = append(points, metricPoint{
+			Measurement: "pg_table_rows",
+			Tags:        map[string]string{"database": m.config.DBName, "table": table},
+			Fields:      map[string]interface{}{"rows": n},
+			Time:        time.Now(),
+		})
+
+		prev, seen := lastRowCount[table]
+		lastRowCount[table] = n
+		if !seen || prev < minDriftRows || n >= prev {
+			continue
+		}
+		pct := float64(prev-n) * 100 / float64(prev)
+		if pct < dropPct {
+			continue
+		}
+
+		dropped = append(dropped, table)
+		log.Printf("Row count of %s dropped %.0f%% (%d -> %d)", table, pct, prev, n)
+		m.notify(Notification{
+			Event:    eventRowCountDrop,
+			Severity: severityCritical,
+			Title:    "Large row count drop",
+			Message:  fmt.Sprintf("%s dropped from about %d to %d rows (%.0f%%) since the last check", table, prev, n, pct),
+			Database: m.config.DBName,
+			Details:  map[string]string{"table": table, "before": fmt.Sprint(prev), "after": fmt.Sprint(n), "percent": fmt.Sprintf("%.0f", pct)},
+		})
+	}
+	m.exportPoints(points...)
+
+	switch {
+	case len(dropped) > 0:
+		m.rowCountItem.SetTitle(fmt.Sprintf("Row Counts: drop in %s", strings.Join(dropped, ", ")))
+	case len(missing) > 0:
+		m.rowCountItem.SetTitle(fmt.Sprintf("Row Counts: %d table(s) not found", len(missing)))
+		m.rowCountItem.SetTooltip(strings.Join(missing, ", "))
+	default:
+		m.rowCountItem.SetTitle(fmt.Sprintf("Row Counts: %d table(s) OK", len(m.config.CriticalTables)))
+	}
+}
+
+func (m *Monitor) addRowCountMenu() {
+	m.rowCountItem = tray.AddMenuItem("Row Counts: -", "Approximate row counts of the critical tables")
+	m.rowCountItem.Disable()
+}
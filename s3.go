@@ -0,0 +1,532 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Region  = "us-east-1"
+	defaultS3PartMB  = 16
+	s3RequestTimeout = 30 * time.Minute
+	s3Unsigned       = "UNSIGNED-PAYLOAD"
+	s3TimeFormat     = "20060102T150405Z"
+)
+
+// s3Endpoint returns the base URL and host of d's bucket: virtual-hosted on
+// AWS, path-style on a custom Endpoint, which MinIO, Wasabi and most other
+// S3-compatible services accept.
+func s3Endpoint(d Destination) (string, error) {
+	region := d.Region
+	if region == "" {
+		region = defaultS3Region
+	}
+	if d.Endpoint == "" {
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", d.Bucket, region), nil
+	}
+	u, err := url.Parse(d.Endpoint)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid S3 endpoint %q", d.Endpoint)
+	}
+	return strings.TrimSuffix(d.Endpoint, "/") + "/" + d.Bucket + "/", nil
+}
+
+// s3Request builds a request for key (relative to the bucket) signed with
+// AWS Signature Version 4. Payloads are not hashed (UNSIGNED-PAYLOAD), so
+// large bodies can be streamed; TLS protects them in transit.
+func s3Request(ctx context.Context, d Destination, method, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	if d.Bucket == "" {
+		return nil, fmt.Errorf("destination %s: S3 needs a Bucket", d.Name)
+	}
+	base, err := s3Endpoint(d)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	u.Path += key
+	u.RawQuery = awsQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	signS3(req, d, time.Now().UTC())
+	return req, nil
+}
+
+func signS3(req *http.Request, d Destination, now time.Time) {
+	region := d.Region
+	if region == "" {
+		region = defaultS3Region
+	}
+	stamp := now.Format(s3TimeFormat)
+	date := stamp[:8]
+	req.Header.Set("x-amz-date", stamp)
+	req.Header.Set("x-amz-content-sha256", s3Unsigned)
+
+	// NewRequest parses the URL again and drops a RawPath it would have
+	// produced itself, so the canonical URI is escaped here and sent as is
+	path := awsEscape(req.URL.Path, false)
+	if path == "" {
+		path = "/"
+	}
+	req.URL.RawPath = path
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + s3Unsigned + "\n" +
+			"x-amz-date:" + stamp + "\n",
+		strings.Join(signed, ";"),
+		s3Unsigned,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+d.SecretKey), date)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		d.AccessKey, scope, strings.Join(signed, ";"), signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEscape percent-encodes everything but unreserved characters, and '/'
+// unless encodeSlash is set, as SigV4 canonical requests require.
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func awsQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Do sends req and turns a non-2xx answer into an error carrying the S3
+// error code; a missing key is reported as os.ErrNotExist.
+func s3Do(req *http.Request) (*http.Response, error) {
+	client := &http.Client{Timeout: s3RequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &os.PathError{Op: req.Method, Path: req.URL.Path, Err: os.ErrNotExist}
+	}
+	var s3err struct {
+		Code    string
+		Message string
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&s3err)
+	return nil, fmt.Errorf("%s %s: %s %s %s", req.Method, path.Base(req.URL.Path), resp.Status, s3err.Code, s3err.Message)
+}
+
+func s3Call(ctx context.Context, d Destination, method, key string, query url.Values, body io.Reader, length int64) (*http.Response, error) {
+	req, err := s3Request(ctx, d, method, key, query, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = length
+	}
+	return s3Do(req)
+}
+
+func (m *Monitor) s3PartSize() int64 {
+	if size := m.uploadChunkSize(); size > 0 {
+		return size
+	}
+	return defaultS3PartMB * mb
+}
+
+// uploadS3 puts a file into the bucket, in one request up to the part size
+// and as a multipart upload above it. Multipart progress is kept in
+// upload-chunks.json like Nextcloud chunking, so a retried upload continues
+// with the next part.
+func (m *Monitor) uploadS3(d Destination, filePath, rate string) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	objectKey := keyPrefix(d) + info.Name()
+	partSize := m.s3PartSize()
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx := context.Background()
+	if info.Size() <= partSize {
+		body := m.chunkBody(f, rate)
+		defer body.Close()
+		resp, err := s3Call(ctx, d, http.MethodPut, objectKey, nil, body, info.Size())
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	key := d.Name + " s3://" + d.Bucket + "/" + objectKey
+	var t ChunkedTransfer
+	updateChunkState(key, func(s *ChunkedTransfer) bool {
+		if s.UploadID == "" || s.Size != info.Size() || !s.ModTime.Equal(info.ModTime()) || s.ChunkSize != partSize {
+			*s = ChunkedTransfer{Size: info.Size(), ModTime: info.ModTime(), ChunkSize: partSize, Started: time.Now()}
+		}
+		t = *s
+		return true
+	})
+	if t.UploadID == "" {
+		if t.UploadID, err = s3CreateMultipart(ctx, d, objectKey); err != nil {
+			return err
+		}
+		updateChunkState(key, func(s *ChunkedTransfer) bool {
+			s.UploadID = t.UploadID
+			return true
+		})
+	} else {
+		log.Printf("Resuming multipart upload of %s to %s at part %d", info.Name(), d.Name, len(t.ETags)+1)
+	}
+
+	parts := int((t.Size + partSize - 1) / partSize)
+	for n := len(t.ETags); n < parts; n++ {
+		offset := int64(n) * partSize
+		body := m.chunkBody(io.NewSectionReader(f, offset, min64(partSize, t.Size-offset)), rate)
+		etag, err := s3UploadPart(ctx, d, objectKey, t.UploadID, n+1, body, min64(partSize, t.Size-offset))
+		body.Close()
+		if err != nil {
+			if os.IsNotExist(err) {
+				// The upload was aborted or expired on the server; start over next time
+				updateChunkState(key, func(*ChunkedTransfer) bool { return false })
+			}
+			return fmt.Errorf("part %d of %d: %v", n+1, parts, err)
+		}
+		updateChunkState(key, func(s *ChunkedTransfer) bool {
+			s.ETags = append(s.ETags, etag)
+			return true
+		})
+		t.ETags = append(t.ETags, etag)
+	}
+
+	if err := s3CompleteMultipart(ctx, d, objectKey, t.UploadID, t.ETags); err != nil {
+		return err
+	}
+	updateChunkState(key, func(*ChunkedTransfer) bool { return false })
+	log.Printf("Uploaded %s to %s in %d parts", info.Name(), d.Name, parts)
+	return nil
+}
+
+func s3CreateMultipart(ctx context.Context, d Destination, key string) (string, error) {
+	resp, err := s3Call(ctx, d, http.MethodPost, key, url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("CreateMultipartUpload: no upload ID (%v)", err)
+	}
+	return result.UploadID, nil
+}
+
+func s3UploadPart(ctx context.Context, d Destination, key, uploadID string, n int, body io.Reader, length int64) (string, error) {
+	query := url.Values{"partNumber": {fmt.Sprintf("%d", n)}, "uploadId": {uploadID}}
+	resp, err := s3Call(ctx, d, http.MethodPut, key, query, body, length)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+func s3CompleteMultipart(ctx context.Context, d Destination, key, uploadID string, etags []string) error {
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for i, etag := range etags {
+		complete.Parts = append(complete.Parts, part{PartNumber: i + 1, ETag: etag})
+	}
+	body, _ := xml.Marshal(complete)
+
+	resp, err := s3Call(ctx, d, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return fmt.Errorf("CompleteMultipartUpload: %v", err)
+	}
+	defer resp.Body.Close()
+	// S3 can report a failed completion with 200 and an <Error> body
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if bytes.Contains(data, []byte("<Error>")) {
+		return fmt.Errorf("CompleteMultipartUpload: %s", strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+func s3AbortMultipart(d Destination, key, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteDeleteTimeout)
+	defer cancel()
+	if resp, err := s3Call(ctx, d, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, 0); err == nil {
+		resp.Body.Close()
+	} else {
+		log.Printf("Failed to abort multipart upload of %s: %v", key, err)
+	}
+}
+
+func openS3(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	resp, err := s3Call(ctx, d, http.MethodGet, keyPrefix(d)+name, nil, nil, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+func deleteS3(d Destination, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteDeleteTimeout)
+	defer cancel()
+	resp, err := s3Call(ctx, d, http.MethodDelete, keyPrefix(d)+name, nil, nil, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listS3 lists the objects directly below the prefix, like a depth-1
+// PROPFIND of a WebDAV folder.
+func listS3(d Destination) ([]remoteObject, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteListTimeout)
+	defer cancel()
+
+	prefix := keyPrefix(d)
+	var objects []remoteObject
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s3Call(ctx, d, http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("ListObjectsV2 %s: %v", d.Bucket, err)
+		}
+		for _, c := range result.Contents {
+			if name := strings.TrimPrefix(c.Key, prefix); name != "" {
+				objects = append(objects, remoteObject{Name: name, Size: c.Size, Modified: c.LastModified})
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// s3Writer uploads what is written to it as a multipart upload started at
+// the first full part; a stream that stays below one part is stored with a
+// single PUT on Finish.
+type s3Writer struct {
+	ctx      context.Context
+	d        Destination
+	key      string
+	partSize int64
+	buf      []byte
+	uploadID string
+	etags    []string
+}
+
+func (m *Monitor) newS3Writer(ctx context.Context, d Destination, name string) *s3Writer {
+	return &s3Writer{ctx: ctx, d: d, key: keyPrefix(d) + name, partSize: m.s3PartSize()}
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for int64(len(w.buf)) >= w.partSize {
+		if err := w.flushPart(w.buf[:w.partSize]); err != nil {
+			return 0, err
+		}
+		w.buf = append(w.buf[:0], w.buf[w.partSize:]...)
+	}
+	return len(p), nil
+}
+
+func (w *s3Writer) flushPart(part []byte) error {
+	if w.uploadID == "" {
+		id, err := s3CreateMultipart(w.ctx, w.d, w.key)
+		if err != nil {
+			return err
+		}
+		w.uploadID = id
+	}
+	etag, err := s3UploadPart(w.ctx, w.d, w.key, w.uploadID, len(w.etags)+1, bytes.NewReader(part), int64(len(part)))
+	if err != nil {
+		return err
+	}
+	w.etags = append(w.etags, etag)
+	return nil
+}
+
+func (w *s3Writer) Finish() error {
+	if w.uploadID == "" {
+		resp, err := s3Call(w.ctx, w.d, http.MethodPut, w.key, nil, bytes.NewReader(w.buf), int64(len(w.buf)))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	if len(w.buf) > 0 {
+		if err := w.flushPart(w.buf); err != nil {
+			return err
+		}
+	}
+	return s3CompleteMultipart(w.ctx, w.d, w.key, w.uploadID, w.etags)
+}
+
+func (w *s3Writer) Abort() {
+	if w.uploadID != "" {
+		s3AbortMultipart(w.d, w.key, w.uploadID)
+	}
+}
+
+// testS3Upload is the S3 counterpart of testUploadTo.
+func testS3Upload(d Destination) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+	defer cancel()
+
+	name := fmt.Sprintf("pg-monitor-test-%s.txt", time.Now().Format("20060102_150405"))
+	body := []byte("pg-monitor upload test\n")
+	start := time.Now()
+	resp, err := s3Call(ctx, d, http.MethodPut, keyPrefix(d)+name, nil, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("bucket %s does not exist", d.Bucket)
+		}
+		return "", fmt.Errorf("upload rejected: %v", err)
+	}
+	resp.Body.Close()
+	elapsed := time.Since(start)
+
+	if err := deleteS3(d, name); err != nil {
+		log.Printf("Test upload: could not delete %s from %s: %v", name, d.Bucket, err)
+	}
+	return fmt.Sprintf("%s: uploaded and deleted %s in %v", d.Name, name, elapsed.Round(time.Millisecond)), nil
+}
+
+// s3Backend stores "s3" destinations.
+type s3Backend struct{}
+
+func init() {
+	registerStorageBackend(destinationS3, s3Backend{})
+}
+
+func (s3Backend) Put(m *Monitor, d Destination, filePath, rate string) error {
+	return m.uploadS3(d, filePath, rate)
+}
+
+func (s3Backend) Stream(m *Monitor, d Destination, name string) (streamSink, error) {
+	return m.newS3Writer(context.Background(), d, name), nil
+}
+
+func (s3Backend) Open(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	return openS3(ctx, d, name)
+}
+
+func (s3Backend) Stat(d Destination, name string) (remoteObject, error) {
+	return statListed(listS3, d, name)
+}
+
+func (s3Backend) List(d Destination) ([]remoteObject, error) {
+	return listS3(d)
+}
+
+func (s3Backend) Delete(d Destination, name string) error {
+	return deleteS3(d, name)
+}
+
+func (s3Backend) Test(d Destination) (string, error) {
+	return testS3Upload(d)
+}
+
+func (s3Backend) Location(d Destination, name string) string {
+	return fmt.Sprintf("s3://%s/%s%s", d.Bucket, keyPrefix(d), name)
+}
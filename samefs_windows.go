@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// sameFilesystem compares the volume mount points of both paths, which also
+// covers volumes mounted into folders.
+func sameFilesystem(a, b string) (bool, error) {
+	va, err := volumePath(a)
+	if err != nil {
+		return false, err
+	}
+	vb, err := volumePath(b)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(va, vb), nil
+}
+
+func volumePath(path string) (string, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]uint16, syscall.MAX_PATH+1)
+	proc := syscall.NewLazyDLL("kernel32.dll").NewProc("GetVolumePathNameW")
+	ret, _, err := proc.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	if ret == 0 {
+		return "", err
+	}
+	return syscall.UTF16ToString(buf), nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	securityReportFile     = "security-report.txt"
+	defaultSecurityHours   = 24
+	maxSecurityFindingItem = 10
+	securityInitialDelay   = 3 * time.Minute
+)
+
+type securityFinding struct {
+	Severity string // severityCritical or severityWarning
+	Subject  string // "pg_hba line 92", "password_encryption", ...
+	Issue    string
+}
+
+func (m *Monitor) securityLoop() {
+	interval := time.Duration(m.config.SecurityReportHours) * time.Hour
+	if interval <= 0 {
+		interval = defaultSecurityHours * time.Hour
+	}
+
+	time.Sleep(securityInitialDelay)
+	for {
+		m.runSecurityReport()
+		time.Sleep(interval)
+	}
+}
+
+func (m *Monitor) runSecurityReport() {
+	findings, notes, err := m.collectSecurityFindings()
+	if err != nil {
+		log.Printf("Security report failed: %v", err)
+		m.securityItem.SetTitle("Security: unavailable")
+		return
+	}
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "PostgreSQL security posture of %s:%d (%s)\n\n", m.config.Host, m.config.Port, time.Now().Format("2006-01-02 15:04"))
+	for _, f := range findings {
+		fmt.Fprintf(&report, "- [%s] %s: %s\n", f.Severity, f.Subject, f.Issue)
+	}
+	if len(findings) == 0 {
+		report.WriteString("No findings.\n")
+	}
+	for _, n := range notes {
+		fmt.Fprintf(&report, "\nNote: %s\n", n)
+	}
+	if err := os.WriteFile(securityReportFile, []byte(report.String()), 0644); err != nil {
+		log.Printf("Failed to write security report: %v", err)
+	}
+
+	log.Printf("Security report: %d finding(s), written to %s", len(findings), securityReportFile)
+	m.securityItem.SetTitle(fmt.Sprintf("Security: %d finding(s)", len(findings)))
+	for i, item := range m.securityItems {
+		if i < len(findings) {
+			item.SetTitle(fmt.Sprintf("%s: %s", findings[i].Subject, findings[i].Issue))
+			item.Show()
+		} else {
+			item.Hide()
+		}
+	}
+}
+
+// collectSecurityFindings checks pg_hba authentication methods, the password
+// hashing setting, superusers and SSL. notes lists checks that couldn't be
+// made with the monitoring user's privileges.
+func (m *Monitor) collectSecurityFindings() ([]securityFinding, []string, error) {
+	db, err := m.openDB(m.config.DBName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.metricTimeout())
+	defer cancel()
+
+	var passwordEncryption, ssl string
+	if err := db.QueryRowContext(ctx, "SELECT current_setting('password_encryption'), current_setting('ssl')").Scan(&passwordEncryption, &ssl); err != nil {
+		return nil, nil, err
+	}
+
+	var findings []securityFinding
+	var notes []string
+	add := func(severity, subject, issue string) {
+		findings = append(findings, securityFinding{Severity: severity, Subject: subject, Issue: issue})
+	}
+	if passwordEncryption != "scram-sha-256" {
+		add(severityWarning, "password_encryption",
+			fmt.Sprintf("%s; new passwords should be hashed with scram-sha-256", passwordEncryption))
+	}
+	if ssl != "on" {
+		add(severityCritical, "ssl",
+			"off; passwords and data cross the network unencrypted")
+	}
+
+	superusers, err := querySuperusers(ctx, db)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(superusers) > m.securityMaxSuperusers() {
+		add(severityWarning, "superusers",
+			fmt.Sprintf("%d roles (%s)", len(superusers), strings.Join(superusers, ", ")))
+	}
+
+	rules, err := queryHBARules(ctx, db)
+	if err != nil {
+		notes = append(notes, fmt.Sprintf("pg_hba rules not checked: %v (needs superuser or pg_read_server_files)", err))
+	}
+	findings = append(findings, hbaFindings(rules, ssl == "on")...)
+	return findings, notes, nil
+}
+
+func (m *Monitor) securityMaxSuperusers() int {
+	if m.config.SecurityMaxSuperusers > 0 {
+		return m.config.SecurityMaxSuperusers
+	}
+	return 1
+}
+
+func querySuperusers(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT rolname FROM pg_roles WHERE rolsuper AND rolcanlogin ORDER BY rolname")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func queryHBARules(ctx context.Context, db *sql.DB) ([]HBARule, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT line_number, type, array_to_string(database, ','), array_to_string(user_name, ','),
+		       COALESCE(address, ''), COALESCE(netmask, ''), COALESCE(auth_method, ''), COALESCE(error, '')
+		FROM pg_hba_file_rules ORDER BY line_number`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rules []HBARule
+	for rows.Next() {
+		var r HBARule
+		if err := rows.Scan(&r.Line, &r.Type, &r.Database, &r.User, &r.Address, &r.Netmask, &r.Method, &r.Error); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// hbaFindings flags rules that skip authentication (trust) or use weak
+// password methods, and, with SSL on, network rules that still accept
+// unencrypted connections.
+func hbaFindings(rules []HBARule, sslOn bool) []securityFinding {
+	var findings []securityFinding
+	add := func(severity, subject, issue string) {
+		findings = append(findings, securityFinding{Severity: severity, Subject: subject, Issue: issue})
+	}
+	for _, r := range rules {
+		subject := fmt.Sprintf("pg_hba line %d", r.Line)
+		who := fmt.Sprintf("%s %s/%s", r.Type, r.Database, r.User)
+		if r.Address != "" {
+			who += " from " + r.Address
+		}
+		remote := r.Type != "local" && !isLoopbackRule(r)
+
+		switch r.Method {
+		case "trust":
+			severity := severityWarning
+			if remote {
+				severity = severityCritical
+			}
+			add(severity, subject, who+" uses trust (no password)")
+		case "password":
+			add(severityCritical, subject, who+" sends passwords in clear text")
+		case "md5":
+			add(severityWarning, subject, who+" uses md5; prefer scram-sha-256")
+		}
+		if sslOn && remote && (r.Type == "host" || r.Type == "hostnossl") && r.Method != "reject" {
+			add(severityWarning, subject, who+" allows connections without SSL (use hostssl)")
+		}
+	}
+	return findings
+}
+
+func isLoopbackRule(r HBARule) bool {
+	switch r.Address {
+	case "127.0.0.1", "::1", "localhost", "samehost":
+		return true
+	}
+	return strings.HasPrefix(r.Address, "127.")
+}
+
+func (m *Monitor) addSecurityMenu() {
+	m.securityItem = tray.AddMenuItem("Security: -", "Security posture findings (see security-report.txt)")
+	for i := 0; i < maxSecurityFindingItem; i++ {
+		item := m.securityItem.AddSubMenuItem("", "")
+		item.Disable()
+		item.Hide()
+		m.securityItems = append(m.securityItems, item)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+const (
+	selfTestDatabase = "pg_monitor_selftest"
+	selfTestRows     = 1000
+	selfTestPassword = "selftest"
+	selfTestTimeout  = 10 * time.Minute
+)
+
+// selfTestStep is one stage of the self-test; its error fails the run.
+type selfTestStep struct {
+	Name string
+	Run  func() error
+}
+
+// runSelfTest runs the backup engine end to end against a disposable
+// PostgreSQL cluster created with the initdb and pg_ctl on PATH: seed a
+// database, back it up with verification, check manifest and catalog,
+// restore it, and let retention prune the first backup after a second one.
+// Everything happens in a temporary directory, so the real catalog, backups
+// and config are never touched; it is kept for inspection when a step fails.
+// It runs inside the tray loop like a normal start, since the backup engine
+// reports to the tray, and returns the process exit code.
+func runSelfTest() int {
+	code := 1
+	tray.Run(func() {
+		go func() {
+			code = selfTest()
+			tray.Quit()
+		}()
+	}, func() {})
+	return code
+}
+
+func selfTest() int {
+	for _, tool := range []string{"initdb", "pg_ctl", "pg_dump", "psql"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			fmt.Printf("Self-test FAILED: %s not found on PATH\n", tool)
+			return 1
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "pg-monitor-selftest-")
+	if err != nil {
+		fmt.Printf("Self-test FAILED: %v\n", err)
+		return 1
+	}
+	home, _ := os.Getwd()
+	defer os.Chdir(home)
+
+	cluster := &selfTestCluster{dir: dir}
+	defer cluster.stop()
+
+	m := &Monitor{startTime: time.Now()}
+	m.lastBackupItem = tray.AddMenuItem("Self-test running...", "")
+	m.lastBackupItem.Disable()
+
+	var first CatalogEntry
+	steps := []selfTestStep{
+		{"start disposable cluster", cluster.start},
+		{"seed database", func() error {
+			m.config = cluster.config()
+			if err := os.Chdir(filepath.Join(dir, "work")); err != nil {
+				return err
+			}
+			return m.seedSelfTest()
+		}},
+		{"backup with verification", func() error {
+			first = m.backupOne(selfTestDatabase, false, backupOptions{Label: "selftest"})
+			switch {
+			case !first.Success:
+				return fmt.Errorf("backup failed: %s", first.Status)
+			case !first.Verified:
+				return fmt.Errorf("test restore failed: %s", first.VerifyError)
+			}
+			return nil
+		}},
+		{"manifest checksum", func() error {
+			return m.verifyBackup(filepath.Join(".", "backups", first.File))
+		}},
+		{"catalog entry", func() error {
+			entries, err := loadCatalog()
+			if err != nil {
+				return err
+			}
+			if len(entries) != 1 || entries[0].File != first.File || entries[0].SHA256 == "" || entries[0].Size == 0 {
+				return fmt.Errorf("expected one entry for %s with size and checksum, got %+v", first.File, entries)
+			}
+			return nil
+		}},
+		{"restore", func() error {
+			return m.selfTestRestore(filepath.Join(".", "backups", first.File), selfTestDatabase+"_restored")
+		}},
+		{"retention", func() error {
+			// Backup names have one-second resolution
+			time.Sleep(1100 * time.Millisecond)
+			second := m.backupOne(selfTestDatabase, false, backupOptions{Label: "selftest"})
+			if !second.Success {
+				return fmt.Errorf("second backup failed: %s", second.Status)
+			}
+			if _, err := os.Stat(filepath.Join(".", "backups", first.File)); !os.IsNotExist(err) {
+				return fmt.Errorf("%s was not pruned with RetentionCount 1", first.File)
+			}
+			entries, err := loadCatalog()
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if e.File == first.File && !e.Deleted {
+					return fmt.Errorf("catalog does not mark %s as deleted", first.File)
+				}
+			}
+			return nil
+		}},
+	}
+
+	for _, s := range steps {
+		started := time.Now()
+		if err := s.Run(); err != nil {
+			fmt.Printf("FAIL  %s: %v\n", s.Name, err)
+			fmt.Printf("Self-test FAILED; files kept in %s\n", dir)
+			log.Printf("Self-test failed at %q: %v", s.Name, err)
+			return 1
+		}
+		fmt.Printf("ok    %s (%v)\n", s.Name, time.Since(started).Round(time.Millisecond))
+	}
+
+	cluster.stop()
+	os.Chdir(home)
+	os.RemoveAll(dir)
+	fmt.Println("Self-test OK")
+	return 0
+}
+
+// selfTestCluster is a throwaway PostgreSQL cluster in dir/data listening
+// on a free loopback port.
+type selfTestCluster struct {
+	dir     string
+	port    int
+	running bool
+}
+
+func (c *selfTestCluster) start() error {
+	pwfile := filepath.Join(c.dir, "pwfile")
+	if err := os.WriteFile(pwfile, []byte(selfTestPassword+"\n"), 0600); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(c.dir, "work"), 0700); err != nil {
+		return err
+	}
+	data := filepath.Join(c.dir, "data")
+	initdb := exec.Command("initdb", "-D", data, "-U", "postgres", "--pwfile", pwfile, "--auth", "md5", "-E", "UTF8")
+	if output, err := initdb.CombinedOutput(); err != nil {
+		return fmt.Errorf("initdb failed: %v, output: %s", err, string(output))
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	c.port = l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	options := fmt.Sprintf("-p %d -c listen_addresses=127.0.0.1", c.port)
+	if runtime.GOOS != "windows" {
+		options += " -c unix_socket_directories=" + c.dir
+	}
+	start := exec.Command("pg_ctl", "-D", data, "-o", options, "-l", filepath.Join(c.dir, "postgres.log"), "-w", "start")
+	if output, err := start.CombinedOutput(); err != nil {
+		return fmt.Errorf("pg_ctl start failed: %v, output: %s", err, string(output))
+	}
+	c.running = true
+	return nil
+}
+
+func (c *selfTestCluster) stop() {
+	if !c.running {
+		return
+	}
+	c.running = false
+	stop := exec.Command("pg_ctl", "-D", filepath.Join(c.dir, "data"), "-m", "immediate", "-w", "stop")
+	if output, err := stop.CombinedOutput(); err != nil {
+		log.Printf("Self-test: pg_ctl stop failed: %v, output: %s", err, string(output))
+	}
+}
+
+// config points the engine at the cluster with verification, checksums and
+// count-based retention on; everything else keeps its zero value.
+func (c *selfTestCluster) config() Config {
+	return Config{
+		Host:               "127.0.0.1",
+		Port:               c.port,
+		User:               "postgres",
+		Password:           selfTestPassword,
+		DBName:             selfTestDatabase,
+		CompressBackups:    true,
+		ManifestChecksum:   true,
+		VerifyBackups:      true,
+		RetentionCount:     1,
+		BackupVolumePolicy: volumeOff,
+	}
+}
+
+func (m *Monitor) seedSelfTest() error {
+	if _, err := m.ensureDatabase(selfTestDatabase); err != nil {
+		return err
+	}
+	db, err := m.openDB(selfTestDatabase)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+	_, err = db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE items (id serial PRIMARY KEY, name text NOT NULL, created timestamptz NOT NULL DEFAULT now());
+		INSERT INTO items (name) SELECT 'item ' || i FROM generate_series(1, %d) AS i;`, selfTestRows))
+	return err
+}
+
+// selfTestRestore restores file into database and compares the row count
+// with the seeded one.
+func (m *Monitor) selfTestRestore(file, database string) error {
+	if _, err := m.startRestore(file, database, false); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(selfTestTimeout)
+	for {
+		snap, _ := m.restoreSnapshot()
+		if snap.Status != restoreRunning {
+			if snap.Status != restoreDone {
+				return fmt.Errorf("restore %s: %s", snap.Status, snap.Error)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			m.cancelRestore()
+			return fmt.Errorf("restore still running after %v", selfTestTimeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	db, err := m.openDB(database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM items").Scan(&n); err != nil {
+		return err
+	}
+	if n != selfTestRows {
+		return fmt.Errorf("restored %d rows, expected %d", n, selfTestRows)
+	}
+	return nil
+}
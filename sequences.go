@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	defaultSequenceCheckMinutes = 60
+	defaultSequenceWarnPct      = 75
+	defaultSequenceCriticalPct  = 90
+	sequenceQueryTimeout        = 60 * time.Second
+
+	eventSequenceOverflow = "sequence_overflow"
+)
+
+// Sequences owned by a column are capped by the column type, which catches
+// the common case of a bigint sequence feeding an int4 primary key.
+const sequenceUsageQuery = `
+SELECT s.schemaname || '.' || s.sequencename,
+       COALESCE(a.attrelid::regclass::text || '.' || a.attname, ''),
+       s.last_value,
+       LEAST(s.max_value, CASE a.atttypid
+           WHEN 'int2'::regtype THEN 32767
+           WHEN 'int4'::regtype THEN 2147483647
+           ELSE 9223372036854775807 END)
+FROM pg_sequences s
+JOIN pg_namespace n ON n.nspname = s.schemaname
+JOIN pg_class c ON c.relnamespace = n.oid AND c.relname = s.sequencename
+LEFT JOIN pg_depend d ON d.classid = 'pg_class'::regclass AND d.objid = c.oid AND d.deptype IN ('a', 'i')
+LEFT JOIN pg_attribute a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
+WHERE s.last_value IS NOT NULL AND s.increment_by > 0`
+
+// Single-column int2/int4 primary keys not fed by a sequence; their current
+// maximum is read with an index-only max() per table.
+const intPrimaryKeyQuery = `
+SELECT format('%I.%I', n.nspname, t.relname), a.attname,
+       CASE a.atttypid WHEN 'int2'::regtype THEN 32767 ELSE 2147483647 END
+FROM pg_index i
+JOIN pg_class t ON t.oid = i.indrelid
+JOIN pg_namespace n ON n.oid = t.relnamespace
+JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = i.indkey[0]
+WHERE i.indisprimary AND i.indnatts = 1
+  AND a.atttypid IN ('int2'::regtype, 'int4'::regtype)
+  AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+  AND NOT EXISTS (SELECT 1 FROM pg_depend d
+                  WHERE d.refobjid = t.oid AND d.refobjsubid = a.attnum
+                    AND d.classid = 'pg_class'::regclass AND d.deptype IN ('a', 'i'))`
+
+type sequenceUsage struct {
+	Name    string
+	Column  string
+	Value   int64
+	Max     int64
+	Percent float64
+}
+
+func (m *Monitor) sequenceLoop() {
+	interval := time.Duration(m.config.SequenceCheckMinutes) * time.Minute
+	if interval <= 0 {
+		interval = defaultSequenceCheckMinutes * time.Minute
+	}
+
+	alerted := make(map[string]string)
+	for {
+		m.checkSequences(alerted)
+		time.Sleep(interval)
+	}
+}
+
+// checkSequences alerts once per sequence and severity level; alerted keeps
+// the last level sent so a sequence stuck at 80% doesn't alert every hour.
+func (m *Monitor) checkSequences(alerted map[string]string) {
+	usages, err := m.collectSequenceUsage()
+	if err != nil {
+		log.Printf("Sequence check failed: %v", err)
+		m.sequenceItem.SetTitle("Sequences: check failed")
+		return
+	}
+
+	warnPct, critPct := m.sequenceThresholds()
+	above := 0
+	for _, u := range usages {
+		level := ""
+		switch {
+		case u.Percent >= critPct:
+			level = severityCritical
+		case u.Percent >= warnPct:
+			level = severityWarning
+		}
+		if level != "" {
+			above++
+		}
+		if level == "" || alerted[u.Name] == level {
+			alerted[u.Name] = level
+			continue
+		}
+		alerted[u.Name] = level
+
+		target := u.Name
+		if u.Column != "" {
+			target = fmt.Sprintf("%s (%s)", u.Name, u.Column)
+		}
+		log.Printf("Sequence %s at %.1f%% of its maximum (%d of %d)", target, u.Percent, u.Value, u.Max)
+		m.notify(Notification{
+			Event:    eventSequenceOverflow,
+			Severity: level,
+			Title:    "Sequence approaching overflow",
+			Message:  fmt.Sprintf("%s is at %.1f%% of its maximum value (%d of %d)", target, u.Percent, u.Value, u.Max),
+			Database: m.config.DBName,
+			Details:  map[string]string{"sequence": u.Name, "column": u.Column, "percent": fmt.Sprintf("%.1f", u.Percent)},
+		})
+	}
+
+	if len(usages) == 0 {
+		m.sequenceItem.SetTitle("Sequences: -")
+		return
+	}
+	worst := usages[0]
+	m.sequenceItem.SetTitle(fmt.Sprintf("Sequences: max %.0f%% (%s)", worst.Percent, worst.Name))
+	m.sequenceItem.SetTooltip(fmt.Sprintf("%d sequence(s)/key(s) above %.0f%%", above, warnPct))
+}
+
+func (m *Monitor) sequenceThresholds() (float64, float64) {
+	warn := float64(m.config.SequenceWarnPercent)
+	if warn <= 0 {
+		warn = defaultSequenceWarnPct
+	}
+	crit := float64(m.config.SequenceCriticalPercent)
+	if crit <= 0 {
+		crit = defaultSequenceCriticalPct
+	}
+	return warn, crit
+}
+
+// collectSequenceUsage returns sequence and int primary key usage, highest
+// percentage first.
+func (m *Monitor) collectSequenceUsage() ([]sequenceUsage, error) {
+	db, err := m.openDB(m.config.DBName)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), sequenceQueryTimeout)
+	defer cancel()
+
+	var usages []sequenceUsage
+	rows, err := db.QueryContext(ctx, sequenceUsageQuery)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var u sequenceUsage
+		if err := rows.Scan(&u.Name, &u.Column, &u.Value, &u.Max); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		usages = append(usages, u)
+	}
+	rows.Close()
+
+	keys, err := intPrimaryKeyUsage(ctx, db)
+	if err != nil {
+		log.Printf("Integer primary key check failed: %v", err)
+	}
+	usages = append(usages, keys...)
+
+	for i := range usages {
+		if usages[i].Max > 0 {
+			usages[i].Percent = float64(usages[i].Value) * 100 / float64(usages[i].Max)
+		}
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Percent > usages[j].Percent })
+	return usages, nil
+}
+
+func intPrimaryKeyUsage(ctx context.Context, db *sql.DB) ([]sequenceUsage, error) {
+	rows, err := db.QueryContext(ctx, intPrimaryKeyQuery)
+	if err != nil {
+		return nil, err
+	}
+	var keys []sequenceUsage
+	for rows.Next() {
+		var u sequenceUsage
+		if err := rows.Scan(&u.Name, &u.Column, &u.Max); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		keys = append(keys, u)
+	}
+	rows.Close()
+
+	var usages []sequenceUsage
+	for _, u := range keys {
+		var value sql.NullInt64
+		query := fmt.Sprintf("SELECT max(%s) FROM %s", quoteIdent(u.Column), u.Name)
+		if err := db.QueryRowContext(ctx, query).Scan(&value); err != nil {
+			log.Printf("Skipping %s: %v", u.Name, err)
+			continue
+		}
+		u.Value = value.Int64
+		u.Column = u.Name + "." + u.Column
+		usages = append(usages, u)
+	}
+	return usages, nil
+}
+
+func (m *Monitor) addSequenceMenu() {
+	m.sequenceItem = tray.AddMenuItem("Sequences: -", "Sequence and integer key headroom")
+	m.sequenceItem.Disable()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const launchAgentLabel = "pg-monitor"
+
+const launchAgentPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array><string>%s</string></array>
+	<key>WorkingDirectory</key>
+	<string>%s</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>LimitLoadToSessionType</key>
+	<string>Aqua</string>
+</dict>
+</plist>
+`
+
+func launchAgentPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", launchAgentLabel+".plist"), nil
+}
+
+func plistString(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// installService starts exe in dir at every login with a per-user launch
+// agent, and returns the agent's path.
+func installService(exe, dir string) (string, error) {
+	path, err := launchAgentPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	plist := fmt.Sprintf(launchAgentPlist, launchAgentLabel, plistString(exe), plistString(dir))
+	return path, os.WriteFile(path, []byte(plist), 0644)
+}
+
+func uninstallService() error {
+	path, err := launchAgentPath()
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
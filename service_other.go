@@ -0,0 +1,83 @@
+//go:build !windows && !darwin
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const systemdUnit = "pg-monitor.service"
+
+const systemdUnitFile = `[Unit]
+Description=PG Monitor
+PartOf=%[3]s
+After=%[3]s
+
+[Service]
+ExecStart="%[1]s"
+WorkingDirectory=%[2]s
+Restart=on-failure
+
+[Install]
+WantedBy=%[3]s
+`
+
+func systemdUnitPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user", systemdUnit), nil
+}
+
+func systemctl(args ...string) error {
+	out, err := exec.Command("systemctl", append([]string{"--user"}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl --user %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// installService starts exe in dir at every login with a systemd user unit,
+// tied to the graphical session unless this is a headless build, and returns
+// the unit's path.
+func installService(exe, dir string) (string, error) {
+	path, err := systemdUnitPath()
+	if err != nil {
+		return "", err
+	}
+	target := "default.target"
+	if trayAvailable {
+		target = "graphical-session.target"
+	}
+	escape := func(s string) string { return strings.ReplaceAll(s, "%", "%%") }
+	unit := fmt.Sprintf(systemdUnitFile, escape(exe), escape(dir), target)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(unit), 0644); err != nil {
+		return "", err
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return "", err
+	}
+	return path, systemctl("enable", systemdUnit)
+}
+
+func uninstallService() error {
+	path, err := systemdUnitPath()
+	if err != nil {
+		return err
+	}
+	if err := systemctl("disable", systemdUnit); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return systemctl("daemon-reload")
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A shortcut in the Startup folder, unlike a Run registry value, carries the
+// working directory where config.json lives.
+const startupShortcut = `
+$s = (New-Object -ComObject WScript.Shell).CreateShortcut($env:SHORTCUT_PATH)
+$s.TargetPath = $env:SHORTCUT_TARGET
+$s.WorkingDirectory = $env:SHORTCUT_DIR
+$s.Save()`
+
+func startupShortcutPath() string {
+	return filepath.Join(os.Getenv("APPDATA"), "Microsoft", "Windows", "Start Menu", "Programs", "Startup", "PG Monitor.lnk")
+}
+
+// installService starts exe in dir at every login through the Startup
+// folder, and returns the shortcut's path.
+func installService(exe, dir string) (string, error) {
+	path := startupShortcutPath()
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", startupShortcut)
+	cmd.Env = append(os.Environ(), "SHORTCUT_PATH="+path, "SHORTCUT_TARGET="+exe, "SHORTCUT_DIR="+dir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("creating the startup shortcut: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return path, nil
+}
+
+func uninstallService() error {
+	return os.Remove(startupShortcutPath())
+}
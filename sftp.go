@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sftpDefaultPort  = 22
+	sftpPartSuffix   = ".part"
+	curlQuoteError   = 21 // CURLE_QUOTE_ERROR
+	curlFileNotFound = 78 // CURLE_REMOTE_FILE_NOT_FOUND
+)
+
+// A line of a directory listing in ls -l style, as curl prints it for SFTP
+// and most FTP servers send it:
+// "-rw-r--r--    1 backup   backup    1048576 Mar  4 02:14 mydb_20240304.sql.gz"
+var lsListLine = regexp.MustCompile(`^([-dl])\S*\s+\d+\s+\S+\s+\S+\s+(\d+)\s+(\w{3}\s+\d+\s+[\d:]+)\s+(.+)$`)
+
+// sftpPath is name below the destination's Path; a relative Path starts in
+// the login directory.
+func sftpPath(d Destination, name string) string {
+	return path.Join(d.Path, name)
+}
+
+// sftpURL addresses name below the destination's Path for curl. A name of ""
+// addresses the folder itself, which curl lists.
+func sftpURL(d Destination, name string) string {
+	port := d.Port
+	if port == 0 {
+		port = sftpDefaultPort
+	}
+	p := sftpPath(d, name)
+	if !strings.HasPrefix(p, "/") {
+		p = "/~/" + p
+	}
+	if name == "" {
+		p = strings.TrimSuffix(p, "/") + "/"
+	}
+	u := url.URL{Scheme: "sftp", Host: fmt.Sprintf("%s:%d", d.Host, port), Path: p}
+	return u.String()
+}
+
+// sftpHostKeySHA256 turns a known_hosts entry ("host ssh-ed25519 AAAA...")
+// into the base64 SHA-256 fingerprint curl pins the server key to.
+func sftpHostKeySHA256(entry string) (string, error) {
+	fields := strings.Fields(entry)
+	for i := 0; i+1 < len(fields); i++ {
+		t := fields[i]
+		if !strings.HasPrefix(t, "ssh-") && !strings.HasPrefix(t, "ecdsa-") && !strings.HasPrefix(t, "sk-") {
+			continue
+		}
+		blob, err := base64.StdEncoding.DecodeString(fields[i+1])
+		if err != nil {
+			return "", fmt.Errorf("KnownHosts: invalid %s key: %v", t, err)
+		}
+		sum := sha256.Sum256(blob)
+		return base64.StdEncoding.EncodeToString(sum[:]), nil
+	}
+	return "", fmt.Errorf("KnownHosts: no host key in %q", entry)
+}
+
+// sftpCurl prepares curl for one SFTP request. Without KnownHosts curl checks
+// the server key against ~/.ssh/known_hosts; an unknown key is never
+// accepted either way.
+func sftpCurl(ctx context.Context, d Destination, args ...string) (*exec.Cmd, error) {
+	base := []string{"--fail", "-sS", "-u", d.User + ":" + d.Pass}
+	if d.KeyFile != "" {
+		// Pass unlocks the key; password authentication is not offered
+		base = []string{"--fail", "-sS", "-u", d.User + ":", "--key", d.KeyFile}
+		if d.Pass != "" {
+			base = append(base, "--pass", d.Pass)
+		}
+	}
+	if d.KnownHosts != "" {
+		fingerprint, err := sftpHostKeySHA256(d.KnownHosts)
+		if err != nil {
+			return nil, err
+		}
+		base = append(base, "--hostpubsha256", fingerprint)
+	}
+	return exec.CommandContext(ctx, "curl", append(base, args...)...), nil
+}
+
+// curlExitCode is curl's exit status, or -1 when it didn't exit normally.
+func curlExitCode(err error) int {
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode()
+	}
+	return -1
+}
+
+// sftpUploadArgs writes name as name.part, creating missing folders, and
+// renames it once the transfer is complete, replacing an older copy. An
+// interrupted upload leaves only the .part file, which -C - continues on the
+// next attempt.
+func sftpUploadArgs(d Destination, name string) []string {
+	final, part := sftpPath(d, name), sftpPath(d, name+sftpPartSuffix)
+	return []string{
+		"--ftp-create-dirs",
+		"-Q", fmt.Sprintf(`-*rm "%s"`, final),
+		"-Q", fmt.Sprintf(`-rename "%s" "%s"`, part, final),
+		sftpURL(d, name+sftpPartSuffix),
+	}
+}
+
+// uploadSFTP uploads a file with curl, continuing an interrupted upload.
+func (m *Monitor) uploadSFTP(d Destination, filePath, limitRate string) error {
+	args := []string{"-C", "-"}
+	throttled := m.config.MaxUploadRateKBps > 0
+	if throttled {
+		args = append(args, "-T", "-")
+	} else {
+		args = append(args, "-T", filePath)
+	}
+	if limitRate != "" {
+		args = append(args, "--limit-rate", limitRate)
+	}
+	cmd, err := sftpCurl(context.Background(), d, append(args, sftpUploadArgs(d, filepath.Base(filePath))...)...)
+	if err != nil {
+		return err
+	}
+
+	var output []byte
+	if throttled {
+		output, err = m.feedThrottled(cmd, filePath)
+	} else {
+		output, err = cmd.CombinedOutput()
+	}
+	if err != nil {
+		return fmt.Errorf("curl failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// openSFTP looks the file up in the folder listing, for its size and to
+// report a missing file as os.ErrNotExist, and then streams it.
+func openSFTP(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	objects, err := listSFTP(d)
+	if err != nil {
+		return nil, 0, err
+	}
+	size := int64(-1)
+	for _, o := range objects {
+		if o.Name == name {
+			size = o.Size
+		}
+	}
+	if size < 0 {
+		return nil, 0, os.ErrNotExist
+	}
+
+	cmd, err := sftpCurl(ctx, d, sftpURL(d, name))
+	if err != nil {
+		return nil, 0, err
+	}
+	body, err := startCurlDownload(cmd)
+	return body, size, err
+}
+
+func startCurlDownload(cmd *exec.Cmd) (io.ReadCloser, error) {
+	s := &curlReader{cmd: cmd}
+	cmd.Stderr = &s.stderr
+	var err error
+	if s.stdout, err = cmd.StdoutPipe(); err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("curl: %v", err)
+	}
+	return s, nil
+}
+
+// curlReader is a download by curl in progress; Close reports how curl
+// ended.
+type curlReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+}
+
+func (s *curlReader) Read(p []byte) (int, error) {
+	return s.stdout.Read(p)
+}
+
+func (s *curlReader) Close() error {
+	s.stdout.Close()
+	if err := s.cmd.Wait(); err != nil {
+		return fmt.Errorf("curl failed: %v, output: %s", err, strings.TrimSpace(s.stderr.String()))
+	}
+	return nil
+}
+
+func deleteSFTP(d Destination, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteDeleteTimeout)
+	defer cancel()
+
+	// The quote command runs before curl lists the folder, which is discarded
+	cmd, err := sftpCurl(ctx, d, "-o", os.DevNull, "-Q", fmt.Sprintf(`rm "%s"`, sftpPath(d, name)), sftpURL(d, ""))
+	if err != nil {
+		return err
+	}
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if curlExitCode(err) == curlQuoteError && strings.Contains(string(output), "No such file") {
+		return nil
+	}
+	return fmt.Errorf("curl failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+}
+
+// listSFTP lists the files in the destination folder; a folder that doesn't
+// exist yet is empty.
+func listSFTP(d Destination) ([]remoteObject, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteListTimeout)
+	defer cancel()
+
+	cmd, err := sftpCurl(ctx, d, sftpURL(d, ""))
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if curlExitCode(err) == curlFileNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("SFTP list %s: %v, output: %s", d.Host, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseListing(string(output)), nil
+}
+
+// parseListing picks the files out of an ls -l style listing.
+func parseListing(listing string) []remoteObject {
+	var objects []remoteObject
+	for _, line := range strings.Split(listing, "\n") {
+		match := lsListLine.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if match == nil || match[1] != "-" {
+			continue
+		}
+		size, _ := strconv.ParseInt(match[2], 10, 64)
+		objects = append(objects, remoteObject{Name: match[4], Size: size, Modified: parseListTime(match[3])})
+	}
+	return objects
+}
+
+// parseListTime reads the ls -l date: "Mar  4 02:14" within the last half
+// year, "Mar  4  2023" before that.
+func parseListTime(s string) time.Time {
+	s = strings.Join(strings.Fields(s), " ")
+	if t, err := time.ParseInLocation("Jan 2 2006", s, time.Local); err == nil {
+		return t
+	}
+	t, err := time.ParseInLocation("Jan 2 15:04", s, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	now := time.Now()
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
+
+// openSFTPSink streams into name with curl -T -; a killed curl never runs
+// the rename, so an aborted dump stays a .part file.
+func openSFTPSink(d Destination, name string) (streamSink, error) {
+	cmd, err := sftpCurl(context.Background(), d, append([]string{"-T", "-"}, sftpUploadArgs(d, name)...)...)
+	if err != nil {
+		return nil, err
+	}
+	return startCurlSink(cmd)
+}
+
+// testSFTPUpload is the SFTP counterpart of testUploadTo.
+func testSFTPUpload(d Destination) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticTimeout)
+	defer cancel()
+
+	name := fmt.Sprintf("pg-monitor-test-%s.txt", time.Now().Format("20060102_150405"))
+	cmd, err := sftpCurl(ctx, d, append([]string{"-T", "-"}, sftpUploadArgs(d, name)...)...)
+	if err != nil {
+		return "", err
+	}
+	cmd.Stdin = strings.NewReader("pg-monitor upload test\n")
+	start := time.Now()
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("upload rejected: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	elapsed := time.Since(start)
+
+	if err := deleteSFTP(d, name); err != nil {
+		log.Printf("Test upload: could not delete %s from %s: %v", name, d.Host, err)
+	}
+	return fmt.Sprintf("%s: uploaded and deleted %s in %v", d.Name, name, elapsed.Round(time.Millisecond)), nil
+}
+
+// sftpBackend stores "sftp" destinations.
+type sftpBackend struct{}
+
+func init() {
+	registerStorageBackend(destinationSFTP, sftpBackend{})
+}
+
+func (sftpBackend) Put(m *Monitor, d Destination, filePath, rate string) error {
+	return m.uploadSFTP(d, filePath, rate)
+}
+
+func (sftpBackend) Stream(m *Monitor, d Destination, name string) (streamSink, error) {
+	return openSFTPSink(d, name)
+}
+
+func (sftpBackend) Open(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	return openSFTP(ctx, d, name)
+}
+
+func (sftpBackend) Stat(d Destination, name string) (remoteObject, error) {
+	return statListed(listSFTP, d, name)
+}
+
+func (sftpBackend) List(d Destination) ([]remoteObject, error) {
+	return listSFTP(d)
+}
+
+func (sftpBackend) Delete(d Destination, name string) error {
+	return deleteSFTP(d, name)
+}
+
+func (sftpBackend) Test(d Destination) (string, error) {
+	return testSFTPUpload(d)
+}
+
+func (sftpBackend) Location(d Destination, name string) string {
+	return sftpURL(d, name)
+}
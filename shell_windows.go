@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// shellCommand runs a configured command line through cmd.exe with args
+// appended, each quoted.
+func shellCommand(ctx context.Context, command string, args ...string) *exec.Cmd {
+	line := command
+	for _, a := range args {
+		line += ` "` + strings.ReplaceAll(a, `"`, `""`) + `"`
+	}
+	cmd := exec.CommandContext(ctx, "cmd")
+	// cmd.exe does its own unquoting, so the line is passed as is
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: `/S /C "` + line + `"`}
+	return cmd
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sinkInflux    = "influxdb"
+	sinkTimescale = "timescale"
+
+	defaultSinkTable = "pg_monitor_metrics"
+	sinkTimeout      = 10 * time.Second
+)
+
+// metricPoint is one measurement written to the metrics sink.
+type metricPoint struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+var (
+	sinkMu sync.Mutex
+	sinkDB *sql.DB
+)
+
+// exportPoints writes points to the configured sink in the background; a
+// slow or unreachable sink never delays the check cycle.
+func (m *Monitor) exportPoints(points ...metricPoint) {
+	if m.config.MetricsSink == "" || len(points) == 0 {
+		return
+	}
+
+	for i := range points {
+		if points[i].Tags == nil {
+			points[i].Tags = make(map[string]string)
+		}
+		points[i].Tags["host"] = m.config.Host
+		for k, v := range m.config.Tags {
+			points[i].Tags[k] = v
+		}
+	}
+
+	go func() {
+		var err error
+		switch m.config.MetricsSink {
+		case sinkInflux:
+			err = m.writeInflux(points)
+		case sinkTimescale:
+			err = m.writeTimescale(points)
+		default:
+			err = fmt.Errorf("unknown metrics sink %q", m.config.MetricsSink)
+		}
+		if err != nil {
+			log.Printf("Metrics export to %s failed: %v", m.config.MetricsSink, err)
+		}
+	}()
+}
+
+// writeInflux posts points in line protocol to an InfluxDB write endpoint,
+// e.g. http://influx:8086/api/v2/write?org=ops&bucket=pg&precision=ns.
+func (m *Monitor) writeInflux(points []metricPoint) error {
+	var body bytes.Buffer
+	for _, p := range points {
+		body.WriteString(influxLine(p))
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest(http.MethodPost, m.config.MetricsSinkURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if m.config.MetricsSinkToken != "" {
+		req.Header.Set("Authorization", "Token "+m.config.MetricsSinkToken)
+	}
+
+	client := &http.Client{Timeout: sinkTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return nil
+}
+
+func influxLine(p metricPoint) string {
+	escape := strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+	var line strings.Builder
+	line.WriteString(escape.Replace(p.Measurement))
+	for _, k := range sortedKeys(p.Tags) {
+		if p.Tags[k] == "" {
+			continue
+		}
+		fmt.Fprintf(&line, ",%s=%s", escape.Replace(k), escape.Replace(p.Tags[k]))
+	}
+
+	sep := " "
+	for _, k := range sortedFieldKeys(p.Fields) {
+		line.WriteString(sep)
+		sep = ","
+		switch v := p.Fields[k].(type) {
+		case int:
+			fmt.Fprintf(&line, "%s=%di", escape.Replace(k), v)
+		case int64:
+			fmt.Fprintf(&line, "%s=%di", escape.Replace(k), v)
+		case float64:
+			fmt.Fprintf(&line, "%s=%g", escape.Replace(k), v)
+		case bool:
+			fmt.Fprintf(&line, "%s=%t", escape.Replace(k), v)
+		default:
+			fmt.Fprintf(&line, "%s=%q", escape.Replace(k), fmt.Sprint(v))
+		}
+	}
+
+	fmt.Fprintf(&line, " %d", p.Time.UnixNano())
+	return line.String()
+}
+
+// writeTimescale inserts points into a (hyper)table with jsonb tags/fields,
+// created on first use.
+func (m *Monitor) writeTimescale(points []metricPoint) error {
+	db, err := m.timescaleDB()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout)
+	defer cancel()
+
+	table := m.sinkTable()
+	for _, p := range points {
+		tags, _ := json.Marshal(p.Tags)
+		fields, _ := json.Marshal(p.Fields)
+		_, err := db.ExecContext(ctx,
+			fmt.Sprintf("INSERT INTO %s (time, measurement, tags, fields) VALUES ($1, $2, $3, $4)", quoteIdent(table)),
+			p.Time, p.Measurement, string(tags), string(fields))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Monitor) sinkTable() string {
+	if m.config.MetricsSinkTable != "" {
+		return m.config.MetricsSinkTable
+	}
+	return defaultSinkTable
+}
+
+func (m *Monitor) timescaleDB() (*sql.DB, error) {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+	if sinkDB != nil {
+		return sinkDB, nil
+	}
+
+	db, err := sql.Open("postgres", m.config.MetricsSinkDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout)
+	defer cancel()
+
+	table := quoteIdent(m.sinkTable())
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (time timestamptz NOT NULL, measurement text NOT NULL, tags jsonb, fields jsonb)", table)); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	var hasTimescale bool
+	db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')").Scan(&hasTimescale)
+	if hasTimescale {
+		if _, err := db.ExecContext(ctx, "SELECT create_hypertable($1, 'time', if_not_exists => TRUE)", m.sinkTable()); err != nil {
+			log.Printf("Metrics sink: create_hypertable failed, using a plain table: %v", err)
+		}
+	}
+
+	sinkDB = db
+	return db, nil
+}
+
+func backupPoint(e CatalogEntry) metricPoint {
+	return metricPoint{
+		Measurement: "pg_backup",
+		Tags:        map[string]string{"database": e.Database, "kind": e.Kind},
+		Fields: map[string]interface{}{
+			"success":          e.Success,
+			"size_bytes":       e.Size,
+			"duration_seconds": e.Duration().Seconds(),
+			"overrun":          e.Overrun,
+			"file":             e.File,
+		},
+		Time: e.Finished,
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedFieldKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
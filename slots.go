@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	defaultSlotCheckMinutes = 5
+	defaultSlotRetainedGB   = 10
+	slotQueryTimeout        = 30 * time.Second
+	maxSlotMenuItems        = 20
+
+	eventSlotRetainingWAL = "slot_retaining_wal"
+)
+
+// WAL retained by each slot is measured from the current insert position, or
+// the receive position on a standby.
+const replicationSlotQuery = `
+SELECT slot_name, slot_type, COALESCE(database, ''), active,
+       COALESCE(pg_wal_lsn_diff(
+           CASE WHEN pg_is_in_recovery() THEN pg_last_wal_receive_lsn() ELSE pg_current_wal_lsn() END,
+           restart_lsn), 0)::bigint
+FROM pg_replication_slots
+ORDER BY 5 DESC`
+
+type replicationSlot struct {
+	Name        string
+	Type        string // "physical" or "logical"
+	Database    string
+	Active      bool
+	RetainedWAL int64 // bytes of WAL kept back by restart_lsn
+}
+
+func (m *Monitor) slotLoop() {
+	interval := time.Duration(m.config.SlotCheckMinutes) * time.Minute
+	if interval <= 0 {
+		interval = defaultSlotCheckMinutes * time.Minute
+	}
+
+	alerted := make(map[string]bool)
+	for {
+		m.checkReplicationSlots(alerted)
+		time.Sleep(interval)
+	}
+}
+
+// checkReplicationSlots alerts once per inactive slot retaining more than
+// SlotRetainedGB of WAL; the alert is re-armed when the slot recovers or is
+// dropped.
+func (m *Monitor) checkReplicationSlots(alerted map[string]bool) {
+	slots, err := m.collectReplicationSlots()
+	if err != nil {
+		log.Printf("Replication slot check failed: %v", err)
+		m.slotItem.SetTitle("Replication Slots: check failed")
+		return
+	}
+
+	threshold := int64(m.slotRetainedGB() * float64(gb))
+	present := make(map[string]bool)
+	problems := 0
+	for _, s := range slots {
+		present[s.Name] = true
+		if s.Active || s.RetainedWAL < threshold {
+			alerted[s.Name] = false
+			continue
+		}
+		problems++
+		if alerted[s.Name] {
+			continue
+		}
+		alerted[s.Name] = true
+
+		log.Printf("Inactive replication slot %s retains %s of WAL", s.Name, formatBytes(s.RetainedWAL))
+		m.notify(Notification{
+			Event:    eventSlotRetainingWAL,
+			Severity: severityWarning,
+			Title:    "Inactive replication slot retaining WAL",
+			Message:  fmt.Sprintf("%s slot %s is inactive and retains %s of WAL; drop it if its consumer is gone", s.Type, s.Name, formatBytes(s.RetainedWAL)),
+			Database: s.Database,
+			Details:  map[string]string{"slot": s.Name, "type": s.Type, "retained_bytes": fmt.Sprintf("%d", s.RetainedWAL)},
+		})
+	}
+	for name := range alerted {
+		if !present[name] {
+			delete(alerted, name)
+		}
+	}
+
+	m.updateSlotMenu(slots)
+	switch {
+	case len(slots) == 0:
+		m.slotItem.SetTitle("Replication Slots: none")
+	case problems > 0:
+		m.slotItem.SetTitle(fmt.Sprintf("Replication Slots: %d inactive retaining WAL", problems))
+	default:
+		m.slotItem.SetTitle(fmt.Sprintf("Replication Slots: %d, max %s retained", len(slots), formatBytes(slots[0].RetainedWAL)))
+	}
+}
+
+func (m *Monitor) slotRetainedGB() float64 {
+	if m.config.SlotRetainedGB <= 0 {
+		return defaultSlotRetainedGB
+	}
+	return m.config.SlotRetainedGB
+}
+
+// collectReplicationSlots returns all slots, most retained WAL first.
+func (m *Monitor) collectReplicationSlots() ([]replicationSlot, error) {
+	db, err := m.openDB(m.config.DBName)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), slotQueryTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, replicationSlotQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slots []replicationSlot
+	for rows.Next() {
+		var s replicationSlot
+		if err := rows.Scan(&s.Name, &s.Type, &s.Database, &s.Active, &s.RetainedWAL); err != nil {
+			return nil, err
+		}
+		slots = append(slots, s)
+	}
+	return slots, rows.Err()
+}
+
+func (m *Monitor) slotCheckEnabled() bool {
+	return m.config.SlotCheckEnabled && m.metricEnabled(metricReplication)
+}
+
+func (m *Monitor) addSlotMenu() {
+	m.slotItem = tray.AddMenuItem("Replication Slots: -", "Replication slots and the WAL they retain")
+	for i := 0; i < maxSlotMenuItems; i++ {
+		item := m.slotItem.AddSubMenuItem("", "")
+		item.Disable()
+		item.Hide()
+		m.slotItems = append(m.slotItems, item)
+	}
+}
+
+func (m *Monitor) updateSlotMenu(slots []replicationSlot) {
+	for i, item := range m.slotItems {
+		if i >= len(slots) {
+			item.Hide()
+			continue
+		}
+		s := slots[i]
+		state := "active"
+		if !s.Active {
+			state = "INACTIVE"
+		}
+		title := fmt.Sprintf("%s (%s): %s, %s retained", s.Name, s.Type, state, formatBytes(s.RetainedWAL))
+		if s.Database != "" {
+			title = fmt.Sprintf("%s (%s, %s): %s, %s retained", s.Name, s.Type, s.Database, state, formatBytes(s.RetainedWAL))
+		}
+		item.SetTitle(title)
+		item.Show()
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const smbPartSuffix = ".part"
+
+// smbFolder is the destination folder below the share root, with forward
+// slashes and without leading or trailing ones.
+func smbFolder(d Destination) string {
+	return strings.Trim(strings.ReplaceAll(d.Path, `\`, "/"), "/")
+}
+
+func smbURL(d Destination, name string) string {
+	return "smb://" + d.Host + "/" + d.Share + "/" + path.Join(smbFolder(d), name)
+}
+
+// smbUser is User qualified with Domain, DOMAIN\user.
+func smbUser(d Destination) string {
+	if d.Domain == "" {
+		return d.User
+	}
+	return d.Domain + `\` + d.User
+}
+
+func smbCheck(d Destination) error {
+	if d.Host == "" || d.Share == "" {
+		return fmt.Errorf("destination %s: SMB needs Host and Share", d.Name)
+	}
+	return nil
+}
+
+// uploadSMB copies a file to the share. It is written as <name>.part and
+// renamed when complete, so retention and restore never see a partial file.
+func (m *Monitor) uploadSMB(d Destination, filePath, rate string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	body := m.chunkBody(f, rate)
+	defer body.Close()
+	return smbWriteFile(d, filepath.Base(filePath), body)
+}
+
+// smbWriter streams into a file on the share. An aborted stream is never
+// renamed, so it stays a .part file.
+type smbWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newSMBWriter(d Destination, name string) *smbWriter {
+	pr, pw := io.Pipe()
+	w := &smbWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := smbWriteFile(d, name, pr)
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w
+}
+
+func (w *smbWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *smbWriter) Finish() error {
+	w.pw.Close()
+	return <-w.done
+}
+
+func (w *smbWriter) Abort() {
+	w.pw.CloseWithError(fmt.Errorf("upload aborted"))
+	<-w.done
+}
+
+// testSMBUpload is the SMB counterpart of testUploadTo.
+func testSMBUpload(d Destination) (string, error) {
+	name := fmt.Sprintf("pg-monitor-test-%s.txt", time.Now().Format("20060102_150405"))
+	start := time.Now()
+	if err := smbWriteFile(d, name, bytes.NewReader([]byte("pg-monitor upload test\n"))); err != nil {
+		return "", fmt.Errorf("upload rejected: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	if err := smbRemoveFile(d, name); err != nil {
+		log.Printf("Test upload: could not delete %s: %v", smbURL(d, name), err)
+	}
+	return fmt.Sprintf("%s: uploaded and deleted %s in %v", d.Name, name, elapsed.Round(time.Millisecond)), nil
+}
+
+// smbBackend stores "smb" destinations.
+type smbBackend struct{}
+
+func init() {
+	registerStorageBackend(destinationSMB, smbBackend{})
+}
+
+func (smbBackend) Put(m *Monitor, d Destination, filePath, rate string) error {
+	return m.uploadSMB(d, filePath, rate)
+}
+
+func (smbBackend) Stream(m *Monitor, d Destination, name string) (streamSink, error) {
+	return newSMBWriter(d, name), nil
+}
+
+func (smbBackend) Open(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	return smbOpenFile(ctx, d, name)
+}
+
+func (smbBackend) Stat(d Destination, name string) (remoteObject, error) {
+	return statListed(smbListFiles, d, name)
+}
+
+func (smbBackend) List(d Destination) ([]remoteObject, error) {
+	return smbListFiles(d)
+}
+
+func (smbBackend) Delete(d Destination, name string) error {
+	return smbRemoveFile(d, name)
+}
+
+func (smbBackend) Test(d Destination) (string, error) {
+	return testSMBUpload(d)
+}
+
+func (smbBackend) Location(d Destination, name string) string {
+	return smbURL(d, name)
+}
@@ -0,0 +1,208 @@
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// smbclient's ls: name, attributes (D for folders), size and date, e.g.
+// "  backup.sql.gz    A  1048576  Mon Mar  4 02:14:09 2024".
+var smbListLine = regexp.MustCompile(`^\s+(.+?)\s+([A-Z]*)\s+(\d+)\s+(\w{3}\s+\w{3}\s+\d+\s+\d\d:\d\d:\d\d\s+\d{4})$`)
+
+var (
+	smbMu      sync.Mutex
+	smbFolders = make(map[string]bool) // folders created this run
+)
+
+// smbCommand runs smbclient on the destination's share, in its folder
+// unless root is set. The credentials go into a temporary authentication
+// file instead of the command line; done removes it.
+func smbCommand(ctx context.Context, d Destination, root bool, commands string) (cmd *exec.Cmd, done func(), err error) {
+	if err := smbCheck(d); err != nil {
+		return nil, nil, err
+	}
+	args := []string{"//" + d.Host + "/" + d.Share}
+	done = func() {}
+	if d.User == "" {
+		args = append(args, "-N")
+	} else {
+		auth, err := os.CreateTemp("", "pg-monitor-smb-*")
+		if err != nil {
+			return nil, nil, err
+		}
+		fmt.Fprintf(auth, "username = %s\npassword = %s\n", d.User, d.Pass)
+		if d.Domain != "" {
+			fmt.Fprintf(auth, "domain = %s\n", d.Domain)
+		}
+		if err := auth.Close(); err != nil {
+			os.Remove(auth.Name())
+			return nil, nil, err
+		}
+		args = append(args, "-A", auth.Name())
+		done = func() { os.Remove(auth.Name()) }
+	}
+	if d.Port > 0 {
+		args = append(args, "-p", strconv.Itoa(d.Port))
+	}
+	if folder := smbFolder(d); folder != "" && !root {
+		args = append(args, "-D", folder)
+	}
+	return exec.CommandContext(ctx, "smbclient", append(args, "-c", commands)...), done, nil
+}
+
+// smbRun runs commands and fails when smbclient does or reports an
+// NT_STATUS error, which not every version turns into its exit code.
+func smbRun(ctx context.Context, d Destination, root bool, commands string, stdin io.Reader) (string, error) {
+	cmd, done, err := smbCommand(ctx, d, root, commands)
+	if err != nil {
+		return "", err
+	}
+	defer done()
+	cmd.Stdin = stdin
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("smbclient failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	if strings.Contains(string(output), "NT_STATUS_") {
+		return string(output), fmt.Errorf("smbclient: %s", strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+func smbNotFound(output string) bool {
+	for _, status := range []string{"NT_STATUS_NO_SUCH_FILE", "NT_STATUS_OBJECT_NAME_NOT_FOUND", "NT_STATUS_OBJECT_PATH_NOT_FOUND"} {
+		if strings.Contains(output, status) {
+			return true
+		}
+	}
+	return false
+}
+
+// smbMakeFolder creates the destination folder level by level, once per
+// run; smbclient has no mkdir -p, and a level that exists already fails
+// harmlessly.
+func smbMakeFolder(ctx context.Context, d Destination) {
+	folder := smbFolder(d)
+	key := d.Host + "/" + d.Share + "/" + folder
+	smbMu.Lock()
+	defer smbMu.Unlock()
+	if folder == "" || smbFolders[key] {
+		return
+	}
+	parts := strings.Split(folder, "/")
+	for i := range parts {
+		smbRun(ctx, d, true, fmt.Sprintf(`mkdir "%s"`, path.Join(parts[:i+1]...)), nil)
+	}
+	smbFolders[key] = true
+}
+
+// smbWriteFile writes r to <name>.part in the destination folder and renames
+// it over name when complete. The rename is a call of its own: smbclient
+// ends a put at the end of stdin, so in the same script it would also
+// rename a part left by a failed or aborted r. Wait reports r's error even
+// when smbclient succeeded.
+func smbWriteFile(d Destination, name string, r io.Reader) error {
+	ctx := context.Background()
+	smbMakeFolder(ctx, d)
+	part := name + smbPartSuffix
+	if _, err := smbRun(ctx, d, false, fmt.Sprintf(`put - "%s"`, part), r); err != nil {
+		smbRun(ctx, d, false, fmt.Sprintf(`del "%s"`, part), nil)
+		return err
+	}
+	_, err := smbRun(ctx, d, false, fmt.Sprintf(`rename "%s" "%s" -f`, part, name), nil)
+	return err
+}
+
+// smbOpenFile looks the file up in the folder listing, for its size and to
+// report a missing file as os.ErrNotExist, and then streams it.
+func smbOpenFile(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	o, err := statListed(smbListFiles, d, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	cmd, done, err := smbCommand(ctx, d, false, fmt.Sprintf(`get "%s" -`, name))
+	if err != nil {
+		return nil, 0, err
+	}
+	s := &smbReader{cmd: cmd, done: done}
+	cmd.Stderr = &s.stderr
+	if s.stdout, err = cmd.StdoutPipe(); err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		done()
+		return nil, 0, fmt.Errorf("smbclient: %v", err)
+	}
+	return s, o.Size, nil
+}
+
+// smbReader is a download by smbclient in progress; Close reports how it
+// ended.
+type smbReader struct {
+	cmd    *exec.Cmd
+	done   func()
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+}
+
+func (s *smbReader) Read(p []byte) (int, error) {
+	return s.stdout.Read(p)
+}
+
+func (s *smbReader) Close() error {
+	s.stdout.Close()
+	defer s.done()
+	if err := s.cmd.Wait(); err != nil {
+		return fmt.Errorf("smbclient failed: %v, output: %s", err, strings.TrimSpace(s.stderr.String()))
+	}
+	return nil
+}
+
+// smbListFiles lists the files in the destination folder; a folder that
+// doesn't exist yet is empty.
+func smbListFiles(d Destination) ([]remoteObject, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteListTimeout)
+	defer cancel()
+
+	output, err := smbRun(ctx, d, false, "ls", nil)
+	if err != nil {
+		if smbNotFound(output) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var objects []remoteObject
+	for _, line := range strings.Split(output, "\n") {
+		match := smbListLine.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if match == nil || strings.Contains(match[2], "D") || strings.HasSuffix(match[1], smbPartSuffix) {
+			continue
+		}
+		size, _ := strconv.ParseInt(match[3], 10, 64)
+		modified, _ := time.ParseInLocation("Mon Jan 2 15:04:05 2006", strings.Join(strings.Fields(match[4]), " "), time.Local)
+		objects = append(objects, remoteObject{Name: match[1], Size: size, Modified: modified})
+	}
+	return objects, nil
+}
+
+func smbRemoveFile(d Destination, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteDeleteTimeout)
+	defer cancel()
+
+	output, err := smbRun(ctx, d, false, fmt.Sprintf(`del "%s"`, name), nil)
+	if err != nil && smbNotFound(output) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	resourceTypeDisk               = 1
+	errorSessionCredentialConflict = 1219
+)
+
+// netResource mirrors NETRESOURCEW.
+type netResource struct {
+	Scope       uint32
+	Type        uint32
+	DisplayType uint32
+	Usage       uint32
+	LocalName   *uint16
+	RemoteName  *uint16
+	Comment     *uint16
+	Provider    *uint16
+}
+
+var (
+	smbMu        sync.Mutex
+	smbConnected = make(map[string]bool) // by \\server\share
+)
+
+func smbShare(d Destination) string {
+	return `\\` + d.Host + `\` + d.Share
+}
+
+// smbConnect connects to the share with the destination's credentials, like
+// "net use \\server\share" without a drive letter, so the files are reached
+// by their UNC path. Without User the app's own Windows account is used.
+func smbConnect(d Destination) error {
+	if err := smbCheck(d); err != nil {
+		return err
+	}
+	if d.User == "" {
+		return nil
+	}
+	share := smbShare(d)
+	smbMu.Lock()
+	defer smbMu.Unlock()
+	if smbConnected[strings.ToLower(share)] {
+		return nil
+	}
+
+	remote, err := syscall.UTF16PtrFromString(share)
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(smbUser(d))
+	if err != nil {
+		return err
+	}
+	pass, err := syscall.UTF16PtrFromString(d.Pass)
+	if err != nil {
+		return err
+	}
+	res := netResource{Type: resourceTypeDisk, RemoteName: remote}
+	ret, _, _ := syscall.NewLazyDLL("mpr.dll").NewProc("WNetAddConnection2W").Call(
+		uintptr(unsafe.Pointer(&res)), uintptr(unsafe.Pointer(pass)), uintptr(unsafe.Pointer(user)), 0)
+	switch ret {
+	case 0:
+	case errorSessionCredentialConflict:
+		return fmt.Errorf("%s is already connected as another user; disconnect it (net use %s /delete) or use the same account", share, share)
+	default:
+		return fmt.Errorf("connecting to %s: %v", share, syscall.Errno(ret))
+	}
+	smbConnected[strings.ToLower(share)] = true
+	return nil
+}
+
+func smbDir(d Destination) string {
+	return filepath.Join(smbShare(d), filepath.FromSlash(smbFolder(d)))
+}
+
+// smbWriteFile writes r to <name>.part in the destination folder, creating
+// it when missing, and renames it over name when complete.
+func smbWriteFile(d Destination, name string, r io.Reader) error {
+	if err := smbConnect(d); err != nil {
+		return err
+	}
+	dir := smbDir(d)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	part := filepath.Join(dir, name+smbPartSuffix)
+	f, err := os.Create(part)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(part, filepath.Join(dir, name))
+}
+
+func smbOpenFile(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	if err := smbConnect(d); err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(filepath.Join(smbDir(d), name))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// smbListFiles lists the files in the destination folder; a folder that
+// doesn't exist yet is empty.
+func smbListFiles(d Destination) ([]remoteObject, error) {
+	if err := smbConnect(d); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(smbDir(d))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var objects []remoteObject
+	for _, e := range entries {
+		if e.IsDir() || strings.HasSuffix(e.Name(), smbPartSuffix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		objects = append(objects, remoteObject{Name: e.Name(), Size: info.Size(), Modified: info.ModTime()})
+	}
+	return objects, nil
+}
+
+func smbRemoveFile(d Destination, name string) error {
+	if err := smbConnect(d); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(smbDir(d), name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const snapshotQueryTimeout = 30 * time.Second
+
+// ServerSnapshot records the server configuration a backup was taken from,
+// so a restore can recreate extensions, settings and access rules.
+type ServerSnapshot struct {
+	ServerVersion string
+	Extensions    []ExtensionInfo
+	Settings      []SettingInfo
+	HBARules      []HBARule `json:",omitempty"`
+	HBAError      string    `json:",omitempty"` // pg_hba_file_rules not readable by the backup user
+}
+
+type ExtensionInfo struct {
+	Database string
+	Name     string
+	Version  string
+	Schema   string
+}
+
+type SettingInfo struct {
+	Name    string
+	Setting string
+	Unit    string `json:",omitempty"`
+	Source  string
+}
+
+type HBARule struct {
+	Line     int
+	Type     string
+	Database string
+	User     string
+	Address  string `json:",omitempty"`
+	Netmask  string `json:",omitempty"`
+	Method   string
+	Error    string `json:",omitempty"`
+}
+
+// serverSnapshot collects installed extensions (in dbName, or every database
+// for a cluster backup), non-default settings and pg_hba rules. Failures are
+// logged and leave the parts that could be read.
+func (m *Monitor) serverSnapshot(source backupSource, dbName string, allDatabases bool) *ServerSnapshot {
+	if !m.config.ManifestServerSnapshot {
+		return nil
+	}
+
+	db, err := m.openDBAt(source.Host, source.Port, dbName)
+	if err != nil {
+		log.Printf("Server snapshot skipped: %v", err)
+		return nil
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotQueryTimeout)
+	defer cancel()
+
+	snap := &ServerSnapshot{}
+	db.QueryRowContext(ctx, "SHOW server_version").Scan(&snap.ServerVersion)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT name, setting, COALESCE(unit, ''), source FROM pg_settings
+		WHERE source NOT IN ('default', 'override', 'client', 'session')
+		ORDER BY name`)
+	if err != nil {
+		log.Printf("Server snapshot: settings unavailable: %v", err)
+	} else {
+		for rows.Next() {
+			var s SettingInfo
+			if err := rows.Scan(&s.Name, &s.Setting, &s.Unit, &s.Source); err == nil {
+				snap.Settings = append(snap.Settings, s)
+			}
+		}
+		rows.Close()
+	}
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT line_number, type, array_to_string(database, ','), array_to_string(user_name, ','),
+		       COALESCE(address, ''), COALESCE(netmask, ''), COALESCE(auth_method, ''), COALESCE(error, '')
+		FROM pg_hba_file_rules ORDER BY line_number`)
+	if err != nil {
+		snap.HBAError = err.Error()
+	} else {
+		for rows.Next() {
+			var r HBARule
+			if err := rows.Scan(&r.Line, &r.Type, &r.Database, &r.User, &r.Address, &r.Netmask, &r.Method, &r.Error); err == nil {
+				snap.HBARules = append(snap.HBARules, r)
+			}
+		}
+		rows.Close()
+	}
+
+	databases := []string{dbName}
+	if allDatabases {
+		databases = nil
+		rows, err := db.QueryContext(ctx, "SELECT datname FROM pg_database WHERE datallowconn ORDER BY datname")
+		if err == nil {
+			for rows.Next() {
+				var name string
+				if rows.Scan(&name) == nil {
+					databases = append(databases, name)
+				}
+			}
+			rows.Close()
+		}
+	}
+	for _, name := range databases {
+		exts, err := m.listExtensions(ctx, source, name)
+		if err != nil {
+			log.Printf("Server snapshot: extensions of %s unavailable: %v", name, err)
+			continue
+		}
+		snap.Extensions = append(snap.Extensions, exts...)
+	}
+	return snap
+}
+
+func (m *Monitor) listExtensions(ctx context.Context, source backupSource, dbName string) ([]ExtensionInfo, error) {
+	db, err := m.openDBAt(source.Host, source.Port, dbName)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT e.extname, e.extversion, n.nspname
+		FROM pg_extension e JOIN pg_namespace n ON n.oid = e.extnamespace
+		ORDER BY e.extname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exts []ExtensionInfo
+	for rows.Next() {
+		e := ExtensionInfo{Database: dbName}
+		if err := rows.Scan(&e.Name, &e.Version, &e.Schema); err != nil {
+			return nil, err
+		}
+		exts = append(exts, e)
+	}
+	return exts, rows.Err()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+)
+
+const (
+	sourcePrimary       = "primary"
+	sourcePreferStandby = "prefer-standby"
+)
+
+// backupSource is the server a dump is taken from.
+type backupSource struct {
+	Host    string
+	Port    int
+	Standby bool
+
+	// Standby replay position and lag when the dump started
+	ReplayLSN  string
+	LagSeconds float64
+}
+
+func (s backupSource) String() string {
+	role := "primary"
+	if s.Standby {
+		role = "standby"
+	}
+	return fmt.Sprintf("%s:%d (%s)", s.Host, s.Port, role)
+}
+
+// clusterNodes returns Host plus any additional HA members from Hosts
+// ("host" or "host:port"), without duplicates.
+func (m *Monitor) clusterNodes() []backupSource {
+	nodes := []backupSource{{Host: m.config.Host, Port: m.config.Port}}
+	seen := map[string]bool{net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port)): true}
+
+	for _, entry := range m.config.Hosts {
+		host, port := entry, m.config.Port
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			host = h
+			if n, err := strconv.Atoi(p); err == nil {
+				port = n
+			}
+		}
+		key := net.JoinHostPort(host, strconv.Itoa(port))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		nodes = append(nodes, backupSource{Host: host, Port: port})
+	}
+	return nodes
+}
+
+// selectBackupSource classifies the configured nodes with pg_is_in_recovery()
+// and picks one according to BackupSourcePolicy. With "prefer-standby" a
+// reachable standby is used and the primary is the fallback; otherwise the
+// primary is used. A single configured host is used whatever its role, but
+// as a standby only within MaxStandbyLagSeconds.
+func (m *Monitor) selectBackupSource() (backupSource, error) {
+	nodes := m.clusterNodes()
+	if len(nodes) == 1 {
+		node := nodes[0]
+		// An unreachable host fails in pg_dump, with its own message
+		if inRecovery, err := m.nodeInRecovery(node.Host, node.Port); err == nil && inRecovery {
+			node.Standby = true
+			if err := m.checkStandbyLag(&node); err != nil {
+				return backupSource{}, err
+			}
+		}
+		return node, nil
+	}
+
+	var primary, standby *backupSource
+	for i := range nodes {
+		node := &nodes[i]
+		inRecovery, err := m.nodeInRecovery(node.Host, node.Port)
+		if err != nil {
+			log.Printf("Backup source %s:%d unavailable: %v", node.Host, node.Port, err)
+			continue
+		}
+		node.Standby = inRecovery
+		if inRecovery && standby == nil {
+			standby = node
+		} else if !inRecovery && primary == nil {
+			primary = node
+		}
+	}
+
+	if m.config.BackupSourcePolicy == sourcePreferStandby && standby != nil {
+		if err := m.checkStandbyLag(standby); err == nil {
+			return *standby, nil
+		} else if primary != nil {
+			log.Printf("Not dumping from standby %s: %v", standby, err)
+		} else {
+			return backupSource{}, err
+		}
+	}
+	if primary != nil {
+		if m.config.BackupSourcePolicy == sourcePreferStandby {
+			log.Printf("No standby available, falling back to primary %s", primary)
+		}
+		return *primary, nil
+	}
+	return backupSource{}, fmt.Errorf("no reachable primary among %d configured hosts", len(nodes))
+}
+
+// checkStandbyLag records the standby's replay LSN and lag and fails when the
+// lag exceeds MaxStandbyLagSeconds. A standby that has replayed everything it
+// received counts as current, so an idle primary doesn't look like lag, but
+// only while its WAL receiver runs: one cut off from the primary has
+// replayed everything too. Without pg_read_all_stats pg_stat_wal_receiver
+// shows just the receiver's pid, so a running receiver counts then.
+func (m *Monitor) checkStandbyLag(node *backupSource) error {
+	db, err := m.openDBAt(node.Host, node.Port, m.config.DBName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), connTimeout)
+	defer cancel()
+
+	err = db.QueryRowContext(ctx, `
+		SELECT COALESCE(pg_last_wal_replay_lsn()::text, ''),
+		       CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn()
+		                 AND EXISTS (SELECT 1 FROM pg_stat_wal_receiver
+		                             WHERE status = 'streaming' OR (status IS NULL AND pid IS NOT NULL)) THEN 0
+		            ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END`).
+		Scan(&node.ReplayLSN, &node.LagSeconds)
+	if err != nil {
+		return fmt.Errorf("replication lag unknown: %v", err)
+	}
+
+	log.Printf("Standby %s replayed up to %s, lag %.0fs", node, node.ReplayLSN, node.LagSeconds)
+	if max := m.config.MaxStandbyLagSeconds; max > 0 && node.LagSeconds > float64(max) {
+		return fmt.Errorf("standby %s lags %.0fs behind the primary (limit %ds)", node, node.LagSeconds, max)
+	}
+	return nil
+}
+
+func (m *Monitor) nodeInRecovery(host string, port int) (bool, error) {
+	db, err := m.openDBAt(host, port, m.config.DBName)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), connTimeout)
+	defer cancel()
+
+	var inRecovery bool
+	err = db.QueryRowContext(ctx, "SELECT pg_is_in_recovery()").Scan(&inRecovery)
+	return inRecovery, err
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStagingBackupAge = 24
+	stagingRestoreTimeout   = 6 * time.Hour
+	stagingHookTimeout      = time.Hour
+	stagingScratchSuffix    = "_refresh_"
+
+	eventStagingRefreshed    = "staging_refreshed"
+	eventStagingRefreshError = "staging_refresh_failed"
+)
+
+// stagingMu keeps a manual refresh from overlapping a scheduled one.
+var stagingMu sync.Mutex
+
+// stagingLoop refreshes staging at StagingRefreshTime, daily or on
+// StagingRefreshWeekday only.
+func (m *Monitor) stagingLoop() {
+	log.Printf("Scheduled staging refresh of %s enabled at %s", m.stagingTarget(), m.config.StagingRefreshTime)
+	if day := m.config.StagingRefreshWeekday; day != "" {
+		if _, ok := parseWeekday(day); !ok {
+			m.configError("invalid StagingRefreshWeekday %q, refreshing daily", day)
+		}
+	}
+	for {
+		next := m.nextStagingRefresh(time.Now())
+		late, jumped := waitUntil(next)
+		if jumped {
+			continue
+		}
+
+		switch {
+		case !m.runMissed("scheduled staging refresh", late):
+		case m.autoBackupPaused():
+			log.Printf("Scheduled staging refresh skipped: auto backups paused")
+		default:
+			log.Printf("Running scheduled staging refresh...")
+			m.refreshStaging(localActor())
+		}
+	}
+}
+
+func (m *Monitor) nextStagingRefresh(from time.Time) time.Time {
+	at, err := time.Parse("15:04", m.config.StagingRefreshTime)
+	if err != nil {
+		log.Printf("Invalid StagingRefreshTime: %v, using 06:00", err)
+		at, _ = time.Parse("15:04", "06:00")
+	}
+
+	weekday, weekly := parseWeekday(m.config.StagingRefreshWeekday)
+	next := time.Date(from.Year(), from.Month(), from.Day(), at.Hour(), at.Minute(), 0, 0, from.Location())
+	for !next.After(from) || (weekly && next.Weekday() != weekday) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func (m *Monitor) stagingSource() string {
+	if m.config.StagingDatabase != "" {
+		return m.config.StagingDatabase
+	}
+	return m.config.DBName
+}
+
+func (m *Monitor) stagingTarget() string {
+	if m.config.StagingTargetDatabase != "" {
+		return m.config.StagingTargetDatabase
+	}
+	return m.stagingSource()
+}
+
+// checkStagingCluster refuses a staging server that is the production
+// cluster (or a standby of it) under another name: host names, ports and
+// addresses can all differ while the data directory is the same, so only
+// the clusters' system identifiers tell.
+func (m *Monitor) checkStagingCluster(staging *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), connTimeout)
+	defer cancel()
+
+	var stagingID, productionID int64
+	if err := staging.QueryRowContext(ctx, "SELECT system_identifier FROM pg_control_system()").Scan(&stagingID); err != nil {
+		return fmt.Errorf("cannot identify the staging cluster: %v", err)
+	}
+	db, err := m.openDB(m.config.DBName)
+	if err != nil {
+		return fmt.Errorf("cannot identify the production cluster: %v", err)
+	}
+	defer db.Close()
+	if err := db.QueryRowContext(ctx, "SELECT system_identifier FROM pg_control_system()").Scan(&productionID); err != nil {
+		return fmt.Errorf("cannot identify the production cluster: %v", err)
+	}
+	if stagingID == productionID {
+		return fmt.Errorf("staging server %s is the production cluster (system identifier %d)", m.config.StagingHost, stagingID)
+	}
+	return nil
+}
+
+// stagingConn returns the staging server's connection settings; user and
+// password default to the monitored server's.
+func (m *Monitor) stagingConn() (host string, port int, user, password string) {
+	host, port, user, password = m.config.StagingHost, m.config.StagingPort, m.config.StagingUser, m.config.StagingPassword
+	if port == 0 {
+		port = 5432
+	}
+	if user == "" {
+		user, password = m.config.User, m.config.Password
+	}
+	return host, port, user, password
+}
+
+// refreshStaging restores the latest production backup onto the staging
+// server. It is restored into a scratch database first, masked and smoke
+// tested there, and only then swapped in under the staging name - a failed
+// refresh leaves the previous staging database untouched and unmasked data
+// never appears under the name applications connect to.
+func (m *Monitor) refreshStaging(actor string) {
+	if !stagingMu.TryLock() {
+		log.Printf("Staging refresh skipped: a refresh is already running")
+		return
+	}
+	defer stagingMu.Unlock()
+
+	start := time.Now()
+	target := m.stagingTarget()
+	file, err := m.stagingRefresh(target)
+	if err != nil {
+		log.Printf("Staging refresh of %s FAILED: %v", target, err)
+		m.audit(actor, "staging_refresh_failed", target, err.Error())
+		m.notify(Notification{
+			Event:    eventStagingRefreshError,
+			Severity: severityWarning,
+			Title:    "Staging refresh failed",
+			Message:  fmt.Sprintf("%s on %s: %v; the previous copy is still in place", target, m.config.StagingHost, err),
+			Database: target,
+		})
+		return
+	}
+
+	elapsed := time.Since(start).Round(time.Second)
+	log.Printf("Staging %s refreshed from %s in %v", target, file, elapsed)
+	m.audit(actor, "staging_refreshed", target, fmt.Sprintf("from %s on %s", file, m.config.StagingHost))
+	m.notify(Notification{
+		Event:    eventStagingRefreshed,
+		Severity: severityInfo,
+		Title:    "Staging refreshed",
+		Message:  fmt.Sprintf("%s on %s restored from %s in %v", target, m.config.StagingHost, file, elapsed),
+		Database: target,
+		Details:  map[string]string{"file": file},
+	})
+}
+
+func (m *Monitor) stagingRefresh(target string) (string, error) {
+	if m.config.StagingHost == "" {
+		return "", fmt.Errorf("StagingHost is not configured")
+	}
+
+	entry, err := m.stagingBackup()
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(".", "backups", entry.File)
+	if err := verifyChecksum(file); err != nil {
+		return "", fmt.Errorf("refusing to restore: %v", err)
+	}
+	if isPGPEncrypted(file) {
+		plain, err := m.decryptForRestore(file)
+		if err != nil {
+			return "", fmt.Errorf("decrypt %s: %v", entry.File, err)
+		}
+		defer os.RemoveAll(plain)
+		file = plain
+	}
+
+	host, port, user, password := m.stagingConn()
+	admin, err := openDBAs(host, port, user, password, "postgres")
+	if err != nil {
+		return "", err
+	}
+	defer admin.Close()
+	if err := m.checkStagingCluster(admin); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), stagingRestoreTimeout)
+	defer cancel()
+
+	scratch := target + stagingScratchSuffix + time.Now().Format("20060102150405")
+	if _, err := admin.ExecContext(ctx, "CREATE DATABASE "+quoteIdent(scratch)); err != nil {
+		return "", fmt.Errorf("create %s: %v", scratch, err)
+	}
+	swapped := false
+	defer func() {
+		if swapped {
+			return
+		}
+		if _, err := admin.Exec("DROP DATABASE IF EXISTS " + quoteIdent(scratch) + " WITH (FORCE)"); err != nil {
+			log.Printf("Failed to drop %s: %v", scratch, err)
+		}
+	}()
+
+	log.Printf("Staging refresh: restoring %s into %s on %s", entry.File, scratch, host)
+	env := append(os.Environ(), "PGPASSWORD="+password)
+	conn := []string{"-h", host, "-p", fmt.Sprintf("%d", port), "-U", user, "-d", scratch}
+	if err := restoreDump(ctx, file, conn, env); err != nil {
+		return "", fmt.Errorf("restore: %v", err)
+	}
+
+	hookEnv := append(os.Environ(),
+		"PGHOST="+host,
+		fmt.Sprintf("PGPORT=%d", port),
+		"PGUSER="+user,
+		"PGPASSWORD="+password,
+		"PGDATABASE="+scratch,
+		"PGM_STAGING_TARGET="+target,
+		"PGM_BACKUP_FILE="+entry.File,
+	)
+	if m.config.StagingMaskCommand != "" {
+		if err := runStagingHook("masking", m.config.StagingMaskCommand, hookEnv); err != nil {
+			return "", err
+		}
+	}
+	if m.config.StagingSmokeTestCommand != "" {
+		if err := runStagingHook("smoke test", m.config.StagingSmokeTestCommand, hookEnv); err != nil {
+			return "", err
+		}
+	}
+
+	// Swap: sessions on the old copy are terminated by WITH (FORCE)
+	if _, err := admin.ExecContext(ctx, "DROP DATABASE IF EXISTS "+quoteIdent(target)+" WITH (FORCE)"); err != nil {
+		return "", fmt.Errorf("drop old %s: %v", target, err)
+	}
+	if _, err := admin.ExecContext(ctx, "ALTER DATABASE "+quoteIdent(scratch)+" RENAME TO "+quoteIdent(target)); err != nil {
+		return "", fmt.Errorf("rename %s to %s: %v", scratch, target, err)
+	}
+	swapped = true
+	return entry.File, nil
+}
+
+// stagingBackup returns the newest complete local backup of the staging
+// source younger than StagingMaxBackupAgeHours, or takes a fresh one.
+func (m *Monitor) stagingBackup() (CatalogEntry, error) {
+	hours := m.config.StagingMaxBackupAgeHours
+	if hours <= 0 {
+		hours = defaultStagingBackupAge
+	}
+	source := m.stagingSource()
+
+	entries, err := loadCatalog()
+	if err != nil {
+		return CatalogEntry{}, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if !e.Success || e.Deleted || e.Kind != "database" || e.Database != source || e.Scope != "" {
+			continue
+		}
+		if time.Since(e.Finished) > time.Duration(hours)*time.Hour {
+			break
+		}
+		if _, err := os.Stat(filepath.Join(".", "backups", e.File)); err == nil {
+			log.Printf("Staging refresh: using %s from %s", e.File, e.Finished.Format("2006-01-02 15:04"))
+			return e, nil
+		}
+	}
+
+	log.Printf("Staging refresh: no backup of %s in the last %d hours, taking one", source, hours)
+	e, ok := m.backupJob(BackupJob{Database: source, Trigger: triggerStaging, Label: "staging refresh"}, func() CatalogEntry {
+		return m.backupOne(source, false, backupOptions{Label: "staging refresh"})
+	})
+	if !ok || !e.Success {
+		return e, fmt.Errorf("backup of %s failed: %s", source, e.Status)
+	}
+	if _, err := os.Stat(filepath.Join(".", "backups", e.File)); err != nil {
+		return e, fmt.Errorf("backup %s has no local copy (LocalCopy off?)", e.File)
+	}
+	return e, nil
+}
+
+func runStagingHook(what, command string, env []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), stagingHookTimeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, command)
+	cmd.Env = env
+	log.Printf("Staging refresh: running %s: %s", what, command)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s timed out after %v", what, stagingHookTimeout)
+	}
+	if err != nil {
+		out := strings.TrimSpace(string(output))
+		if len(out) > 500 {
+			out = out[len(out)-500:]
+		}
+		return fmt.Errorf("%s failed: %v: %s", what, err, out)
+	}
+	if out := strings.TrimSpace(string(output)); out != "" {
+		log.Printf("Staging %s: %s", what, out)
+	}
+	return nil
+}
+
+func (m *Monitor) addStagingMenu() {
+	item := tray.AddMenuItem(fmt.Sprintf("Refresh Staging (%s)", m.stagingTarget()), "Restore the latest backup onto the staging server now")
+	go func() {
+		for range item.ClickedCh {
+			item.Disable()
+			m.refreshStaging(localActor())
+			item.Enable()
+		}
+	}()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// StorageBackend is one kind of upload destination, chosen by a
+// destination's Type. Put uploads a local file under its base name and
+// Stream uploads a dump while it is written; Open, Stat, List and Delete work
+// on the files directly in the destination's folder, reporting a missing one
+// as os.ErrNotExist. Test uploads and deletes a small file for the
+// diagnostics, and Location names where a file goes, for logging.
+//
+// A provider is a file of its own that registers its backend from init;
+// nothing else needs to know about it.
+type StorageBackend interface {
+	Put(m *Monitor, d Destination, filePath, rate string) error
+	Stream(m *Monitor, d Destination, name string) (streamSink, error)
+	Open(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error)
+	Stat(d Destination, name string) (remoteObject, error)
+	List(d Destination) ([]remoteObject, error)
+	Delete(d Destination, name string) error
+	Test(d Destination) (string, error)
+	Location(d Destination, name string) string
+}
+
+var storageBackends = make(map[string]StorageBackend) // by lower-case Type
+
+// registerStorageBackend makes b the backend of destinations with Type typ.
+func registerStorageBackend(typ string, b StorageBackend) {
+	storageBackends[strings.ToLower(typ)] = b
+}
+
+// storageFor returns the backend of d; destinations without a Type are
+// WebDAV.
+func storageFor(d Destination) (StorageBackend, error) {
+	typ := strings.ToLower(d.Type)
+	if typ == "" {
+		typ = destinationWebDAV
+	}
+	b, ok := storageBackends[typ]
+	if !ok {
+		return nil, fmt.Errorf("destination %s: unknown type %q", d.Name, d.Type)
+	}
+	return b, nil
+}
+
+// statListed looks name up in the listing of d's folder, for backends
+// without a cheaper way to ask about a single file.
+func statListed(list func(Destination) ([]remoteObject, error), d Destination, name string) (remoteObject, error) {
+	objects, err := list(d)
+	if err != nil {
+		return remoteObject{}, err
+	}
+	for _, o := range objects {
+		if o.Name == name {
+			return o, nil
+		}
+	}
+	return remoteObject{}, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
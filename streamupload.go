@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var localCopyWarning sync.Once
+
+// streamedUpload is what a dump streamed to the destinations left behind.
+type streamedUpload struct {
+	Stderr    []byte
+	Usage     []ProcessUsage
+	Size      int64  // compressed dump, before encryption
+	SHA256    string // of the same, as it would be on disk
+	Remote    string // object name on the destinations
+	Encrypted bool
+}
+
+// directUpload reports whether a dump goes straight to the destinations
+// without a local copy. LocalCopy is a pointer so configs that predate it
+// keep theirs; only an explicit false streams. Only plain dumps are
+// streamed, and only when nothing needs the file on disk after the dump.
+func (m *Monitor) directUpload(format string) bool {
+	if m.config.LocalCopy == nil || *m.config.LocalCopy || !m.config.UploadToCloud || format != dumpFormatPlain {
+		return false
+	}
+	reason := ""
+	switch {
+	case m.config.UntrustedRemote || m.config.PGPEncryptBackups:
+		reason = "OpenPGP encryption"
+	case m.config.VerifyBackups:
+		reason = "VerifyBackups"
+	case m.config.PostBackupCheckCommand != "":
+		reason = "PostBackupCheckCommand"
+	}
+	if reason != "" {
+		localCopyWarning.Do(func() {
+			m.configError("LocalCopy false is ignored: %s needs the dump on disk", reason)
+		})
+		return false
+	}
+	return true
+}
+
+// streamToDestinations runs a dump writing to stdout and uploads it through
+// codec (and the upload key with EncryptBackups) to every destination while
+// it is produced, one curl per destination reading from a pipe. There is no
+// local copy to retry from, so any failing destination fails the run.
+func (m *Monitor) streamToDestinations(cmd *exec.Cmd, entry CatalogEntry, codec dumpCodec, dests []Destination) (streamedUpload, error) {
+	up := streamedUpload{Remote: entry.File}
+	var key []byte
+	if m.config.EncryptBackups {
+		var err error
+		if key, err = m.encryptionKey(); err != nil {
+			return up, err
+		}
+		up.Remote += encryptedExt
+		up.Encrypted = true
+	}
+	if err := chaosError(chaosUpload); err != nil {
+		return up, err
+	}
+
+	// The real size is only known at the end; quota goes by the last one
+	estimate := lastBackupSize(entry)
+	for _, d := range dests {
+		if err := m.enforceQuota(d, entry.File, estimate); err != nil {
+			return up, err
+		}
+	}
+
+	var sinks []streamSink
+	abort := func(err error) (streamedUpload, error) {
+		for _, s := range sinks {
+			s.Abort()
+		}
+		return up, err
+	}
+	for _, d := range dests {
+		s, err := m.openStreamSink(d, up.Remote)
+		if err != nil {
+			return abort(fmt.Errorf("upload to %s: %v", d.Name, err))
+		}
+		sinks = append(sinks, s)
+	}
+	// Each copy counts against MaxUploadRateKBps
+	writers := make([]io.Writer, len(sinks))
+	for i, s := range sinks {
+		writers[i] = m.throttleUpload(s)
+	}
+	remote := io.MultiWriter(writers...)
+
+	h := sha256.New()
+	var size countingWriter
+	var target io.Writer = remote
+	var encIn *io.PipeWriter
+	var encDone chan error
+	if key != nil {
+		pr, pw := io.Pipe()
+		encIn, encDone = pw, make(chan error, 1)
+		go func() {
+			err := encryptStream(pr, remote, key)
+			pr.CloseWithError(err)
+			encDone <- err
+		}()
+		target = pw
+	}
+
+	w, err := codec.NewWriter(io.MultiWriter(target, h, &size))
+	if err != nil {
+		if encIn != nil {
+			encIn.CloseWithError(err)
+			<-encDone
+		}
+		return abort(err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = w, &stderr
+	dumpUsage, err := runMeasured(cmd)
+	closeErr := w.Close()
+	up.Usage = []ProcessUsage{dumpUsage}
+	if p, ok := w.(*codecProcess); ok {
+		up.Usage = append(up.Usage, p.usage)
+	}
+	up.Stderr = stderr.Bytes()
+	if err == nil {
+		err = closeErr
+	}
+	if encIn != nil {
+		encIn.CloseWithError(err)
+		if encErr := <-encDone; err == nil {
+			err = encErr
+		}
+	}
+	if err != nil {
+		return abort(err)
+	}
+
+	for i, s := range sinks {
+		if ferr := s.Finish(); ferr != nil && err == nil {
+			err = fmt.Errorf("upload to %s failed: %v", dests[i].Name, ferr)
+		}
+	}
+	up.Size = size.n
+	up.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return up, err
+}
+
+// streamSink is one destination receiving a streamed dump.
+type streamSink interface {
+	io.Writer
+	Finish() error // the input is complete; wait until the upload is stored
+	Abort()        // keep what was written so far from being stored
+}
+
+func (m *Monitor) openStreamSink(d Destination, name string) (streamSink, error) {
+	b, err := storageFor(d)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Streaming to: %s", b.Location(d, name))
+	return b.Stream(m, d, name)
+}
+
+// startCurlSink starts a curl that uploads what is written to it (-T -).
+func startCurlSink(cmd *exec.Cmd) (streamSink, error) {
+	s := &curlSink{cmd: cmd}
+	s.cmd.Stdout, s.cmd.Stderr = &s.out, &s.out
+	var err error
+	if s.stdin, err = s.cmd.StdinPipe(); err == nil {
+		err = s.cmd.Start()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("curl: %v", err)
+	}
+	return s, nil
+}
+
+// curlSink feeds its input to an uploading curl (SFTP and FTP).
+type curlSink struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	out   bytes.Buffer
+}
+
+func (c *curlSink) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+func (c *curlSink) Finish() error {
+	c.stdin.Close()
+	if err := c.cmd.Wait(); err != nil {
+		return fmt.Errorf("%v, output: %s", err, c.out.String())
+	}
+	return nil
+}
+
+// Abort kills curl, the only way to keep a failed dump from being stored: a
+// curl that sees its input end uploads what it got.
+func (c *curlSink) Abort() {
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+}
+
+type countingWriter struct{ n int64 }
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// lastBackupSize is the size of the newest successful backup like e, or 0.
+func lastBackupSize(e CatalogEntry) int64 {
+	entries, err := loadCatalog()
+	if err != nil {
+		return 0
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		p := entries[i]
+		if p.Success && p.Database == e.Database && p.Kind == e.Kind && p.Scope == e.Scope {
+			return p.Size
+		}
+	}
+	return 0
+}
+
+// finishStreamedBackup writes and uploads the manifest of a streamed dump
+// and records the run. The manifest stays in the backups directory as the
+// local trace of a backup that only exists remotely.
+func (m *Monitor) finishStreamedBackup(entry *CatalogEntry, up streamedUpload, backupFile, dbName string, allDatabases bool, source backupSource, dests []Destination) {
+	manifest := BackupManifest{
+		Version:      manifestFormatVersion,
+		File:         filepath.Base(backupFile),
+		Size:         up.Size,
+		Database:     dbName,
+		AllDatabases: allDatabases,
+		Scope:        entry.Scope,
+
+		ConsistencyGroup: entry.ConsistencyGroup,
+		Host:             source.Host,
+		Standby:          source.Standby,
+		ReplayLSN:        source.ReplayLSN,
+		LagSeconds:       source.LagSeconds,
+		CreatedAt:        time.Now(),
+		Duration:         time.Since(entry.Started).Seconds(),
+		Usage:            up.Usage,
+	}
+	if allDatabases {
+		manifest.Database = ""
+		manifest.Server = m.serverSnapshot(source, m.config.DBName, true)
+	} else {
+		manifest.Server = m.serverSnapshot(source, dbName, false)
+	}
+	if manifest.Server != nil {
+		manifest.ServerVersion = manifest.Server.ServerVersion
+	} else {
+		manifest.ServerVersion = m.serverVersion(source)
+	}
+	if m.config.ManifestChecksum {
+		manifest.SHA256 = up.SHA256
+	}
+
+	manifestFile, err := m.saveManifest(backupFile, manifest)
+	if err == nil {
+		files := []string{manifestFile}
+		if up.Encrypted {
+			if files[0], err = m.encryptForUpload(manifestFile); err == nil {
+				defer os.Remove(files[0])
+			}
+		}
+		if _, statErr := os.Stat(manifestFile + signatureSuffix); statErr == nil {
+			files = append(files, manifestFile+signatureSuffix)
+		}
+		for _, d := range dests {
+			for _, f := range files {
+				if err == nil {
+					err = m.uploadTo(d, f)
+				}
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Manifest of streamed backup %s not uploaded: %v", entry.File, err)
+	}
+
+	entry.Size = up.Size
+	entry.SHA256 = manifest.SHA256
+	entry.Encrypted = up.Encrypted
+	for _, d := range dests {
+		entry.Uploaded = append(entry.Uploaded, d.Name)
+	}
+	entry.Success = true
+	m.checkSizeAnomaly(*entry)
+
+	sizeKB := float64(up.Size) / 1024.0
+	log.Printf("Backup streamed to %v: %s (%.2f KB)", entry.Uploaded, up.Remote, sizeKB)
+	tray.SetTooltip(fmt.Sprintf("Backup complete: %.2f KB (streamed to cloud)", sizeKB))
+	m.lastBackupTime = time.Now()
+	m.lastBackupStatus = fmt.Sprintf("%.2f KB (cloud only)", sizeKB)
+	m.updateBackupStatus()
+	m.notifyBackup(true, entry.Database, fmt.Sprintf("%s: %s", up.Remote, m.lastBackupStatus))
+	m.pruneRemoteBackups(entry.Uploaded)
+}
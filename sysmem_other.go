@@ -0,0 +1,20 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+func totalMemory() (int64, error) {
+	out, err := exec.Command("sysctl", "-n", "hw.memsize").Output()
+	if err != nil {
+		out, err = exec.Command("sysctl", "-n", "hw.physmem").Output()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+}
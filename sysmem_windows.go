@@ -0,0 +1,30 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+type memoryStatusEx struct {
+	Length               uint32
+	MemoryLoad           uint32
+	TotalPhys            uint64
+	AvailPhys            uint64
+	TotalPageFile        uint64
+	AvailPageFile        uint64
+	TotalVirtual         uint64
+	AvailVirtual         uint64
+	AvailExtendedVirtual uint64
+}
+
+func totalMemory() (int64, error) {
+	proc := syscall.NewLazyDLL("kernel32.dll").NewProc("GlobalMemoryStatusEx")
+
+	var status memoryStatusEx
+	status.Length = uint32(unsafe.Sizeof(status))
+	ret, _, err := proc.Call(uintptr(unsafe.Pointer(&status)))
+	if ret == 0 {
+		return 0, err
+	}
+	return int64(status.TotalPhys), nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	testRestorePrefix  = "pg_monitor_verify_"
+	testRestoreTimeout = 2 * time.Hour
+	sanityQueryTimeout = time.Minute
+
+	eventBackupVerifyFailed = "backup_verify_failed"
+)
+
+// Tables and materialized views outside the system schemas; compared between
+// the source and the restored copy.
+const userRelationCountQuery = `
+SELECT count(*) FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p', 'm')
+  AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+  AND n.nspname NOT LIKE 'pg_toast%'`
+
+// "ALTER TABLE public.t OWNER TO app;" and the like in a plain dump.
+var ownerStatement = regexp.MustCompile(`^ALTER [A-Z ]+ .+ OWNER TO .+;\s*$`)
+
+// verifyTarget is the server test restores go to: VerifyHost/VerifyPort, e.g.
+// a disposable container, or the monitored server itself.
+func (m *Monitor) verifyTarget() (string, int) {
+	host, port := m.config.VerifyHost, m.config.VerifyPort
+	if host == "" {
+		host = m.config.Host
+	}
+	if port == 0 {
+		port = m.config.Port
+	}
+	return host, port
+}
+
+// verifyByRestore restores a fresh dump of dbName into a scratch database,
+// runs sanity queries against it and drops it again. The result is recorded
+// on the catalog entry; a failure is alerted but leaves the backup itself
+// successful, since the file is still the best copy there is.
+func (m *Monitor) verifyByRestore(file, dbName string, entry *CatalogEntry) {
+	if isClusterDump(file) {
+		log.Printf("Skipping test restore of %s: pg_dumpall backups recreate roles and databases", file)
+		return
+	}
+
+	tray.SetTooltip("Verifying backup by test restore...")
+	start := time.Now()
+	err := m.testRestore(file, dbName, entry.Scope != "")
+	entry.VerifiedAt = time.Now()
+	if err == nil {
+		entry.Verified = true
+		log.Printf("Test restore of %s passed in %v", file, time.Since(start).Round(time.Second))
+		return
+	}
+
+	entry.VerifyError = err.Error()
+	log.Printf("Test restore of %s FAILED: %v", file, err)
+	m.notify(Notification{
+		Event:    eventBackupVerifyFailed,
+		Severity: severityCritical,
+		Title:    "Backup failed verification",
+		Message:  fmt.Sprintf("Test restore of %s failed: %v", entry.File, err),
+		Database: dbName,
+		Details:  map[string]string{"file": entry.File},
+	})
+}
+
+func (m *Monitor) testRestore(file, dbName string, partial bool) error {
+	host, port := m.verifyTarget()
+	scratch := testRestorePrefix + time.Now().Format("20060102150405")
+
+	admin, err := m.openDBAt(host, port, "postgres")
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testRestoreTimeout)
+	defer cancel()
+
+	if _, err := admin.ExecContext(ctx, "CREATE DATABASE "+quoteIdent(scratch)); err != nil {
+		return fmt.Errorf("create scratch database: %v", err)
+	}
+	defer func() {
+		dropCtx, dropCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer dropCancel()
+		if _, err := admin.ExecContext(dropCtx, "DROP DATABASE IF EXISTS "+quoteIdent(scratch)+" WITH (FORCE)"); err != nil {
+			log.Printf("Failed to drop scratch database %s: %v", scratch, err)
+		}
+	}()
+
+	env := append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", m.config.Password))
+	conn := []string{"-h", host, "-p", fmt.Sprintf("%d", port), "-U", m.config.User, "-d", scratch}
+	if err := restoreDump(ctx, file, conn, env); err != nil {
+		return fmt.Errorf("restore: %v", err)
+	}
+
+	return m.sanityCheck(host, port, scratch, dbName, partial)
+}
+
+// restoreDump loads a dump into the existing database named in conn, with
+// pg_restore for archives and psql for plain dumps. Owners and grants refer
+// to roles that needn't exist on the target, so both skip them.
+func restoreDump(ctx context.Context, file string, conn, env []string) error {
+	var cmd *exec.Cmd
+	if isArchiveDump(file) {
+		cmd = exec.CommandContext(ctx, pgTool("pg_restore"), append(conn, "--no-owner", "--no-privileges", "--exit-on-error", file)...)
+	} else {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input, err := codecForFile(file).NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer input.Close()
+		cmd = exec.CommandContext(ctx, pgTool("psql"), append(conn, "-v", "ON_ERROR_STOP=1", "-q")...)
+		filtered := withoutOwnership(input)
+		defer filtered.Close()
+		cmd.Stdin = filtered
+	}
+	cmd.Env = env
+	return runLogged(cmd, cmd.Args[0])
+}
+
+// withoutOwnership drops from a plain dump what pg_restore --no-owner
+// --no-privileges skips in an archive: the ALTER ... OWNER TO statements and
+// the GRANTs and REVOKEs of the entries pg_dump heads "Type: ACL" or
+// "Type: DEFAULT ACL". COPY data passes through untouched; closing the
+// reader stops the filter.
+func withoutOwnership(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(filterOwnership(r, pw))
+	}()
+	return pr
+}
+
+func filterOwnership(r io.Reader, w io.Writer) error {
+	in := bufio.NewReaderSize(r, 64<<10)
+	out := bufio.NewWriter(w)
+	copying, acl := false, false
+	for {
+		line, err := in.ReadString('\n')
+		keep := true
+		switch {
+		case copying:
+			copying = strings.TrimRight(line, "\r\n") != `\.`
+		case strings.HasPrefix(line, "-- "):
+			acl = strings.Contains(line, "; Type: ACL;") || strings.Contains(line, "; Type: DEFAULT ACL;")
+		case acl:
+			keep = strings.TrimSpace(line) == "" || strings.HasPrefix(line, "--")
+		case strings.HasPrefix(line, "COPY ") && strings.HasSuffix(strings.TrimRight(line, "\r\n"), " FROM stdin;"):
+			copying = true
+		case ownerStatement.MatchString(line):
+			keep = false
+		}
+		if keep {
+			if _, werr := out.WriteString(line); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return out.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sanityCheck compares the restored copy with the source: the same number of
+// tables, and every CriticalTables table present (both for complete dumps
+// only).
+func (m *Monitor) sanityCheck(host string, port int, scratch, dbName string, partial bool) error {
+	restored, err := m.openDBAt(host, port, scratch)
+	if err != nil {
+		return err
+	}
+	defer restored.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), sanityQueryTimeout)
+	defer cancel()
+
+	var got int
+	if err := restored.QueryRowContext(ctx, userRelationCountQuery).Scan(&got); err != nil {
+		return fmt.Errorf("sanity query: %v", err)
+	}
+
+	if !partial {
+		source, err := m.openDB(dbName)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		var want int
+		if err := source.QueryRowContext(ctx, userRelationCountQuery).Scan(&want); err != nil {
+			return fmt.Errorf("sanity query on %s: %v", dbName, err)
+		}
+		if got != want {
+			return fmt.Errorf("restored copy has %d tables, %s has %d", got, dbName, want)
+		}
+	}
+
+	if partial || dbName != m.config.DBName {
+		return nil
+	}
+	var missing []string
+	for _, table := range m.config.CriticalTables {
+		var present bool
+		if err := restored.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&present); err != nil || !present {
+			missing = append(missing, table)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("critical table(s) not readable in the restored copy: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
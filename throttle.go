@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const throttleChunk = 32 * 1024
+
+// uplink is shared by every upload, so parallel uploads to several
+// destinations stay within MaxUploadRateKBps together.
+var uplink rateLimiter
+
+// rateLimiter hands out transmission slots back to back: each write reserves
+// the time its bytes take at the configured rate and waits for it to pass.
+type rateLimiter struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (l *rateLimiter) wait(n int, bytesPerSecond int64) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / bytesPerSecond))
+	until := l.next
+	l.mu.Unlock()
+	time.Sleep(time.Until(until))
+}
+
+type throttledWriter struct {
+	w       io.Writer
+	limiter *rateLimiter
+	rate    int64
+}
+
+func (t throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > throttleChunk {
+			n = throttleChunk
+		}
+		t.limiter.wait(n, t.rate)
+		k, err := t.w.Write(p[:n])
+		written += k
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// feedThrottled runs an upload reading from stdin (curl -T -) and writes
+// the file to it through the uplink limiter. It returns curl's output.
+func (m *Monitor) feedThrottled(cmd *exec.Cmd, file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	_, copyErr := io.Copy(m.throttleUpload(stdin), f)
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		return output.Bytes(), err
+	}
+	return output.Bytes(), copyErr
+}
+
+// throttleUpload limits what is written to w to MaxUploadRateKBps, counted
+// together with every other upload; without a limit w is returned as is.
+func (m *Monitor) throttleUpload(w io.Writer) io.Writer {
+	if m.config.MaxUploadRateKBps <= 0 {
+		return w
+	}
+	return throttledWriter{w: w, limiter: &uplink, rate: int64(m.config.MaxUploadRateKBps) * 1024}
+}
+
+// limitRate applies a curl style --limit-rate ("500K", "2M") to a single
+// transfer written in Go, which curl's option can't reach.
+func limitRate(w io.Writer, rate string) io.Writer {
+	bytesPerSecond, ok := parseCurlRate(rate)
+	if !ok {
+		return w
+	}
+	return throttledWriter{w: w, limiter: &rateLimiter{}, rate: bytesPerSecond}
+}
+
+func parseCurlRate(rate string) (int64, bool) {
+	rate = strings.TrimSpace(rate)
+	if rate == "" {
+		return 0, false
+	}
+	multiplier := int64(1)
+	switch strings.ToUpper(rate[len(rate)-1:]) {
+	case "K":
+		multiplier = 1024
+	case "M":
+		multiplier = mb
+	case "G":
+		multiplier = gb
+	}
+	if multiplier > 1 {
+		rate = rate[:len(rate)-1]
+	}
+	n, err := strconv.ParseInt(rate, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n * multiplier, true
+}
@@ -0,0 +1,31 @@
+//go:build !headless
+
+package main
+
+import "github.com/getlantern/systray"
+
+// MenuItem is a tray menu entry; headless builds replace it with a stub.
+type MenuItem = systray.MenuItem
+
+// trayAvailable is false in headless builds, where nobody can click a menu
+// item to approve something.
+const trayAvailable = true
+
+type trayUI struct{}
+
+var tray trayUI
+
+func (trayUI) Run(onReady, onExit func()) { systray.Run(onReady, onExit) }
+func (trayUI) Quit()                      { systray.Quit() }
+func (trayUI) SetIcon(icon []byte)        { systray.SetIcon(platformIcon(icon)) }
+func (trayUI) SetTitle(title string)      { systray.SetTitle(title) }
+func (trayUI) SetTooltip(tooltip string)  { systray.SetTooltip(tooltip) }
+func (trayUI) AddSeparator()              { systray.AddSeparator() }
+
+func (trayUI) AddMenuItem(title, tooltip string) *MenuItem {
+	return systray.AddMenuItem(title, tooltip)
+}
+
+func (trayUI) AddMenuItemCheckbox(title, tooltip string, checked bool) *MenuItem {
+	return systray.AddMenuItemCheckbox(title, tooltip, checked)
+}
@@ -0,0 +1,72 @@
+//go:build headless
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// MenuItem stands in for the tray menu in headless builds: nothing is shown
+// and ClickedCh never fires, so the backup engine runs on its schedule and
+// is controlled through the HTTP API.
+type MenuItem struct {
+	ClickedCh chan struct{}
+	checked   bool
+}
+
+func newMenuItem(checked bool) *MenuItem {
+	return &MenuItem{ClickedCh: make(chan struct{}), checked: checked}
+}
+
+func (i *MenuItem) AddSubMenuItem(title, tooltip string) *MenuItem { return newMenuItem(false) }
+func (i *MenuItem) AddSubMenuItemCheckbox(title, tooltip string, checked bool) *MenuItem {
+	return newMenuItem(checked)
+}
+func (i *MenuItem) SetTitle(title string)     {}
+func (i *MenuItem) SetTooltip(tooltip string) {}
+func (i *MenuItem) SetIcon(icon []byte)       {}
+func (i *MenuItem) Disable()                  {}
+func (i *MenuItem) Enable()                   {}
+func (i *MenuItem) Hide()                     {}
+func (i *MenuItem) Show()                     {}
+func (i *MenuItem) Check()                    { i.checked = true }
+func (i *MenuItem) Uncheck()                  { i.checked = false }
+func (i *MenuItem) Checked() bool             { return i.checked }
+
+const trayAvailable = false
+
+type trayUI struct {
+	quit chan struct{}
+}
+
+var tray = trayUI{quit: make(chan struct{})}
+
+// Run calls onReady and blocks until Quit or SIGINT/SIGTERM.
+func (t trayUI) Run(onReady, onExit func()) {
+	log.Printf("Running headless")
+	onReady()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	select {
+	case s := <-sig:
+		log.Printf("Received %v, shutting down", s)
+	case <-t.quit:
+	}
+	onExit()
+}
+
+func (t trayUI) Quit()                   { close(t.quit) }
+func (trayUI) SetIcon(icon []byte)       {}
+func (trayUI) SetTitle(title string)     {}
+func (trayUI) SetTooltip(tooltip string) {}
+func (trayUI) AddSeparator()             {}
+
+func (trayUI) AddMenuItem(title, tooltip string) *MenuItem { return newMenuItem(false) }
+
+func (trayUI) AddMenuItemCheckbox(title, tooltip string, checked bool) *MenuItem {
+	return newMenuItem(checked)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"time"
+)
+
+const (
+	iconModeConnection = "connection"
+	iconModeBackupAge  = "backup-age"
+
+	defaultBackupAgeWarnHours     = 24
+	defaultBackupAgeCriticalHours = 72
+	iconSize                      = 16
+)
+
+var (
+	ageGreen  = color.RGBA{0x2e, 0xa0, 0x43, 0xff}
+	ageYellow = color.RGBA{0xe3, 0xb3, 0x41, 0xff}
+	ageRed    = color.RGBA{0xd7, 0x3a, 0x49, 0xff}
+)
+
+// refreshIcon sets the tray icon. In backup-age mode the color follows the
+// age of the last successful backup and a lost connection only hollows the
+// circle out, since backup freshness matters more than connectivity.
+func (m *Monitor) refreshIcon() {
+	if m.config.IconMode != iconModeBackupAge {
+		tray.SetIcon(getIcon(m.isConnected))
+		return
+	}
+	tray.SetIcon(circleIcon(m.backupAgeColor(), !m.isConnected))
+}
+
+func (m *Monitor) backupAgeColor() color.RGBA {
+	warn := time.Duration(m.config.BackupAgeWarnHours) * time.Hour
+	if warn <= 0 {
+		warn = defaultBackupAgeWarnHours * time.Hour
+	}
+	crit := time.Duration(m.config.BackupAgeCriticalHours) * time.Hour
+	if crit <= 0 {
+		crit = defaultBackupAgeCriticalHours * time.Hour
+	}
+
+	age := time.Since(m.lastBackupTime)
+	switch {
+	case m.lastBackupTime.IsZero() || age >= crit:
+		return ageRed
+	case age >= warn:
+		return ageYellow
+	default:
+		return ageGreen
+	}
+}
+
+// seedLastBackup takes the last successful backup from the catalog, so the
+// icon's age and "Last Backup" survive restarts.
+func (m *Monitor) seedLastBackup() {
+	entries, err := loadCatalog()
+	if err != nil {
+		log.Printf("Catalog unreadable, backup age unknown: %v", err)
+		return
+	}
+	for _, e := range entries {
+		if e.Success && e.Finished.After(m.lastBackupTime) {
+			m.lastBackupTime = e.Finished
+			m.lastBackupStatus = e.Status
+		}
+	}
+}
+
+func circleIcon(fill color.RGBA, hollow bool) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, iconSize, iconSize))
+	c := float64(iconSize-1) / 2
+	r := c
+	for y := 0; y < iconSize; y++ {
+		for x := 0; x < iconSize; x++ {
+			dx, dy := float64(x)-c, float64(y)-c
+			d := dx*dx + dy*dy
+			if d > r*r || (hollow && d < (r-3)*(r-3)) {
+				continue
+			}
+			img.Set(x, y, fill)
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
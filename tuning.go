@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tuningReportFile     = "tuning-report.txt"
+	defaultTuningHours   = 24
+	maxTuningHintItems   = 8
+	tuningQueryTimeout   = 15 * time.Second
+	tuningInitialDelay   = 2 * time.Minute
+	mb                   = 1024 * 1024
+	gb                   = 1024 * mb
+	defaultSharedBuffers = 128 * mb
+)
+
+var tuningSettings = []string{
+	"shared_buffers",
+	"effective_cache_size",
+	"work_mem",
+	"maintenance_work_mem",
+	"max_wal_size",
+	"max_connections",
+	"autovacuum",
+	"autovacuum_vacuum_scale_factor",
+	"autovacuum_max_workers",
+}
+
+type tuningHint struct {
+	Setting string
+	Current string
+	Advice  string
+}
+
+func (m *Monitor) tuningLoop() {
+	interval := time.Duration(m.config.TuningReportHours) * time.Hour
+	if interval <= 0 {
+		interval = defaultTuningHours * time.Hour
+	}
+
+	time.Sleep(tuningInitialDelay)
+	for {
+		m.runTuningReport()
+		time.Sleep(interval)
+	}
+}
+
+func (m *Monitor) runTuningReport() {
+	hints, err := m.collectTuningHints()
+	if err != nil {
+		log.Printf("Tuning report failed: %v", err)
+		m.tuningItem.SetTitle("Tuning Hints: unavailable")
+		return
+	}
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "PostgreSQL tuning hints for %s:%d (%s)\n", m.config.Host, m.config.Port, time.Now().Format("2006-01-02 15:04"))
+	fmt.Fprintf(&report, "These are informational heuristics, not a replacement for proper benchmarking.\n\n")
+	for _, h := range hints {
+		fmt.Fprintf(&report, "- %s (current: %s)\n  %s\n", h.Setting, h.Current, h.Advice)
+	}
+	if len(hints) == 0 {
+		report.WriteString("No suggestions - settings look reasonable for this server.\n")
+	}
+	if err := os.WriteFile(tuningReportFile, []byte(report.String()), 0644); err != nil {
+		log.Printf("Failed to write tuning report: %v", err)
+	}
+
+	log.Printf("Tuning report: %d hint(s), written to %s", len(hints), tuningReportFile)
+	m.tuningItem.SetTitle(fmt.Sprintf("Tuning Hints: %d", len(hints)))
+	for i, item := range m.tuningHintItems {
+		if i < len(hints) {
+			item.SetTitle(fmt.Sprintf("%s: %s", hints[i].Setting, hints[i].Advice))
+			item.Show()
+		} else {
+			item.Hide()
+		}
+	}
+}
+
+func (m *Monitor) collectTuningHints() ([]tuningHint, error) {
+	db, err := m.openDB(m.config.DBName)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), tuningQueryTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT name, setting, COALESCE(unit, '') FROM pg_settings WHERE name = ANY(string_to_array($1, ','))",
+		strings.Join(tuningSettings, ","))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	raw := make(map[string]string)
+	bytes := make(map[string]int64)
+	for rows.Next() {
+		var name, setting, unit string
+		if err := rows.Scan(&name, &setting, &unit); err != nil {
+			return nil, err
+		}
+		raw[name] = setting
+		if b, ok := settingBytes(setting, unit); ok {
+			bytes[name] = b
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var clusterSize int64
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(sum(pg_database_size(oid)), 0) FROM pg_database").Scan(&clusterSize); err != nil {
+		log.Printf("Tuning report: could not determine cluster size: %v", err)
+	}
+
+	ram := m.serverMemory()
+	return tuningHintsFor(raw, bytes, ram, clusterSize), nil
+}
+
+// serverMemory returns the configured RAM of the database server, or the
+// local machine's RAM when the monitored server runs on this host.
+func (m *Monitor) serverMemory() int64 {
+	if m.config.ServerMemoryMB > 0 {
+		return int64(m.config.ServerMemoryMB) * mb
+	}
+	if isLocalHost(m.config.Host) {
+		if ram, err := totalMemory(); err == nil {
+			return ram
+		}
+	}
+	return 0
+}
+
+func isLocalHost(host string) bool {
+	if host == "" || host == "localhost" || strings.HasPrefix(host, "/") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// tuningHintsFor applies simple rules of thumb. ram and clusterSize may be 0
+// when unknown, in which case the rules depending on them are skipped.
+func tuningHintsFor(raw map[string]string, bytes map[string]int64, ram, clusterSize int64) []tuningHint {
+	var hints []tuningHint
+	add := func(setting, advice string) {
+		current := raw[setting]
+		if b, ok := bytes[setting]; ok {
+			current = formatBytes(b)
+		}
+		hints = append(hints, tuningHint{Setting: setting, Current: current, Advice: advice})
+	}
+
+	if ram > 0 {
+		if sb := bytes["shared_buffers"]; sb > 0 && sb < ram/8 {
+			add("shared_buffers", fmt.Sprintf("consider ~25%% of RAM (%s)", formatBytes(ram/4)))
+		}
+		if ecs := bytes["effective_cache_size"]; ecs > 0 && ecs < ram/2 {
+			add("effective_cache_size", fmt.Sprintf("consider ~75%% of RAM (%s)", formatBytes(ram*3/4)))
+		}
+		if mwm := bytes["maintenance_work_mem"]; mwm > 0 && mwm <= 64*mb && ram >= 4*gb {
+			add("maintenance_work_mem", fmt.Sprintf("consider %s for faster VACUUM and index builds", formatBytes(min64(ram/16, 1*gb))))
+		}
+		maxConns, _ := strconv.ParseInt(raw["max_connections"], 10, 64)
+		if wm := bytes["work_mem"]; wm > 0 && wm <= 4*mb && maxConns > 0 && ram >= 8*gb {
+			suggested := min64(max64(ram/(maxConns*4), 8*mb), 64*mb)
+			if suggested > wm {
+				add("work_mem", fmt.Sprintf("consider %s (RAM / (max_connections * 4))", formatBytes(suggested)))
+			}
+		}
+	} else if sb := bytes["shared_buffers"]; sb > 0 && sb <= defaultSharedBuffers && clusterSize > 1*gb {
+		add("shared_buffers", "still at the 128 MB default; set ServerMemoryMB for a sized suggestion")
+	}
+
+	if clusterSize > 10*gb {
+		if mws := bytes["max_wal_size"]; mws > 0 && mws <= 1*gb {
+			add("max_wal_size", "consider 4 GB or more to reduce checkpoint frequency on larger databases")
+		}
+	}
+
+	if raw["autovacuum"] == "off" {
+		add("autovacuum", "autovacuum is disabled - tables will bloat and risk transaction ID wraparound")
+	}
+	if clusterSize > 50*gb && raw["autovacuum_vacuum_scale_factor"] == "0.2" {
+		add("autovacuum_vacuum_scale_factor", "consider 0.05 so large tables are vacuumed before bloating")
+	}
+
+	return hints
+}
+
+// settingBytes converts a pg_settings value with a memory unit (e.g. "8kB")
+// to bytes.
+func settingBytes(setting, unit string) (int64, bool) {
+	if unit == "" {
+		return 0, false
+	}
+	value, err := strconv.ParseInt(setting, 10, 64)
+	if err != nil || value < 0 {
+		return 0, false
+	}
+
+	multiplier := int64(1)
+	numeric := strings.TrimRightFunc(unit, func(r rune) bool { return r < '0' || r > '9' })
+	if numeric != "" {
+		multiplier, _ = strconv.ParseInt(numeric, 10, 64)
+	}
+	switch strings.TrimPrefix(unit, numeric) {
+	case "B":
+	case "kB":
+		multiplier *= 1024
+	case "MB":
+		multiplier *= mb
+	case "GB":
+		multiplier *= gb
+	default:
+		return 0, false
+	}
+	return value * multiplier, true
+}
+
+func formatBytes(b int64) string {
+	switch {
+	case b >= gb:
+		return fmt.Sprintf("%.1f GB", float64(b)/gb)
+	case b >= mb:
+		return fmt.Sprintf("%d MB", b/mb)
+	default:
+		return fmt.Sprintf("%d kB", b/1024)
+	}
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func (m *Monitor) addTuningMenu() {
+	m.tuningItem = tray.AddMenuItem("Tuning Hints: -", "Configuration suggestions (see tuning-report.txt)")
+	for i := 0; i < maxTuningHintItems; i++ {
+		item := m.tuningItem.AddSubMenuItem("", "")
+		item.Disable()
+		item.Hide()
+		m.tuningHintItems = append(m.tuningHintItems, item)
+	}
+}
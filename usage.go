@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ProcessUsage is what one child process of a backup run consumed, recorded
+// in the manifest for capacity planning. Counters the platform does not
+// report stay zero.
+type ProcessUsage struct {
+	Process       string
+	UserSeconds   float64
+	SystemSeconds float64
+	PeakMemory    int64 // bytes (peak RSS / peak working set)
+	ReadBytes     int64 `json:",omitempty"`
+	WriteBytes    int64 `json:",omitempty"`
+}
+
+// runMeasured runs cmd like cmd.Run and returns its resource usage.
+func runMeasured(cmd *exec.Cmd) (ProcessUsage, error) {
+	if err := cmd.Start(); err != nil {
+		return ProcessUsage{}, err
+	}
+	probe := openUsageProbe(cmd.Process.Pid)
+	err := cmd.Wait()
+	return probe.collect(cmd), err
+}
+
+func logUsage(usage []ProcessUsage) {
+	for _, u := range usage {
+		log.Printf("Resource usage of %s: %.1fs user, %.1fs system, peak %s, read %s, written %s",
+			u.Process, u.UserSeconds, u.SystemSeconds, formatBytes(u.PeakMemory), formatBytes(u.ReadBytes), formatBytes(u.WriteBytes))
+	}
+}
+
+func processName(cmd *exec.Cmd) string {
+	return strings.TrimSuffix(filepath.Base(cmd.Path), ".exe")
+}
+
+func baseUsage(cmd *exec.Cmd) ProcessUsage {
+	u := ProcessUsage{Process: processName(cmd)}
+	if state := cmd.ProcessState; state != nil {
+		u.UserSeconds = state.UserTime().Seconds()
+		u.SystemSeconds = state.SystemTime().Seconds()
+	}
+	return u
+}
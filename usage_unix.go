@@ -0,0 +1,36 @@
+//go:build !windows
+
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// On Unix everything comes from the rusage returned by wait4.
+type usageProbe struct{}
+
+func openUsageProbe(pid int) usageProbe {
+	return usageProbe{}
+}
+
+func (usageProbe) collect(cmd *exec.Cmd) ProcessUsage {
+	u := baseUsage(cmd)
+	if cmd.ProcessState == nil {
+		return u
+	}
+	ru, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return u
+	}
+	if runtime.GOOS == "darwin" {
+		u.PeakMemory = int64(ru.Maxrss)
+	} else {
+		// Linux reports max RSS in KB and block I/O in 512-byte units
+		u.PeakMemory = int64(ru.Maxrss) * 1024
+		u.ReadBytes = int64(ru.Inblock) * 512
+		u.WriteBytes = int64(ru.Oublock) * 512
+	}
+	return u
+}
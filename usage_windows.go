@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	processQueryLimitedInformation = 0x1000
+	processVMRead                  = 0x0010
+)
+
+type processMemoryCounters struct {
+	Cb                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+type ioCounters struct {
+	ReadOperationCount  uint64
+	WriteOperationCount uint64
+	OtherOperationCount uint64
+	ReadTransferCount   uint64
+	WriteTransferCount  uint64
+	OtherTransferCount  uint64
+}
+
+// usageProbe keeps a handle to the child so its memory and I/O counters can
+// still be read after it exits.
+type usageProbe struct {
+	handle syscall.Handle
+}
+
+func openUsageProbe(pid int) usageProbe {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation|processVMRead, false, uint32(pid))
+	if err != nil {
+		return usageProbe{}
+	}
+	return usageProbe{handle: h}
+}
+
+func (p usageProbe) collect(cmd *exec.Cmd) ProcessUsage {
+	u := baseUsage(cmd)
+	if p.handle == 0 {
+		return u
+	}
+	defer syscall.CloseHandle(p.handle)
+
+	kernel32 := syscall.NewLazyDLL("kernel32.dll")
+	mem := processMemoryCounters{Cb: uint32(unsafe.Sizeof(processMemoryCounters{}))}
+	if ret, _, _ := kernel32.NewProc("K32GetProcessMemoryInfo").Call(uintptr(p.handle), uintptr(unsafe.Pointer(&mem)), uintptr(mem.Cb)); ret != 0 {
+		u.PeakMemory = int64(mem.PeakWorkingSetSize)
+	}
+	var io ioCounters
+	if ret, _, _ := kernel32.NewProc("GetProcessIoCounters").Call(uintptr(p.handle), uintptr(unsafe.Pointer(&io))); ret != 0 {
+		u.ReadBytes = int64(io.ReadTransferCount)
+		u.WriteBytes = int64(io.WriteTransferCount)
+	}
+	return u
+}
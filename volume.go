@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	volumeWarn   = "warn"
+	volumeRefuse = "refuse"
+	volumeOff    = "off"
+
+	eventBackupVolume = "backup_on_data_volume"
+)
+
+var volumeWarnOnce sync.Once
+
+// checkBackupVolume guards against dumping onto the data volume of a local
+// server: filling it up takes the database down. It returns an error only
+// when BackupVolumePolicy is "refuse".
+func (m *Monitor) checkBackupVolume(backupDir string) error {
+	if m.config.BackupVolumePolicy == volumeOff || !isLocalHost(m.config.Host) {
+		return nil
+	}
+
+	dataDir, err := m.dataDirectory()
+	if err != nil {
+		log.Printf("Backup volume check skipped: %v", err)
+		return nil
+	}
+	same, err := sameFilesystem(backupDir, dataDir)
+	if err != nil {
+		log.Printf("Backup volume check skipped: %v", err)
+		return nil
+	}
+	if !same {
+		return nil
+	}
+
+	abs, _ := filepath.Abs(backupDir)
+	msg := fmt.Sprintf("backup directory %s is on the same filesystem as PGDATA (%s)", abs, dataDir)
+	if m.config.BackupVolumePolicy == volumeRefuse {
+		m.notify(Notification{Event: eventBackupVolume, Severity: severityCritical, Title: "Backup refused", Message: msg, Database: m.config.DBName})
+		return fmt.Errorf("%s; refusing per BackupVolumePolicy", msg)
+	}
+
+	log.Printf("WARNING: %s", msg)
+	volumeWarnOnce.Do(func() {
+		m.notify(Notification{Event: eventBackupVolume, Severity: severityWarning, Title: "Backups on the data volume", Message: msg, Database: m.config.DBName})
+	})
+	return nil
+}
+
+// dataDirectory needs superuser or pg_read_all_settings.
+func (m *Monitor) dataDirectory() (string, error) {
+	db, err := m.openDB(m.config.DBName)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), connTimeout)
+	defer cancel()
+
+	var dir string
+	err = db.QueryRowContext(ctx, "SHOW data_directory").Scan(&dir)
+	return dir, err
+}
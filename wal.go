@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	walSubfolder        = "wal"
+	walArchiveManifest  = "wal-archive.json"
+	defaultWALSlot      = "pg_monitor_wal"
+	defaultWALRetention = 7
+	walScanInterval     = time.Minute
+	walRestartDelay     = 30 * time.Second
+	walHealthyRun       = 5 * time.Minute
+	walCompressLevel    = "5"
+
+	eventWALArchiveFailed = "wal_archive_failed"
+)
+
+// Completed segments and timeline history files; pg_receivewal writes the
+// segment in progress as .partial and renames it when it is complete.
+var walFilePattern = regexp.MustCompile(`^([0-9A-F]{8})([0-9A-F]{16})(\.gz)?$|^[0-9A-F]{8}\.history$`)
+
+// WALSegment is one archived WAL file.
+type WALSegment struct {
+	Name     string
+	Timeline string
+	Size     int64
+	Archived time.Time
+	Uploaded []string `json:",omitempty"` // destinations holding a copy
+
+	// How the uploaded copies are encrypted, fixed by the first upload
+	RemoteName string `json:",omitempty"` // opaque object name with UntrustedRemote
+	PGP        bool   `json:",omitempty"` // OpenPGP layer of PGPEncryptBackups
+	Encrypted  bool   `json:",omitempty"` // uploaded as .enc with EncryptBackups
+}
+
+// remoteObject returns the name the segment has on the destinations.
+func (s WALSegment) remoteObject() string {
+	if s.RemoteName != "" {
+		return s.RemoteName
+	}
+	name := s.Name
+	if s.PGP {
+		name += pgpExt
+	}
+	if s.Encrypted {
+		name += encryptedExt
+	}
+	return name
+}
+
+// WALArchive is the archive manifest: every segment received, in order, so
+// a point-in-time restore can tell which stretch of WAL is available.
+type WALArchive struct {
+	Timeline string // timeline of the newest segment
+	Segments []WALSegment
+}
+
+var walMu sync.Mutex
+
+func walDir() string {
+	return filepath.Join(".", "backups", walSubfolder)
+}
+
+func loadWALArchive() WALArchive {
+	var archive WALArchive
+	if data, err := os.ReadFile(filepath.Join(walDir(), walArchiveManifest)); err == nil {
+		if err := json.Unmarshal(data, &archive); err != nil {
+			log.Printf("Ignoring unreadable %s: %v", walArchiveManifest, err)
+		}
+	}
+	return archive
+}
+
+func saveWALArchive(archive WALArchive) {
+	data, _ := json.MarshalIndent(archive, "", "  ")
+	if err := os.WriteFile(filepath.Join(walDir(), walArchiveManifest), data, 0644); err != nil {
+		log.Printf("Failed to write %s: %v", walArchiveManifest, err)
+	}
+}
+
+// walArchiveLoop runs pg_receivewal on a replication slot, so no WAL is lost
+// while the app isn't running, and restarts it when it exits.
+func (m *Monitor) walArchiveLoop() {
+	if err := os.MkdirAll(walDir(), 0755); err != nil {
+		log.Printf("WAL archiving disabled: %v", err)
+		return
+	}
+	go m.walShipLoop()
+
+	// One notification per outage: a run that lasted a while means the
+	// previous outage was over
+	notified := false
+	for {
+		started := time.Now()
+		err := m.receiveWAL()
+		if time.Since(started) > walHealthyRun {
+			notified = false
+		}
+		log.Printf("pg_receivewal stopped: %v", err)
+		if !notified {
+			notified = true
+			m.notify(Notification{
+				Event:    eventWALArchiveFailed,
+				Severity: severityCritical,
+				Title:    "WAL archiving interrupted",
+				Message:  fmt.Sprintf("pg_receivewal stopped: %v; restarting every %v", err, walRestartDelay),
+			})
+		}
+		time.Sleep(walRestartDelay)
+	}
+}
+
+func (m *Monitor) walSlot() string {
+	if m.config.WALSlot != "" {
+		return m.config.WALSlot
+	}
+	return defaultWALSlot
+}
+
+func (m *Monitor) receiveWAL() error {
+	conn := []string{
+		"-h", m.config.Host,
+		"-p", fmt.Sprintf("%d", m.config.Port),
+		"-U", m.config.User,
+		"--slot", m.walSlot(),
+	}
+	env := append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", m.config.Password))
+
+	create := exec.Command(pgTool("pg_receivewal"), append(conn, "--create-slot", "--if-not-exists")...)
+	create.Env = env
+	if err := runLogged(create, "pg_receivewal --create-slot"); err != nil {
+		return err
+	}
+
+	args := append(conn, "-D", walDir(), "--no-loop", "--synchronous")
+	if m.config.WALCompress {
+		args = append(args, "-Z", walCompressLevel)
+	}
+	cmd := exec.Command(pgTool("pg_receivewal"), args...)
+	cmd.Env = env
+	log.Printf("WAL archiving to %s (slot %s)", walDir(), m.walSlot())
+	return runLogged(cmd, "pg_receivewal")
+}
+
+// walShipLoop records completed segments in the archive manifest, uploads
+// them and prunes local copies.
+func (m *Monitor) walShipLoop() {
+	for {
+		m.shipWAL()
+		time.Sleep(walScanInterval)
+	}
+}
+
+func (m *Monitor) shipWAL() {
+	walMu.Lock()
+	defer walMu.Unlock()
+
+	archive := loadWALArchive()
+	known := make(map[string]bool)
+	for _, s := range archive.Segments {
+		known[s.Name] = true
+	}
+
+	entries, err := os.ReadDir(walDir())
+	if err != nil {
+		log.Printf("WAL scan failed: %v", err)
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		match := walFilePattern.FindStringSubmatch(name)
+		if match == nil || known[name] {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		s := WALSegment{Name: name, Timeline: name[:8], Size: info.Size(), Archived: info.ModTime()}
+		archive.Segments = append(archive.Segments, s)
+		if match[1] != "" {
+			archive.Timeline = s.Timeline
+		}
+	}
+	sort.Slice(archive.Segments, func(i, j int) bool { return archive.Segments[i].Name < archive.Segments[j].Name })
+
+	dests := m.destinations()
+	if !m.config.UploadToCloud {
+		dests = nil
+	}
+	for i := range archive.Segments {
+		s := &archive.Segments[i]
+		path := filepath.Join(walDir(), s.Name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		var pending []Destination
+		for _, d := range dests {
+			if !containsString(s.Uploaded, d.Name) {
+				pending = append(pending, d)
+			}
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		upload, temp, err := m.encryptWAL(s, path)
+		if err != nil {
+			log.Printf("WAL encryption of %s failed, retrying: %v", s.Name, err)
+			continue
+		}
+		for _, d := range pending {
+			if err := m.uploadWAL(d, upload); err != nil {
+				log.Printf("WAL upload of %s to %s failed, retrying: %v", s.Name, d.Name, err)
+				continue
+			}
+			s.Uploaded = append(s.Uploaded, d.Name)
+		}
+		removeFiles(temp)
+	}
+	saveWALArchive(archive)
+	m.pruneLocalWAL(archive, dests)
+
+	if m.walItem != nil && len(archive.Segments) > 0 {
+		last := archive.Segments[len(archive.Segments)-1]
+		m.walItem.SetTitle(fmt.Sprintf("WAL Archive: %s (%s)", strings.TrimSuffix(last.Name, ".gz"), last.Archived.Format("15:04")))
+	}
+}
+
+// encryptWAL prepares a segment for upload the way uploadFiles prepares a
+// dump: with PGPEncryptBackups' OpenPGP layer, then as an opaque blob with
+// UntrustedRemote or as .enc with EncryptBackups. The layers are chosen when
+// no destination has the segment yet and kept after that, so every
+// destination holds the same object. temp lists the encrypted copies to
+// remove after the uploads.
+func (m *Monitor) encryptWAL(s *WALSegment, path string) (upload string, temp []string, err error) {
+	if len(s.Uploaded) == 0 {
+		s.PGP = m.config.PGPEncryptBackups
+		s.Encrypted = m.config.EncryptBackups && !m.config.UntrustedRemote
+		s.RemoteName = ""
+		if m.config.UntrustedRemote {
+			if s.RemoteName, err = opaqueName(); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	defer func() {
+		if err != nil {
+			removeFiles(temp)
+		}
+	}()
+
+	upload = path
+	if s.PGP {
+		if m.config.PGPRecipient == "" {
+			return "", nil, fmt.Errorf("PGPEncryptBackups requires PGPRecipient")
+		}
+		encrypted := path + pgpExt
+		if err := m.encryptOpenPGP(upload, encrypted); err != nil {
+			return "", nil, err
+		}
+		temp = append(temp, encrypted)
+		upload = encrypted
+	}
+	switch {
+	case s.RemoteName != "":
+		blob := filepath.Join(filepath.Dir(path), s.RemoteName)
+		if err := m.encryptOpenPGP(upload, blob); err != nil {
+			return "", temp, err
+		}
+		temp = append(temp, blob)
+		upload = blob
+	case s.Encrypted:
+		encrypted, err := m.encryptForUpload(upload)
+		if err != nil {
+			return "", temp, err
+		}
+		temp = append(temp, encrypted)
+		upload = encrypted
+	}
+	return upload, temp, nil
+}
+
+func removeFiles(files []string) {
+	for _, f := range files {
+		os.Remove(f)
+	}
+}
+
+// uploadWAL puts a segment into the wal/ folder of a destination.
+func (m *Monitor) uploadWAL(d Destination, path string) error {
+	if err := makeRemoteFolder(d, walSubfolder+"/"); err != nil {
+		return err
+	}
+	return m.uploadTo(d.subfolder(walSubfolder), path)
+}
+
+// makeRemoteFolder creates a folder below the destination with MKCOL, which
+// Nextcloud's chunked uploads need before assembling into it; one that
+// already exists is fine. Object storage has no folders, only key prefixes,
+// and SFTP and FTP uploads create missing folders themselves.
+func makeRemoteFolder(d Destination, name string) error {
+	if !d.isWebDAV() {
+		return nil
+	}
+	return davMkcolAll(d, d.URL+name)
+}
+
+// pruneLocalWAL removes local segments older than WALRetentionDays once every
+// destination has them. They stay in the archive manifest, which describes
+// the remote archive too.
+func (m *Monitor) pruneLocalWAL(archive WALArchive, dests []Destination) {
+	days := m.config.WALRetentionDays
+	if days <= 0 {
+		days = defaultWALRetention
+	}
+	if len(dests) == 0 {
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+	for _, s := range archive.Segments {
+		if s.Archived.After(cutoff) || len(s.Uploaded) < len(dests) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(walDir(), s.Name)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to prune WAL segment %s: %v", s.Name, err)
+		}
+	}
+}
+
+func (m *Monitor) addWALMenu() {
+	m.walItem = tray.AddMenuItem("WAL Archive: -", "Newest archived WAL segment")
+	m.walItem.Disable()
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
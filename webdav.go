@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	davUploadAttempts = 3
+	davRetryDelay     = 10 * time.Second // doubled after every failed attempt
+	davFolderTimeout  = 60 * time.Second
+
+	propfindBody = `<?xml version="1.0"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:getlastmodified/><d:getcontentlength/><d:resourcetype/></d:prop></d:propfind>`
+)
+
+// davClient has no overall timeout: a large backup can take hours to send
+// on a slow uplink. Only waiting for the answer is bounded.
+var davClient = &http.Client{Transport: &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	ResponseHeaderTimeout: chunkRequestTimeout,
+	TLSHandshakeTimeout:   30 * time.Second,
+	IdleConnTimeout:       90 * time.Second,
+}}
+
+// davURL is the URL of name in d's folder. Names are escaped everywhere, so
+// a backup uploaded with a space, "#" or "%" in its name can also be read,
+// listed and deleted.
+func davURL(d Destination, name string) string {
+	return d.URL + url.PathEscape(name)
+}
+
+// davAuthError is a 401 or 403 answer: the credentials were rejected.
+type davAuthError struct{ Status string }
+
+func (e davAuthError) Error() string {
+	return e.Status + ": credentials rejected, check User/Pass (an app password with 2FA)"
+}
+
+// davQuotaError is a 507 answer: the destination is full.
+type davQuotaError struct{ Status string }
+
+func (e davQuotaError) Error() string {
+	return e.Status + ": destination quota exceeded"
+}
+
+// davError explains the answers a WebDAV upload can't recover from by
+// itself.
+func davError(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return davAuthError{Status: resp.Status}
+	case http.StatusInsufficientStorage:
+		return davQuotaError{Status: resp.Status}
+	}
+	return davStatusError{Method: resp.Request.Method, Status: resp.Status, Code: resp.StatusCode}
+}
+
+// davRetryable reports whether another attempt may succeed: the connection
+// failed, or the server was busy or broken for a moment.
+func davRetryable(err error) bool {
+	var auth davAuthError
+	var quota davQuotaError
+	if errors.As(err, &auth) || errors.As(err, &quota) {
+		return false
+	}
+	var s davStatusError
+	if !errors.As(err, &s) {
+		return true
+	}
+	return s.Code >= 500 || s.Code == http.StatusRequestTimeout || s.Code == http.StatusTooManyRequests
+}
+
+// uploadWebDAV PUTs a file, streamed from disk through the upload limits.
+// A missing target folder is created with MKCOL; connection errors and
+// server errors are retried with a growing delay before the upload is left
+// to the spool.
+func (m *Monitor) uploadWebDAV(d Destination, filePath, rate string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	target := davURL(d, filepath.Base(filePath))
+
+	delay := davRetryDelay
+	createdFolder := false
+	for attempt := 1; ; attempt++ {
+		err = m.davPut(d, target, io.NewSectionReader(f, 0, info.Size()), info.Size(), rate)
+		if (isStatus(err, http.StatusNotFound) || isStatus(err, http.StatusConflict)) && !createdFolder {
+			createdFolder = true
+			if err = davMkcolAll(d, d.URL); err == nil {
+				log.Printf("Created folder %s", d.URL)
+				attempt--
+				continue
+			}
+		}
+		if err == nil || attempt >= davUploadAttempts || !davRetryable(err) {
+			return err
+		}
+		log.Printf("Upload to %s failed (attempt %d of %d), retrying in %v: %v", d.Name, attempt, davUploadAttempts, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (m *Monitor) davPut(d Destination, target string, r io.Reader, size int64, rate string) error {
+	body := m.chunkBody(r, rate)
+	defer body.Close()
+	req, err := http.NewRequest(http.MethodPut, target, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.SetBasicAuth(d.User, d.Pass)
+
+	resp, err := davClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 300 {
+		return davError(resp)
+	}
+	return nil
+}
+
+// davMkcolAll creates folder and, when the server answers 409 Conflict, its
+// missing parents first. A folder that already exists is fine.
+func davMkcolAll(d Destination, folder string) error {
+	req, err := http.NewRequest("MKCOL", strings.TrimSuffix(folder, "/"), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(d.User, d.Pass)
+	client := &http.Client{Timeout: davFolderTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300, resp.StatusCode == http.StatusMethodNotAllowed:
+		return nil
+	case resp.StatusCode == http.StatusConflict:
+		u, err := url.Parse(strings.TrimSuffix(folder, "/"))
+		if err != nil || u.Path == "" || u.Path == "/" {
+			return davError(resp)
+		}
+		u.Path = u.Path[:strings.LastIndex(u.Path, "/")+1]
+		u.RawPath = ""
+		if err := davMkcolAll(d, u.String()); err != nil {
+			return err
+		}
+		return davMkcolAll(d, folder)
+	}
+	return davError(resp)
+}
+
+// davWriter streams into a PUT with chunked transfer encoding. Aborting
+// breaks the request off, so the server discards what it got.
+type davWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (m *Monitor) newDAVWriter(d Destination, name string) (*davWriter, error) {
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPut, davURL(d, name), pr)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = -1
+	req.SetBasicAuth(d.User, d.Pass)
+
+	w := &davWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		resp, err := davClient.Do(req)
+		if err == nil {
+			if resp.StatusCode >= 300 {
+				err = davError(resp)
+			}
+			resp.Body.Close()
+		}
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+func (w *davWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *davWriter) Finish() error {
+	w.pw.Close()
+	return <-w.done
+}
+
+func (w *davWriter) Abort() {
+	w.pw.CloseWithError(fmt.Errorf("upload aborted"))
+	<-w.done
+}
+
+type davMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				LastModified  string `xml:"getlastmodified"`
+				ContentLength int64  `xml:"getcontentlength"`
+				ResourceType  struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// listWebDAV lists the files in a destination folder with a depth-1
+// PROPFIND.
+func listWebDAV(d Destination) ([]remoteObject, error) {
+	req, err := http.NewRequest("PROPFIND", d.URL, strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(d.User, d.Pass)
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	client := &http.Client{Timeout: remoteListTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND %s: %s", d.URL, resp.Status)
+	}
+
+	var ms davMultistatus
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("PROPFIND %s: %v", d.URL, err)
+	}
+
+	var objects []remoteObject
+	for _, r := range ms.Responses {
+		href, err := url.PathUnescape(r.Href)
+		if err != nil || strings.HasSuffix(href, "/") {
+			continue
+		}
+		o := remoteObject{Name: path.Base(href)}
+		collection := false
+		for _, ps := range r.Propstat {
+			if ps.Prop.ResourceType.Collection != nil {
+				collection = true
+			}
+			if ps.Prop.LastModified != "" {
+				o.Modified, _ = http.ParseTime(ps.Prop.LastModified)
+			}
+			if ps.Prop.ContentLength > 0 {
+				o.Size = ps.Prop.ContentLength
+			}
+		}
+		if !collection {
+			objects = append(objects, o)
+		}
+	}
+	return objects, nil
+}
+
+func deleteWebDAV(d Destination, name string) error {
+	req, err := http.NewRequest(http.MethodDelete, davURL(d, name), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(d.User, d.Pass)
+
+	client := &http.Client{Timeout: remoteDeleteTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("DELETE %s: %s", name, resp.Status)
+	}
+	return nil
+}
+
+func openWebDAV(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, davURL(d, name), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.SetBasicAuth(d.User, d.Pass)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, 0, &os.PathError{Op: "GET", Path: name, Err: os.ErrNotExist}
+	case resp.StatusCode >= 300:
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("GET %s: %s", name, resp.Status)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// testWebDAVUpload PUTs a small file and deletes it again, explaining the
+// usual rejections.
+func testWebDAVUpload(d Destination) (string, error) {
+	if !strings.HasSuffix(d.URL, "/") {
+		return "", fmt.Errorf("destination URL must end with '/'")
+	}
+
+	name := fmt.Sprintf("pg-monitor-test-%s.txt", time.Now().Format("20060102_150405"))
+	url := davURL(d, name)
+	client := &http.Client{Timeout: diagnosticTimeout}
+
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader([]byte("pg-monitor upload test\n")))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(d.User, d.Pass)
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("cannot reach destination: %v", err)
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return "", fmt.Errorf("401 Unauthorized: check user / password (app password?)")
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusConflict:
+		return "", fmt.Errorf("%s: target folder does not exist", resp.Status)
+	case resp.StatusCode == http.StatusInsufficientStorage:
+		return "", fmt.Errorf("507 Insufficient Storage: destination quota exceeded")
+	case resp.StatusCode >= 300:
+		return "", fmt.Errorf("upload rejected: %s", resp.Status)
+	}
+	elapsed := time.Since(start)
+
+	req, _ = http.NewRequest(http.MethodDelete, url, nil)
+	req.SetBasicAuth(d.User, d.Pass)
+	if resp, err := client.Do(req); err != nil || resp.StatusCode >= 300 {
+		log.Printf("Test upload: could not delete %s", url)
+		if resp != nil {
+			resp.Body.Close()
+		}
+	} else {
+		resp.Body.Close()
+	}
+
+	return fmt.Sprintf("%s: uploaded and deleted %s in %v", d.Name, name, elapsed.Round(time.Millisecond)), nil
+}
+
+// webdavBackend stores WebDAV (the default) destinations.
+type webdavBackend struct{}
+
+func init() {
+	registerStorageBackend(destinationWebDAV, webdavBackend{})
+}
+
+func (webdavBackend) Put(m *Monitor, d Destination, filePath, rate string) error {
+	if info, err := os.Stat(filePath); err == nil && m.useChunkedUpload(d, info.Size()) {
+		return m.uploadChunked(d, filePath, rate)
+	}
+	return m.uploadWebDAV(d, filePath, rate)
+}
+
+func (webdavBackend) Stream(m *Monitor, d Destination, name string) (streamSink, error) {
+	return m.newDAVWriter(d, name)
+}
+
+func (webdavBackend) Open(ctx context.Context, d Destination, name string) (io.ReadCloser, int64, error) {
+	return openWebDAV(ctx, d, name)
+}
+
+func (webdavBackend) Stat(d Destination, name string) (remoteObject, error) {
+	return statListed(listWebDAV, d, name)
+}
+
+func (webdavBackend) List(d Destination) ([]remoteObject, error) {
+	return listWebDAV(d)
+}
+
+func (webdavBackend) Delete(d Destination, name string) error {
+	return deleteWebDAV(d, name)
+}
+
+func (webdavBackend) Test(d Destination) (string, error) {
+	return testWebDAVUpload(d)
+}
+
+func (webdavBackend) Location(d Destination, name string) string {
+	return davURL(d, name)
+}
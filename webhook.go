@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	webhookMaxSkew  = 5 * time.Minute
+	webhookMaxBody  = 64 * 1024
+	signatureHeader = "X-Signature"
+	timestampHeader = "X-Timestamp"
+)
+
+// WebhookRequest is the body of POST /api/webhook/backup.
+type WebhookRequest struct {
+	Database     string // default: DBName
+	AllDatabases bool   // pg_dumpall instead of a single database
+	Label        string // e.g. "erp-close-2024-06"
+	Destination  string // upload only to this destination (default: all)
+}
+
+// handleWebhookBackup starts a backup for an external caller. The request
+// must carry X-Timestamp (unix seconds) and X-Signature: sha256=<hex> with
+// the HMAC-SHA256 of "<timestamp>.<body>" under WebhookSecret.
+func (m *Monitor) handleWebhookBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	if m.config.WebhookSecret == "" {
+		writeError(w, http.StatusNotFound, "webhook not configured")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, webhookMaxBody))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := m.verifyWebhook(r.Header.Get(timestampHeader), r.Header.Get(signatureHeader), body); err != nil {
+		log.Printf("Webhook rejected from %s: %v", r.RemoteAddr, err)
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	var req WebhookRequest
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+			return
+		}
+	}
+	if err := m.checkBackupRequest(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	job := m.startBackupJob(req, triggerWebhook)
+	m.audit("webhook:"+r.RemoteAddr, "backup", job.Database, fmt.Sprintf("job %s label %q", job.ID, job.Label))
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// checkBackupRequest fills in the default database and rejects unknown
+// databases and destinations. The name ends up as pg_dump's last argument
+// and in the backup's file name, so anything that could be read as an
+// option or a path is refused before the server is asked.
+func (m *Monitor) checkBackupRequest(req *WebhookRequest) error {
+	if req.Database == "" {
+		req.Database = m.config.DBName
+	}
+	if !req.AllDatabases {
+		if strings.HasPrefix(req.Database, "-") || strings.ContainsAny(req.Database, `/\`) || strings.Contains(req.Database, "..") {
+			return fmt.Errorf("invalid database name %q", req.Database)
+		}
+		exists, err := m.databaseExists(req.Database)
+		if err != nil {
+			return fmt.Errorf("cannot check database %q: %v", req.Database, err)
+		}
+		if !exists {
+			return fmt.Errorf("unknown database %q", req.Database)
+		}
+	}
+	if req.Destination != "" {
+		if _, ok := m.destination(req.Destination); !ok {
+			return fmt.Errorf("unknown destination %q", req.Destination)
+		}
+	}
+	return nil
+}
+
+func (m *Monitor) databaseExists(name string) (bool, error) {
+	db, err := m.openDB(m.config.DBName)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), connTimeout)
+	defer cancel()
+
+	var exists bool
+	err = db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", name).Scan(&exists)
+	return exists, err
+}
+
+func (m *Monitor) verifyWebhook(timestamp, signature string, body []byte) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid %s", timestampHeader)
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > webhookMaxSkew || skew < -webhookMaxSkew {
+		return fmt.Errorf("timestamp outside the allowed %v window", webhookMaxSkew)
+	}
+
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || len(got) == 0 {
+		return fmt.Errorf("missing or invalid %s", signatureHeader)
+	}
+	mac := hmac.New(sha256.New, []byte(m.config.WebhookSecret))
+	fmt.Fprintf(mac, "%s.", timestamp)
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// startBackupJob queues the requested backup and runs it in the background
+// in its turn.
+func (m *Monitor) startBackupJob(req WebhookRequest, trigger string) BackupJob {
+	job := BackupJob{
+		Database:    req.Database,
+		Trigger:     trigger,
+		Label:       req.Label,
+		Destination: req.Destination,
+	}
+	if req.AllDatabases {
+		job.Database = "all databases"
+	}
+	job, _ = m.queueBackupJob(job)
+	go m.runBackupJob(job.ID, func() CatalogEntry {
+		return m.backupOne(req.Database, req.AllDatabases, backupOptions{Label: req.Label, Destination: req.Destination})
+	})
+	return job
+}
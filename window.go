@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	overrunAlert    = "alert"
+	overrunThrottle = "throttle"
+	overrunCancel   = "cancel"
+
+	eventBackupOverrun = "backup_overrun"
+)
+
+// backupWindow watches a running dump, pg_dumpall or pg_basebackup and
+// enforces its window (see backupWindowMinutes). When the window is exceeded
+// it always alerts and then, per BackupOverrunPolicy, lowers the process's
+// CPU/IO priority or cancels it.
+type backupWindow struct {
+	timer   *time.Timer
+	overrun int32
+}
+
+func (m *Monitor) watchBackupWindow(cancel context.CancelFunc, cmd *exec.Cmd, database string, minutes int) *backupWindow {
+	w := &backupWindow{}
+	if minutes <= 0 {
+		return w
+	}
+
+	window := time.Duration(minutes) * time.Minute
+	w.timer = time.AfterFunc(window, func() {
+		atomic.StoreInt32(&w.overrun, 1)
+
+		policy := m.config.BackupOverrunPolicy
+		if policy == "" {
+			policy = overrunAlert
+		}
+		log.Printf("Backup of %s exceeded its %v window (policy: %s)", database, window, policy)
+
+		action := "still running"
+		switch policy {
+		case overrunThrottle:
+			if cmd.Process != nil {
+				if err := lowerPriority(cmd.Process.Pid); err != nil {
+					log.Printf("Failed to throttle backup process: %v", err)
+				} else {
+					action = "throttled to low priority"
+				}
+			}
+		case overrunCancel:
+			cancel()
+			action = "cancelled"
+		}
+
+		m.notify(Notification{
+			Event:    eventBackupOverrun,
+			Severity: severityWarning,
+			Title:    "Backup window exceeded",
+			Message:  fmt.Sprintf("Backup has been running for more than %v and was %s", window, action),
+			Database: database,
+		})
+	})
+	return w
+}
+
+func (w *backupWindow) Stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+func (w *backupWindow) Overrun() bool {
+	return atomic.LoadInt32(&w.overrun) == 1
+}